@@ -0,0 +1,71 @@
+package access
+
+import "authService/config"
+
+// AllPermissions granted to a role allows every permission, it is meant for admin role
+const AllPermissions = "*"
+
+// Policy maps role names to permissions granted to their members
+type Policy map[string][]string
+
+func NewPolicy(c config.AccessConfig) Policy {
+
+	p := Policy{}
+
+	for role, permissions := range c.Roles {
+		p[role] = append([]string(nil), permissions...)
+	}
+
+	return p
+}
+
+// Permissions returns union of permissions granted to roles, unknown roles grant nothing
+func (p Policy) Permissions(roles []string) map[string]bool {
+
+	granted := map[string]bool{}
+
+	for _, role := range roles {
+		for _, permission := range p[role] {
+			granted[permission] = true
+		}
+	}
+
+	return granted
+}
+
+// Allows reports whether roles together have every required permission. It returns first missing one otherwise
+func (p Policy) Allows(roles []string, required ...string) (bool, string) {
+
+	granted := p.Permissions(roles)
+
+	if granted[AllPermissions] {
+		return true, ""
+	}
+
+	for _, permission := range required {
+		if !granted[permission] {
+			return false, permission
+		}
+	}
+
+	return true, ""
+}
+
+// Defines reports whether role is configured, roles which are not configured grant nothing
+func (p Policy) Defines(role string) bool {
+	_, defined := p[role]
+	return defined
+}
+
+// Includes reports whether roles together have every permission other roles grant, so their members
+// can't hand out more than they have. It returns first missing permission otherwise
+func (p Policy) Includes(roles []string, other []string) (bool, string) {
+
+	for _, role := range other {
+		if allowed, missing := p.Allows(roles, p[role]...); !allowed {
+			return false, missing
+		}
+	}
+
+	return true, ""
+}
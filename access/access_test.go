@@ -0,0 +1,76 @@
+package access
+
+import (
+	"testing"
+
+	"authService/config"
+)
+
+func TestPolicy_Allows(t *testing.T) {
+
+	p := NewPolicy(config.AccessConfig{Roles: map[string][]string{
+		"admin":   {AllPermissions},
+		"support": {"users:read"},
+		"editor":  {"users:read", "users:write"},
+	}})
+
+	tests := []struct {
+		description string
+		roles       []string
+		required    []string
+		allowed     bool
+		missing     string
+	}{
+		{"Nothing required", nil, nil, true, ""},
+		{"No roles", nil, []string{"users:read"}, false, "users:read"},
+		{"Unknown role", []string{"guest"}, []string{"users:read"}, false, "users:read"},
+		{"Granted permission", []string{"support"}, []string{"users:read"}, true, ""},
+		{"One of permissions missing", []string{"support"}, []string{"users:read", "users:write"}, false, "users:write"},
+		{"Permissions of several roles", []string{"support", "editor"}, []string{"users:read", "users:write"}, true, ""},
+		{"Admin", []string{"admin"}, []string{"users:write", "clients:write"}, true, ""},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			allowed, missing := p.Allows(tc.roles, tc.required...)
+
+			if allowed != tc.allowed || missing != tc.missing {
+				t.Errorf("Expected [%v, %v] but was: [%v, %v]", tc.allowed, tc.missing, allowed, missing)
+			}
+		})
+	}
+}
+
+func TestPolicy_Includes(t *testing.T) {
+
+	p := NewPolicy(config.AccessConfig{Roles: map[string][]string{
+		"admin":   {AllPermissions},
+		"support": {"users:read"},
+		"editor":  {"users:read", "users:write"},
+	}})
+
+	tests := []struct {
+		description string
+		roles       []string
+		other       []string
+		included    bool
+		missing     string
+	}{
+		{"No other roles", []string{"support"}, nil, true, ""},
+		{"Same role", []string{"editor"}, []string{"editor"}, true, ""},
+		{"Narrower role", []string{"editor"}, []string{"support"}, true, ""},
+		{"Wider role", []string{"support"}, []string{"editor"}, false, "users:write"},
+		{"Admin role", []string{"editor"}, []string{"admin"}, false, AllPermissions},
+		{"Admin grants any role", []string{"admin"}, []string{"admin", "editor"}, true, ""},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			included, missing := p.Includes(tc.roles, tc.other)
+
+			if included != tc.included || missing != tc.missing {
+				t.Errorf("Expected [%v, %v] but was: [%v, %v]", tc.included, tc.missing, included, missing)
+			}
+		})
+	}
+}
@@ -4,16 +4,176 @@ import (
 	"encoding/json"
 	"errors"
 	"os"
+	"time"
 )
 
+// Duration is read from configuration as a string like "1h30m"
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+
+	d.Duration = v
+	return nil
+}
+
+// RetiredKeyConfig is a previous signing key which still verifies tokens for a grace period after RetiredAt
+type RetiredKeyConfig struct {
+	PublicKey string
+	Algorithm string
+	RetiredAt time.Time
+}
+
+// AuthConfig describes token signing keys. Algorithm is one of RS*, PS*, ES* or EdDSA and has to match
+// the key type, only AllowedAlgorithms are accepted on verification (signing algorithms by default).
+// With GenerateKeys a new key pair is created at PrivateKey and PublicKey paths when both are missing
 type AuthConfig struct {
-	PublicKey  string
-	PrivateKey string
+	PublicKey         string
+	PrivateKey        string
+	Algorithm         string
+	AllowedAlgorithms []string
+	RetiredKeys       []RetiredKeyConfig
+	GracePeriod       Duration
+	GenerateKeys      bool
+}
+
+// TokenConfig describes access tokens: lifetime, issuer and audience stamped on them and clock skew
+// tolerated when exp, nbf and iat are checked. Tokens of other issuer or audience are rejected.
+// Issuer is required, it is an https URL the OpenID provider is published under (http only on loopback).
+// Claims lists user fields embedded into tokens: email, roles, groups, attributes or attributes.<name>.
+// Cookie names cookie /sso and /logout read access token from when it is not sent otherwise
+type TokenConfig struct {
+	TTL      Duration
+	Issuer   string
+	Audience string
+	Skew     Duration
+	Claims   []string
+	Cookie   string
+}
+
+// AccessConfig grants permissions to roles, "*" permission allows everything
+type AccessConfig struct {
+	Roles map[string][]string
+}
+
+// ClientConfig registers OAuth client on startup, its Secret is stored hashed.
+// Public clients have no secret, authorization codes are redirected to RedirectURIs only.
+// Scopes are what confidential client may request for itself with client credentials grant,
+// client may also ask users for them besides scopes of user claims
+type ClientConfig struct {
+	Id           string
+	Secret       string
+	Name         string
+	RedirectURIs []string
+	Public       bool
+	Scopes       []string
+}
+
+type BcryptConfig struct {
+	Cost int
+}
+
+type Argon2idConfig struct {
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+}
+
+type ScryptConfig struct {
+	LogN int
+	R    int
+	P    int
+}
+
+// PasswordConfig describes algorithm and its parameters used for new password hashes.
+// Hashes produced with other algorithms or parameters are still verified and upgraded on login
+type PasswordConfig struct {
+	Algorithm string
+	Bcrypt    BcryptConfig
+	Argon2id  Argon2idConfig
+	Scrypt    ScryptConfig
+}
+
+// StorageConfig selects storage backend: "memory" (default) or "sqlite" with database file at Path
+type StorageConfig struct {
+	Type string
+	Path string
+}
+
+// MailConfig selects how emails are delivered: "log" (default) or "file" writing messages to Path directory.
+// BaseURL is public address of the service links in messages point to, it is required as request Host can be forged
+type MailConfig struct {
+	Type    string
+	Path    string
+	BaseURL string
+}
+
+// WebAuthnConfig describes relying party of passkey ceremonies. RPID is a domain credentials are scoped to,
+// Origins are pages allowed to run ceremonies. Both default to the token issuer
+type WebAuthnConfig struct {
+	RPID    string
+	RPName  string
+	Origins []string
+}
+
+// LoginThrottleConfig limits password guessing. Repeated failed logins of an account delay the next one from BaseDelay
+// doubling up to MaxDelay, MaxFailures in a Window lock the account for Lockout. Login requests from one
+// address are limited to IPAttempts per IPWindow. Zero values fall back to defaults
+type LoginThrottleConfig struct {
+	MaxFailures int
+	BaseDelay   Duration
+	MaxDelay    Duration
+	Lockout     Duration
+	Window      Duration
+	IPAttempts  int
+	IPWindow    Duration
+}
+
+// RateLimitConfig is a token bucket holding up to Burst requests, refilled with Requests per Period.
+// Burst defaults to Requests, zero Requests means no limit
+type RateLimitConfig struct {
+	Requests int
+	Period   Duration
+	Burst    int
+}
+
+// RouteRateLimitConfig replaces default limits for one request path, zero limits fall back to defaults
+type RouteRateLimitConfig struct {
+	IP   RateLimitConfig
+	User RateLimitConfig
+}
+
+// RateLimitsConfig limits requests of every client address (IP) and every user of a valid bearer token (User).
+// Routes are keyed by exact request path like "/login", each route counts its requests separately
+type RateLimitsConfig struct {
+	IP     RateLimitConfig
+	User   RateLimitConfig
+	Routes map[string]RouteRateLimitConfig
 }
 
 type Configuration struct {
-	Port int
-	Auth AuthConfig
+	Port      int
+	Auth      AuthConfig
+	Token     TokenConfig
+	Access    AccessConfig
+	Clients   []ClientConfig
+	Password  PasswordConfig
+	Storage   StorageConfig
+	Mail      MailConfig
+	WebAuthn  WebAuthnConfig
+	Login     LoginThrottleConfig
+	RateLimit RateLimitsConfig
 }
 
 var config *Configuration = nil
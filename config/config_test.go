@@ -2,6 +2,7 @@ package config
 
 import (
 	"testing"
+	"time"
 )
 
 func TestConfigurationLoading(t *testing.T) {
@@ -26,6 +27,61 @@ func TestConfigurationLoading(t *testing.T) {
 		if c.Auth.PrivateKey != "PrivateKeyPath" {
 			t.Errorf("Expected Auth.PrivateKey [%v], but was: [%v]", "PrivateKeyPath", c.Auth.PrivateKey)
 		}
+		if c.Password.Algorithm != "bcrypt" {
+			t.Errorf("Expected Password.Algorithm [%v], but was: [%v]", "bcrypt", c.Password.Algorithm)
+		}
+		if c.Password.Bcrypt.Cost != 11 {
+			t.Errorf("Expected Password.Bcrypt.Cost [%v], but was: [%v]", 11, c.Password.Bcrypt.Cost)
+		}
+		if c.Auth.Algorithm != "ES256" || len(c.Auth.AllowedAlgorithms) != 2 {
+			t.Errorf("Expected Auth.Algorithm [%v] allowing [%v], but was: [%v, %v]", "ES256", "ES256, PS512", c.Auth.Algorithm, c.Auth.AllowedAlgorithms)
+		}
+		if c.Auth.GracePeriod.Duration != 24*time.Hour {
+			t.Errorf("Expected Auth.GracePeriod [%v], but was: [%v]", 24*time.Hour, c.Auth.GracePeriod)
+		}
+		if c.Token.TTL.Duration != 15*time.Minute || c.Token.Skew.Duration != 30*time.Second {
+			t.Errorf("Expected Token TTL and Skew [%v, %v], but was: [%v, %v]", 15*time.Minute, 30*time.Second, c.Token.TTL, c.Token.Skew)
+		}
+		if c.Token.Issuer != "https://auth.example.com" || c.Token.Audience != "example" {
+			t.Errorf("Expected Token Issuer and Audience [%v, %v], but was: [%v, %v]", "https://auth.example.com", "example", c.Token.Issuer, c.Token.Audience)
+		}
+		if c.Token.Cookie != "access_token" {
+			t.Errorf("Expected Token.Cookie [%v], but was: [%v]", "access_token", c.Token.Cookie)
+		}
+		if len(c.Token.Claims) != 3 || c.Token.Claims[2] != "attributes.department" {
+			t.Errorf("Expected Token.Claims [%v], but was: [%v]", "email, roles, attributes.department", c.Token.Claims)
+		}
+		if len(c.Access.Roles) != 2 || len(c.Access.Roles["support"]) != 2 {
+			t.Errorf("Expected Access.Roles [%v], but was: [%v]", "admin, support", c.Access.Roles)
+		}
+		if len(c.Clients) != 2 || c.Clients[0].Id != "gateway" || c.Clients[0].Secret != "gateway-secret" || len(c.Clients[0].Scopes) != 1 {
+			t.Errorf("Expected Clients [%v], but was: [%v]", "gateway, app", c.Clients)
+		}
+		if len(c.Clients) == 2 && (!c.Clients[1].Public || len(c.Clients[1].RedirectURIs) != 1) {
+			t.Errorf("Expected public client [%v] with redirect URI, but was: [%v]", "app", c.Clients[1])
+		}
+		if c.Mail.Type != "file" || c.Mail.Path != "./mail" || c.Mail.BaseURL != "https://auth.example.com" {
+			t.Errorf("Expected Mail [%v, %v, %v], but was: [%v]", "file", "./mail", "https://auth.example.com", c.Mail)
+		}
+		if c.WebAuthn.RPID != "example.com" || c.WebAuthn.RPName != "Example" || len(c.WebAuthn.Origins) != 1 {
+			t.Errorf("Expected WebAuthn relying party [%v], but was: [%v]", "example.com", c.WebAuthn)
+		}
+		if c.Login.MaxFailures != 3 || c.Login.Lockout.Duration != 30*time.Minute || c.Login.IPAttempts != 50 || c.Login.BaseDelay.Duration != 0 {
+			t.Errorf("Expected Login throttle [%v, %v, %v], but was: [%v]", 3, 30*time.Minute, 50, c.Login)
+		}
+		if c.RateLimit.IP.Requests != 100 || c.RateLimit.IP.Period.Duration != time.Minute || c.RateLimit.IP.Burst != 20 || c.RateLimit.User.Requests != 0 {
+			t.Errorf("Expected RateLimit.IP [%v per %v, burst %v], but was: [%v]", 100, time.Minute, 20, c.RateLimit.IP)
+		}
+		if r, ok := c.RateLimit.Routes["/signin"]; !ok || r.IP.Requests != 5 || r.IP.Period.Duration != time.Hour {
+			t.Errorf("Expected RateLimit.Routes [%v], but was: [%v]", "/signin", c.RateLimit.Routes)
+		}
+		if !c.Auth.GenerateKeys {
+			t.Errorf("Expected Auth.GenerateKeys [%v], but was: [%v]", true, c.Auth.GenerateKeys)
+		}
+		retiredAt := time.Date(2018, 12, 20, 10, 0, 0, 0, time.UTC)
+		if len(c.Auth.RetiredKeys) != 1 || c.Auth.RetiredKeys[0].PublicKey != "RetiredPublicKeyPath" || !c.Auth.RetiredKeys[0].RetiredAt.Equal(retiredAt) {
+			t.Errorf("Expected Auth.RetiredKeys [%v at %v], but was: [%v]", "RetiredPublicKeyPath", retiredAt, c.Auth.RetiredKeys)
+		}
 	}
 
 }
@@ -77,3 +133,20 @@ func TestGetConfigReturnsLoadedConfigurationt(t *testing.T) {
 	}
 
 }
+
+func TestDurationUnmarshal(t *testing.T) {
+
+	d := Duration{}
+
+	if err := d.UnmarshalJSON([]byte(`"1h30m"`)); err != nil || d.Duration != 90*time.Minute {
+		t.Errorf("Expected duration [%v] but got [%v, %v]", 90*time.Minute, d.Duration, err)
+	}
+
+	if err := d.UnmarshalJSON([]byte(`"forever"`)); err == nil {
+		t.Errorf("Malformed duration should return an error")
+	}
+
+	if err := d.UnmarshalJSON([]byte(`3600`)); err == nil {
+		t.Errorf("Duration should be a string")
+	}
+}
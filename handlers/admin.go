@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"authService/model"
+	"authService/server"
+)
+
+type rolesRequest struct {
+	Roles  []string `json:"roles"`
+	Groups []string `json:"groups"`
+}
+
+// statusRequest changes only flags present in the request
+type statusRequest struct {
+	Active *bool `json:"active"`
+	Banned *bool `json:"banned"`
+}
+
+// GetUser returns user with id from the path, password hash is never exposed
+func GetUser(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+
+	u, err := server.RunningServer.UserStore.GetUserById(mux.Vars(r)["id"])
+
+	if err != nil {
+		prepareErrorResponse(rw, model.AuthError{ErrorCode: "Not Found", Reason: "User not found"}, http.StatusNotFound)
+		return
+	}
+
+	writeUser(rw, u)
+}
+
+// SetUserRoles replaces roles and groups of user with id from the path. Only configured roles can be given
+// and only those granting no permission the caller does not have, so admins can't escalate themselves.
+// Roles of users above the caller can't be changed
+func SetUserRoles(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+
+	uStore := server.RunningServer.UserStore
+
+	u, err := uStore.GetUserById(mux.Vars(r)["id"])
+
+	if err != nil {
+		prepareErrorResponse(rw, model.AuthError{ErrorCode: "Not Found", Reason: "User not found"}, http.StatusNotFound)
+		return
+	}
+
+	b, err := ioutil.ReadAll(r.Body)
+
+	req := rolesRequest{}
+	if err == nil {
+		err = json.Unmarshal(b, &req)
+	}
+
+	if err != nil {
+		prepareErrorResponse(rw, model.AuthError{ErrorCode: "Bad Request", Reason: "Cant retrive roles"}, http.StatusBadRequest)
+		return
+	}
+
+	if !canManage(rw, r, u) {
+		return
+	}
+
+	policy := server.RunningServer.Access
+
+	for _, role := range req.Roles {
+		if !policy.Defines(role) {
+			prepareErrorResponse(rw, model.AuthError{ErrorCode: "Bad Request", Reason: "Unknown role " + role}, http.StatusBadRequest)
+			return
+		}
+	}
+
+	if included, missing := policy.Includes(GetPrincipal(r).User.Roles, req.Roles); !included {
+		prepareErrorResponse(rw, model.AuthError{ErrorCode: "Forbidden", Reason: "Missing permission " + missing}, http.StatusForbidden)
+		return
+	}
+
+	u.Roles = req.Roles
+	u.Groups = req.Groups
+
+	if err := uStore.UpdateUser(*u); err != nil {
+		prepareErrorResponse(rw, model.AuthError{ErrorCode: "Internal Server Error", Reason: err.Error()}, http.StatusInternalServerError)
+		return
+	}
+
+	logger.Printf("Roles of user %v changed to %v by %v", u.Id, u.Roles, GetPrincipal(r).User.Id)
+
+	writeUser(rw, u)
+}
+
+// SetUserStatus activates, deactivates, bans or unbans user with id from the path.
+// User who can't sign in anymore is logged out everywhere right away. Users above the caller can't be changed
+func SetUserStatus(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+
+	uStore := server.RunningServer.UserStore
+
+	u, err := uStore.GetUserById(mux.Vars(r)["id"])
+
+	if err != nil {
+		prepareErrorResponse(rw, model.AuthError{ErrorCode: "Not Found", Reason: "User not found"}, http.StatusNotFound)
+		return
+	}
+
+	b, err := ioutil.ReadAll(r.Body)
+
+	req := statusRequest{}
+	if err == nil {
+		err = json.Unmarshal(b, &req)
+	}
+
+	if err != nil {
+		prepareErrorResponse(rw, model.AuthError{ErrorCode: "Bad Request", Reason: "Cant retrive status"}, http.StatusBadRequest)
+		return
+	}
+
+	if !canManage(rw, r, u) {
+		return
+	}
+
+	if req.Active != nil {
+		u.Active = *req.Active
+	}
+
+	if req.Banned != nil {
+		u.Banned = *req.Banned
+	}
+
+	if err := uStore.UpdateUser(*u); err != nil {
+		prepareErrorResponse(rw, model.AuthError{ErrorCode: "Internal Server Error", Reason: err.Error()}, http.StatusInternalServerError)
+		return
+	}
+
+	logger.Printf("Status of user %v changed to active: %v, banned: %v by %v", u.Id, u.Active, u.Banned, GetPrincipal(r).User.Id)
+
+	if u.Banned || !u.Active {
+		revokeUserSessions(u)
+	}
+
+	writeUser(rw, u)
+}
+
+// canManage refuses changes of users whose roles grant permissions the caller does not have,
+// so admins can't demote or ban those above them
+func canManage(rw http.ResponseWriter, r *http.Request, u *model.User) bool {
+
+	if included, missing := server.RunningServer.Access.Includes(GetPrincipal(r).User.Roles, u.Roles); !included {
+		prepareErrorResponse(rw, model.AuthError{ErrorCode: "Forbidden", Reason: "Missing permission " + missing}, http.StatusForbidden)
+		return false
+	}
+
+	return true
+}
+
+func writeUser(rw http.ResponseWriter, u *model.User) {
+
+	u.Credentials = &model.Credentilas{Email: u.Credentials.Email}
+
+	resp, err := json.Marshal(u)
+
+	if err != nil {
+		prepareErrorResponse(rw, model.AuthError{ErrorCode: "Internal Server Error", Reason: err.Error()}, http.StatusInternalServerError)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Write(resp)
+}
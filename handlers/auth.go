@@ -8,27 +8,40 @@ import (
 	"net/http"
 	"os"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/dgrijalva/jwt-go"
 
 	"authService/model"
 	"authService/server"
+	"authService/storage"
 )
 
 var logger = log.New(os.Stdout, "[authenticaton] ", log.LstdFlags)
 
+var (
+	errAccountBanned   = errors.New("Account is banned")
+	errAccountInactive = errors.New("Account is not active")
+)
+
 func Login(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
 
 	c, err := retriveCredentials(r)
 	if err != nil {
-		prepareErrorResponse(rw, model.AuthError{"Bad Request", "Cant retrive credentials"}, http.StatusBadRequest)
+		prepareErrorResponse(rw, model.AuthError{ErrorCode: "Bad Request", Reason: "Cant retrive credentials"}, http.StatusBadRequest)
 		return
 	}
 
 	err = isCredentialsFull(c)
 
 	if err != nil {
-		prepareErrorResponse(rw, model.AuthError{"Bad Request", err.Error()}, http.StatusBadRequest)
+		prepareErrorResponse(rw, model.AuthError{ErrorCode: "Bad Request", Reason: err.Error()}, http.StatusBadRequest)
+		return
+	}
+
+	if wait, err := allowLogin(r, c.Email); err != nil {
+		writeThrottled(rw, wait, err)
 		return
 	}
 
@@ -36,36 +49,96 @@ func Login(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
 
 	u, err := uStore.GetUserByLogin(c.Email)
 
+	if err != nil && err != storage.ErrUserNotFound {
+		prepareErrorResponse(rw, model.AuthError{ErrorCode: "Bad Request", Reason: "Error during retrieving user from storage"}, http.StatusBadRequest)
+		return
+	}
+
+	// unknown user gets the same answer as wrong password, after the same work
+	ok, err := verifyPassword(u, c.Password)
+
+	if err != nil {
+		prepareErrorResponse(rw, model.AuthError{ErrorCode: "Bad Request", Reason: "Error during password verification"}, http.StatusBadRequest)
+		return
+	}
+
+	if !ok {
+		loginFailed(c.Email)
+		prepareErrorResponse(rw, model.AuthError{ErrorCode: "Unauthorized", Reason: "Wrong credentials"}, http.StatusUnauthorized)
+		return
+	}
+
+	if err := accountError(u); err != nil {
+		// the link could be lost or expired, so every sign in attempt of unverified user sends a fresh one.
+		// Banned and disabled accounts get none, verification must not look like a way back in
+		if err == errEmailNotVerified && !u.Banned && u.Active {
+			if err := sendVerificationEmail(u); err != nil {
+				logger.Printf("Can't send verification email to user %v: %v", u.Id, err)
+			}
+		}
+		prepareErrorResponse(rw, model.AuthError{ErrorCode: accountErrorCode(err), Reason: err.Error()}, http.StatusForbidden)
+		return
+	}
+
+	logger.Printf("Got user %v", u.Id)
+
+	mfa, err := mfaEnabled(u)
+
 	if err != nil {
-		prepareErrorResponse(rw, model.AuthError{"Bad Request", "Error during retrieving user from storage"}, http.StatusBadRequest)
+		prepareErrorResponse(rw, model.AuthError{ErrorCode: "Internal Server Error", Reason: "Can't check second factor"}, http.StatusInternalServerError)
+		return
+	}
+
+	// user with second factor gets a challenge instead of tokens, LoginMFA exchanges it for tokens
+	if mfa {
+		challenge, err := newMFAChallenge(u)
+
+		if err != nil {
+			prepareErrorResponse(rw, model.AuthError{ErrorCode: "Internal Server Error", Reason: "Can't issue MFA challenge"}, http.StatusInternalServerError)
+			return
+		}
+
+		writeNoStore(rw, model.MFAChallenge{MFARequired: true, MFAToken: challenge, ExpiresIn: int(mfaChallengeDuration / time.Second)})
 		return
 	}
 
-	logger.Printf("Got user %v", u)
+	loginSucceeded(c.Email)
+
+	issueLoginTokens(rw, u)
+}
+
+// issueLoginTokens finishes login of authenticated user with access and refresh tokens
+func issueLoginTokens(rw http.ResponseWriter, u *model.User) {
 
 	t := server.RunningServer.Tokenizer
 	token, err := t.GenerateToken(u)
 
 	if err != nil {
-		prepareErrorResponse(rw, model.AuthError{"Bad Request", "Error during token generation"}, http.StatusBadRequest)
+		prepareErrorResponse(rw, model.AuthError{ErrorCode: "Bad Request", Reason: "Error during token generation"}, http.StatusBadRequest)
 		return
 	}
 
 	tStore := server.RunningServer.SessionStore
-	tStore.StoreToken(token)
+	tStore.StoreToken(token, u.Id.String())
+
+	refresh, err := newRefreshToken(u, "", token)
+
+	if err != nil {
+		prepareErrorResponse(rw, model.AuthError{ErrorCode: "Bad Request", Reason: "Error during token generation"}, http.StatusBadRequest)
+		return
+	}
 
-	rw.Write([]byte(token))
+	writeTokenResponse(rw, model.TokenResponse{AccessToken: token, RefreshToken: refresh})
 
-	logger.Printf("Loging done for user %v, with token %v", c, t)
-	logger.Println("Hello from Login")
+	logger.Printf("Loging done for user %v", u.Credentials.Email)
 }
 
 func Logout(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
 
-	token, err := retriveToken(r)
+	token, err := accessToken(r)
 
 	if err != nil {
-		prepareErrorResponse(rw, model.AuthError{"Bad Request", "Didn't get token form request body"}, http.StatusBadRequest)
+		writeTokenError(rw, err)
 		return
 	}
 
@@ -76,15 +149,18 @@ func Logout(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
 		err := store.DeleteToken(token)
 
 		if err != nil {
-			prepareErrorResponse(rw, model.AuthError{"Bad Request", err.Error()}, http.StatusBadRequest)
+			prepareErrorResponse(rw, model.AuthError{ErrorCode: "Bad Request", Reason: err.Error()}, http.StatusBadRequest)
 			return
 		}
 
+		// refresh token issued with the access token would start a new session otherwise
+		revokeAccessTokenFamily(token)
+
 		rw.WriteHeader(http.StatusOK)
 		return
 	}
 
-	prepareErrorResponse(rw, model.AuthError{"Unauthorized", "User not Logged in"}, http.StatusUnauthorized)
+	unauthorized(rw, "invalid_token", "User not Logged in")
 
 	logger.Println("Logout done")
 }
@@ -94,39 +170,53 @@ func Signin(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
 	c, err := retriveCredentials(r)
 
 	if err != nil {
-		prepareErrorResponse(rw, model.AuthError{"Bad Request", "Cant retrive credentials"}, http.StatusBadRequest)
+		prepareErrorResponse(rw, model.AuthError{ErrorCode: "Bad Request", Reason: "Cant retrive credentials"}, http.StatusBadRequest)
 		return
 	}
 
 	err = isCredentialsFull(c)
 
 	if err != nil {
-		prepareErrorResponse(rw, model.AuthError{"Bad Request", err.Error()}, http.StatusBadRequest)
+		prepareErrorResponse(rw, model.AuthError{ErrorCode: "Bad Request", Reason: err.Error()}, http.StatusBadRequest)
 		return
 	}
 
 	uStore := server.RunningServer.UserStore
 
 	if uStore.IsUserExistByLogin(c.Email) {
-		prepareErrorResponse(rw, model.AuthError{"Bad Request", "User already exist"}, http.StatusBadRequest)
+		prepareErrorResponse(rw, model.AuthError{ErrorCode: "Bad Request", Reason: "User already exist"}, http.StatusBadRequest)
+		return
+	}
+
+	hash, err := server.RunningServer.Hasher.Hash(c.Password)
+
+	if err != nil {
+		prepareErrorResponse(rw, model.AuthError{ErrorCode: "Bad Request", Reason: "Can't process password"}, http.StatusBadRequest)
 		return
 	}
 
+	c.Password = hash
 	u := model.NewUser(*c)
 
 	err = uStore.Store(u)
 	if err != nil {
-		prepareErrorResponse(rw, model.AuthError{"Bad Request", err.Error()}, http.StatusBadRequest)
+		prepareErrorResponse(rw, model.AuthError{ErrorCode: "Bad Request", Reason: err.Error()}, http.StatusBadRequest)
 		return
 	}
 
-	logger.Printf("User %v", u)
+	logger.Printf("User %v created", u.Id)
+
+	if err := sendVerificationEmail(&u); err != nil {
+		logger.Printf("Can't send verification email to user %v: %v", u.Id, err)
+	}
+
+	u.Credentials = &model.Credentilas{Email: c.Email}
 	u.Claims = nil
 	u.Valid = nil
 	j, err := json.Marshal(u)
 
 	if err != nil {
-		prepareErrorResponse(rw, model.AuthError{"Bad Request", err.Error()}, http.StatusBadRequest)
+		prepareErrorResponse(rw, model.AuthError{ErrorCode: "Bad Request", Reason: err.Error()}, http.StatusBadRequest)
 		return
 	}
 
@@ -135,40 +225,35 @@ func Signin(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
 	logger.Println("Signin done")
 }
 
+// Sso dumps user of a session token.
+// Deprecated: OpenID Connect clients should use UserInfo instead.
 func Sso(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
 
 	//TODO
 	logger.Println("SSO handler started")
-	token, err := retriveToken(r)
+	token, err := accessToken(r)
 
 	if err != nil {
-		prepareErrorResponse(rw, model.AuthError{"Bad Request", "Didn't get token form request body"}, http.StatusBadRequest)
+		writeTokenError(rw, err)
 		return
 	}
 
-	if ok := server.RunningServer.SessionStore.IsTokenPresent(token); !ok {
-		prepareErrorResponse(rw, model.AuthError{"Unauthorized", "User not logged in"}, http.StatusUnauthorized)
-		return
-	}
-
-	logger.Printf("Got token %v", token)
-
-	pt, err := server.RunningServer.Tokenizer.ParceAndVerifyToken(token)
+	pt, err := verifyAccessToken(token)
 
 	if err != nil {
-		prepareErrorResponse(rw, model.AuthError{"Bad Request", err.Error()}, http.StatusBadRequest)
-		return
-	}
-
-	if !pt.Valid {
-		prepareErrorResponse(rw, model.AuthError{"Bad Request", "Token in invalid"}, http.StatusBadRequest)
+		unauthorized(rw, "invalid_token", err.Error())
 		return
 	}
 
 	u, claims := getUserForToken(pt)
 
 	if u == nil {
-		prepareErrorResponse(rw, model.AuthError{"Bad Request", "User not found"}, http.StatusBadRequest)
+		unauthorized(rw, "invalid_token", "User not found")
+		return
+	}
+
+	if err := accountError(u); err != nil {
+		prepareErrorResponse(rw, model.AuthError{ErrorCode: accountErrorCode(err), Reason: err.Error()}, http.StatusForbidden)
 		return
 	}
 
@@ -179,7 +264,7 @@ func Sso(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
 	resp, err := json.Marshal(u)
 
 	if err != nil {
-		prepareErrorResponse(rw, model.AuthError{"Bad Request", err.Error()}, http.StatusBadRequest)
+		prepareErrorResponse(rw, model.AuthError{ErrorCode: "Bad Request", Reason: err.Error()}, http.StatusBadRequest)
 		return
 	}
 
@@ -226,14 +311,64 @@ func isCredentialsFull(c *model.Credentilas) error {
 	return nil
 }
 
-func retriveToken(r *http.Request) (string, error) {
-	b, err := ioutil.ReadAll(r.Body)
+// verifyPassword checks provided password against stored hash and transparently
+// upgrades the hash if it was made with outdated algorithm or parameters.
+// Unknown user is nil, dummy hash is verified for it, so timing does not tell whether account exists
+func verifyPassword(u *model.User, password string) (bool, error) {
+
+	if u == nil || u.Credentials == nil || len(u.Credentials.Password) == 0 {
+		verifyDummyPassword(password)
+		return false, nil
+	}
+
+	hasher := server.RunningServer.Hasher
+
+	ok, err := hasher.Verify(password, u.Credentials.Password)
+
+	if err != nil || !ok {
+		return false, err
+	}
+
+	if hasher.NeedsRehash(u.Credentials.Password) {
+		rehashPassword(u, password)
+	}
+
+	return true, nil
+}
+
+// dummyPassword hash is made once by the running hasher, so it costs the same as stored hashes
+var dummyPassword struct {
+	once sync.Once
+	hash string
+}
+
+func verifyDummyPassword(password string) {
+
+	hasher := server.RunningServer.Hasher
+
+	dummyPassword.once.Do(func() {
+		dummyPassword.hash, _ = hasher.Hash("dummy password")
+	})
+
+	hasher.Verify(password, dummyPassword.hash)
+}
+
+func rehashPassword(u *model.User, password string) {
+
+	hash, err := server.RunningServer.Hasher.Hash(password)
 
 	if err != nil {
-		return "", err
+		logger.Printf("Can't rehash password for user %v: %v", u.Id, err)
+		return
 	}
 
-	return string(b), nil
+	c := *u.Credentials
+	c.Password = hash
+	u.Credentials = &c
+
+	if err := server.RunningServer.UserStore.UpdateUser(*u); err != nil {
+		logger.Printf("Can't store rehashed password for user %v: %v", u.Id, err)
+	}
 }
 
 func prepareErrorResponse(rw http.ResponseWriter, err model.AuthError, status int) {
@@ -241,26 +376,47 @@ func prepareErrorResponse(rw http.ResponseWriter, err model.AuthError, status in
 	rw.Write(err.ToBytes())
 }
 
-func getUserForToken(pt *jwt.Token) (*model.User, []model.Claim) {
-	var u *model.User
-	var err error
-	cl := []model.Claim{}
-	if claims, ok := pt.Claims.(jwt.MapClaims); ok && pt.Valid {
-		logger.Println(claims)
-		for key, value := range claims {
-			logger.Printf("Parcing [%v, %v]", key, value)
-			if key == "sub" {
-				if id, ok := value.(string); ok {
-					u, err = server.RunningServer.UserStore.GetUserById(string(id))
-				}
-			}
-			cl = append(cl, model.Claim{Key: key, Value: value})
-		}
+// accountError tells why user can't sign in or use tokens, it is nil for accounts in good standing
+func accountError(u *model.User) error {
+	switch {
+	case u.Banned:
+		return errAccountBanned
+	case !u.EmailVerified:
+		return errEmailNotVerified
+	case !u.Active:
+		return errAccountInactive
 	}
+	return nil
+}
+
+func accountErrorCode(err error) string {
+	switch err {
+	case errAccountBanned:
+		return "Account Banned"
+	case errEmailNotVerified:
+		return "Email Not Verified"
+	}
+	return "Account Inactive"
+}
+
+func getUserForToken(pt *jwt.Token) (*model.User, *model.TokenClaims) {
+
+	claims, ok := pt.Claims.(*model.TokenClaims)
+
+	if !ok || !pt.Valid {
+		return nil, nil
+	}
+
+	// client token subject is a client id, it must never be resolved to a user
+	if claims.IsClientToken() {
+		return nil, claims
+	}
+
+	u, err := server.RunningServer.UserStore.GetUserById(claims.Subject)
 
 	if err != nil {
-		return nil, cl
+		return nil, claims
 	}
 
-	return u, cl
+	return u, claims
 }
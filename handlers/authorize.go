@@ -0,0 +1,421 @@
+package handlers
+
+import (
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"authService/model"
+	"authService/opaque"
+	"authService/server"
+	"authService/storage"
+)
+
+const (
+	authorizationCodeDuration = time.Minute
+	sessionCookie             = "auth_session"
+)
+
+// oauthError is an error of RFC 6749 section 4.1.2.1 and 5.2
+type oauthError struct {
+	Code        string
+	Description string
+}
+
+func (e *oauthError) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+// authorizeRequest is authorization request of RFC 6749 4.1.1 with PKCE of RFC 7636 and OpenID Connect nonce.
+// AuthTime is when user signed in to the service, it is stamped on ID token. RequestedRedirectURI is
+// redirect_uri parameter, it is empty when RedirectURI defaults to the only one registered
+type authorizeRequest struct {
+	Client               *model.Client
+	RedirectURI          string
+	RequestedRedirectURI string
+	State                string
+	Scope                string
+	CodeChallenge        string
+	Nonce                string
+	AuthTime             time.Time
+}
+
+// OAuthAuthorize is authorization endpoint of authorization code flow. It signs user in with a form served
+// by the service, asks for consent once per client and scopes and redirects back to client with a code
+func OAuthAuthorize(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+
+	req, err := parseAuthorizeRequest(r)
+
+	if err != nil {
+		renderPage(rw, errorPage, pageData{Error: err.Error()}, http.StatusBadRequest)
+		return
+	}
+
+	if e := req.validate(r); e != nil {
+		redirectError(rw, r, req, e)
+		return
+	}
+
+	if r.Method == http.MethodPost && r.PostFormValue("action") == "login" {
+		login(rw, r, req)
+		return
+	}
+
+	if r.Method == http.MethodPost && r.PostFormValue("action") == "mfa" {
+		loginMFA(rw, r, req)
+		return
+	}
+
+	u, authTime := sessionUser(r)
+
+	if u == nil {
+		renderPage(rw, loginPage, pageData{ClientName: req.clientName()}, http.StatusOK)
+		return
+	}
+
+	req.AuthTime = authTime
+
+	if r.Method == http.MethodPost && r.PostFormValue("action") == "consent" {
+		consent(rw, r, req, u)
+		return
+	}
+
+	continueAuthorization(rw, r, req, u)
+}
+
+// parseAuthorizeRequest returns error when client or redirect URI are not valid, user can't be redirected then
+func parseAuthorizeRequest(r *http.Request) (*authorizeRequest, error) {
+
+	c, err := server.RunningServer.ClientStore.GetClient(r.FormValue("client_id"))
+
+	if err != nil {
+		return nil, &oauthError{"invalid_client", "Unknown client"}
+	}
+
+	redirectURI := r.FormValue("redirect_uri")
+
+	switch {
+	case redirectURI == "" && len(c.RedirectURIs) == 1:
+		redirectURI = c.RedirectURIs[0]
+	case !contains(c.RedirectURIs, redirectURI):
+		return nil, &oauthError{"invalid_request", "Redirect URI is not registered for the client"}
+	}
+
+	return &authorizeRequest{
+		Client:               c,
+		RedirectURI:          redirectURI,
+		RequestedRedirectURI: r.FormValue("redirect_uri"),
+		State:                r.FormValue("state"),
+		Scope:                strings.Join(strings.Fields(r.FormValue("scope")), " "),
+		CodeChallenge:        r.FormValue("code_challenge"),
+		Nonce:                r.FormValue("nonce"),
+	}, nil
+}
+
+// validate checks parameters which are reported back to client by redirect
+func (req *authorizeRequest) validate(r *http.Request) *oauthError {
+
+	if r.FormValue("response_type") != "code" {
+		return &oauthError{"unsupported_response_type", "Only code response type is supported"}
+	}
+
+	if r.FormValue("code_challenge_method") != "S256" || !isPKCEValue(req.CodeChallenge, 43) {
+		return &oauthError{"invalid_request", "PKCE code challenge with S256 method is required"}
+	}
+
+	allowed := append(append([]string{}, supportedScopes...), req.Client.Scopes...)
+
+	if !containsAll(allowed, req.scopes()) {
+		return &oauthError{"invalid_scope", "Requested scope is not allowed for the client"}
+	}
+
+	return nil
+}
+
+func (req *authorizeRequest) scopes() []string {
+	return strings.Fields(req.Scope)
+}
+
+func (req *authorizeRequest) clientName() string {
+	if req.Client.Name != "" {
+		return req.Client.Name
+	}
+	return req.Client.Id
+}
+
+func login(rw http.ResponseWriter, r *http.Request, req *authorizeRequest) {
+
+	email := r.PostFormValue("email")
+
+	if wait, err := allowLogin(r, email); err != nil {
+		if !isThrottled(err) {
+			renderPage(rw, errorPage, pageData{Error: "Can't sign in, try again later"}, http.StatusInternalServerError)
+			return
+		}
+		retryAfter(rw, wait)
+		renderPage(rw, loginPage, pageData{ClientName: req.clientName(), Email: email, Error: err.Error()}, http.StatusTooManyRequests)
+		return
+	}
+
+	u, err := server.RunningServer.UserStore.GetUserByLogin(email)
+
+	ok := false
+	if err == nil || err == storage.ErrUserNotFound {
+		ok, err = verifyPassword(u, r.PostFormValue("password"))
+	}
+
+	if err != nil || !ok {
+		loginFailed(email)
+		renderPage(rw, loginPage, pageData{ClientName: req.clientName(), Email: email, Error: "Wrong credentials"}, http.StatusUnauthorized)
+		return
+	}
+
+	if err := accountError(u); err != nil {
+		renderPage(rw, loginPage, pageData{ClientName: req.clientName(), Email: email, Error: err.Error()}, http.StatusForbidden)
+		return
+	}
+
+	mfa, err := mfaEnabled(u)
+
+	if err != nil {
+		renderPage(rw, errorPage, pageData{Error: "Can't sign in, try again later"}, http.StatusInternalServerError)
+		return
+	}
+
+	if mfa {
+		challenge, err := newMFAChallenge(u)
+
+		if err != nil {
+			renderPage(rw, errorPage, pageData{Error: "Can't sign in, try again later"}, http.StatusInternalServerError)
+			return
+		}
+
+		renderPage(rw, mfaPage, pageData{ClientName: req.clientName(), Token: challenge}, http.StatusOK)
+		return
+	}
+
+	loginSucceeded(email)
+
+	signIn(rw, r, req, u)
+}
+
+// loginMFA is the second step of login for user with second factor, a wrong code counts as failed login
+// and sends user back to password
+func loginMFA(rw http.ResponseWriter, r *http.Request, req *authorizeRequest) {
+
+	u, err := useOneTimeToken(r.PostFormValue("mfa_token"), mfaChallengePurpose, passwordBinding)
+
+	if err == nil {
+		err = accountError(u)
+	}
+
+	if err != nil {
+		renderPage(rw, loginPage, pageData{ClientName: req.clientName(), Error: err.Error()}, http.StatusUnauthorized)
+		return
+	}
+
+	if wait, err := allowLogin(r, u.Credentials.Email); err != nil {
+		if !isThrottled(err) {
+			renderPage(rw, errorPage, pageData{Error: "Can't sign in, try again later"}, http.StatusInternalServerError)
+			return
+		}
+		retryAfter(rw, wait)
+		renderPage(rw, loginPage, pageData{ClientName: req.clientName(), Email: u.Credentials.Email, Error: err.Error()}, http.StatusTooManyRequests)
+		return
+	}
+
+	if err := verifySecondFactor(u, r.PostFormValue("code")); err != nil {
+		loginFailed(u.Credentials.Email)
+		renderPage(rw, loginPage, pageData{ClientName: req.clientName(), Error: err.Error()}, http.StatusUnauthorized)
+		return
+	}
+
+	loginSucceeded(u.Credentials.Email)
+
+	signIn(rw, r, req, u)
+}
+
+func signIn(rw http.ResponseWriter, r *http.Request, req *authorizeRequest, u *model.User) {
+
+	if err := startSession(rw, r, u); err != nil {
+		renderPage(rw, errorPage, pageData{Error: "Can't sign in, try again later"}, http.StatusInternalServerError)
+		return
+	}
+
+	logger.Printf("User %v signed in to authorize client %v", u.Id, req.Client.Id)
+
+	req.AuthTime = time.Now()
+
+	continueAuthorization(rw, r, req, u)
+}
+
+func consent(rw http.ResponseWriter, r *http.Request, req *authorizeRequest, u *model.User) {
+
+	if r.PostFormValue("decision") != "allow" {
+		redirectError(rw, r, req, &oauthError{"access_denied", "User denied access"})
+		return
+	}
+
+	err := server.RunningServer.ConsentStore.SaveConsent(model.Consent{
+		UserId:    u.Id,
+		ClientId:  req.Client.Id,
+		Scopes:    req.scopes(),
+		GrantedAt: time.Now(),
+	})
+
+	if err != nil {
+		redirectError(rw, r, req, &oauthError{"server_error", "Can't store consent"})
+		return
+	}
+
+	issueCode(rw, r, req, u)
+}
+
+// continueAuthorization issues code right away if user already allowed client every requested scope
+func continueAuthorization(rw http.ResponseWriter, r *http.Request, req *authorizeRequest, u *model.User) {
+
+	c, err := server.RunningServer.ConsentStore.GetConsent(u.Id, req.Client.Id)
+
+	if err != nil && err != storage.ErrNoConsent {
+		redirectError(rw, r, req, &oauthError{"server_error", "Can't read consent"})
+		return
+	}
+
+	if c != nil && containsAll(c.Scopes, req.scopes()) {
+		issueCode(rw, r, req, u)
+		return
+	}
+
+	renderPage(rw, consentPage, pageData{ClientName: req.clientName(), Email: u.Credentials.Email, Scopes: req.scopes()}, http.StatusOK)
+}
+
+func issueCode(rw http.ResponseWriter, r *http.Request, req *authorizeRequest, u *model.User) {
+
+	code, err := opaque.New()
+
+	if err == nil {
+		err = server.RunningServer.CodeStore.StoreAuthorizationCode(model.AuthorizationCode{
+			Code:          opaque.Hash(code),
+			ClientId:      req.Client.Id,
+			UserId:        u.Id,
+			RedirectURI:   req.RequestedRedirectURI,
+			Scope:         req.Scope,
+			CodeChallenge: req.CodeChallenge,
+			Nonce:         req.Nonce,
+			AuthTime:      req.AuthTime,
+			ExpiresAt:     time.Now().Add(authorizationCodeDuration),
+		})
+	}
+
+	if err != nil {
+		redirectError(rw, r, req, &oauthError{"server_error", "Can't issue authorization code"})
+		return
+	}
+
+	redirect(rw, r, req, url.Values{"code": {code}})
+}
+
+func redirectError(rw http.ResponseWriter, r *http.Request, req *authorizeRequest, e *oauthError) {
+	redirect(rw, r, req, url.Values{"error": {e.Code}, "error_description": {e.Description}})
+}
+
+// redirect sends user back to the client, redirect URI may already have a query of its own
+func redirect(rw http.ResponseWriter, r *http.Request, req *authorizeRequest, params url.Values) {
+
+	u, _ := url.Parse(req.RedirectURI)
+	q := u.Query()
+
+	for k, v := range params {
+		q[k] = v
+	}
+
+	if req.State != "" {
+		q.Set("state", req.State)
+	}
+
+	u.RawQuery = q.Encode()
+
+	http.Redirect(rw, r, u.String(), http.StatusFound)
+}
+
+// startSession signs user in to the service itself, so consent and following authorizations don't ask for password.
+// Lax cookie is not sent with cross site POST, so consent can't be given by a forged form
+func startSession(rw http.ResponseWriter, r *http.Request, u *model.User) error {
+
+	token, err := server.RunningServer.Tokenizer.GenerateToken(u)
+
+	if err != nil {
+		return err
+	}
+
+	if err := server.RunningServer.SessionStore.StoreToken(token, u.Id.String()); err != nil {
+		return err
+	}
+
+	http.SetCookie(rw, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return nil
+}
+
+// sessionUser returns signed in user and time of sign in
+func sessionUser(r *http.Request) (*model.User, time.Time) {
+
+	c, err := r.Cookie(sessionCookie)
+
+	if err != nil {
+		return nil, time.Time{}
+	}
+
+	pt, err := verifyAccessToken(c.Value)
+
+	if err != nil {
+		return nil, time.Time{}
+	}
+
+	u, claims := getUserForToken(pt)
+
+	if u == nil || accountError(u) != nil {
+		return nil, time.Time{}
+	}
+
+	return u, time.Unix(claims.IssuedAt, 0)
+}
+
+func renderPage(rw http.ResponseWriter, page *template.Template, data pageData, status int) {
+
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rw.Header().Set("Cache-Control", "no-store")
+	rw.Header().Set("X-Frame-Options", "DENY")
+	rw.WriteHeader(status)
+
+	if err := page.Execute(rw, data); err != nil {
+		logger.Printf("Can't render %v page: %v", page.Name(), err)
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAll(values []string, required []string) bool {
+	for _, v := range required {
+		if !contains(values, v) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,485 @@
+package handlers_test
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"authService/model"
+	"authService/opaque"
+	"authService/storage"
+)
+
+const (
+	testVerifier    = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	testRedirectURI = "https://app.example.com/callback?app=1"
+)
+
+func testChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func withOAuthClients(s storage.Store) {
+	hash, _ := hasher.Hash("backend-secret")
+	s.SaveClient(model.Client{Id: "app", Name: "Mobile App", Public: true, RedirectURIs: []string{testRedirectURI, "app://callback"}, Scopes: []string{"admin"}})
+	s.SaveClient(model.Client{Id: "backend", SecretHash: hash, RedirectURIs: []string{"https://backend.example.com/callback"}, Scopes: []string{"users:read", "users:write"}})
+	s.Store(testUser("user@gmail.com", "qwerty"))
+}
+
+func authorizeQuery(change func(q url.Values)) string {
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {"app"},
+		"redirect_uri":          {testRedirectURI},
+		"scope":                 {"profile email"},
+		"state":                 {"xyz"},
+		"code_challenge":        {testChallenge(testVerifier)},
+		"code_challenge_method": {"S256"},
+	}
+	change(q)
+	return q.Encode()
+}
+
+// browser keeps session cookie and does not follow redirects back to the client
+type browser struct {
+	t      *testing.T
+	base   string
+	cookie *http.Cookie
+}
+
+func (b *browser) do(method, query string, form url.Values) (*http.Response, string) {
+
+	req, _ := http.NewRequest(method, b.base+"/authorize?"+query, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if b.cookie != nil {
+		req.AddCookie(b.cookie)
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	res, err := client.Do(req)
+
+	if err != nil {
+		b.t.Fatal(err.Error())
+	}
+	defer res.Body.Close()
+
+	for _, c := range res.Cookies() {
+		b.cookie = c
+	}
+
+	body, _ := ioutil.ReadAll(res.Body)
+	return res, string(body)
+}
+
+func redirectParams(t *testing.T, res *http.Response) url.Values {
+
+	if res.StatusCode != http.StatusFound {
+		t.Fatalf("Wrong response status code. Expected: [%v] Actual: [%v]", http.StatusFound, res.StatusCode)
+	}
+
+	u, _ := url.Parse(res.Header.Get("Location"))
+
+	if u.Scheme+"://"+u.Host+u.Path != "https://app.example.com/callback" || u.Query().Get("app") != "1" {
+		t.Errorf("Redirect to registered URI expected but got: %v", u)
+	}
+
+	return u.Query()
+}
+
+func TestOAuthAuthorize_InvalidRequest(t *testing.T) {
+
+	ts := newTestServer()
+	defer ts.Close()
+
+	tests := []struct {
+		description   string
+		query         string
+		expectedCode  int
+		expectedError string
+	}{
+		{
+			description:  "Should not redirect to unknown client",
+			query:        authorizeQuery(func(q url.Values) { q.Set("client_id", "unknown") }),
+			expectedCode: 400,
+		},
+		{
+			description:  "Should not redirect to unregistered URI",
+			query:        authorizeQuery(func(q url.Values) { q.Set("redirect_uri", "https://evil.example.com/callback") }),
+			expectedCode: 400,
+		},
+		{
+			description:  "Should require redirect URI when client has several of them",
+			query:        authorizeQuery(func(q url.Values) { q.Del("redirect_uri") }),
+			expectedCode: 400,
+		},
+		{
+			description:   "Should redirect unsupported response type",
+			query:         authorizeQuery(func(q url.Values) { q.Set("response_type", "token") }),
+			expectedCode:  302,
+			expectedError: "unsupported_response_type",
+		},
+		{
+			description:   "Should require PKCE",
+			query:         authorizeQuery(func(q url.Values) { q.Del("code_challenge") }),
+			expectedCode:  302,
+			expectedError: "invalid_request",
+		},
+		{
+			description:   "Should reject plain PKCE method",
+			query:         authorizeQuery(func(q url.Values) { q.Set("code_challenge_method", "plain") }),
+			expectedCode:  302,
+			expectedError: "invalid_request",
+		},
+		{
+			description:   "Should reject unsupported scope",
+			query:         authorizeQuery(func(q url.Values) { q.Set("scope", "profile unknown") }),
+			expectedCode:  302,
+			expectedError: "invalid_scope",
+		},
+		{
+			description:   "Should reject scope not registered for the client",
+			query:         authorizeQuery(func(q url.Values) { q.Set("scope", "profile users:read") }),
+			expectedCode:  302,
+			expectedError: "invalid_scope",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			testSetToDefault()
+			withOAuthClients(s)
+
+			b := &browser{t: t, base: ts.URL}
+			res, _ := b.do("GET", tc.query, nil)
+
+			if res.StatusCode != tc.expectedCode {
+				t.Fatalf("Wrong response status code. Expected: [%v] Actual: [%v]", tc.expectedCode, res.StatusCode)
+			}
+
+			if tc.expectedError != "" {
+				q := redirectParams(t, res)
+				if q.Get("error") != tc.expectedError || q.Get("state") != "xyz" || q.Get("code") != "" {
+					t.Errorf("Expected [%v] error with state but was: [%v]", tc.expectedError, q)
+				}
+			}
+		})
+	}
+}
+
+func TestOAuthAuthorize_CodeFlow(t *testing.T) {
+
+	ts := newTestServer()
+	defer ts.Close()
+
+	withOAuthClients(s)
+
+	b := &browser{t: t, base: ts.URL}
+	query := authorizeQuery(func(q url.Values) {})
+
+	res, body := b.do("GET", query, nil)
+
+	if res.StatusCode != 200 || !strings.Contains(body, "Sign in to Mobile App") {
+		t.Fatalf("Login page expected but got [%v, %v]", res.StatusCode, body)
+	}
+
+	res, body = b.do("POST", query, url.Values{"action": {"login"}, "email": {"user@gmail.com"}, "password": {"wrong"}})
+
+	if res.StatusCode != 401 || !strings.Contains(body, "Wrong credentials") || b.cookie != nil {
+		t.Fatalf("Login page with error expected but got [%v, %v]", res.StatusCode, body)
+	}
+
+	res, body = b.do("POST", query, url.Values{"action": {"login"}, "email": {"user@gmail.com"}, "password": {"qwerty"}})
+
+	if res.StatusCode != 200 || !strings.Contains(body, "Mobile App wants to access your account") || !strings.Contains(body, "<li>profile</li>") {
+		t.Fatalf("Consent page expected but got [%v, %v]", res.StatusCode, body)
+	}
+
+	if b.cookie == nil || !b.cookie.HttpOnly || b.cookie.SameSite != http.SameSiteLaxMode {
+		t.Fatalf("HttpOnly Lax session cookie expected but got %v", b.cookie)
+	}
+
+	res, _ = b.do("POST", query, url.Values{"action": {"consent"}, "decision": {"deny"}})
+
+	if q := redirectParams(t, res); q.Get("error") != "access_denied" || q.Get("state") != "xyz" {
+		t.Errorf("Expected [%v] but was: [%v]", "access_denied", q)
+	}
+
+	res, _ = b.do("POST", query, url.Values{"action": {"consent"}, "decision": {"allow"}})
+
+	q := redirectParams(t, res)
+
+	if q.Get("code") == "" || q.Get("state") != "xyz" {
+		t.Fatalf("Authorization code with state expected but got %v", q)
+	}
+
+	// consent is remembered, signed in user is redirected right away
+	res, _ = b.do("GET", query, nil)
+
+	if again := redirectParams(t, res); again.Get("code") == "" || again.Get("code") == q.Get("code") {
+		t.Errorf("New authorization code expected but got %v", again)
+	}
+
+	// wider scope needs consent again
+	res, body = b.do("GET", authorizeQuery(func(q url.Values) { q.Set("scope", "profile email admin") }), nil)
+
+	if res.StatusCode != 200 || !strings.Contains(body, "<li>admin</li>") {
+		t.Errorf("Consent page expected but got [%v, %v]", res.StatusCode, body)
+	}
+
+	token := func(form url.Values) (int, map[string]string) {
+		res, err := http.PostForm(ts.URL+"/token", form)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		defer res.Body.Close()
+		resp := map[string]string{}
+		json.NewDecoder(res.Body).Decode(&resp)
+		return res.StatusCode, resp
+	}
+
+	exchange := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {q.Get("code")},
+		"redirect_uri":  {testRedirectURI},
+		"client_id":     {"app"},
+		"code_verifier": {testVerifier},
+	}
+
+	wrongVerifier := url.Values{}
+	for k, v := range exchange {
+		wrongVerifier[k] = v
+	}
+	wrongVerifier.Set("code_verifier", strings.Repeat("a", 43))
+
+	if status, resp := token(wrongVerifier); status != 400 || resp["error"] != "invalid_grant" {
+		t.Errorf("Wrong verifier mast be rejected but got [%v, %v]", status, resp)
+	}
+
+	// failed exchange burns the code
+	if status, resp := token(exchange); status != 400 || resp["error"] != "invalid_grant" {
+		t.Errorf("Used code mast be rejected but got [%v, %v]", status, resp)
+	}
+
+	res, _ = b.do("GET", query, nil)
+	exchange.Set("code", redirectParams(t, res).Get("code"))
+
+	noRedirect := url.Values{}
+	for k, v := range exchange {
+		noRedirect[k] = v
+	}
+	noRedirect.Del("redirect_uri")
+
+	if status, resp := token(noRedirect); status != 400 || resp["error"] != "invalid_grant" {
+		t.Errorf("Redirect URI given to authorize endpoint mast be required but got [%v, %v]", status, resp)
+	}
+
+	res, _ = b.do("GET", query, nil)
+	exchange.Set("code", redirectParams(t, res).Get("code"))
+
+	status, resp := token(exchange)
+
+	if status != 200 || resp["access_token"] != testClientToken || resp["token_type"] != "Bearer" || resp["scope"] != "profile email" || resp["id_token"] != "" {
+		t.Errorf("Access token expected but got [%v, %v]", status, resp)
+	}
+
+	if !s.IsTokenPresent(testClientToken) {
+		t.Errorf("Issued token mast be active")
+	}
+
+	if status, resp := token(exchange); status != 400 || resp["error"] != "invalid_grant" {
+		t.Errorf("Code mast be exchanged once but got [%v, %v]", status, resp)
+	}
+
+	if s.IsTokenPresent(testClientToken) {
+		t.Errorf("Token issued for replayed code mast be revoked")
+	}
+}
+
+func TestOAuthAuthorize_OpenIDConnect(t *testing.T) {
+
+	ts := newTestServer()
+	defer ts.Close()
+
+	withOAuthClients(s)
+
+	b := &browser{t: t, base: ts.URL}
+	query := authorizeQuery(func(q url.Values) {
+		q.Set("scope", "openid email")
+		q.Set("nonce", "n-0S6_WzA2Mj")
+	})
+
+	b.do("POST", query, url.Values{"action": {"login"}, "email": {"user@gmail.com"}, "password": {"qwerty"}})
+	res, _ := b.do("POST", query, url.Values{"action": {"consent"}, "decision": {"allow"}})
+
+	code := redirectParams(t, res).Get("code")
+
+	stored, _ := s.UseAuthorizationCode(opaque.Hash(code))
+
+	if stored == nil || stored.Nonce != "n-0S6_WzA2Mj" || stored.AuthTime.IsZero() {
+		t.Fatalf("Authorization code mast keep nonce and auth time but got %v", stored)
+	}
+
+	res, _ = b.do("GET", query, nil)
+
+	exchange := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {redirectParams(t, res).Get("code")},
+		"redirect_uri":  {testRedirectURI},
+		"client_id":     {"app"},
+		"code_verifier": {testVerifier},
+	}
+
+	tr, err := http.PostForm(ts.URL+"/token", exchange)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer tr.Body.Close()
+
+	resp := model.TokenResponse{}
+	json.NewDecoder(tr.Body).Decode(&resp)
+
+	if tr.StatusCode != 200 || resp.AccessToken != testClientToken || resp.IdToken != testIdToken {
+		t.Errorf("ID token expected along access token but got [%v, %v]", tr.StatusCode, resp)
+	}
+}
+
+func TestOAuthToken_ClientAuthentication(t *testing.T) {
+
+	ts := newTestServer()
+	defer ts.Close()
+
+	tests := []struct {
+		description   string
+		form          url.Values
+		expectedCode  int
+		expectedError string
+	}{
+		{
+			description:   "Should reject unsupported grant",
+			form:          url.Values{"grant_type": {"password"}, "client_id": {"app"}},
+			expectedCode:  400,
+			expectedError: "unsupported_grant_type",
+		},
+		{
+			description:   "Should require secret of confidential client",
+			form:          url.Values{"grant_type": {"authorization_code"}, "client_id": {"backend"}, "code": {"code"}},
+			expectedCode:  401,
+			expectedError: "Unauthorized",
+		},
+		{
+			description:   "Should reject public client pretending to have secret",
+			form:          url.Values{"grant_type": {"authorization_code"}, "client_id": {"app"}, "client_secret": {"secret"}, "code": {"code"}},
+			expectedCode:  401,
+			expectedError: "Unauthorized",
+		},
+		{
+			description:   "Should reject unknown code of authenticated client",
+			form:          url.Values{"grant_type": {"authorization_code"}, "client_id": {"backend"}, "client_secret": {"backend-secret"}, "code": {"code"}},
+			expectedCode:  400,
+			expectedError: "invalid_grant",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			testSetToDefault()
+			withOAuthClients(s)
+
+			res, err := http.PostForm(ts.URL+"/token", tc.form)
+
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			defer res.Body.Close()
+
+			resp := model.AuthError{}
+			json.NewDecoder(res.Body).Decode(&resp)
+
+			if res.StatusCode != tc.expectedCode || resp.ErrorCode != tc.expectedError {
+				t.Errorf("Expected [%v, %v] but was: [%v, %v]", tc.expectedCode, tc.expectedError, res.StatusCode, resp)
+			}
+
+			if cc := res.Header.Get("Cache-Control"); cc != "no-store" && res.StatusCode != 401 {
+				t.Errorf("Token endpoint responses mast not be cached")
+			}
+		})
+	}
+}
+
+func TestOAuthToken_ClientCredentials(t *testing.T) {
+
+	ts := newTestServer()
+	defer ts.Close()
+
+	tests := []struct {
+		description   string
+		form          url.Values
+		expectedCode  int
+		expectedError string
+		expectedScope string
+	}{
+		{
+			description:   "Should grant every allowed scope when none is requested",
+			form:          url.Values{"client_id": {"backend"}, "client_secret": {"backend-secret"}},
+			expectedCode:  200,
+			expectedScope: "users:read users:write",
+		},
+		{
+			description:   "Should grant requested scope",
+			form:          url.Values{"client_id": {"backend"}, "client_secret": {"backend-secret"}, "scope": {"users:read"}},
+			expectedCode:  200,
+			expectedScope: "users:read",
+		},
+		{
+			description:   "Should reject scope client is not allowed",
+			form:          url.Values{"client_id": {"backend"}, "client_secret": {"backend-secret"}, "scope": {"users:read admin"}},
+			expectedCode:  400,
+			expectedError: "invalid_scope",
+		},
+		{
+			description:   "Should reject wrong secret",
+			form:          url.Values{"client_id": {"backend"}, "client_secret": {"wrong"}},
+			expectedCode:  401,
+			expectedError: "Unauthorized",
+		},
+		{
+			description:   "Should reject public client",
+			form:          url.Values{"client_id": {"app"}},
+			expectedCode:  401,
+			expectedError: "Unauthorized",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			testSetToDefault()
+			withOAuthClients(s)
+
+			tc.form.Set("grant_type", "client_credentials")
+			res, err := http.PostForm(ts.URL+"/token", tc.form)
+
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			defer res.Body.Close()
+
+			resp := map[string]string{}
+			json.NewDecoder(res.Body).Decode(&resp)
+
+			if res.StatusCode != tc.expectedCode || resp["error"] != tc.expectedError || resp["scope"] != tc.expectedScope {
+				t.Errorf("Expected [%v, %v, %v] but was: [%v, %v]", tc.expectedCode, tc.expectedError, tc.expectedScope, res.StatusCode, resp)
+			}
+
+			if tc.expectedCode == 200 && (resp["access_token"] != testServiceToken || resp["refresh_token"] != "" || !s.IsTokenPresent(testServiceToken)) {
+				t.Errorf("Active client token without refresh token expected but got %v", resp)
+			}
+		})
+	}
+}
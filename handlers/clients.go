@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+
+	"authService/model"
+	"authService/server"
+)
+
+var errInvalidClient = errors.New("Invalid client credentials")
+
+// authenticateClient accepts client credentials in Basic authorization header or in form fields
+// client_id and client_secret, as client_secret_basic and client_secret_post of RFC 6749 describe
+func authenticateClient(r *http.Request) (*model.Client, error) {
+
+	id, secret, ok := r.BasicAuth()
+
+	if ok {
+		// RFC 6749 2.3.1: credentials are form encoded before they are put into Basic header
+		var err error
+		if id, err = url.QueryUnescape(id); err != nil {
+			return nil, errInvalidClient
+		}
+		if secret, err = url.QueryUnescape(secret); err != nil {
+			return nil, errInvalidClient
+		}
+	} else {
+		id, secret = r.PostFormValue("client_id"), r.PostFormValue("client_secret")
+	}
+
+	if id == "" || secret == "" {
+		return nil, errInvalidClient
+	}
+
+	c, err := server.RunningServer.ClientStore.GetClient(id)
+
+	if err != nil || c.Public {
+		return nil, errInvalidClient
+	}
+
+	if ok, err := server.RunningServer.Hasher.Verify(secret, c.SecretHash); err != nil || !ok {
+		return nil, errInvalidClient
+	}
+
+	return c, nil
+}
+
+func isClientRegistered(id string) bool {
+	_, err := server.RunningServer.ClientStore.GetClient(id)
+	return err == nil
+}
+
+func invalidClient(rw http.ResponseWriter) {
+	rw.Header().Set("WWW-Authenticate", `Basic realm="authService"`)
+	prepareErrorResponse(rw, model.AuthError{ErrorCode: "Unauthorized", Reason: errInvalidClient.Error()}, http.StatusUnauthorized)
+}
@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"authService/model"
+	"authService/server"
+)
+
+// Health reports state of service dependencies, it responds with 503 if any of them is broken.
+// The endpoint is public, so errors of broken dependencies are only logged
+func Health(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+
+	report := server.RunningServer.Health()
+
+	for i, c := range report.Checks {
+		if !c.Healthy {
+			logger.Printf("Health check %v failed: %v", c.Name, c.Error)
+			report.Checks[i].Error = ""
+		}
+	}
+
+	resp, err := json.Marshal(report)
+
+	if err != nil {
+		prepareErrorResponse(rw, model.AuthError{ErrorCode: "Internal Server Error", Reason: err.Error()}, http.StatusInternalServerError)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+
+	if !report.Healthy {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	rw.Write(resp)
+}
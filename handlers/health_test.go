@@ -0,0 +1,67 @@
+package handlers_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/urfave/negroni"
+
+	"authService/handlers"
+	"authService/server"
+)
+
+func TestHealth(t *testing.T) {
+
+	tests := []struct {
+		description    string
+		checks         []server.HealthCheck
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			description:    "All dependencies are healthy",
+			checks:         []server.HealthCheck{{Name: "storage", Check: func() error { return nil }}},
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"healthy":true,"checks":[{"name":"storage","healthy":true}]}`,
+		},
+		{
+			description: "Signing keys are broken",
+			checks: []server.HealthCheck{
+				{Name: "signing keys", Check: func() error { return errors.New("Can't read key") }},
+				{Name: "storage", Check: func() error { return nil }},
+			},
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedBody:   `{"healthy":false,"checks":[{"name":"signing keys","healthy":false},{"name":"storage","healthy":true}]}`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+
+			server.RunningServer = &server.Server{HealthChecks: tc.checks}
+
+			ts := httptest.NewServer(negroni.New(negroni.HandlerFunc(handlers.Health)))
+			defer ts.Close()
+
+			res, err := http.Get(ts.URL + "/health")
+
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			defer res.Body.Close()
+
+			b, _ := ioutil.ReadAll(res.Body)
+
+			if !IsEqualJson(string(b), tc.expectedBody) {
+				t.Errorf("Wrong response body. Expected: [%v] Actual: [%v]", tc.expectedBody, string(b))
+			}
+
+			if res.StatusCode != tc.expectedStatus {
+				t.Errorf("Wrong response status code. Expected: [%v] Actual: [%v]", tc.expectedStatus, res.StatusCode)
+			}
+		})
+	}
+}
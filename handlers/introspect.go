@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"authService/model"
+)
+
+// Introspect tells authenticated clients whether access token is active and what it carries as RFC 7662 describes.
+// Unknown, expired and logged out tokens are reported as inactive without any details
+func Introspect(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+
+	client, err := authenticateClient(r)
+
+	if err != nil {
+		invalidClient(rw)
+		return
+	}
+
+	token := r.PostFormValue("token")
+
+	if token == "" {
+		prepareErrorResponse(rw, model.AuthError{ErrorCode: "Bad Request", Reason: "No token provided"}, http.StatusBadRequest)
+		return
+	}
+
+	resp := model.IntrospectionResponse{}
+
+	if pt, err := verifyAccessToken(token); err == nil {
+		u, claims := getUserForToken(pt)
+
+		if u != nil && accountError(u) == nil || u == nil && claims != nil && claims.IsClientToken() && isClientRegistered(claims.ClientId) {
+			resp = model.IntrospectionResponse{
+				Active:    true,
+				Scope:     claims.Scope,
+				ClientId:  claims.ClientId,
+				TokenType: "Bearer",
+				Exp:       claims.ExpiresAt,
+				Iat:       claims.IssuedAt,
+				Nbf:       claims.NotBefore,
+				Sub:       claims.Subject,
+				Aud:       claims.Audience,
+				Iss:       claims.Issuer,
+				Jti:       claims.Id,
+			}
+
+			// inactive response carries nothing but active:false, so email is released for active users only
+			if u != nil && u.Credentials != nil {
+				resp.Username = u.Credentials.Email
+			}
+		}
+	}
+
+	logger.Printf("Token introspected by client %v, active: %v", client.Id, resp.Active)
+
+	b, err := json.Marshal(resp)
+
+	if err != nil {
+		prepareErrorResponse(rw, model.AuthError{ErrorCode: "Internal Server Error", Reason: err.Error()}, http.StatusInternalServerError)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("Cache-Control", "no-store")
+	rw.Write(b)
+}
@@ -0,0 +1,211 @@
+package handlers_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/urfave/negroni"
+
+	"authService/handlers"
+	"authService/model"
+	"authService/server"
+	"authService/storage"
+)
+
+func withClient(s storage.Store) {
+	hash, _ := hasher.Hash("gateway-secret")
+	s.SaveClient(model.Client{Id: "gateway", SecretHash: hash})
+}
+
+func TestIntrospect(t *testing.T) {
+
+	tests := []struct {
+		description  string
+		clientId     string
+		clientSecret string
+		basic        bool
+		tokenValid   bool
+		form         url.Values
+		expectedBody string
+		expectedCode int
+		storeInitter func(s storage.Store)
+	}{
+		{
+			description:  "Should reject request without client credentials",
+			form:         url.Values{"token": {testToken}},
+			expectedBody: `{"error":"Unauthorized","reason":"Invalid client credentials"}`,
+			expectedCode: 401,
+			storeInitter: withClient,
+		},
+		{
+			description:  "Should reject wrong client secret",
+			clientId:     "gateway",
+			clientSecret: "wrong",
+			basic:        true,
+			form:         url.Values{"token": {testToken}},
+			expectedBody: `{"error":"Unauthorized","reason":"Invalid client credentials"}`,
+			expectedCode: 401,
+			storeInitter: withClient,
+		},
+		{
+			description:  "Should reject unknown client",
+			clientId:     "other",
+			clientSecret: "gateway-secret",
+			basic:        true,
+			form:         url.Values{"token": {testToken}},
+			expectedBody: `{"error":"Unauthorized","reason":"Invalid client credentials"}`,
+			expectedCode: 401,
+			storeInitter: withClient,
+		},
+		{
+			description:  "Should require token",
+			clientId:     "gateway",
+			clientSecret: "gateway-secret",
+			basic:        true,
+			form:         url.Values{},
+			expectedBody: `{"error":"Bad Request","reason":"No token provided"}`,
+			expectedCode: 400,
+			storeInitter: withClient,
+		},
+		{
+			description:  "Should report logged out token as inactive",
+			clientId:     "gateway",
+			clientSecret: "gateway-secret",
+			basic:        true,
+			tokenValid:   true,
+			form:         url.Values{"token": {testToken}},
+			expectedBody: `{"active":false}`,
+			expectedCode: 200,
+			storeInitter: func(s storage.Store) {
+				withClient(s)
+				s.Store(testUser("user@gmail.com", "qwerty"))
+			},
+		},
+		{
+			description:  "Should report invalid token as inactive",
+			clientId:     "gateway",
+			clientSecret: "gateway-secret",
+			basic:        true,
+			form:         url.Values{"token": {testToken}},
+			expectedBody: `{"active":false}`,
+			expectedCode: 200,
+			storeInitter: func(s storage.Store) {
+				withClient(s)
+				s.Store(testUser("user@gmail.com", "qwerty"))
+				s.StoreToken(testToken, userId.String())
+			},
+		},
+		{
+			description:  "Should report token of banned user as inactive without username",
+			clientId:     "gateway",
+			clientSecret: "gateway-secret",
+			basic:        true,
+			tokenValid:   true,
+			form:         url.Values{"token": {testToken}},
+			expectedBody: `{"active":false}`,
+			expectedCode: 200,
+			storeInitter: func(s storage.Store) {
+				withClient(s)
+				u := testUser("user@gmail.com", "qwerty")
+				u.Banned = true
+				s.Store(u)
+				s.StoreToken(testToken, userId.String())
+			},
+		},
+		{
+			description:  "Should describe active token to client authenticated with Basic header",
+			clientId:     "gateway",
+			clientSecret: "gateway-secret",
+			basic:        true,
+			tokenValid:   true,
+			form:         url.Values{"token": {testToken}, "token_type_hint": {"access_token"}},
+			expectedBody: `{"active":true,"username":"user@gmail.com","token_type":"Bearer","exp":15000,"sub":"bfra5o2cc8imh64se1s0","iss":"test"}`,
+			expectedCode: 200,
+			storeInitter: func(s storage.Store) {
+				withClient(s)
+				s.Store(testUser("user@gmail.com", "qwerty"))
+				s.StoreToken(testToken, userId.String())
+			},
+		},
+		{
+			description:  "Should describe active token to client authenticated with form fields",
+			clientId:     "gateway",
+			clientSecret: "gateway-secret",
+			tokenValid:   true,
+			form:         url.Values{"token": {testToken}},
+			expectedBody: `{"active":true,"username":"user@gmail.com","token_type":"Bearer","exp":15000,"sub":"bfra5o2cc8imh64se1s0","iss":"test"}`,
+			expectedCode: 200,
+			storeInitter: func(s storage.Store) {
+				withClient(s)
+				s.Store(testUser("user@gmail.com", "qwerty"))
+				s.StoreToken(testToken, userId.String())
+			},
+		},
+		{
+			description:  "Should describe active client token without username",
+			clientId:     "gateway",
+			clientSecret: "gateway-secret",
+			basic:        true,
+			tokenValid:   true,
+			form:         url.Values{"token": {testServiceToken}},
+			expectedBody: `{"active":true,"scope":"users:read","client_id":"gateway","token_type":"Bearer","exp":15000,"sub":"gateway","iss":"test"}`,
+			expectedCode: 200,
+			storeInitter: func(s storage.Store) {
+				withClient(s)
+				s.StoreToken(testServiceToken, "gateway")
+			},
+		},
+	}
+
+	server.RunningServer = &server.Server{Tokenizer: &TestTokenizer{secret: "my_test_sercert"}, Hasher: hasher}
+
+	ts := httptest.NewServer(negroni.New(negroni.HandlerFunc(handlers.Introspect)))
+	defer ts.Close()
+
+	for _, tc := range tests {
+
+		t.Run(tc.description, func(t *testing.T) {
+			testSetToDefault()
+			tokenValid = tc.tokenValid
+			tc.storeInitter(s)
+
+			form := tc.form
+			if !tc.basic && tc.clientId != "" {
+				form.Set("client_id", tc.clientId)
+				form.Set("client_secret", tc.clientSecret)
+			}
+
+			req, _ := http.NewRequest("POST", ts.URL+"/introspect", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tc.basic {
+				req.SetBasicAuth(tc.clientId, tc.clientSecret)
+			}
+
+			res, err := http.DefaultClient.Do(req)
+
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			defer res.Body.Close()
+
+			b, _ := ioutil.ReadAll(res.Body)
+
+			// inactive response must be exactly active:false, extra fields would leak details of the token
+			if tc.expectedBody == `{"active":false}` && strings.TrimSpace(string(b)) != tc.expectedBody {
+				t.Errorf("Wrong response body. Expected exactly: [%v] Actual: [%v]", tc.expectedBody, string(b))
+			}
+
+			if !IsEqualJson(string(b), tc.expectedBody) {
+				t.Errorf("Wrong response body. Expected: [%v] Actual: [%v]", tc.expectedBody, string(b))
+			}
+
+			if res.StatusCode != tc.expectedCode {
+				t.Errorf("Wrong response status code. Expected: [%v] Actual: [%v]", tc.expectedCode, res.StatusCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"authService/model"
+	"authService/server"
+)
+
+// Jwks publishes public keys of the service so token consumers can fetch and cache them
+func Jwks(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+
+	keys, err := server.RunningServer.Tokenizer.JWKS()
+
+	if err != nil {
+		prepareErrorResponse(rw, model.AuthError{ErrorCode: "Service Unavailable", Reason: "Signing keys are not available"}, http.StatusServiceUnavailable)
+		return
+	}
+
+	resp, err := json.Marshal(keys)
+
+	if err != nil {
+		prepareErrorResponse(rw, model.AuthError{ErrorCode: "Internal Server Error", Reason: err.Error()}, http.StatusInternalServerError)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("Cache-Control", "public, max-age=3600")
+	rw.Write(resp)
+}
@@ -0,0 +1,44 @@
+package handlers_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/urfave/negroni"
+
+	"authService/handlers"
+	"authService/server"
+)
+
+func TestJwks(t *testing.T) {
+
+	server.RunningServer = &server.Server{}
+	server.RunningServer.Tokenizer = &TestTokenizer{secret: "my_test_sercert"}
+
+	ts := httptest.NewServer(negroni.New(negroni.HandlerFunc(handlers.Jwks)))
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL + "/.well-known/jwks.json")
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer res.Body.Close()
+
+	b, _ := ioutil.ReadAll(res.Body)
+	expected := `{"keys":[{"kty":"RSA","kid":"test","n":"AQAB","e":"AQAB"}]}`
+
+	if !IsEqualJson(string(b), expected) {
+		t.Errorf("Wrong response body. Expected: [%v] Actual: [%v]", expected, string(b))
+	}
+
+	if res.StatusCode != 200 {
+		t.Errorf("Wrong response status code. Expected: [%v] Actual: [%v]", 200, res.StatusCode)
+	}
+
+	if cc := res.Header.Get("Cache-Control"); cc == "" {
+		t.Errorf("JWKS response should be cacheable")
+	}
+}
@@ -0,0 +1,330 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"authService/model"
+	"authService/opaque"
+	"authService/server"
+	"authService/storage"
+	"authService/totp"
+)
+
+const (
+	mfaChallengeDuration = 5 * time.Minute
+	mfaChallengePurpose  = "mfa-challenge"
+	recoveryCodesCount   = 10
+)
+
+var (
+	errInvalidMFACode = errors.New("Authentication code is invalid")
+	errMFANotEnabled  = errors.New("MFA is not enabled")
+	errMFAEnabled     = errors.New("MFA is already enabled")
+	errNotFirstParty  = errors.New("Credentials can be managed with first-party token only")
+)
+
+type mfaRequest struct {
+	MFAToken string `json:"mfa_token"`
+	Code     string `json:"code"`
+}
+
+// LoginMFA finishes login of user with second factor. The challenge from Login is single use,
+// so after a wrong code user has to sign in with password again
+func LoginMFA(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+
+	req, err := retriveMFARequest(r)
+
+	if err != nil || req.MFAToken == "" || req.Code == "" {
+		prepareErrorResponse(rw, model.AuthError{ErrorCode: "Bad Request", Reason: "Cant retrive MFA token and code"}, http.StatusBadRequest)
+		return
+	}
+
+	u, err := useOneTimeToken(req.MFAToken, mfaChallengePurpose, passwordBinding)
+
+	if err != nil {
+		prepareErrorResponse(rw, model.AuthError{ErrorCode: "Unauthorized", Reason: err.Error()}, http.StatusUnauthorized)
+		return
+	}
+
+	if err := accountError(u); err != nil {
+		prepareErrorResponse(rw, model.AuthError{ErrorCode: accountErrorCode(err), Reason: err.Error()}, http.StatusForbidden)
+		return
+	}
+
+	// failed logins are reset only when second factor passes, wrong codes count as failed logins too
+	if wait, err := allowLogin(r, u.Credentials.Email); err != nil {
+		writeThrottled(rw, wait, err)
+		return
+	}
+
+	if err := verifySecondFactor(u, req.Code); err != nil {
+		loginFailed(u.Credentials.Email)
+		prepareErrorResponse(rw, model.AuthError{ErrorCode: "Unauthorized", Reason: err.Error()}, http.StatusUnauthorized)
+		return
+	}
+
+	loginSucceeded(u.Credentials.Email)
+
+	issueLoginTokens(rw, u)
+}
+
+// EnrollTOTP generates a new authenticator secret for the user, it is not used for login until confirmed
+func EnrollTOTP(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+
+	u, ok := firstPartyUser(rw, r)
+
+	if !ok {
+		return
+	}
+
+	store := server.RunningServer.TOTPStore
+
+	if t, err := store.GetTOTP(u.Id); err == nil && t.Confirmed {
+		prepareErrorResponse(rw, model.AuthError{ErrorCode: "Conflict", Reason: errMFAEnabled.Error()}, http.StatusConflict)
+		return
+	}
+
+	secret, err := totp.NewSecret()
+
+	if err == nil {
+		err = store.SaveTOTP(model.TOTP{UserId: u.Id, Secret: secret})
+	}
+
+	if err != nil {
+		prepareErrorResponse(rw, model.AuthError{ErrorCode: "Internal Server Error", Reason: "Can't set up TOTP"}, http.StatusInternalServerError)
+		return
+	}
+
+	issuer := server.RunningServer.Config.Token.Issuer
+	if iu, err := url.Parse(issuer); err == nil && iu.Host != "" {
+		issuer = iu.Host
+	}
+
+	writeNoStore(rw, model.TOTPEnrollment{Secret: secret, URI: totp.ProvisioningURI(issuer, u.Credentials.Email, secret)})
+}
+
+// ConfirmTOTP enables second factor once user proves authenticator app works and returns recovery codes.
+// Codes are shown only here, storage keeps their hashes
+func ConfirmTOTP(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+
+	u, ok := firstPartyUser(rw, r)
+
+	if !ok {
+		return
+	}
+
+	req, err := retriveMFARequest(r)
+
+	if err != nil || req.Code == "" {
+		prepareErrorResponse(rw, model.AuthError{ErrorCode: "Bad Request", Reason: "Cant retrive code"}, http.StatusBadRequest)
+		return
+	}
+
+	store := server.RunningServer.TOTPStore
+
+	t, err := store.GetTOTP(u.Id)
+
+	switch {
+	case err == storage.ErrTOTPNotFound:
+		prepareErrorResponse(rw, model.AuthError{ErrorCode: "Bad Request", Reason: "TOTP enrollment has not been started"}, http.StatusBadRequest)
+		return
+	case err != nil:
+		prepareErrorResponse(rw, model.AuthError{ErrorCode: "Internal Server Error", Reason: "Can't read TOTP"}, http.StatusInternalServerError)
+		return
+	case t.Confirmed:
+		prepareErrorResponse(rw, model.AuthError{ErrorCode: "Conflict", Reason: errMFAEnabled.Error()}, http.StatusConflict)
+		return
+	}
+
+	step, ok := totp.Validate(t.Secret, req.Code, time.Now())
+
+	if !ok {
+		prepareErrorResponse(rw, model.AuthError{ErrorCode: "Bad Request", Reason: errInvalidMFACode.Error()}, http.StatusBadRequest)
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+
+	if err == nil {
+		err = store.SaveTOTP(model.TOTP{UserId: u.Id, Secret: t.Secret, Confirmed: true, LastStep: step, RecoveryCodes: hashes})
+	}
+
+	if err != nil {
+		prepareErrorResponse(rw, model.AuthError{ErrorCode: "Internal Server Error", Reason: "Can't enable TOTP"}, http.StatusInternalServerError)
+		return
+	}
+
+	logger.Printf("TOTP enabled for user %v", u.Id)
+
+	writeNoStore(rw, model.RecoveryCodes{RecoveryCodes: codes})
+}
+
+// DisableTOTP turns second factor off, it takes a valid code so a stolen session alone can't do it
+func DisableTOTP(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+
+	u, ok := firstPartyUser(rw, r)
+
+	if !ok {
+		return
+	}
+
+	req, err := retriveMFARequest(r)
+
+	if err != nil || req.Code == "" {
+		prepareErrorResponse(rw, model.AuthError{ErrorCode: "Bad Request", Reason: "Cant retrive code"}, http.StatusBadRequest)
+		return
+	}
+
+	// wrong codes count as failed logins, so the code can't be guessed with a stolen session either
+	if wait, err := allowLogin(r, u.Credentials.Email); err != nil {
+		writeThrottled(rw, wait, err)
+		return
+	}
+
+	if err := verifySecondFactor(u, req.Code); err != nil {
+		loginFailed(u.Credentials.Email)
+		prepareErrorResponse(rw, model.AuthError{ErrorCode: "Bad Request", Reason: err.Error()}, http.StatusBadRequest)
+		return
+	}
+
+	if err := server.RunningServer.TOTPStore.DeleteTOTP(u.Id); err != nil {
+		prepareErrorResponse(rw, model.AuthError{ErrorCode: "Internal Server Error", Reason: "Can't disable TOTP"}, http.StatusInternalServerError)
+		return
+	}
+
+	logger.Printf("TOTP disabled for user %v", u.Id)
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+// firstPartyUser returns user who manages own credentials, tokens issued to OAuth clients are refused
+func firstPartyUser(rw http.ResponseWriter, r *http.Request) (*model.User, bool) {
+
+	p := GetPrincipal(r)
+
+	if p.Claims != nil && p.Claims.ClientId != "" {
+		prepareErrorResponse(rw, model.AuthError{ErrorCode: "Forbidden", Reason: errNotFirstParty.Error()}, http.StatusForbidden)
+		return nil, false
+	}
+
+	return p.User, true
+}
+
+// mfaEnabled tells whether user has to pass second factor to sign in
+func mfaEnabled(u *model.User) (bool, error) {
+
+	t, err := server.RunningServer.TOTPStore.GetTOTP(u.Id)
+
+	if err == storage.ErrTOTPNotFound {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	return t.Confirmed, nil
+}
+
+// newMFAChallenge issues token which proves user passed the first factor, it is bound to the password
+// so changing password voids challenges in flight
+func newMFAChallenge(u *model.User) (string, error) {
+	return newOneTimeToken(u.Id, mfaChallengePurpose, passwordBinding(u), mfaChallengeDuration)
+}
+
+// verifySecondFactor accepts TOTP code or recovery code of the user, each of them only once
+func verifySecondFactor(u *model.User, code string) error {
+
+	store := server.RunningServer.TOTPStore
+
+	t, err := store.GetTOTP(u.Id)
+
+	if err != nil || !t.Confirmed {
+		return errMFANotEnabled
+	}
+
+	code = strings.Replace(code, " ", "", -1)
+
+	if step, ok := totp.Validate(t.Secret, code, time.Now()); ok {
+		if err := store.UseTOTPStep(u.Id, step); err != nil {
+			return errInvalidMFACode
+		}
+		return nil
+	}
+
+	if err := store.UseRecoveryCode(u.Id, opaque.Hash(normalizeRecoveryCode(code))); err != nil {
+		return errInvalidMFACode
+	}
+
+	logger.Printf("Recovery code used by user %v, %d left", u.Id, len(t.RecoveryCodes)-1)
+
+	return nil
+}
+
+var recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// newRecoveryCodes returns codes shown to user and their hashes to store
+func newRecoveryCodes() ([]string, []string, error) {
+
+	codes := make([]string, recoveryCodesCount)
+	hashes := make([]string, recoveryCodesCount)
+
+	for i := range codes {
+		b := make([]byte, 5)
+
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+
+		c := strings.ToLower(recoveryEncoding.EncodeToString(b))
+		codes[i] = c[:4] + "-" + c[4:]
+		hashes[i] = opaque.Hash(c)
+	}
+
+	return codes, hashes, nil
+}
+
+// normalizeRecoveryCode lets user type recovery code in any case and with or without the dash
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.Replace(code, "-", "", -1))
+}
+
+func retriveMFARequest(r *http.Request) (*mfaRequest, error) {
+
+	b, err := ioutil.ReadAll(r.Body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	req := &mfaRequest{}
+
+	if err := json.Unmarshal(b, req); err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+// writeNoStore writes JSON response carrying secrets which must not be cached
+func writeNoStore(rw http.ResponseWriter, v interface{}) {
+
+	resp, err := json.Marshal(v)
+
+	if err != nil {
+		prepareErrorResponse(rw, model.AuthError{ErrorCode: "Internal Server Error", Reason: err.Error()}, http.StatusInternalServerError)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("Cache-Control", "no-store")
+	rw.Write(resp)
+}
@@ -0,0 +1,356 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"authService/config"
+	"authService/model"
+	"authService/opaque"
+	"authService/server"
+	"authService/totp"
+)
+
+const testSecret = "JBSWY3DPEHPK3PXP"
+
+// withTOTP enables second factor of test user with known secret and recovery code
+func withTOTP(recoveryCodes ...string) {
+	hashes := []string{}
+	for _, c := range recoveryCodes {
+		hashes = append(hashes, opaque.Hash(c))
+	}
+	s.SaveTOTP(model.TOTP{UserId: userId, Secret: testSecret, Confirmed: true, RecoveryCodes: hashes})
+}
+
+func codeAt(t *testing.T, step int64) string {
+	code, err := totp.Code(testSecret, step)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return code
+}
+
+func mfaChallenge(t *testing.T, base string) string {
+
+	status, body := doRequest(t, "POST", base+"/login", "", `{"email":"user@gmail.com","password":"qwerty"}`)
+
+	c := model.MFAChallenge{}
+	json.Unmarshal([]byte(body), &c)
+
+	if status != 200 || !c.MFARequired || c.MFAToken == "" || c.ExpiresIn != 300 {
+		t.Fatalf("MFA challenge expected but got [%v, %v]", status, body)
+	}
+
+	return c.MFAToken
+}
+
+func TestLoginMFA(t *testing.T) {
+
+	ts := newTestServer()
+	defer ts.Close()
+
+	s.Store(testUser("user@gmail.com", "qwerty"))
+	withTOTP("abcd1234")
+
+	step := totp.Step(time.Now())
+
+	tests := []struct {
+		description  string
+		requestBody  func(challenge string) string
+		expestedBody string
+		expectedCode int
+	}{
+		{
+			description:  "Should return bad request for missing code",
+			requestBody:  func(challenge string) string { return `{"mfa_token":"` + challenge + `"}` },
+			expestedBody: `{"error":"Bad Request","reason":"Cant retrive MFA token and code"}`,
+			expectedCode: 400,
+		},
+		{
+			description:  "Should return unauthorized for unknown challenge",
+			requestBody:  func(challenge string) string { return `{"mfa_token":"unknown","code":"` + codeAt(t, step) + `"}` },
+			expestedBody: `{"error":"Unauthorized","reason":"Token is invalid or expired"}`,
+			expectedCode: 401,
+		},
+		{
+			description: "Should return unauthorized for wrong code",
+			requestBody: func(challenge string) string {
+				return `{"mfa_token":"` + challenge + `","code":"` + codeAt(t, step-5) + `"}`
+			},
+			expestedBody: `{"error":"Unauthorized","reason":"Authentication code is invalid"}`,
+			expectedCode: 401,
+		},
+		{
+			description: "Should issue tokens for valid code",
+			requestBody: func(challenge string) string {
+				return `{"mfa_token":"` + challenge + `","code":"` + codeAt(t, step) + `"}`
+			},
+			expestedBody: `{"access_token":"` + testToken + `","token_type":"Bearer"}`,
+			expectedCode: 200,
+		},
+		{
+			description: "Should reject code which was used already",
+			requestBody: func(challenge string) string {
+				return `{"mfa_token":"` + challenge + `","code":"` + codeAt(t, step) + `"}`
+			},
+			expestedBody: `{"error":"Unauthorized","reason":"Authentication code is invalid"}`,
+			expectedCode: 401,
+		},
+		{
+			description:  "Should issue tokens for recovery code",
+			requestBody:  func(challenge string) string { return `{"mfa_token":"` + challenge + `","code":"ABCD-1234"}` },
+			expestedBody: `{"access_token":"` + testToken + `","token_type":"Bearer"}`,
+			expectedCode: 200,
+		},
+		{
+			description:  "Should reject recovery code which was used already",
+			requestBody:  func(challenge string) string { return `{"mfa_token":"` + challenge + `","code":"abcd1234"}` },
+			expestedBody: `{"error":"Unauthorized","reason":"Authentication code is invalid"}`,
+			expectedCode: 401,
+		},
+	}
+
+	for _, tc := range tests {
+
+		t.Run(tc.description, func(t *testing.T) {
+			status, body := doRequest(t, "POST", ts.URL+"/login/mfa", "", tc.requestBody(mfaChallenge(t, ts.URL)))
+
+			m := map[string]interface{}{}
+			json.Unmarshal([]byte(body), &m)
+			delete(m, "refresh_token")
+			b, _ := json.Marshal(m)
+
+			if !IsEqualJson(string(b), tc.expestedBody) {
+				t.Errorf("Wrong response body. Expected: [%v] Actual: [%v]", tc.expestedBody, body)
+			}
+
+			if status != tc.expectedCode {
+				t.Errorf("Wrong response status code. Expected: [%v] Actual: [%v]", tc.expectedCode, status)
+			}
+		})
+	}
+}
+
+func TestLoginMFA_ChallengeIsSingleUse(t *testing.T) {
+
+	ts := newTestServer()
+	defer ts.Close()
+
+	s.Store(testUser("user@gmail.com", "qwerty"))
+	withTOTP()
+
+	challenge := mfaChallenge(t, ts.URL)
+	step := totp.Step(time.Now())
+
+	doRequest(t, "POST", ts.URL+"/login/mfa", "", `{"mfa_token":"`+challenge+`","code":"000000"}`)
+
+	status, body := doRequest(t, "POST", ts.URL+"/login/mfa", "", `{"mfa_token":"`+challenge+`","code":"`+codeAt(t, step)+`"}`)
+
+	if status != 401 || !strings.Contains(body, "Token is invalid or expired") {
+		t.Errorf("Challenge mast be void after failed attempt but got [%v, %v]", status, body)
+	}
+}
+
+func TestLoginMFA_CountsFailedLogins(t *testing.T) {
+
+	ts := newTestServer()
+	defer ts.Close()
+
+	server.RunningServer.Config.Login = config.LoginThrottleConfig{MaxFailures: 2, BaseDelay: config.Duration{Duration: time.Nanosecond},
+		Lockout: config.Duration{Duration: time.Hour}}
+
+	s.Store(testUser("user@gmail.com", "qwerty"))
+	withTOTP()
+
+	doRequest(t, "POST", ts.URL+"/login", "", `{"email":"user@gmail.com","password":"wrong"}`)
+
+	// right password alone mast not forget the failure, second factor is not passed yet
+	doRequest(t, "POST", ts.URL+"/login/mfa", "", `{"mfa_token":"`+mfaChallenge(t, ts.URL)+`","code":"000000"}`)
+
+	status, body := doRequest(t, "POST", ts.URL+"/login", "", `{"email":"user@gmail.com","password":"qwerty"}`)
+
+	if status != 429 || !strings.Contains(body, "Account is temporarily locked") {
+		t.Errorf("Wrong code mast count as failed login but got [%v, %v]", status, body)
+	}
+}
+
+func TestTOTPEnrollment(t *testing.T) {
+
+	ts := newTestServer()
+	defer ts.Close()
+
+	s.Store(testUser("user@gmail.com", "qwerty"))
+	s.StoreToken(testToken, userId.String())
+
+	if status, body := doRequest(t, "POST", ts.URL+"/mfa/totp/confirm", "", `{"code":"123456"}`); status != 401 {
+		t.Fatalf("Bearer token mast be required but got [%v, %v]", status, body)
+	}
+
+	if status, body := doRequest(t, "POST", ts.URL+"/mfa/totp/confirm", testToken, `{"code":"123456"}`); status != 400 || !strings.Contains(body, "TOTP enrollment has not been started") {
+		t.Fatalf("Confirmation without enrollment mast be rejected but got [%v, %v]", status, body)
+	}
+
+	status, body := doRequest(t, "POST", ts.URL+"/mfa/totp", testToken, "")
+
+	enrollment := model.TOTPEnrollment{}
+	json.Unmarshal([]byte(body), &enrollment)
+
+	if status != 200 || enrollment.Secret == "" {
+		t.Fatalf("TOTP secret expected but got [%v, %v]", status, body)
+	}
+
+	uri, _ := url.Parse(enrollment.URI)
+
+	if uri.Scheme != "otpauth" || uri.Query().Get("secret") != enrollment.Secret || !strings.HasSuffix(uri.Path, ":user@gmail.com") {
+		t.Errorf("Provisioning URI for the user expected but got: %v", enrollment.URI)
+	}
+
+	// second factor is not required until enrollment is confirmed
+	if status, body := doRequest(t, "POST", ts.URL+"/login", "", `{"email":"user@gmail.com","password":"qwerty"}`); status != 200 || !strings.Contains(body, "access_token") {
+		t.Fatalf("Tokens expected for unconfirmed TOTP but got [%v, %v]", status, body)
+	}
+
+	step := totp.Step(time.Now())
+	code, _ := totp.Code(enrollment.Secret, step)
+	wrong, _ := totp.Code(enrollment.Secret, step-5)
+
+	if status, body := doRequest(t, "POST", ts.URL+"/mfa/totp/confirm", testToken, `{"code":"`+wrong+`"}`); status != 400 || !strings.Contains(body, "Authentication code is invalid") {
+		t.Fatalf("Wrong code mast be rejected but got [%v, %v]", status, body)
+	}
+
+	status, body = doRequest(t, "POST", ts.URL+"/mfa/totp/confirm", testToken, `{"code":"`+code+`"}`)
+
+	codes := model.RecoveryCodes{}
+	json.Unmarshal([]byte(body), &codes)
+
+	if status != 200 || len(codes.RecoveryCodes) != 10 {
+		t.Fatalf("Recovery codes expected but got [%v, %v]", status, body)
+	}
+
+	stored, _ := s.GetTOTP(userId)
+
+	for i, c := range codes.RecoveryCodes {
+		if stored.RecoveryCodes[i] == c || stored.RecoveryCodes[i] != opaque.Hash(strings.Replace(c, "-", "", -1)) {
+			t.Errorf("Recovery code %v mast be stored hashed but was: %v", c, stored.RecoveryCodes[i])
+		}
+	}
+
+	if status, body := doRequest(t, "POST", ts.URL+"/mfa/totp", testToken, ""); status != 409 {
+		t.Errorf("Enrollment mast be refused while TOTP is enabled but got [%v, %v]", status, body)
+	}
+
+	if status, body := doRequest(t, "POST", ts.URL+"/login", "", `{"email":"user@gmail.com","password":"qwerty"}`); status != 200 || !strings.Contains(body, "mfa_token") {
+		t.Fatalf("MFA challenge expected once TOTP is confirmed but got [%v, %v]", status, body)
+	}
+
+	// code used for confirmation can't be replayed
+	if status, _ := doRequest(t, "DELETE", ts.URL+"/mfa/totp", testToken, `{"code":"`+code+`"}`); status != 400 {
+		t.Errorf("Used code mast not disable TOTP but got [%v]", status)
+	}
+
+	if status, body := doRequest(t, "DELETE", ts.URL+"/mfa/totp", testToken, `{"code":"`+codes.RecoveryCodes[0]+`"}`); status != 200 {
+		t.Fatalf("TOTP should be disabled but got [%v, %v]", status, body)
+	}
+
+	if _, err := s.GetTOTP(userId); err == nil {
+		t.Errorf("TOTP mast be deleted")
+	}
+}
+
+func TestDisableTOTP_CountsFailedCodes(t *testing.T) {
+
+	ts := newTestServer()
+	defer ts.Close()
+
+	server.RunningServer.Config.Login = config.LoginThrottleConfig{MaxFailures: 2, BaseDelay: config.Duration{Duration: time.Nanosecond},
+		Lockout: config.Duration{Duration: time.Hour}}
+
+	s.Store(testUser("user@gmail.com", "qwerty"))
+	s.StoreToken(testToken, userId.String())
+	withTOTP("abcd1234")
+
+	for i := 0; i < 2; i++ {
+		doRequest(t, "DELETE", ts.URL+"/mfa/totp", testToken, `{"code":"000000"}`)
+	}
+
+	if status, body := doRequest(t, "DELETE", ts.URL+"/mfa/totp", testToken, `{"code":"abcd1234"}`); status != 429 {
+		t.Errorf("Guessing codes with a session mast lock the account but got [%v, %v]", status, body)
+	}
+
+	if _, err := s.GetTOTP(userId); err != nil {
+		t.Errorf("TOTP mast stay enabled")
+	}
+}
+
+func TestTOTPEnrollment_ClientToken(t *testing.T) {
+
+	ts := newTestServer()
+	defer ts.Close()
+
+	s.Store(testUser("user@gmail.com", "qwerty"))
+	s.StoreToken(testClientToken, userId.String())
+
+	req, _ := http.NewRequest("POST", ts.URL+"/mfa/totp", nil)
+	req.Header.Set("Authorization", "Bearer "+testClientToken)
+
+	res, err := http.DefaultClient.Do(req)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 403 {
+		t.Errorf("Token of OAuth client mast not manage MFA but got [%v]", res.StatusCode)
+	}
+
+	if _, err := s.GetTOTP(userId); err == nil {
+		t.Errorf("TOTP mast not be created with token of OAuth client")
+	}
+}
+
+func TestOAuthAuthorize_MFA(t *testing.T) {
+
+	ts := newTestServer()
+	defer ts.Close()
+
+	withOAuthClients(s)
+	withTOTP()
+
+	b := &browser{t: t, base: ts.URL}
+	query := authorizeQuery(func(q url.Values) {})
+
+	res, body := b.do("POST", query, url.Values{"action": {"login"}, "email": {"user@gmail.com"}, "password": {"qwerty"}})
+
+	if res.StatusCode != 200 || !strings.Contains(body, `name="mfa_token"`) || b.cookie != nil {
+		t.Fatalf("MFA page without session expected but got [%v, %v]", res.StatusCode, body)
+	}
+
+	challenge := body[strings.Index(body, `name="mfa_token" value="`)+len(`name="mfa_token" value="`):]
+	challenge = challenge[:strings.Index(challenge, `"`)]
+
+	res, body = b.do("POST", query, url.Values{"action": {"mfa"}, "mfa_token": {challenge}, "code": {"000000"}})
+
+	if res.StatusCode != 401 || !strings.Contains(body, "Authentication code is invalid") || b.cookie != nil {
+		t.Fatalf("Login page with error expected but got [%v, %v]", res.StatusCode, body)
+	}
+
+	if a, err := s.GetLoginAttempts("account:user@gmail.com"); err != nil || a.Count != 1 {
+		t.Errorf("Wrong code mast count as failed login but got [%v, %v]", a, err)
+	}
+
+	_, body = b.do("POST", query, url.Values{"action": {"login"}, "email": {"user@gmail.com"}, "password": {"qwerty"}})
+
+	challenge = body[strings.Index(body, `name="mfa_token" value="`)+len(`name="mfa_token" value="`):]
+	challenge = challenge[:strings.Index(challenge, `"`)]
+
+	res, body = b.do("POST", query, url.Values{"action": {"mfa"}, "mfa_token": {challenge}, "code": {codeAt(t, totp.Step(time.Now()))}})
+
+	if res.StatusCode != 200 || !strings.Contains(body, "Mobile App wants to access your account") || b.cookie == nil {
+		t.Fatalf("Consent page with session expected but got [%v, %v]", res.StatusCode, body)
+	}
+}
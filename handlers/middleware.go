@@ -0,0 +1,216 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/urfave/negroni"
+
+	"authService/model"
+	"authService/server"
+)
+
+var (
+	errNotLoggedIn    = errors.New("User not logged in")
+	errInvalidToken   = errors.New("Token is invalid")
+	errNoToken        = errors.New("No access token provided")
+	errMultipleTokens = errors.New("Access token has to be sent with one method only")
+	errMalformedBody  = errors.New("Request body is not a JSON object")
+	errClientToken    = errors.New("Permissions are not granted to OAuth client tokens")
+)
+
+type tokenRequest struct {
+	AccessToken string `json:"access_token"`
+}
+
+type contextKey int
+
+const principalKey contextKey = 0
+
+// Principal is a logged in user the request is made on behalf of
+type Principal struct {
+	User   *model.User
+	Claims *model.TokenClaims
+	Token  string
+}
+
+// GetPrincipal returns user authenticated by Authorize middleware or nil for public routes
+func GetPrincipal(r *http.Request) *Principal {
+	p, _ := r.Context().Value(principalKey).(*Principal)
+	return p
+}
+
+// Protect wraps handler into Authorize middleware, so permissions are declared where the route is registered:
+//
+//	router.Handle("/admin/users/{id}", handlers.Protect(handlers.GetUser, "users:read")).Methods("GET")
+func Protect(h negroni.HandlerFunc, permissions ...string) *negroni.Negroni {
+	return negroni.New(Authorize(permissions...), h)
+}
+
+// Authorize lets request through only with valid bearer token of logged in user whose roles grant
+// every required permission. Roles are read from storage, so revoked roles take effect before token expires.
+// Tokens issued to OAuth clients pass only where no permission is required
+func Authorize(permissions ...string) negroni.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+
+		token, ok := bearerToken(r)
+
+		if !ok {
+			unauthorized(rw, "", "No bearer token provided")
+			return
+		}
+
+		pt, err := verifyAccessToken(token)
+
+		if err != nil {
+			unauthorized(rw, "invalid_token", err.Error())
+			return
+		}
+
+		u, claims := getUserForToken(pt)
+
+		if u == nil {
+			unauthorized(rw, "invalid_token", "User not found")
+			return
+		}
+
+		if err := accountError(u); err != nil {
+			unauthorized(rw, "invalid_token", err.Error())
+			return
+		}
+
+		// permissions are granted to the user, not to OAuth clients the user signed in to
+		if len(permissions) > 0 && claims != nil && claims.ClientId != "" {
+			prepareErrorResponse(rw, model.AuthError{ErrorCode: "Forbidden", Reason: errClientToken.Error()}, http.StatusForbidden)
+			return
+		}
+
+		if allowed, missing := server.RunningServer.Access.Allows(u.Roles, permissions...); !allowed {
+			prepareErrorResponse(rw, model.AuthError{ErrorCode: "Forbidden", Reason: "Missing permission " + missing}, http.StatusForbidden)
+			return
+		}
+
+		p := &Principal{User: u, Claims: claims, Token: token}
+
+		next(rw, r.WithContext(context.WithValue(r.Context(), principalKey, p)))
+	}
+}
+
+// verifyAccessToken accepts only valid tokens of logged in users
+func verifyAccessToken(token string) (*jwt.Token, error) {
+
+	if !server.RunningServer.SessionStore.IsTokenPresent(token) {
+		return nil, errNotLoggedIn
+	}
+
+	pt, err := server.RunningServer.Tokenizer.ParceAndVerifyToken(token)
+
+	if err != nil || !pt.Valid {
+		return nil, errInvalidToken
+	}
+
+	return pt, nil
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+
+	h := r.Header.Get("Authorization")
+
+	if len(h) < 7 || !strings.EqualFold(h[:7], "Bearer ") {
+		return "", false
+	}
+
+	token := strings.TrimSpace(h[7:])
+
+	return token, token != ""
+}
+
+// accessToken reads access token from Authorization header, access_token field of JSON body or cookie named
+// in Token.Cookie. RFC 6750 allows one method per request, so header together with body field is rejected.
+// Browsers send cookies along with everything, so the cookie is used only when neither of them is present
+func accessToken(r *http.Request) (string, error) {
+
+	header, hasHeader := bearerToken(r)
+
+	body, err := bodyToken(r)
+
+	if err != nil {
+		return "", err
+	}
+
+	switch {
+	case hasHeader && body != "":
+		return "", errMultipleTokens
+	case hasHeader:
+		return header, nil
+	case body != "":
+		return body, nil
+	}
+
+	if name := server.RunningServer.Config.Token.Cookie; name != "" {
+		if c, err := r.Cookie(name); err == nil && c.Value != "" {
+			return c.Value, nil
+		}
+	}
+
+	return "", errNoToken
+}
+
+func bodyToken(r *http.Request) (string, error) {
+
+	b, err := ioutil.ReadAll(r.Body)
+
+	if err != nil {
+		return "", errMalformedBody
+	}
+
+	if len(bytes.TrimSpace(b)) == 0 {
+		return "", nil
+	}
+
+	req := tokenRequest{}
+	if err := json.Unmarshal(b, &req); err != nil {
+		return "", errMalformedBody
+	}
+
+	return strings.TrimSpace(req.AccessToken), nil
+}
+
+// writeTokenError responds to request accessToken found no usable token in
+func writeTokenError(rw http.ResponseWriter, err error) {
+
+	if err == errNoToken {
+		unauthorized(rw, "", err.Error())
+		return
+	}
+
+	rw.Header().Set("WWW-Authenticate", bearerChallenge("invalid_request", err.Error()))
+	prepareErrorResponse(rw, model.AuthError{ErrorCode: "Bad Request", Reason: err.Error()}, http.StatusBadRequest)
+}
+
+// unauthorized responds with 401 and RFC 6750 challenge, errorCode is empty when no token was provided
+func unauthorized(rw http.ResponseWriter, errorCode, reason string) {
+
+	rw.Header().Set("WWW-Authenticate", bearerChallenge(errorCode, reason))
+	prepareErrorResponse(rw, model.AuthError{ErrorCode: "Unauthorized", Reason: reason}, http.StatusUnauthorized)
+}
+
+// bearerChallenge is WWW-Authenticate value of RFC 6750. Request without token gets bare challenge,
+// as it is not an error but a missing authentication
+func bearerChallenge(errorCode, description string) string {
+
+	if errorCode == "" {
+		return "Bearer"
+	}
+
+	// quotes and backslashes are not allowed in error_description
+	description = strings.NewReplacer(`"`, "", `\`, "").Replace(description)
+
+	return `Bearer error="` + errorCode + `", error_description="` + description + `"`
+}
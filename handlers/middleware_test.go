@@ -0,0 +1,360 @@
+package handlers_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/rs/xid"
+
+	"authService/access"
+	"authService/config"
+	"authService/handlers"
+	"authService/model"
+	"authService/opaque"
+	"authService/server"
+	"authService/storage"
+)
+
+func newAdminRouter() *mux.Router {
+	router := mux.NewRouter()
+	router.Handle("/admin/users/{id}", handlers.Protect(handlers.GetUser, "users:read")).Methods("GET")
+	router.Handle("/admin/users/{id}/roles", handlers.Protect(handlers.SetUserRoles, "users:write")).Methods("PUT")
+	router.Handle("/admin/users/{id}/status", handlers.Protect(handlers.SetUserStatus, "users:write")).Methods("PUT")
+	return router
+}
+
+func withRoles(roles ...string) func(s storage.Store) {
+	return func(s storage.Store) {
+		u := testUser("admin@gmail.com", "qwerty")
+		u.Roles = roles
+		s.Store(u)
+		s.StoreToken(testToken, userId.String())
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+
+	superiorId, _ := xid.FromString("bfra5o2cc8imh64se1t0")
+
+	withSuperior := func(s storage.Store) {
+		withRoles("manager")(s)
+		s.Store(model.User{Id: superiorId, Credentials: &model.Credentilas{Email: "root@gmail.com"}, Active: true, EmailVerified: true, Roles: []string{"admin"}})
+	}
+
+	tests := []struct {
+		description    string
+		method         string
+		path           string
+		authorization  string
+		requestBody    string
+		expectedBody   string
+		expectedCode   int
+		expectedHeader string
+		storeInitter   func(s storage.Store)
+	}{
+		{
+			description:    "Should reject request without bearer token",
+			method:         "GET",
+			path:           "/admin/users/" + userId.String(),
+			expectedBody:   `{"error":"Unauthorized","reason":"No bearer token provided"}`,
+			expectedCode:   401,
+			expectedHeader: "Bearer",
+			storeInitter:   withRoles("admin"),
+		},
+		{
+			description:    "Should reject token of logged out user",
+			method:         "GET",
+			path:           "/admin/users/" + userId.String(),
+			authorization:  "Bearer " + testToken,
+			expectedBody:   `{"error":"Unauthorized","reason":"User not logged in"}`,
+			expectedCode:   401,
+			expectedHeader: `Bearer error="invalid_token", error_description="User not logged in"`,
+			storeInitter: func(s storage.Store) {
+				s.Store(testUser("admin@gmail.com", "qwerty"))
+			},
+		},
+		{
+			description:    "Should reject invalid token",
+			method:         "GET",
+			path:           "/admin/users/" + userId.String(),
+			authorization:  "Bearer wrong",
+			expectedBody:   `{"error":"Unauthorized","reason":"Token is invalid"}`,
+			expectedCode:   401,
+			expectedHeader: `Bearer error="invalid_token", error_description="Token is invalid"`,
+			storeInitter: func(s storage.Store) {
+				s.StoreToken("wrong", userId.String())
+			},
+		},
+		{
+			description:    "Should reject token of unknown user",
+			method:         "GET",
+			path:           "/admin/users/" + userId.String(),
+			authorization:  "Bearer " + testToken,
+			expectedBody:   `{"error":"Unauthorized","reason":"User not found"}`,
+			expectedCode:   401,
+			expectedHeader: `Bearer error="invalid_token", error_description="User not found"`,
+			storeInitter: func(s storage.Store) {
+				s.StoreToken(testToken, userId.String())
+			},
+		},
+		{
+			description:    "Should not resolve client token to a user",
+			method:         "GET",
+			path:           "/admin/users/" + userId.String(),
+			authorization:  "Bearer " + testServiceToken,
+			expectedBody:   `{"error":"Unauthorized","reason":"User not found"}`,
+			expectedCode:   401,
+			expectedHeader: `Bearer error="invalid_token", error_description="User not found"`,
+			storeInitter: func(s storage.Store) {
+				withRoles("admin")(s)
+				s.StoreToken(testServiceToken, "gateway")
+			},
+		},
+		{
+			description:   "Should forbid user without required permission",
+			method:        "GET",
+			path:          "/admin/users/" + userId.String(),
+			authorization: "Bearer " + testToken,
+			expectedBody:  `{"error":"Forbidden","reason":"Missing permission users:read"}`,
+			expectedCode:  403,
+			storeInitter:  withRoles("user"),
+		},
+		{
+			description:   "Should forbid write with read permission only",
+			method:        "PUT",
+			path:          "/admin/users/" + userId.String() + "/roles",
+			authorization: "Bearer " + testToken,
+			requestBody:   `{"roles":["admin"]}`,
+			expectedBody:  `{"error":"Forbidden","reason":"Missing permission users:write"}`,
+			expectedCode:  403,
+			storeInitter:  withRoles("support"),
+		},
+		{
+			description:   "Should forbid token issued to OAuth client on admin route",
+			method:        "GET",
+			path:          "/admin/users/" + userId.String(),
+			authorization: "Bearer " + testClientToken,
+			expectedBody:  `{"error":"Forbidden","reason":"Permissions are not granted to OAuth client tokens"}`,
+			expectedCode:  403,
+			storeInitter: func(s storage.Store) {
+				withRoles("admin")(s)
+				s.StoreToken(testClientToken, userId.String())
+			},
+		},
+		{
+			description:   "Should return user for permitted request",
+			method:        "GET",
+			path:          "/admin/users/" + userId.String(),
+			authorization: "bearer " + testToken,
+			expectedBody:  `{"id":"bfra5o2cc8imh64se1s0","credentials":{"email":"admin@gmail.com"},"active":true,"banned":false,"email_verified":true,"roles":["support"]}`,
+			expectedCode:  200,
+			storeInitter:  withRoles("support"),
+		},
+		{
+			description:   "Should return not found for unknown user",
+			method:        "GET",
+			path:          "/admin/users/bfra5o2cc8imh64se1sg",
+			authorization: "Bearer " + testToken,
+			expectedBody:  `{"error":"Not Found","reason":"User not found"}`,
+			expectedCode:  404,
+			storeInitter:  withRoles("admin"),
+		},
+		{
+			description:    "Should reject token of banned user",
+			method:         "GET",
+			path:           "/admin/users/" + userId.String(),
+			authorization:  "Bearer " + testToken,
+			expectedBody:   `{"error":"Unauthorized","reason":"Account is banned"}`,
+			expectedCode:   401,
+			expectedHeader: `Bearer error="invalid_token", error_description="Account is banned"`,
+			storeInitter: func(s storage.Store) {
+				u := testUser("admin@gmail.com", "qwerty")
+				u.Roles = []string{"admin"}
+				u.Banned = true
+				s.Store(u)
+				s.StoreToken(testToken, userId.String())
+			},
+		},
+		{
+			description:   "Should return bad request for unprocesable status",
+			method:        "PUT",
+			path:          "/admin/users/" + userId.String() + "/status",
+			authorization: "Bearer " + testToken,
+			requestBody:   `"banned":true`,
+			expectedBody:  `{"error":"Bad Request","reason":"Cant retrive status"}`,
+			expectedCode:  400,
+			storeInitter:  withRoles("admin"),
+		},
+		{
+			description:   "Should reject unknown role",
+			method:        "PUT",
+			path:          "/admin/users/" + userId.String() + "/roles",
+			authorization: "Bearer " + testToken,
+			requestBody:   `{"roles":["support","root"]}`,
+			expectedBody:  `{"error":"Bad Request","reason":"Unknown role root"}`,
+			expectedCode:  400,
+			storeInitter:  withRoles("admin"),
+		},
+		{
+			description:   "Should forbid giving role with permissions caller does not have",
+			method:        "PUT",
+			path:          "/admin/users/" + userId.String() + "/roles",
+			authorization: "Bearer " + testToken,
+			requestBody:   `{"roles":["admin"]}`,
+			expectedBody:  `{"error":"Forbidden","reason":"Missing permission *"}`,
+			expectedCode:  403,
+			storeInitter:  withRoles("manager"),
+		},
+		{
+			description:   "Should let caller give role with permissions it has",
+			method:        "PUT",
+			path:          "/admin/users/" + userId.String() + "/roles",
+			authorization: "Bearer " + testToken,
+			requestBody:   `{"roles":["support","manager"]}`,
+			expectedBody:  `{"id":"bfra5o2cc8imh64se1s0","credentials":{"email":"admin@gmail.com"},"active":true,"banned":false,"email_verified":true,"roles":["support","manager"]}`,
+			expectedCode:  200,
+			storeInitter:  withRoles("manager"),
+		},
+		{
+			description:   "Should forbid demoting user with permissions caller does not have",
+			method:        "PUT",
+			path:          "/admin/users/" + superiorId.String() + "/roles",
+			authorization: "Bearer " + testToken,
+			requestBody:   `{"roles":["support"]}`,
+			expectedBody:  `{"error":"Forbidden","reason":"Missing permission *"}`,
+			expectedCode:  403,
+			storeInitter:  withSuperior,
+		},
+		{
+			description:   "Should forbid banning user with permissions caller does not have",
+			method:        "PUT",
+			path:          "/admin/users/" + superiorId.String() + "/status",
+			authorization: "Bearer " + testToken,
+			requestBody:   `{"banned":true}`,
+			expectedBody:  `{"error":"Forbidden","reason":"Missing permission *"}`,
+			expectedCode:  403,
+			storeInitter:  withSuperior,
+		},
+		{
+			description:   "Should change user roles",
+			method:        "PUT",
+			path:          "/admin/users/" + userId.String() + "/roles",
+			authorization: "Bearer " + testToken,
+			requestBody:   `{"roles":["support"],"groups":["staff"]}`,
+			expectedBody:  `{"id":"bfra5o2cc8imh64se1s0","credentials":{"email":"admin@gmail.com"},"active":true,"banned":false,"email_verified":true,"roles":["support"],"groups":["staff"]}`,
+			expectedCode:  200,
+			storeInitter:  withRoles("admin"),
+		},
+	}
+
+	server.RunningServer = &server.Server{
+		Tokenizer: &TestTokenizer{secret: "my_test_sercert"},
+		Access: access.NewPolicy(config.AccessConfig{Roles: map[string][]string{
+			"admin":   {access.AllPermissions},
+			"support": {"users:read"},
+			"manager": {"users:read", "users:write"},
+		}}),
+	}
+
+	ts := httptest.NewServer(newAdminRouter())
+	defer ts.Close()
+
+	for _, tc := range tests {
+
+		t.Run(tc.description, func(t *testing.T) {
+			testSetToDefault()
+			tc.storeInitter(s)
+
+			req, _ := http.NewRequest(tc.method, ts.URL+tc.path, strings.NewReader(tc.requestBody))
+			if tc.authorization != "" {
+				req.Header.Set("Authorization", tc.authorization)
+			}
+
+			res, err := http.DefaultClient.Do(req)
+
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			defer res.Body.Close()
+
+			b, _ := ioutil.ReadAll(res.Body)
+
+			if !IsEqualJson(string(b), tc.expectedBody) {
+				t.Errorf("Wrong response body. Expected: [%v] Actual: [%v]", tc.expectedBody, string(b))
+			}
+
+			if res.StatusCode != tc.expectedCode {
+				t.Errorf("Wrong response status code. Expected: [%v] Actual: [%v]", tc.expectedCode, res.StatusCode)
+			}
+
+			if h := res.Header.Get("WWW-Authenticate"); h != tc.expectedHeader {
+				t.Errorf("Wrong WWW-Authenticate header. Expected: [%v] Actual: [%v]", tc.expectedHeader, h)
+			}
+		})
+	}
+
+	if u, _ := s.GetUserById(userId.String()); len(u.Roles) != 1 || u.Roles[0] != "support" {
+		t.Errorf("Changed roles mast be stored but got %v", u.Roles)
+	}
+}
+
+func TestSetUserStatusRevokesSessions(t *testing.T) {
+
+	bannedId, _ := xid.FromString("bfra5o2cc8imh64se1sg")
+
+	server.RunningServer = &server.Server{
+		Tokenizer: &TestTokenizer{secret: "my_test_sercert"},
+		Access:    access.NewPolicy(config.AccessConfig{Roles: map[string][]string{"admin": {access.AllPermissions}}}),
+	}
+
+	ts := httptest.NewServer(newAdminRouter())
+	defer ts.Close()
+
+	testSetToDefault()
+	withRoles("admin")(s)
+	s.Store(model.User{Id: bannedId, Credentials: &model.Credentilas{Email: "banned@gmail.com"}, Active: true, EmailVerified: true})
+	s.StoreToken("banned session", bannedId.String())
+	s.StoreRefreshToken(model.RefreshToken{Id: opaque.Hash("banned refresh"), Family: "family", UserId: bannedId, ExpiresAt: time.Now().Add(time.Hour)})
+
+	req, _ := http.NewRequest("PUT", ts.URL+"/admin/users/"+bannedId.String()+"/status", strings.NewReader(`{"banned":true}`))
+	req.Header.Set("Authorization", "Bearer "+testToken)
+
+	res, err := http.DefaultClient.Do(req)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer res.Body.Close()
+
+	b, _ := ioutil.ReadAll(res.Body)
+	expected := `{"id":"bfra5o2cc8imh64se1sg","credentials":{"email":"banned@gmail.com"},"active":true,"banned":true,"email_verified":true}`
+
+	if !IsEqualJson(string(b), expected) {
+		t.Errorf("Wrong response body. Expected: [%v] Actual: [%v]", expected, string(b))
+	}
+
+	if res.StatusCode != 200 {
+		t.Errorf("Wrong response status code. Expected: [%v] Actual: [%v]", 200, res.StatusCode)
+	}
+
+	if u, _ := s.GetUserById(bannedId.String()); !u.Banned {
+		t.Errorf("Ban mast be stored")
+	}
+
+	if s.IsTokenPresent("banned session") {
+		t.Errorf("Sessions of banned user mast be deleted")
+	}
+
+	if _, err := s.UseRefreshToken(opaque.Hash("banned refresh")); err == nil {
+		t.Errorf("Refresh tokens of banned user mast be revoked")
+	}
+
+	if !s.IsTokenPresent(testToken) {
+		t.Errorf("Sessions of other users mast be kept")
+	}
+}
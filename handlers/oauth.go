@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"authService/model"
+	"authService/opaque"
+	"authService/server"
+)
+
+// OAuthToken is token endpoint of RFC 6749, it exchanges authorization codes for access tokens
+// and issues tokens to confidential clients acting on their own behalf with client credentials
+func OAuthToken(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+
+	switch r.PostFormValue("grant_type") {
+	case "authorization_code":
+		exchangeAuthorizationCode(rw, r)
+	case "client_credentials":
+		issueClientToken(rw, r)
+	default:
+		oauthErrorResponse(rw, &oauthError{"unsupported_grant_type", "Grant type is not supported"}, http.StatusBadRequest)
+	}
+}
+
+func exchangeAuthorizationCode(rw http.ResponseWriter, r *http.Request) {
+
+	client, err := tokenClient(r)
+
+	if err != nil {
+		invalidClient(rw)
+		return
+	}
+
+	codeHash := opaque.Hash(r.PostFormValue("code"))
+	code, err := server.RunningServer.CodeStore.UseAuthorizationCode(codeHash)
+
+	if err != nil {
+		oauthErrorResponse(rw, &oauthError{"invalid_grant", "Invalid authorization code"}, http.StatusBadRequest)
+		return
+	}
+
+	// replayed code may be stolen, token already issued for it is revoked as RFC 6749 4.1.2 advises
+	if code.Used {
+		logger.Printf("Authorization code reuse detected for client %v and user %v", code.ClientId, code.UserId)
+		revokeCodeToken(code)
+	}
+
+	switch {
+	case code.Used, time.Now().After(code.ExpiresAt), code.ClientId != client.Id:
+		oauthErrorResponse(rw, &oauthError{"invalid_grant", "Invalid authorization code"}, http.StatusBadRequest)
+		return
+	// redirect_uri given to authorize endpoint must be repeated exactly, RFC 6749 4.1.3
+	case code.RedirectURI != "" && code.RedirectURI != r.PostFormValue("redirect_uri"):
+		oauthErrorResponse(rw, &oauthError{"invalid_grant", "Redirect URI does not match authorization request"}, http.StatusBadRequest)
+		return
+	case !verifyPKCE(r.PostFormValue("code_verifier"), code.CodeChallenge):
+		oauthErrorResponse(rw, &oauthError{"invalid_grant", "PKCE verification failed"}, http.StatusBadRequest)
+		return
+	}
+
+	u, err := server.RunningServer.UserStore.GetUserById(code.UserId.String())
+
+	if err != nil {
+		oauthErrorResponse(rw, &oauthError{"invalid_grant", "User not found"}, http.StatusBadRequest)
+		return
+	}
+
+	if err := accountError(u); err != nil {
+		oauthErrorResponse(rw, &oauthError{"invalid_grant", err.Error()}, http.StatusBadRequest)
+		return
+	}
+
+	token, err := server.RunningServer.Tokenizer.GenerateTokenFor(u, client.Id, code.Scope)
+
+	if err == nil {
+		err = server.RunningServer.SessionStore.StoreToken(token, u.Id.String())
+	}
+
+	if err == nil {
+		err = server.RunningServer.CodeStore.SetAuthorizationCodeToken(codeHash, token)
+	}
+
+	if err != nil {
+		oauthErrorResponse(rw, &oauthError{"server_error", "Error during token generation"}, http.StatusInternalServerError)
+		return
+	}
+
+	resp := model.TokenResponse{AccessToken: token, Scope: code.Scope}
+
+	if contains(strings.Fields(code.Scope), openIDScope) {
+		resp.IdToken, err = server.RunningServer.Tokenizer.GenerateIdToken(u, client.Id, code.Nonce, code.AuthTime, token)
+
+		if err != nil {
+			oauthErrorResponse(rw, &oauthError{"server_error", "Error during ID token generation"}, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	logger.Printf("Authorization code exchanged by client %v for user %v", client.Id, u.Id)
+
+	writeTokenResponse(rw, resp)
+}
+
+func revokeCodeToken(code *model.AuthorizationCode) {
+
+	sStore := server.RunningServer.SessionStore
+
+	if code.AccessToken != "" && sStore.IsTokenPresent(code.AccessToken) {
+		sStore.DeleteToken(code.AccessToken)
+	}
+}
+
+// issueClientToken is client credentials grant of RFC 6749 4.4, client gets requested scopes it is allowed
+// or every allowed scope when none is requested. No refresh token is issued, client just asks again
+func issueClientToken(rw http.ResponseWriter, r *http.Request) {
+
+	client, err := authenticateClient(r)
+
+	if err != nil {
+		invalidClient(rw)
+		return
+	}
+
+	scopes := strings.Fields(r.PostFormValue("scope"))
+
+	if len(scopes) == 0 {
+		scopes = client.Scopes
+	}
+
+	if !containsAll(client.Scopes, scopes) {
+		oauthErrorResponse(rw, &oauthError{"invalid_scope", "Requested scope is not allowed for the client"}, http.StatusBadRequest)
+		return
+	}
+
+	scope := strings.Join(scopes, " ")
+
+	token, err := server.RunningServer.Tokenizer.GenerateClientToken(client, scope)
+
+	if err == nil {
+		err = server.RunningServer.SessionStore.StoreToken(token, client.Id)
+	}
+
+	if err != nil {
+		oauthErrorResponse(rw, &oauthError{"server_error", "Error during token generation"}, http.StatusInternalServerError)
+		return
+	}
+
+	logger.Printf("Token issued to client %v with scope %q", client.Id, scope)
+
+	writeTokenResponse(rw, model.TokenResponse{AccessToken: token, Scope: scope})
+}
+
+// tokenClient authenticates confidential clients, public clients only identify themselves with client_id
+func tokenClient(r *http.Request) (*model.Client, error) {
+
+	if _, _, ok := r.BasicAuth(); ok || r.PostFormValue("client_secret") != "" {
+		return authenticateClient(r)
+	}
+
+	c, err := server.RunningServer.ClientStore.GetClient(r.PostFormValue("client_id"))
+
+	if err != nil || !c.Public {
+		return nil, errInvalidClient
+	}
+
+	return c, nil
+}
+
+func oauthErrorResponse(rw http.ResponseWriter, e *oauthError, status int) {
+	rw.Header().Set("Cache-Control", "no-store")
+	prepareErrorResponse(rw, model.AuthError{ErrorCode: e.Code, Reason: e.Description}, status)
+}
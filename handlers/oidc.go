@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"authService/model"
+	"authService/server"
+)
+
+const (
+	openIDScope  = "openid"
+	profileScope = "profile"
+	emailScope   = "email"
+)
+
+// supportedScopes are scopes of user claims, every client may ask users for them
+var supportedScopes = []string{openIDScope, profileScope, emailScope}
+
+// Discovery serves OpenID Connect provider metadata, endpoints are published under configured issuer
+func Discovery(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+
+	keys, err := server.RunningServer.Tokenizer.JWKS()
+
+	if err != nil {
+		prepareErrorResponse(rw, model.AuthError{ErrorCode: "Service Unavailable", Reason: "Signing keys are not available"}, http.StatusServiceUnavailable)
+		return
+	}
+
+	algorithms := []string{}
+	for _, k := range keys.Keys {
+		if k.Alg != "" && !contains(algorithms, k.Alg) {
+			algorithms = append(algorithms, k.Alg)
+		}
+	}
+
+	issuer := server.RunningServer.Config.Token.Issuer
+	base := strings.TrimSuffix(issuer, "/")
+
+	resp, err := json.Marshal(model.ProviderMetadata{
+		Issuer:                            issuer,
+		AuthorizationEndpoint:             base + "/authorize",
+		TokenEndpoint:                     base + "/token",
+		UserinfoEndpoint:                  base + "/userinfo",
+		JwksURI:                           base + "/.well-known/jwks.json",
+		IntrospectionEndpoint:             base + "/introspect",
+		ScopesSupported:                   supportedScopes,
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               []string{"authorization_code", "client_credentials"},
+		SubjectTypesSupported:             []string{"public"},
+		IdTokenSigningAlgValuesSupported:  algorithms,
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{"S256"},
+		ClaimsSupported:                   []string{"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce", "at_hash", "email", "preferred_username"},
+	})
+
+	if err != nil {
+		prepareErrorResponse(rw, model.AuthError{ErrorCode: "Internal Server Error", Reason: err.Error()}, http.StatusInternalServerError)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("Cache-Control", "public, max-age=3600")
+	rw.Write(resp)
+}
+
+// UserInfo is OpenID Connect userinfo endpoint, it has to be wrapped into Authorize middleware.
+// Claims are released according to scope of client tokens, first party tokens have no scope and get every claim
+func UserInfo(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+
+	p := GetPrincipal(r)
+
+	if p == nil {
+		unauthorized(rw, "", "No bearer token provided")
+		return
+	}
+
+	info := model.UserInfo{Sub: p.User.Id.String()}
+
+	scopes := strings.Fields(p.Claims.Scope)
+	firstParty := p.Claims.ClientId == ""
+
+	if p.User.Credentials != nil {
+		if firstParty || contains(scopes, emailScope) {
+			info.Email = p.User.Credentials.Email
+		}
+		if firstParty || contains(scopes, profileScope) {
+			info.PreferredUsername = p.User.Credentials.Email
+		}
+	}
+
+	resp, err := json.Marshal(info)
+
+	if err != nil {
+		prepareErrorResponse(rw, model.AuthError{ErrorCode: "Internal Server Error", Reason: err.Error()}, http.StatusInternalServerError)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("Cache-Control", "no-store")
+	rw.Write(resp)
+}
@@ -0,0 +1,133 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"authService/access"
+	"authService/config"
+	"authService/handlers"
+	"authService/model"
+	"authService/server"
+)
+
+func TestDiscovery(t *testing.T) {
+
+	tests := []struct {
+		description    string
+		issuer         string
+		expectedIssuer string
+		expectedToken  string
+	}{
+		{
+			description:    "Should publish endpoints under configured issuer",
+			issuer:         "https://auth.example.com/",
+			expectedIssuer: "https://auth.example.com/",
+			expectedToken:  "https://auth.example.com/token",
+		},
+		{
+			description:    "Should publish endpoints under configured issuer without trailing slash",
+			issuer:         "https://auth.example.com",
+			expectedIssuer: "https://auth.example.com",
+			expectedToken:  "https://auth.example.com/token",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+
+			server.RunningServer = &server.Server{
+				Config:    config.Configuration{Token: config.TokenConfig{Issuer: tc.issuer}},
+				Tokenizer: &TestTokenizer{secret: "my_test_sercert"},
+			}
+
+			req := httptest.NewRequest("GET", "http://auth.local:8081/.well-known/openid-configuration", nil)
+			rw := httptest.NewRecorder()
+
+			handlers.Discovery(rw, req, nil)
+
+			meta := model.ProviderMetadata{}
+			json.NewDecoder(rw.Body).Decode(&meta)
+
+			if rw.Code != 200 || meta.Issuer != tc.expectedIssuer || meta.TokenEndpoint != tc.expectedToken {
+				t.Errorf("Expected [%v, %v] but was: [%v, %v]", tc.expectedIssuer, tc.expectedToken, meta.Issuer, meta.TokenEndpoint)
+			}
+
+			if len(meta.ResponseTypesSupported) != 1 || meta.CodeChallengeMethodsSupported[0] != "S256" || meta.SubjectTypesSupported[0] != "public" {
+				t.Errorf("Unexpected provider metadata: %v", meta)
+			}
+		})
+	}
+}
+
+func TestUserInfo(t *testing.T) {
+
+	tests := []struct {
+		description  string
+		token        string
+		expectedBody string
+		expectedCode int
+	}{
+		{
+			description:  "Should release every claim to first party token",
+			token:        testToken,
+			expectedBody: `{"sub":"bfra5o2cc8imh64se1s0","email":"user@gmail.com","preferred_username":"user@gmail.com"}`,
+			expectedCode: 200,
+		},
+		{
+			description:  "Should release claims of client token scopes",
+			token:        testClientToken,
+			expectedBody: `{"sub":"bfra5o2cc8imh64se1s0","email":"user@gmail.com","preferred_username":"user@gmail.com"}`,
+			expectedCode: 200,
+		},
+		{
+			description:  "Should reject request without token",
+			expectedBody: `{"error":"Unauthorized","reason":"No bearer token provided"}`,
+			expectedCode: 401,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+
+			server.RunningServer = &server.Server{Tokenizer: &TestTokenizer{secret: "my_test_sercert"}, Access: access.NewPolicy(config.AccessConfig{})}
+			testSetToDefault()
+			s.Store(testUser("user@gmail.com", "qwerty"))
+			s.StoreToken(testToken, userId.String())
+			s.StoreToken(testClientToken, userId.String())
+
+			router := mux.NewRouter()
+			router.Handle("/userinfo", handlers.Protect(handlers.UserInfo)).Methods("GET", "POST")
+
+			ts := httptest.NewServer(router)
+			defer ts.Close()
+
+			req, _ := http.NewRequest("GET", ts.URL+"/userinfo", nil)
+			if tc.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tc.token)
+			}
+
+			res, err := http.DefaultClient.Do(req)
+
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			defer res.Body.Close()
+
+			b, _ := ioutil.ReadAll(res.Body)
+
+			if ok := IsEqualJson(string(b), tc.expectedBody); !ok {
+				t.Errorf("Wrong response body. Expected: [%v] Actual: [%v]", tc.expectedBody, string(b))
+			}
+
+			if res.StatusCode != tc.expectedCode {
+				t.Errorf("Wrong response status code. Expected: [%v] Actual: [%v]", tc.expectedCode, res.StatusCode)
+			}
+		})
+	}
+}
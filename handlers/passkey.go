@@ -0,0 +1,387 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	"authService/model"
+	"authService/opaque"
+	"authService/server"
+	"authService/storage"
+	"authService/webauthn"
+)
+
+const (
+	passkeyChallengeDuration = 5 * time.Minute
+	passkeyRegisterPurpose   = "webauthn-register"
+	passkeyLoginPurpose      = "webauthn-login"
+)
+
+var (
+	errInvalidChallenge  = errors.New("Challenge is invalid or expired")
+	errInvalidAssertion  = errors.New("Passkey assertion is invalid")
+	errUnknownPasskey    = errors.New("Passkey is not registered")
+	errCredentialMissing = errors.New("Credential id does not match attestation")
+	errPasskeyCloned     = errors.New("Passkey signature counter did not increase")
+	errUserNotVerified   = errors.New("Passkey did not verify user")
+)
+
+// reauthRequest proves the signed in user is present, code is needed when second factor is enabled
+type reauthRequest struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+// BeginPasskeyRegistration starts registration ceremony of a passkey for the signed in user. Passkey signs in
+// without password and second factor, so user enters them again and a stolen session alone can't add one
+func BeginPasskeyRegistration(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+
+	u, ok := firstPartyUser(rw, r)
+
+	if !ok {
+		return
+	}
+
+	req := &reauthRequest{}
+
+	if err := retriveCeremonyResponse(r, req); err != nil || req.Password == "" {
+		prepareErrorResponse(rw, model.AuthError{ErrorCode: "Bad Request", Reason: "Cant retrive password"}, http.StatusBadRequest)
+		return
+	}
+
+	if !reauthenticate(rw, r, u, req) {
+		return
+	}
+
+	credentials, err := server.RunningServer.WebAuthnStore.GetUserWebAuthnCredentials(u.Id)
+
+	if err != nil {
+		prepareErrorResponse(rw, model.AuthError{ErrorCode: "Internal Server Error", Reason: "Can't read passkeys"}, http.StatusInternalServerError)
+		return
+	}
+
+	challenge, err := newOneTimeToken(u.Id, passkeyRegisterPurpose, "", passkeyChallengeDuration)
+
+	if err != nil {
+		prepareErrorResponse(rw, model.AuthError{ErrorCode: "Internal Server Error", Reason: "Can't issue challenge"}, http.StatusInternalServerError)
+		return
+	}
+
+	rp := relyingParty()
+
+	writeNoStore(rw, webauthn.CreationOptions{
+		Challenge:          challenge,
+		RP:                 webauthn.RelyingPartyEntity{ID: rp.ID, Name: rp.Name},
+		User:               webauthn.UserEntity{ID: u.Id.Bytes(), Name: u.Credentials.Email, DisplayName: u.Credentials.Email},
+		PubKeyCredParams:   webauthn.NewCredentialParameters(),
+		Timeout:            int(passkeyChallengeDuration / time.Millisecond),
+		ExcludeCredentials: credentialDescriptors(credentials),
+		// login takes no user name, so only credentials the authenticator can discover by itself are useful
+		AuthenticatorSelection: webauthn.AuthenticatorSelection{ResidentKey: "required", UserVerification: "required"},
+		Attestation:            "none",
+	})
+}
+
+// reauthenticate checks password and second factor of the user, failures count as failed logins
+func reauthenticate(rw http.ResponseWriter, r *http.Request, u *model.User, req *reauthRequest) bool {
+
+	if wait, err := allowLogin(r, u.Credentials.Email); err != nil {
+		writeThrottled(rw, wait, err)
+		return false
+	}
+
+	if ok, err := verifyPassword(u, req.Password); err != nil || !ok {
+		loginFailed(u.Credentials.Email)
+		prepareErrorResponse(rw, model.AuthError{ErrorCode: "Forbidden", Reason: "Wrong credentials"}, http.StatusForbidden)
+		return false
+	}
+
+	mfa, err := mfaEnabled(u)
+
+	if err != nil {
+		prepareErrorResponse(rw, model.AuthError{ErrorCode: "Internal Server Error", Reason: "Can't check second factor"}, http.StatusInternalServerError)
+		return false
+	}
+
+	if mfa {
+		if err := verifySecondFactor(u, req.Code); err != nil {
+			loginFailed(u.Credentials.Email)
+			prepareErrorResponse(rw, model.AuthError{ErrorCode: "Forbidden", Reason: err.Error()}, http.StatusForbidden)
+			return false
+		}
+	}
+
+	loginSucceeded(u.Credentials.Email)
+
+	return true
+}
+
+// FinishPasskeyRegistration verifies new credential made by authenticator and stores it for the signed in user
+func FinishPasskeyRegistration(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+
+	u, ok := firstPartyUser(rw, r)
+
+	if !ok {
+		return
+	}
+
+	res := &webauthn.RegistrationResponse{}
+
+	if err := retriveCeremonyResponse(r, res); err != nil {
+		prepareErrorResponse(rw, model.AuthError{ErrorCode: "Bad Request", Reason: "Cant retrive credential"}, http.StatusBadRequest)
+		return
+	}
+
+	c, err := registerPasskey(r, u, res)
+
+	switch {
+	case err == storage.ErrCredentialExists:
+		prepareErrorResponse(rw, model.AuthError{ErrorCode: "Conflict", Reason: err.Error()}, http.StatusConflict)
+		return
+	case err != nil:
+		prepareErrorResponse(rw, model.AuthError{ErrorCode: "Bad Request", Reason: err.Error()}, http.StatusBadRequest)
+		return
+	}
+
+	logger.Printf("Passkey %v registered for user %v", c.Id, u.Id)
+
+	resp, _ := json.Marshal(c)
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusCreated)
+	rw.Write(resp)
+}
+
+// BeginPasskeyLogin starts authentication ceremony. No user is named, authenticator offers passkeys it holds
+func BeginPasskeyLogin(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+
+	challenge, err := opaque.New()
+
+	if err == nil {
+		err = server.RunningServer.OneTimeStore.StoreOneTimeToken(model.OneTimeToken{
+			Id:        opaque.Hash(challenge),
+			Purpose:   passkeyLoginPurpose,
+			ExpiresAt: time.Now().Add(passkeyChallengeDuration),
+		})
+	}
+
+	if err != nil {
+		prepareErrorResponse(rw, model.AuthError{ErrorCode: "Internal Server Error", Reason: "Can't issue challenge"}, http.StatusInternalServerError)
+		return
+	}
+
+	writeNoStore(rw, webauthn.RequestOptions{
+		Challenge:        challenge,
+		Timeout:          int(passkeyChallengeDuration / time.Millisecond),
+		RPID:             relyingParty().ID,
+		AllowCredentials: []webauthn.CredentialDescriptor{},
+		UserVerification: "required",
+	})
+}
+
+// FinishPasskeyLogin verifies assertion of a registered passkey and issues tokens like Login does.
+// Passkey is phishing resistant and bound to a device and the authenticator must verify user with PIN
+// or biometrics, so no second factor is asked for
+func FinishPasskeyLogin(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+
+	res := &webauthn.AuthenticationResponse{}
+
+	if err := retriveCeremonyResponse(r, res); err != nil {
+		prepareErrorResponse(rw, model.AuthError{ErrorCode: "Bad Request", Reason: "Cant retrive assertion"}, http.StatusBadRequest)
+		return
+	}
+
+	u, err := verifyPasskey(r, res)
+
+	if err != nil {
+		prepareErrorResponse(rw, model.AuthError{ErrorCode: "Unauthorized", Reason: err.Error()}, http.StatusUnauthorized)
+		return
+	}
+
+	if err := accountError(u); err != nil {
+		prepareErrorResponse(rw, model.AuthError{ErrorCode: accountErrorCode(err), Reason: err.Error()}, http.StatusForbidden)
+		return
+	}
+
+	issueLoginTokens(rw, u)
+}
+
+func registerPasskey(r *http.Request, u *model.User, res *webauthn.RegistrationResponse) (*model.WebAuthnCredential, error) {
+
+	rp := relyingParty()
+
+	c, err := webauthn.ParseClientData(res.Response.ClientDataJSON, webauthn.TypeCreate)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := rp.VerifyOrigin(c); err != nil {
+		return nil, err
+	}
+
+	owner, err := useOneTimeToken(c.Challenge, passkeyRegisterPurpose, func(*model.User) string { return "" })
+
+	if err != nil || owner.Id != u.Id {
+		return nil, errInvalidChallenge
+	}
+
+	d, err := webauthn.ParseAttestationObject(res.Response.AttestationObject)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := rp.VerifyAuthenticatorData(d); err != nil {
+		return nil, err
+	}
+
+	// login relies on user verification, a passkey made without it would not be usable
+	if d.Flags&webauthn.FlagUserVerified == 0 {
+		return nil, errUserNotVerified
+	}
+
+	if !bytes.Equal(d.CredentialId, res.RawID) {
+		return nil, errCredentialMissing
+	}
+
+	if _, _, err := webauthn.ParsePublicKey(d.PublicKey); err != nil {
+		return nil, err
+	}
+
+	credential := model.WebAuthnCredential{
+		Id:        base64.RawURLEncoding.EncodeToString(d.CredentialId),
+		UserId:    u.Id,
+		PublicKey: d.PublicKey,
+		SignCount: d.SignCount,
+		CreatedAt: time.Now(),
+	}
+
+	if err := server.RunningServer.WebAuthnStore.SaveWebAuthnCredential(credential); err != nil {
+		return nil, err
+	}
+
+	return &credential, nil
+}
+
+// verifyPasskey returns owner of the passkey which signed the assertion
+func verifyPasskey(r *http.Request, res *webauthn.AuthenticationResponse) (*model.User, error) {
+
+	rp := relyingParty()
+	store := server.RunningServer.WebAuthnStore
+
+	c, err := webauthn.ParseClientData(res.Response.ClientDataJSON, webauthn.TypeGet)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := rp.VerifyOrigin(c); err != nil {
+		return nil, err
+	}
+
+	t, err := server.RunningServer.OneTimeStore.UseOneTimeToken(opaque.Hash(c.Challenge), passkeyLoginPurpose)
+
+	if err != nil || t.Used || time.Now().After(t.ExpiresAt) {
+		return nil, errInvalidChallenge
+	}
+
+	credential, err := store.GetWebAuthnCredential(base64.RawURLEncoding.EncodeToString(res.RawID))
+
+	if err != nil {
+		return nil, errUnknownPasskey
+	}
+
+	if len(res.Response.UserHandle) > 0 && !bytes.Equal(res.Response.UserHandle, credential.UserId.Bytes()) {
+		return nil, errInvalidAssertion
+	}
+
+	d, err := webauthn.ParseAuthenticatorData(res.Response.AuthenticatorData)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := rp.VerifyAuthenticatorData(d); err != nil {
+		return nil, err
+	}
+
+	err = webauthn.VerifyAssertion(credential.PublicKey, res.Response.AuthenticatorData, res.Response.ClientDataJSON, res.Response.Signature)
+
+	if err != nil {
+		return nil, errInvalidAssertion
+	}
+
+	// presence alone is a touch of whoever holds the device, verification stands in for second factor
+	if d.Flags&webauthn.FlagUserVerified == 0 {
+		return nil, errUserNotVerified
+	}
+
+	if err := store.UpdateSignCount(credential.Id, d.SignCount); err != nil {
+		if err == storage.ErrSignCountNotIncreased {
+			logger.Printf("Signature counter of passkey %v of user %v did not increase, authenticator may be cloned", credential.Id, credential.UserId)
+			return nil, errPasskeyCloned
+		}
+		return nil, err
+	}
+
+	u, err := server.RunningServer.UserStore.GetUserById(credential.UserId.String())
+
+	if err != nil {
+		return nil, errUnknownPasskey
+	}
+
+	return u, nil
+}
+
+// relyingParty is configured one, RP ID and origin default to the issuer
+func relyingParty() webauthn.RelyingParty {
+
+	c := server.RunningServer.Config.WebAuthn
+	rp := webauthn.RelyingParty{ID: c.RPID, Name: c.RPName, Origins: c.Origins}
+
+	if issuer, err := url.Parse(server.RunningServer.Config.Token.Issuer); err == nil {
+		if rp.ID == "" {
+			rp.ID = issuer.Hostname()
+		}
+		if len(rp.Origins) == 0 {
+			rp.Origins = []string{issuer.Scheme + "://" + issuer.Host}
+		}
+	}
+
+	if rp.Name == "" {
+		rp.Name = rp.ID
+	}
+
+	return rp
+}
+
+func credentialDescriptors(credentials []model.WebAuthnCredential) []webauthn.CredentialDescriptor {
+
+	descriptors := []webauthn.CredentialDescriptor{}
+
+	for _, c := range credentials {
+		if id, err := base64.RawURLEncoding.DecodeString(c.Id); err == nil {
+			descriptors = append(descriptors, webauthn.CredentialDescriptor{Type: "public-key", ID: id})
+		}
+	}
+
+	return descriptors
+}
+
+func retriveCeremonyResponse(r *http.Request, v interface{}) error {
+
+	b, err := ioutil.ReadAll(r.Body)
+
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, v)
+}
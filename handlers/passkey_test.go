@@ -0,0 +1,391 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"authService/storage"
+	"authService/totp"
+	"authService/webauthn"
+	"authService/webauthn/webauthntest"
+)
+
+const testOrigin = "https://auth.example.com"
+
+func beginRegistration(t *testing.T, base string) webauthn.CreationOptions {
+
+	status, body := doRequest(t, "POST", base+"/webauthn/register/begin", testToken, `{"password":"qwerty"}`)
+
+	options := webauthn.CreationOptions{}
+	json.Unmarshal([]byte(body), &options)
+
+	if status != 200 || options.Challenge == "" {
+		t.Fatalf("Creation options expected but got [%v, %v]", status, body)
+	}
+
+	return options
+}
+
+func beginLogin(t *testing.T, base string) webauthn.RequestOptions {
+
+	status, body := doRequest(t, "POST", base+"/webauthn/login/begin", "", nil)
+
+	options := webauthn.RequestOptions{}
+	json.Unmarshal([]byte(body), &options)
+
+	if status != 200 || options.Challenge == "" {
+		t.Fatalf("Request options expected but got [%v, %v]", status, body)
+	}
+
+	return options
+}
+
+// registeredAuthenticator returns authenticator with passkey of test user
+func registeredAuthenticator(t *testing.T, base string) *webauthntest.Authenticator {
+
+	a := webauthntest.New("example.com", testOrigin)
+
+	if status, body := doRequest(t, "POST", base+"/webauthn/register/finish", testToken, a.Register(beginRegistration(t, base))); status != 201 {
+		t.Fatalf("Passkey should be registered but got [%v, %v]", status, body)
+	}
+
+	return a
+}
+
+func TestPasskey_Flow(t *testing.T) {
+
+	ts := newTestServer()
+	defer ts.Close()
+
+	s.Store(testUser("user@gmail.com", "qwerty"))
+	s.StoreToken(testToken, userId.String())
+
+	s.StoreToken(testClientToken, userId.String())
+
+	if status, body := doRequest(t, "POST", ts.URL+"/webauthn/register/begin", testClientToken, nil); status != 403 {
+		t.Errorf("OAuth client mast not register passkeys but got [%v, %v]", status, body)
+	}
+
+	options := beginRegistration(t, ts.URL)
+
+	if options.RP.ID != "example.com" || options.RP.Name != "Example" || string(options.User.ID) != string(userId.Bytes()) ||
+		options.User.Name != "user@gmail.com" || options.Attestation != "none" || len(options.ExcludeCredentials) != 0 {
+		t.Errorf("Unexpected creation options: %v", options)
+	}
+
+	a := webauthntest.New("example.com", testOrigin)
+	status, body := doRequest(t, "POST", ts.URL+"/webauthn/register/finish", testToken, a.Register(options))
+
+	if status != 201 || !strings.Contains(body, `"sign_count":0`) || strings.Contains(body, "public") {
+		t.Fatalf("Registered passkey expected but got [%v, %v]", status, body)
+	}
+
+	options = beginRegistration(t, ts.URL)
+
+	if len(options.ExcludeCredentials) != 1 || string(options.ExcludeCredentials[0].ID) != string(a.CredentialId) {
+		t.Errorf("Registered passkey mast be excluded but got %v", options.ExcludeCredentials)
+	}
+
+	if status, body := doRequest(t, "POST", ts.URL+"/webauthn/register/finish", testToken, a.Register(options)); status != 409 {
+		t.Errorf("Passkey mast not be registered twice but got [%v, %v]", status, body)
+	}
+
+	login := beginLogin(t, ts.URL)
+
+	if login.RPID != "example.com" || len(login.AllowCredentials) != 0 || login.UserVerification != "required" {
+		t.Errorf("Request options for discoverable passkeys expected but got %v", login)
+	}
+
+	assertion := a.Login(login)
+	status, body = doRequest(t, "POST", ts.URL+"/webauthn/login/finish", "", assertion)
+
+	if status != 200 || !strings.Contains(body, `"access_token":"`+testToken+`"`) || !strings.Contains(body, "refresh_token") {
+		t.Fatalf("Tokens expected but got [%v, %v]", status, body)
+	}
+
+	if status, body := doRequest(t, "POST", ts.URL+"/webauthn/login/finish", "", assertion); status != 401 || !strings.Contains(body, "Challenge is invalid or expired") {
+		t.Errorf("Replayed assertion mast be rejected but got [%v, %v]", status, body)
+	}
+
+	// a clone of the authenticator signs with a counter the service has seen already
+	a.SignCount--
+
+	if status, body := doRequest(t, "POST", ts.URL+"/webauthn/login/finish", "", a.Login(beginLogin(t, ts.URL))); status != 401 || !strings.Contains(body, "Passkey signature counter did not increase") {
+		t.Errorf("Assertion of cloned authenticator mast be rejected but got [%v, %v]", status, body)
+	}
+
+	if stored, _ := s.GetWebAuthnCredential(assertion.ID); stored.SignCount != 1 {
+		t.Errorf("Expected sign count [%v] but was: [%v]", 1, stored.SignCount)
+	}
+}
+
+func TestFinishPasskeyLogin(t *testing.T) {
+
+	tests := []struct {
+		description  string
+		change       func(a *webauthntest.Authenticator, res *webauthn.AuthenticationResponse)
+		storeInitter func(s storage.Store)
+		expestedBody string
+		expectedCode int
+	}{
+		{
+			description:  "Should reject assertion made on other origin",
+			change:       func(a *webauthntest.Authenticator, res *webauthn.AuthenticationResponse) {},
+			storeInitter: func(s storage.Store) {},
+			expestedBody: `{"error":"Unauthorized","reason":"Origin https://evil.com is not allowed"}`,
+			expectedCode: 401,
+		},
+		{
+			description: "Should reject unknown passkey",
+			change: func(a *webauthntest.Authenticator, res *webauthn.AuthenticationResponse) {
+				res.RawID = []byte("unknown")
+			},
+			storeInitter: func(s storage.Store) {},
+			expestedBody: `{"error":"Unauthorized","reason":"Passkey is not registered"}`,
+			expectedCode: 401,
+		},
+		{
+			description: "Should reject signature of other key",
+			change: func(a *webauthntest.Authenticator, res *webauthn.AuthenticationResponse) {
+				other := webauthntest.New("example.com", testOrigin)
+				other.CredentialId = a.CredentialId
+				other.SignCount = a.SignCount
+				*res = other.Login(webauthn.RequestOptions{Challenge: challengeOf(t, res)})
+			},
+			storeInitter: func(s storage.Store) {},
+			expestedBody: `{"error":"Unauthorized","reason":"Passkey assertion is invalid"}`,
+			expectedCode: 401,
+		},
+		{
+			description: "Should reject user handle of other user",
+			change: func(a *webauthntest.Authenticator, res *webauthn.AuthenticationResponse) {
+				res.Response.UserHandle = []byte("other user")
+			},
+			storeInitter: func(s storage.Store) {},
+			expestedBody: `{"error":"Unauthorized","reason":"Passkey assertion is invalid"}`,
+			expectedCode: 401,
+		},
+		{
+			description: "Should reject assertion without user presence",
+			change: func(a *webauthntest.Authenticator, res *webauthn.AuthenticationResponse) {
+				a.Flags = webauthn.FlagUserVerified
+				*res = a.Login(webauthn.RequestOptions{Challenge: challengeOf(t, res)})
+			},
+			storeInitter: func(s storage.Store) {},
+			expestedBody: `{"error":"Unauthorized","reason":"User presence is required"}`,
+			expectedCode: 401,
+		},
+		{
+			description: "Should reject assertion without user verification",
+			change: func(a *webauthntest.Authenticator, res *webauthn.AuthenticationResponse) {
+				a.Flags = webauthn.FlagUserPresent
+				*res = a.Login(webauthn.RequestOptions{Challenge: challengeOf(t, res)})
+			},
+			storeInitter: func(s storage.Store) {},
+			expestedBody: `{"error":"Unauthorized","reason":"Passkey did not verify user"}`,
+			expectedCode: 401,
+		},
+		{
+			description: "Should refuse passkey of banned user",
+			change:      func(a *webauthntest.Authenticator, res *webauthn.AuthenticationResponse) {},
+			storeInitter: func(s storage.Store) {
+				u, _ := s.GetUserById(userId.String())
+				u.Banned = true
+				s.UpdateUser(*u)
+			},
+			expestedBody: `{"error":"Account Banned","reason":"Account is banned"}`,
+			expectedCode: 403,
+		},
+	}
+
+	ts := newTestServer()
+	defer ts.Close()
+
+	for i, tc := range tests {
+
+		t.Run(tc.description, func(t *testing.T) {
+			testSetToDefault()
+			s.Store(testUser("user@gmail.com", "qwerty"))
+			s.StoreToken(testToken, userId.String())
+
+			a := registeredAuthenticator(t, ts.URL)
+			tc.storeInitter(s)
+
+			// the first case runs the ceremony on a phishing page
+			if i == 0 {
+				a.Origin = "https://evil.com"
+			}
+
+			res := a.Login(beginLogin(t, ts.URL))
+			tc.change(a, &res)
+
+			status, body := doRequest(t, "POST", ts.URL+"/webauthn/login/finish", "", res)
+
+			if !IsEqualJson(body, tc.expestedBody) {
+				t.Errorf("Wrong response body. Expected: [%v] Actual: [%v]", tc.expestedBody, body)
+			}
+
+			if status != tc.expectedCode {
+				t.Errorf("Wrong response status code. Expected: [%v] Actual: [%v]", tc.expectedCode, status)
+			}
+		})
+	}
+}
+
+func challengeOf(t *testing.T, res *webauthn.AuthenticationResponse) string {
+	c, err := webauthn.ParseClientData(res.Response.ClientDataJSON, webauthn.TypeGet)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return c.Challenge
+}
+
+func TestBeginPasskeyRegistration(t *testing.T) {
+
+	tests := []struct {
+		description  string
+		mfa          bool
+		requestBody  func() string
+		expestedBody string
+		expectedCode int
+	}{
+		{
+			description:  "Should require password",
+			requestBody:  func() string { return `{}` },
+			expestedBody: `{"error":"Bad Request","reason":"Cant retrive password"}`,
+			expectedCode: 400,
+		},
+		{
+			description:  "Should reject wrong password",
+			requestBody:  func() string { return `{"password":"wrong"}` },
+			expestedBody: `{"error":"Forbidden","reason":"Wrong credentials"}`,
+			expectedCode: 403,
+		},
+		{
+			description:  "Should require code when second factor is enabled",
+			mfa:          true,
+			requestBody:  func() string { return `{"password":"qwerty"}` },
+			expestedBody: `{"error":"Forbidden","reason":"Authentication code is invalid"}`,
+			expectedCode: 403,
+		},
+		{
+			description:  "Should start ceremony with password and code",
+			mfa:          true,
+			requestBody:  func() string { return `{"password":"qwerty","code":"` + codeAt(t, totp.Step(time.Now())) + `"}` },
+			expectedCode: 200,
+		},
+	}
+
+	ts := newTestServer()
+	defer ts.Close()
+
+	for _, tc := range tests {
+
+		t.Run(tc.description, func(t *testing.T) {
+			testSetToDefault()
+			s.Store(testUser("user@gmail.com", "qwerty"))
+			s.StoreToken(testToken, userId.String())
+			if tc.mfa {
+				withTOTP()
+			}
+
+			status, body := doRequest(t, "POST", ts.URL+"/webauthn/register/begin", testToken, tc.requestBody())
+
+			if tc.expectedCode == 200 && !strings.Contains(body, `"challenge"`) || tc.expectedCode != 200 && !IsEqualJson(body, tc.expestedBody) {
+				t.Errorf("Wrong response body. Expected: [%v] Actual: [%v]", tc.expestedBody, body)
+			}
+
+			if status != tc.expectedCode {
+				t.Errorf("Wrong response status code. Expected: [%v] Actual: [%v]", tc.expectedCode, status)
+			}
+		})
+	}
+}
+
+func TestFinishPasskeyRegistration(t *testing.T) {
+
+	tests := []struct {
+		description  string
+		response     func(base string) webauthn.RegistrationResponse
+		expestedBody string
+		expectedCode int
+	}{
+		{
+			description: "Should reject challenge of login ceremony",
+			response: func(base string) webauthn.RegistrationResponse {
+				login := beginLogin(t, base)
+				return webauthntest.New("example.com", testOrigin).Register(webauthn.CreationOptions{Challenge: login.Challenge})
+			},
+			expestedBody: `{"error":"Bad Request","reason":"Challenge is invalid or expired"}`,
+			expectedCode: 400,
+		},
+		{
+			description: "Should reject credential of other relying party",
+			response: func(base string) webauthn.RegistrationResponse {
+				return webauthntest.New("evil.com", testOrigin).Register(beginRegistration(t, base))
+			},
+			expestedBody: `{"error":"Bad Request","reason":"Authenticator data is made for other relying party"}`,
+			expectedCode: 400,
+		},
+		{
+			description: "Should reject credential id which does not match attestation",
+			response: func(base string) webauthn.RegistrationResponse {
+				res := webauthntest.New("example.com", testOrigin).Register(beginRegistration(t, base))
+				res.RawID = []byte("other")
+				return res
+			},
+			expestedBody: `{"error":"Bad Request","reason":"Credential id does not match attestation"}`,
+			expectedCode: 400,
+		},
+		{
+			description: "Should reject credential made without user verification",
+			response: func(base string) webauthn.RegistrationResponse {
+				a := webauthntest.New("example.com", testOrigin)
+				a.Flags = webauthn.FlagUserPresent
+				return a.Register(beginRegistration(t, base))
+			},
+			expestedBody: `{"error":"Bad Request","reason":"Passkey did not verify user"}`,
+			expectedCode: 400,
+		},
+		{
+			description: "Should reject assertion instead of attestation",
+			response: func(base string) webauthn.RegistrationResponse {
+				options := beginRegistration(t, base)
+				a := webauthntest.New("example.com", testOrigin)
+				res := a.Register(options)
+				res.Response.ClientDataJSON = a.ClientData(webauthn.TypeGet, options.Challenge)
+				return res
+			},
+			expestedBody: `{"error":"Bad Request","reason":"Client data of webauthn.create ceremony expected"}`,
+			expectedCode: 400,
+		},
+	}
+
+	ts := newTestServer()
+	defer ts.Close()
+
+	for _, tc := range tests {
+
+		t.Run(tc.description, func(t *testing.T) {
+			testSetToDefault()
+			s.Store(testUser("user@gmail.com", "qwerty"))
+			s.StoreToken(testToken, userId.String())
+
+			status, body := doRequest(t, "POST", ts.URL+"/webauthn/register/finish", testToken, tc.response(ts.URL))
+
+			if !IsEqualJson(body, tc.expestedBody) {
+				t.Errorf("Wrong response body. Expected: [%v] Actual: [%v]", tc.expestedBody, body)
+			}
+
+			if status != tc.expectedCode {
+				t.Errorf("Wrong response status code. Expected: [%v] Actual: [%v]", tc.expectedCode, status)
+			}
+
+			if credentials, _ := s.GetUserWebAuthnCredentials(userId); len(credentials) != 0 {
+				t.Errorf("Passkey mast not be stored but got %v", credentials)
+			}
+		})
+	}
+}
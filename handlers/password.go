@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"time"
+
+	"authService/mail"
+	"authService/model"
+	"authService/opaque"
+	"authService/server"
+)
+
+const (
+	resetTokenDuration   = time.Hour
+	resetPasswordPurpose = "reset-password"
+)
+
+var errNoNewPassword = errors.New("No new password provided")
+
+type forgotRequest struct {
+	Email string `json:"email"`
+}
+
+type resetRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// ForgotPassword mails reset link to the user. Response is the same whether the user exists or not
+// and the mail goes out in background, so neither body nor timing tells which addresses are registered
+func ForgotPassword(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+
+	req := &forgotRequest{}
+	b, err := ioutil.ReadAll(r.Body)
+
+	if err == nil {
+		err = json.Unmarshal(b, req)
+	}
+
+	if err != nil || req.Email == "" {
+		prepareErrorResponse(rw, model.AuthError{ErrorCode: "Bad Request", Reason: "Cant retrive email"}, http.StatusBadRequest)
+		return
+	}
+
+	if u, err := server.RunningServer.UserStore.GetUserByLogin(req.Email); err == nil {
+		go func() {
+			if err := sendResetEmail(u); err != nil {
+				logger.Printf("Can't send password reset email to user %v: %v", u.Id, err)
+			}
+		}()
+	}
+
+	rw.WriteHeader(http.StatusAccepted)
+}
+
+// ResetPassword sets new password with token from reset email and logs the user out everywhere.
+// GET serves a form for the link from the email, POST accepts the form or JSON request
+func ResetPassword(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+
+	if r.Method == http.MethodGet {
+		renderPage(rw, resetPage, pageData{Token: r.FormValue("token")}, http.StatusOK)
+		return
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	isForm := mediaType == "application/x-www-form-urlencoded"
+
+	req, err := retriveResetRequest(r, isForm)
+
+	if err == nil {
+		err = resetPassword(req)
+	}
+
+	switch {
+	case err != nil && isForm:
+		renderPage(rw, resetPage, pageData{Token: req.Token, Error: err.Error()}, http.StatusBadRequest)
+	case err != nil:
+		prepareErrorResponse(rw, model.AuthError{ErrorCode: "Bad Request", Reason: err.Error()}, http.StatusBadRequest)
+	case isForm:
+		renderPage(rw, resetPage, pageData{Done: true}, http.StatusOK)
+	default:
+		rw.WriteHeader(http.StatusOK)
+	}
+}
+
+func resetPassword(req *resetRequest) error {
+
+	u, err := useOneTimeToken(req.Token, resetPasswordPurpose, passwordBinding)
+
+	if err != nil {
+		return err
+	}
+
+	hash, err := server.RunningServer.Hasher.Hash(req.Password)
+
+	if err != nil {
+		return errors.New("Can't process password")
+	}
+
+	c := *u.Credentials
+	c.Password = hash
+	u.Credentials = &c
+
+	if err := server.RunningServer.UserStore.UpdateUser(*u); err != nil {
+		return errors.New("Can't change password")
+	}
+
+	logger.Printf("Password of user %v has been reset", u.Id)
+
+	revokeUserSessions(u)
+
+	return nil
+}
+
+// revokeUserSessions logs user out everywhere, refresh tokens are revoked so sessions can't be renewed
+func revokeUserSessions(u *model.User) {
+
+	if err := server.RunningServer.SessionStore.DeleteOwnerTokens(u.Id.String()); err != nil {
+		logger.Printf("Can't revoke sessions of user %v: %v", u.Id, err)
+	}
+
+	if _, err := server.RunningServer.RefreshStore.RevokeUserTokens(u.Id); err != nil {
+		logger.Printf("Can't revoke refresh tokens of user %v: %v", u.Id, err)
+	}
+}
+
+// sendResetEmail mails user a link to ResetPassword, the link is bound to the current password
+func sendResetEmail(u *model.User) error {
+
+	token, err := newOneTimeToken(u.Id, resetPasswordPurpose, passwordBinding(u), resetTokenDuration)
+
+	if err != nil {
+		return err
+	}
+
+	link, err := mailedLink("/password/reset", token)
+
+	if err != nil {
+		return err
+	}
+
+	return server.RunningServer.Mailer.Send(mail.Message{
+		To:      u.Credentials.Email,
+		Subject: "Reset your password",
+		Body:    "Follow the link to set a new password, it is valid for an hour:\n\n" + link + "\n\nIgnore this email if you didn't ask for it.",
+	})
+}
+
+// passwordBinding ties reset token to the current password hash, so the token is void once password changes
+func passwordBinding(u *model.User) string {
+	return opaque.Hash(u.Credentials.Password)
+}
+
+func retriveResetRequest(r *http.Request, isForm bool) (*resetRequest, error) {
+
+	req := &resetRequest{}
+
+	if isForm {
+		req.Token, req.Password = r.PostFormValue("token"), r.PostFormValue("password")
+	} else {
+		b, err := ioutil.ReadAll(r.Body)
+
+		if err != nil {
+			return req, err
+		}
+
+		if err := json.Unmarshal(b, req); err != nil {
+			return req, errors.New("Cant retrive reset request")
+		}
+	}
+
+	if req.Token == "" {
+		return req, errors.New("No token provided")
+	}
+
+	if req.Password == "" {
+		return req, errNoNewPassword
+	}
+
+	return req, nil
+}
@@ -0,0 +1,162 @@
+package handlers_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"authService/model"
+	"authService/opaque"
+)
+
+func TestResetPassword_Flow(t *testing.T) {
+
+	ts := newTestServer()
+	defer ts.Close()
+
+	s.Store(testUser("user@gmail.com", "qwerty"))
+	s.StoreToken(testToken, userId.String())
+	s.StoreToken("other", "other")
+	s.StoreRefreshToken(model.RefreshToken{Id: "refresh", Family: "family", UserId: userId, AccessToken: testToken, ExpiresAt: time.Now().Add(time.Hour)})
+
+	if status, _ := doRequest(t, "POST", ts.URL+"/password/forgot", "", `{"email":"unknown@gmail.com"}`); status != 202 || len(mailbox.sent) != 0 {
+		t.Errorf("Unknown address mast be accepted without mail but got [%v, %v]", status, mailbox.sent)
+	}
+
+	// forged Host mast not change where the link points to
+	req, _ := http.NewRequest("POST", ts.URL+"/password/forgot", strings.NewReader(`{"email":"user@gmail.com"}`))
+	req.Host = "evil.example.com"
+	res, _ := http.DefaultClient.Do(req)
+	res.Body.Close()
+	mailbox.waitFor(t, 1)
+	first := mailedToken(t, "user@gmail.com")
+
+	status, _ := doRequest(t, "POST", ts.URL+"/password/forgot", "", `{"email":"user@gmail.com"}`)
+	mailbox.waitFor(t, 2)
+	second := mailedToken(t, "user@gmail.com")
+
+	if status != 202 || !strings.Contains(mailbox.sent[0].Body, ts.URL+"/password/reset?token=") {
+		t.Fatalf("Reset link expected but got [%v, %v]", status, mailbox.sent)
+	}
+
+	status, body := doRequest(t, "POST", ts.URL+"/password/reset", "", `{"token":"`+first+`","password":"new-password"}`)
+
+	if status != 200 {
+		t.Fatalf("Password mast be reset but got [%v, %v]", status, body)
+	}
+
+	if s.IsTokenPresent(testToken) || !s.IsTokenPresent("other") {
+		t.Errorf("Sessions of the user mast be revoked")
+	}
+
+	if _, err := s.UseRefreshToken("refresh"); err == nil {
+		t.Errorf("Refresh tokens of the user mast be revoked")
+	}
+
+	if status, _ := doRequest(t, "POST", ts.URL+"/login", "", `{"email":"user@gmail.com","password":"qwerty"}`); status != 401 {
+		t.Errorf("Old password mast be rejected but got: %v", status)
+	}
+
+	if status, _ := doRequest(t, "POST", ts.URL+"/login", "", `{"email":"user@gmail.com","password":"new-password"}`); status != 200 {
+		t.Errorf("New password mast be accepted but got: %v", status)
+	}
+
+	if status, _ := doRequest(t, "POST", ts.URL+"/password/reset", "", `{"token":"`+first+`","password":"again"}`); status != 400 {
+		t.Errorf("Reset token mast work once but got: %v", status)
+	}
+
+	if status, _ := doRequest(t, "POST", ts.URL+"/password/reset", "", `{"token":"`+second+`","password":"again"}`); status != 400 {
+		t.Errorf("Reset token issued for previous password mast be rejected but got: %v", status)
+	}
+}
+
+func TestResetPassword_Form(t *testing.T) {
+
+	ts := newTestServer()
+	defer ts.Close()
+
+	u := testUser("user@gmail.com", "qwerty")
+	s.Store(u)
+	s.StoreOneTimeToken(model.OneTimeToken{Id: opaque.Hash("token"), Purpose: "reset-password", UserId: userId,
+		Binding: opaque.Hash(u.Credentials.Password), ExpiresAt: time.Now().Add(time.Hour)})
+
+	res, err := http.Get(ts.URL + "/password/reset?token=token")
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	b, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+
+	if res.StatusCode != 200 || !strings.Contains(string(b), `name="token" value="token"`) {
+		t.Fatalf("Reset form with token expected but got [%v, %v]", res.StatusCode, string(b))
+	}
+
+	form := url.Values{"token": {"token"}, "password": {"new-password"}}
+
+	if status, body := doRequest(t, "POST", ts.URL+"/password/reset", "", form); status != 200 || !strings.Contains(body, "Password has been changed") {
+		t.Errorf("Confirmation page expected but got [%v, %v]", status, body)
+	}
+
+	if status, body := doRequest(t, "POST", ts.URL+"/password/reset", "", form); status != 400 || !strings.Contains(body, "Token is invalid or expired") {
+		t.Errorf("Form with error expected but got [%v, %v]", status, body)
+	}
+}
+
+func TestResetPassword(t *testing.T) {
+
+	tests := []struct {
+		description  string
+		requestBody  string
+		expectedBody string
+		expectedCode int
+	}{
+		{
+			description:  "Should reject malformed request",
+			requestBody:  `"token":"token"`,
+			expectedBody: `{"error":"Bad Request","reason":"Cant retrive reset request"}`,
+			expectedCode: 400,
+		},
+		{
+			description:  "Should require token",
+			requestBody:  `{"password":"new-password"}`,
+			expectedBody: `{"error":"Bad Request","reason":"No token provided"}`,
+			expectedCode: 400,
+		},
+		{
+			description:  "Should require new password",
+			requestBody:  `{"token":"token"}`,
+			expectedBody: `{"error":"Bad Request","reason":"No new password provided"}`,
+			expectedCode: 400,
+		},
+		{
+			description:  "Should reject unknown token",
+			requestBody:  `{"token":"unknown","password":"new-password"}`,
+			expectedBody: `{"error":"Bad Request","reason":"Token is invalid or expired"}`,
+			expectedCode: 400,
+		},
+	}
+
+	ts := newTestServer()
+	defer ts.Close()
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			testSetToDefault()
+			s.Store(testUser("user@gmail.com", "qwerty"))
+
+			status, body := doRequest(t, "POST", ts.URL+"/password/reset", "", tc.requestBody)
+
+			if ok := IsEqualJson(body, tc.expectedBody); !ok {
+				t.Errorf("Wrong response body. Expected: [%v] Actual: [%v]", tc.expectedBody, body)
+			}
+
+			if status != tc.expectedCode {
+				t.Errorf("Wrong response status code. Expected: [%v] Actual: [%v]", tc.expectedCode, status)
+			}
+		})
+	}
+}
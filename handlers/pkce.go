@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+)
+
+// isPKCEValue checks code verifier or challenge consists of RFC 7636 unreserved characters
+// and is between min and 128 characters long
+func isPKCEValue(s string, min int) bool {
+
+	if len(s) < min || len(s) > 128 {
+		return false
+	}
+
+	for _, c := range s {
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9':
+		case c == '-', c == '.', c == '_', c == '~':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// verifyPKCE checks verifier against S256 challenge, RFC 7636 4.6
+func verifyPKCE(verifier, challenge string) bool {
+
+	if !isPKCEValue(verifier, 43) {
+		return false
+	}
+
+	sum := sha256.Sum256([]byte(verifier))
+	expected := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) == 1
+}
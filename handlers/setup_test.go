@@ -0,0 +1,96 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/urfave/negroni"
+
+	"authService/access"
+	"authService/config"
+	"authService/handlers"
+	"authService/server"
+)
+
+// newTestServer resets storage and serves endpoints the flow tests go through, routed the way main does.
+// Links in mails point to the test server
+func newTestServer() *httptest.Server {
+
+	server.RunningServer = &server.Server{
+		Config:    config.Configuration{WebAuthn: config.WebAuthnConfig{RPID: "example.com", RPName: "Example", Origins: []string{testOrigin}}},
+		Tokenizer: &TestTokenizer{secret: "my_test_sercert"},
+		Hasher:    hasher,
+		Access:    access.NewPolicy(config.AccessConfig{}),
+	}
+	testSetToDefault()
+
+	public := func(h negroni.HandlerFunc) *negroni.Negroni {
+		return negroni.New(h)
+	}
+
+	router := mux.NewRouter()
+	router.Handle("/signin", public(handlers.Signin)).Methods("POST")
+	router.Handle("/login", public(handlers.Login)).Methods("POST")
+	router.Handle("/logout", public(handlers.Logout)).Methods("POST")
+	router.Handle("/login/mfa", public(handlers.LoginMFA)).Methods("POST")
+	router.Handle("/token/refresh", public(handlers.Refresh)).Methods("POST")
+	router.Handle("/verify-email", public(handlers.VerifyEmail)).Methods("GET", "POST")
+	router.Handle("/password/forgot", public(handlers.ForgotPassword)).Methods("POST")
+	router.Handle("/password/reset", public(handlers.ResetPassword)).Methods("GET", "POST")
+	router.Handle("/mfa/totp", handlers.Protect(handlers.EnrollTOTP)).Methods("POST")
+	router.Handle("/mfa/totp", handlers.Protect(handlers.DisableTOTP)).Methods("DELETE")
+	router.Handle("/mfa/totp/confirm", handlers.Protect(handlers.ConfirmTOTP)).Methods("POST")
+	router.Handle("/webauthn/register/begin", handlers.Protect(handlers.BeginPasskeyRegistration)).Methods("POST")
+	router.Handle("/webauthn/register/finish", handlers.Protect(handlers.FinishPasskeyRegistration)).Methods("POST")
+	router.Handle("/webauthn/login/begin", public(handlers.BeginPasskeyLogin)).Methods("POST")
+	router.Handle("/webauthn/login/finish", public(handlers.FinishPasskeyLogin)).Methods("POST")
+	router.Handle("/authorize", public(handlers.OAuthAuthorize)).Methods("GET", "POST")
+	router.Handle("/token", public(handlers.OAuthToken)).Methods("POST")
+
+	ts := httptest.NewServer(router)
+	server.RunningServer.Config.Mail.BaseURL = ts.URL
+	return ts
+}
+
+// doRequest sends body and returns status and body of the response. String body is sent as it is,
+// url.Values as a form and anything else as JSON. Bearer token is sent when it is not empty
+func doRequest(t *testing.T, method, target, bearer string, body interface{}) (int, string) {
+
+	var r io.Reader
+	contentType := "application/json"
+
+	switch b := body.(type) {
+	case nil:
+	case string:
+		r = strings.NewReader(b)
+	case url.Values:
+		r = strings.NewReader(b.Encode())
+		contentType = "application/x-www-form-urlencoded"
+	default:
+		encoded, _ := json.Marshal(b)
+		r = strings.NewReader(string(encoded))
+	}
+
+	req, _ := http.NewRequest(method, target, r)
+	req.Header.Set("Content-Type", contentType)
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer res.Body.Close()
+
+	b, _ := ioutil.ReadAll(res.Body)
+	return res.StatusCode, string(b)
+}
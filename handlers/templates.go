@@ -0,0 +1,89 @@
+package handlers
+
+import "html/template"
+
+// pages of authorization and password reset flows are rendered by the service itself, so user credentials never reach client apps
+var (
+	loginPage = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Sign in</title></head>
+<body>
+<h1>Sign in to {{.ClientName}}</h1>
+{{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+<form method="POST" action="">
+<input type="hidden" name="action" value="login">
+<label>Email <input type="email" name="email" value="{{.Email}}" required autofocus></label>
+<label>Password <input type="password" name="password" required></label>
+<button type="submit">Sign in</button>
+</form>
+</body>
+</html>
+`))
+
+	mfaPage = template.Must(template.New("mfa").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Two-factor authentication</title></head>
+<body>
+<h1>Sign in to {{.ClientName}}</h1>
+<form method="POST" action="">
+<input type="hidden" name="action" value="mfa">
+<input type="hidden" name="mfa_token" value="{{.Token}}">
+<label>Code from authenticator app or recovery code <input type="text" name="code" autocomplete="one-time-code" required autofocus></label>
+<button type="submit">Verify</button>
+</form>
+</body>
+</html>
+`))
+
+	consentPage = template.Must(template.New("consent").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Allow access</title></head>
+<body>
+<h1>{{.ClientName}} wants to access your account</h1>
+<p>Signed in as {{.Email}}</p>
+{{if .Scopes}}<ul>{{range .Scopes}}<li>{{.}}</li>{{end}}</ul>{{end}}
+<form method="POST" action="">
+<input type="hidden" name="action" value="consent">
+<button type="submit" name="decision" value="allow">Allow</button>
+<button type="submit" name="decision" value="deny">Deny</button>
+</form>
+</body>
+</html>
+`))
+
+	resetPage = template.Must(template.New("reset").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Reset password</title></head>
+<body>
+<h1>Reset password</h1>
+{{if .Done}}<p>Password has been changed, sign in with the new one.</p>{{else}}
+{{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+<form method="POST" action="">
+<input type="hidden" name="token" value="{{.Token}}">
+<label>New password <input type="password" name="password" required autofocus></label>
+<button type="submit">Change password</button>
+</form>
+{{end}}
+</body>
+</html>
+`))
+
+	errorPage = template.Must(template.New("error").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Authorization error</title></head>
+<body>
+<h1>Authorization request is invalid</h1>
+<p>{{.Error}}</p>
+</body>
+</html>
+`))
+)
+
+type pageData struct {
+	ClientName string
+	Email      string
+	Scopes     []string
+	Error      string
+	Token      string
+	Done       bool
+}
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"authService/model"
+	"authService/ratelimit"
+	"authService/server"
+	"authService/throttle"
+)
+
+// loginThrottle limits password guessing with counters shared by replicas of the running server
+func loginThrottle() *throttle.Tracker {
+	return throttle.NewTracker(server.RunningServer.Config.Login, server.RunningServer.AttemptStore)
+}
+
+// allowLogin counts login request of the client address and checks the account may try password now
+func allowLogin(r *http.Request, login string) (time.Duration, error) {
+
+	t := loginThrottle()
+
+	if wait, err := t.AllowAddress(ratelimit.ClientIP(r)); err != nil {
+		return wait, err
+	}
+
+	return t.AllowAccount(login)
+}
+
+func loginFailed(login string) {
+
+	t := loginThrottle()
+
+	if err := t.Failed(login); err != nil {
+		logger.Printf("Can't count failed login of %v: %v", login, err)
+	}
+
+	if err := t.Prune(); err != nil {
+		logger.Printf("Can't prune login attempts: %v", err)
+	}
+}
+
+func loginSucceeded(login string) {
+	if err := loginThrottle().Succeeded(login); err != nil {
+		logger.Printf("Can't reset failed logins of %v: %v", login, err)
+	}
+}
+
+func isThrottled(err error) bool {
+	return err == throttle.ErrAccountLocked || err == throttle.ErrLoginDelayed || err == throttle.ErrAddressLimited
+}
+
+// retryAfter sets Retry-After header in whole seconds, rounded up so client never retries too early
+func retryAfter(rw http.ResponseWriter, wait time.Duration) {
+
+	seconds := int((wait + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+
+	rw.Header().Set("Retry-After", strconv.Itoa(seconds))
+}
+
+// writeThrottled responds to throttled login with 429, storage failures are reported as 500
+func writeThrottled(rw http.ResponseWriter, wait time.Duration, err error) {
+
+	if !isThrottled(err) {
+		prepareErrorResponse(rw, model.AuthError{ErrorCode: "Internal Server Error", Reason: "Can't check login attempts"}, http.StatusInternalServerError)
+		return
+	}
+
+	retryAfter(rw, wait)
+	prepareErrorResponse(rw, model.AuthError{ErrorCode: "Too Many Attempts", Reason: err.Error()}, http.StatusTooManyRequests)
+}
@@ -0,0 +1,138 @@
+package handlers_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/xid"
+
+	"authService/config"
+	"authService/server"
+)
+
+func TestLoginThrottle(t *testing.T) {
+
+	const (
+		wrong   = `{"email":"user@gmail.com","password":"wrong"}`
+		right   = `{"email":"user@gmail.com","password":"qwerty"}`
+		unknown = `{"email":"unknown@gmail.com","password":"qwerty"}`
+		other   = `{"email":"other@gmail.com","password":"qwerty"}`
+	)
+
+	hour := config.Duration{Duration: time.Hour}
+
+	tests := []struct {
+		description   string
+		config        config.LoginThrottleConfig
+		attempts      []string
+		requestBody   string
+		expestedBody  string
+		expectedCode  int
+		expectedRetry string
+	}{
+		{
+			description:  "Should let user retry after single typo",
+			config:       config.LoginThrottleConfig{BaseDelay: hour, MaxDelay: hour},
+			attempts:     []string{wrong},
+			requestBody:  right,
+			expectedCode: 200,
+		},
+		{
+			description:   "Should delay login after repeated failures",
+			config:        config.LoginThrottleConfig{BaseDelay: hour, MaxDelay: hour},
+			attempts:      []string{wrong, wrong},
+			requestBody:   right,
+			expestedBody:  `{"error":"Too Many Attempts","reason":"Too many failed logins, try again later"}`,
+			expectedCode:  429,
+			expectedRetry: "3600",
+		},
+		{
+			description:  "Should not delay other accounts",
+			config:       config.LoginThrottleConfig{BaseDelay: hour, MaxDelay: hour},
+			attempts:     []string{wrong, wrong},
+			requestBody:  other,
+			expectedCode: 200,
+		},
+		{
+			description:  "Should forget failures after successful login",
+			config:       config.LoginThrottleConfig{BaseDelay: hour, MaxDelay: hour},
+			attempts:     []string{wrong, right, wrong},
+			requestBody:  right,
+			expectedCode: 200,
+		},
+		{
+			description:   "Should lock account after too many failures",
+			config:        config.LoginThrottleConfig{MaxFailures: 3, BaseDelay: config.Duration{Duration: time.Nanosecond}, Lockout: hour},
+			attempts:      []string{wrong, wrong, wrong},
+			requestBody:   right,
+			expestedBody:  `{"error":"Too Many Attempts","reason":"Account is temporarily locked after too many failed logins"}`,
+			expectedCode:  429,
+			expectedRetry: "3600",
+		},
+		{
+			description:   "Should lock unknown account the same way",
+			config:        config.LoginThrottleConfig{MaxFailures: 3, BaseDelay: config.Duration{Duration: time.Nanosecond}, Lockout: hour},
+			attempts:      []string{unknown, unknown, unknown},
+			requestBody:   unknown,
+			expestedBody:  `{"error":"Too Many Attempts","reason":"Account is temporarily locked after too many failed logins"}`,
+			expectedCode:  429,
+			expectedRetry: "3600",
+		},
+		{
+			description:   "Should limit attempts from one address",
+			config:        config.LoginThrottleConfig{IPAttempts: 3, IPWindow: hour},
+			attempts:      []string{right, other, unknown},
+			requestBody:   right,
+			expestedBody:  `{"error":"Too Many Attempts","reason":"Too many login attempts from this address"}`,
+			expectedCode:  429,
+			expectedRetry: "3600",
+		},
+	}
+
+	ts := newTestServer()
+	defer ts.Close()
+
+	for _, tc := range tests {
+
+		t.Run(tc.description, func(t *testing.T) {
+			server.RunningServer.Config.Login = tc.config
+			testSetToDefault()
+			s.Store(testUser("user@gmail.com", "qwerty"))
+			other := testUser("other@gmail.com", "qwerty")
+			other.Id = xid.New()
+			s.Store(other)
+
+			for _, a := range tc.attempts {
+				doRequest(t, "POST", ts.URL+"/login", "", a)
+			}
+
+			res, err := http.Post(ts.URL+"/login", "application/json", strings.NewReader(tc.requestBody))
+
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			defer res.Body.Close()
+
+			b, _ := ioutil.ReadAll(res.Body)
+
+			if tc.expectedCode == 200 {
+				if res.StatusCode != 200 || !strings.Contains(string(b), `"access_token":"`+testToken+`"`) {
+					t.Errorf("Tokens expected but got [%v, %v]", res.StatusCode, string(b))
+				}
+			} else if !IsEqualJson(string(b), tc.expestedBody) {
+				t.Errorf("Wrong response body. Expected: [%v] Actual: [%v]", tc.expestedBody, string(b))
+			}
+
+			if res.StatusCode != tc.expectedCode {
+				t.Errorf("Wrong response status code. Expected: [%v] Actual: [%v]", tc.expectedCode, res.StatusCode)
+			}
+
+			if h := res.Header.Get("Retry-After"); h != tc.expectedRetry {
+				t.Errorf("Wrong Retry-After header. Expected: [%v] Actual: [%v]", tc.expectedRetry, h)
+			}
+		})
+	}
+}
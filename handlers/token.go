@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/rs/xid"
+
+	"authService/model"
+	"authService/opaque"
+	"authService/server"
+)
+
+const refreshTokenDuration = 30 * 24 * time.Hour
+
+var errNoRefreshToken = errors.New("No refresh token provided")
+
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// Refresh exchanges refresh token for a new access and refresh tokens pair.
+// Every refresh token can be used only once, replaying it revokes the whole token family
+func Refresh(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+
+	req, err := retriveRefreshToken(r)
+
+	if err != nil {
+		prepareErrorResponse(rw, model.AuthError{ErrorCode: "Bad Request", Reason: "Cant retrive refresh token"}, http.StatusBadRequest)
+		return
+	}
+
+	rStore := server.RunningServer.RefreshStore
+
+	rt, err := rStore.UseRefreshToken(opaque.Hash(req.RefreshToken))
+
+	if err != nil {
+		prepareErrorResponse(rw, model.AuthError{ErrorCode: "Unauthorized", Reason: "Invalid refresh token"}, http.StatusUnauthorized)
+		return
+	}
+
+	if rt.Used {
+		logger.Printf("Refresh token reuse detected for user %v, revoking token family %v", rt.UserId, rt.Family)
+		revokeTokenFamily(rt.Family)
+		prepareErrorResponse(rw, model.AuthError{ErrorCode: "Unauthorized", Reason: "Refresh token reuse detected"}, http.StatusUnauthorized)
+		return
+	}
+
+	if time.Now().After(rt.ExpiresAt) {
+		revokeTokenFamily(rt.Family)
+		prepareErrorResponse(rw, model.AuthError{ErrorCode: "Unauthorized", Reason: "Refresh token expired"}, http.StatusUnauthorized)
+		return
+	}
+
+	u, err := server.RunningServer.UserStore.GetUserById(rt.UserId.String())
+
+	if err != nil {
+		prepareErrorResponse(rw, model.AuthError{ErrorCode: "Unauthorized", Reason: "User not found"}, http.StatusUnauthorized)
+		return
+	}
+
+	if err := accountError(u); err != nil {
+		revokeTokenFamily(rt.Family)
+		prepareErrorResponse(rw, model.AuthError{ErrorCode: accountErrorCode(err), Reason: err.Error()}, http.StatusForbidden)
+		return
+	}
+
+	token, err := server.RunningServer.Tokenizer.GenerateToken(u)
+
+	if err != nil {
+		prepareErrorResponse(rw, model.AuthError{ErrorCode: "Bad Request", Reason: "Error during token generation"}, http.StatusBadRequest)
+		return
+	}
+
+	err = server.RunningServer.SessionStore.UpdateToken(rt.AccessToken, token, u.Id.String())
+
+	if err != nil {
+		prepareErrorResponse(rw, model.AuthError{ErrorCode: "Bad Request", Reason: err.Error()}, http.StatusBadRequest)
+		return
+	}
+
+	refresh, err := newRefreshToken(u, rt.Family, token)
+
+	if err != nil {
+		prepareErrorResponse(rw, model.AuthError{ErrorCode: "Bad Request", Reason: "Error during token generation"}, http.StatusBadRequest)
+		return
+	}
+
+	writeTokenResponse(rw, model.TokenResponse{AccessToken: token, RefreshToken: refresh})
+}
+
+// newRefreshToken stores a refresh token paired with the access token, new family is started if family is empty
+func newRefreshToken(u *model.User, family, accessToken string) (string, error) {
+
+	token, err := opaque.New()
+
+	if err != nil {
+		return "", err
+	}
+
+	if family == "" {
+		family = xid.New().String()
+	}
+
+	err = server.RunningServer.RefreshStore.StoreRefreshToken(model.RefreshToken{
+		Id:          opaque.Hash(token),
+		Family:      family,
+		UserId:      u.Id,
+		AccessToken: accessToken,
+		ExpiresAt:   time.Now().Add(refreshTokenDuration),
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+func revokeTokenFamily(family string) {
+
+	revoked, err := server.RunningServer.RefreshStore.RevokeTokenFamily(family)
+
+	if err != nil {
+		logger.Printf("Can't revoke token family %v: %v", family, err)
+		return
+	}
+
+	deleteAccessTokens(revoked)
+}
+
+// revokeAccessTokenFamily revokes refresh tokens of the family the access token was issued with
+func revokeAccessTokenFamily(accessToken string) {
+
+	revoked, err := server.RunningServer.RefreshStore.RevokeAccessTokenFamily(accessToken)
+
+	if err != nil {
+		logger.Printf("Can't revoke token family of access token: %v", err)
+		return
+	}
+
+	deleteAccessTokens(revoked)
+}
+
+// deleteAccessTokens ends sessions of access tokens issued with revoked refresh tokens
+func deleteAccessTokens(revoked []model.RefreshToken) {
+
+	sStore := server.RunningServer.SessionStore
+
+	for _, rt := range revoked {
+		if sStore.IsTokenPresent(rt.AccessToken) {
+			sStore.DeleteToken(rt.AccessToken)
+		}
+	}
+}
+
+func writeTokenResponse(rw http.ResponseWriter, t model.TokenResponse) {
+
+	t.TokenType = "Bearer"
+
+	resp, err := json.Marshal(t)
+
+	if err != nil {
+		prepareErrorResponse(rw, model.AuthError{ErrorCode: "Bad Request", Reason: err.Error()}, http.StatusBadRequest)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("Cache-Control", "no-store")
+	rw.Write(resp)
+}
+
+func retriveRefreshToken(r *http.Request) (*refreshRequest, error) {
+	b, err := ioutil.ReadAll(r.Body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	req := &refreshRequest{}
+	err = json.Unmarshal(b, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(req.RefreshToken) < 1 {
+		return nil, errNoRefreshToken
+	}
+
+	return req, nil
+}
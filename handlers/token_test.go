@@ -0,0 +1,218 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"authService/model"
+	"authService/opaque"
+	"authService/storage"
+)
+
+func TestLoginIssuesTokens(t *testing.T) {
+
+	ts := newTestServer()
+	defer ts.Close()
+
+	s.Store(testUser("test@gmail.com", "qwerty"))
+
+	code, body := doRequest(t, "POST", ts.URL+"/login", "", `{"email":"test@gmail.com","password":"qwerty"}`)
+
+	tokens := model.TokenResponse{}
+	json.Unmarshal([]byte(body), &tokens)
+
+	if code != 200 {
+		t.Fatalf("Wrong response status code. Expected: [%v] Actual: [%v] %v", 200, code, body)
+	}
+
+	if tokens.AccessToken != testToken {
+		t.Errorf("Expected access token [%v] but was: [%v]", testToken, tokens.AccessToken)
+	}
+
+	if tokens.TokenType != "Bearer" {
+		t.Errorf("Expected token type [%v] but was: [%v]", "Bearer", tokens.TokenType)
+	}
+
+	if len(tokens.RefreshToken) == 0 {
+		t.Fatalf("Refresh token should be issued with access token")
+	}
+
+	if !s.IsTokenPresent(testToken) {
+		t.Errorf("Access token should be stored in session")
+	}
+
+	rt, err := s.UseRefreshToken(opaque.Hash(tokens.RefreshToken))
+
+	if err != nil {
+		t.Fatalf("Refresh token hash should be stored but got error: %v", err)
+	}
+
+	if rt.UserId != userId || rt.AccessToken != testToken || rt.Used {
+		t.Errorf("Unexpected refresh token record: %v", rt)
+	}
+}
+
+func TestRefreshRotatesTokens(t *testing.T) {
+
+	ts := newTestServer()
+	defer ts.Close()
+
+	s.Store(testUser("test@gmail.com", "qwerty"))
+
+	_, body := doRequest(t, "POST", ts.URL+"/login", "", `{"email":"test@gmail.com","password":"qwerty"}`)
+
+	login := model.TokenResponse{}
+	json.Unmarshal([]byte(body), &login)
+
+	code, body := doRequest(t, "POST", ts.URL+"/token/refresh", "", `{"refresh_token":"`+login.RefreshToken+`"}`)
+
+	refreshed := model.TokenResponse{}
+	json.Unmarshal([]byte(body), &refreshed)
+
+	if code != 200 {
+		t.Fatalf("Wrong response status code. Expected: [%v] Actual: [%v] %v", 200, code, body)
+	}
+
+	if len(refreshed.RefreshToken) == 0 || refreshed.RefreshToken == login.RefreshToken {
+		t.Errorf("Refresh token should be rotated but got: [%v]", refreshed.RefreshToken)
+	}
+
+	if !s.IsTokenPresent(refreshed.AccessToken) {
+		t.Errorf("New access token should be stored in session")
+	}
+
+	code, body = doRequest(t, "POST", ts.URL+"/token/refresh", "", `{"refresh_token":"`+login.RefreshToken+`"}`)
+
+	expected := `{"error":"Unauthorized","reason":"Refresh token reuse detected"}`
+	if code != 401 || !IsEqualJson(body, expected) {
+		t.Errorf("Replayed refresh token should be rejected. Expected: [%v] Actual: [%v %v]", expected, code, body)
+	}
+
+	if s.IsTokenPresent(refreshed.AccessToken) {
+		t.Errorf("Access tokens of revoked family should be deleted from session")
+	}
+
+	code, body = doRequest(t, "POST", ts.URL+"/token/refresh", "", `{"refresh_token":"`+refreshed.RefreshToken+`"}`)
+
+	expected = `{"error":"Unauthorized","reason":"Invalid refresh token"}`
+	if code != 401 || !IsEqualJson(body, expected) {
+		t.Errorf("Refresh token of revoked family should be rejected. Expected: [%v] Actual: [%v %v]", expected, code, body)
+	}
+}
+
+func TestLogoutRevokesRefreshToken(t *testing.T) {
+
+	ts := newTestServer()
+	defer ts.Close()
+
+	s.Store(testUser("test@gmail.com", "qwerty"))
+
+	_, body := doRequest(t, "POST", ts.URL+"/login", "", `{"email":"test@gmail.com","password":"qwerty"}`)
+
+	login := model.TokenResponse{}
+	json.Unmarshal([]byte(body), &login)
+
+	if code, body := doRequest(t, "POST", ts.URL+"/logout", login.AccessToken, nil); code != 200 {
+		t.Fatalf("Wrong response status code. Expected: [%v] Actual: [%v] %v", 200, code, body)
+	}
+
+	code, body := doRequest(t, "POST", ts.URL+"/token/refresh", "", `{"refresh_token":"`+login.RefreshToken+`"}`)
+
+	expected := `{"error":"Unauthorized","reason":"Invalid refresh token"}`
+	if code != 401 || !IsEqualJson(body, expected) {
+		t.Errorf("Refresh token should be revoked on logout. Expected: [%v] Actual: [%v %v]", expected, code, body)
+	}
+
+	if s.IsTokenPresent(login.AccessToken) {
+		t.Errorf("Access token should be deleted from session on logout")
+	}
+}
+
+func TestRefresh(t *testing.T) {
+
+	expiredToken := "expired"
+
+	tests := []struct {
+		description  string
+		requestBody  string
+		expestedBody string
+		expectedCode int
+		storeInitter func(s storage.Store)
+	}{
+		{
+			description:  "Should return bad request for unprocesable json",
+			requestBody:  `"refresh_token":"token"`,
+			expestedBody: `{"error":"Bad Request","reason":"Cant retrive refresh token"}`,
+			expectedCode: 400,
+			storeInitter: func(s storage.Store) {},
+		},
+		{
+			description:  "Should return bad request for empty refresh token",
+			requestBody:  `{"refresh_token":""}`,
+			expestedBody: `{"error":"Bad Request","reason":"Cant retrive refresh token"}`,
+			expectedCode: 400,
+			storeInitter: func(s storage.Store) {},
+		},
+		{
+			description:  "Should return unauthorized for unknown refresh token",
+			requestBody:  `{"refresh_token":"unknown"}`,
+			expestedBody: `{"error":"Unauthorized","reason":"Invalid refresh token"}`,
+			expectedCode: 401,
+			storeInitter: func(s storage.Store) {},
+		},
+		{
+			description:  "Should return unauthorized for expired refresh token",
+			requestBody:  `{"refresh_token":"` + expiredToken + `"}`,
+			expestedBody: `{"error":"Unauthorized","reason":"Refresh token expired"}`,
+			expectedCode: 401,
+			storeInitter: func(s storage.Store) {
+				s.Store(testUser("test@gmail.com", "qwerty"))
+				s.StoreRefreshToken(model.RefreshToken{
+					Id:        opaque.Hash(expiredToken),
+					Family:    "family",
+					UserId:    userId,
+					ExpiresAt: time.Now().Add(-time.Minute),
+				})
+			},
+		},
+		{
+			description:  "Should return forbidden for refresh token of banned user",
+			requestBody:  `{"refresh_token":"banned"}`,
+			expestedBody: `{"error":"Account Banned","reason":"Account is banned"}`,
+			expectedCode: 403,
+			storeInitter: func(s storage.Store) {
+				u := testUser("test@gmail.com", "qwerty")
+				u.Banned = true
+				s.Store(u)
+				s.StoreRefreshToken(model.RefreshToken{
+					Id:        opaque.Hash("banned"),
+					Family:    "family",
+					UserId:    userId,
+					ExpiresAt: time.Now().Add(time.Hour),
+				})
+			},
+		},
+	}
+
+	ts := newTestServer()
+	defer ts.Close()
+
+	for _, tc := range tests {
+
+		t.Run(tc.description, func(t *testing.T) {
+			testSetToDefault()
+			tc.storeInitter(s)
+
+			code, body := doRequest(t, "POST", ts.URL+"/token/refresh", "", tc.requestBody)
+
+			if ok := IsEqualJson(body, tc.expestedBody); !ok {
+				t.Errorf("Wrong response body. Expected: [%v] Actual: [%v]", tc.expestedBody, body)
+			}
+
+			if code != tc.expectedCode {
+				t.Errorf("Wrong response status code. Expected: [%v] Actual: [%v]", tc.expectedCode, code)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/rs/xid"
+
+	"authService/mail"
+	"authService/model"
+	"authService/opaque"
+	"authService/server"
+)
+
+const (
+	verificationTokenDuration = 24 * time.Hour
+	verifyEmailPurpose        = "verify-email"
+)
+
+var (
+	errEmailNotVerified    = errors.New("Email address is not verified")
+	errInvalidOneTimeToken = errors.New("Token is invalid or expired")
+	errNoBaseURL           = errors.New("Base URL of mailed links is not configured")
+)
+
+// VerifyEmail confirms email address of user who followed the link from verification email.
+// The link works once and only while user's email address is the one it was sent to. It never touches
+// Active flag, so account disabled by an admin stays disabled
+func VerifyEmail(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+
+	token := r.FormValue("token")
+
+	if token == "" {
+		prepareErrorResponse(rw, model.AuthError{ErrorCode: "Bad Request", Reason: "No token provided"}, http.StatusBadRequest)
+		return
+	}
+
+	u, err := useOneTimeToken(token, verifyEmailPurpose, func(u *model.User) string { return u.Credentials.Email })
+
+	if err != nil {
+		prepareErrorResponse(rw, model.AuthError{ErrorCode: "Bad Request", Reason: err.Error()}, http.StatusBadRequest)
+		return
+	}
+
+	u.EmailVerified = true
+
+	if err := server.RunningServer.UserStore.UpdateUser(*u); err != nil {
+		prepareErrorResponse(rw, model.AuthError{ErrorCode: "Internal Server Error", Reason: "Can't verify email"}, http.StatusInternalServerError)
+		return
+	}
+
+	logger.Printf("Email of user %v verified", u.Id)
+
+	writeUser(rw, u)
+}
+
+// sendVerificationEmail mails user a link to VerifyEmail, the link is bound to the address it is sent to
+func sendVerificationEmail(u *model.User) error {
+
+	token, err := newOneTimeToken(u.Id, verifyEmailPurpose, u.Credentials.Email, verificationTokenDuration)
+
+	if err != nil {
+		return err
+	}
+
+	link, err := mailedLink("/verify-email", token)
+
+	if err != nil {
+		return err
+	}
+
+	return server.RunningServer.Mailer.Send(mail.Message{
+		To:      u.Credentials.Email,
+		Subject: "Verify your email address",
+		Body:    "Follow the link to activate your account, it is valid for 24 hours:\n\n" + link,
+	})
+}
+
+// mailedLink points to path of the configured base URL. Request Host is never used, anyone could
+// forge it and get a token mailed to victim along with a link to their own site
+func mailedLink(path, token string) (string, error) {
+
+	base := server.RunningServer.Config.Mail.BaseURL
+
+	if base == "" {
+		return "", errNoBaseURL
+	}
+
+	return strings.TrimSuffix(base, "/") + path + "?token=" + url.QueryEscape(token), nil
+}
+
+// newOneTimeToken stores single use token of the purpose, binding is a state of the user the token is valid for
+func newOneTimeToken(userId xid.ID, purpose, binding string, ttl time.Duration) (string, error) {
+
+	token, err := opaque.New()
+
+	if err != nil {
+		return "", err
+	}
+
+	err = server.RunningServer.OneTimeStore.StoreOneTimeToken(model.OneTimeToken{
+		Id:        opaque.Hash(token),
+		Purpose:   purpose,
+		UserId:    userId,
+		Binding:   binding,
+		ExpiresAt: time.Now().Add(ttl),
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// useOneTimeToken burns the token and returns its user when token is unused, not expired and user
+// is still in the state the token was bound to
+func useOneTimeToken(token, purpose string, binding func(u *model.User) string) (*model.User, error) {
+
+	t, err := server.RunningServer.OneTimeStore.UseOneTimeToken(opaque.Hash(token), purpose)
+
+	if err != nil || t.Used || time.Now().After(t.ExpiresAt) {
+		return nil, errInvalidOneTimeToken
+	}
+
+	u, err := server.RunningServer.UserStore.GetUserById(t.UserId.String())
+
+	if err != nil || u.Credentials == nil || binding(u) != t.Binding {
+		return nil, errInvalidOneTimeToken
+	}
+
+	return u, nil
+}
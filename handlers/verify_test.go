@@ -0,0 +1,220 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"authService/model"
+	"authService/opaque"
+)
+
+// mailedToken returns token from the link of the last message sent to the address
+func mailedToken(t *testing.T, to string) string {
+
+	for i := len(mailbox.sent) - 1; i >= 0; i-- {
+		m := mailbox.sent[i]
+		if m.To != to {
+			continue
+		}
+		link := strings.Fields(m.Body[strings.Index(m.Body, "http"):])[0]
+		u, err := url.Parse(link)
+		if err != nil {
+			t.Fatalf("Mail mast contain a link but got: %v", m.Body)
+		}
+		return u.Query().Get("token")
+	}
+
+	t.Fatalf("No mail sent to %v", to)
+	return ""
+}
+
+func TestVerifyEmail_Flow(t *testing.T) {
+
+	ts := newTestServer()
+	defer ts.Close()
+
+	credentials := `{"email":"new@gmail.com","password":"qwerty"}`
+
+	// links point to configured base URL whatever Host the request came with
+	req, _ := http.NewRequest("POST", ts.URL+"/signin", strings.NewReader(credentials))
+	req.Host = "evil.example.com"
+
+	res, _ := http.DefaultClient.Do(req)
+	res.Body.Close()
+
+	if u, _ := s.GetUserByLogin("new@gmail.com"); u == nil || !u.Active || u.EmailVerified {
+		t.Fatalf("Pending user expected but got %v", u)
+	}
+
+	first := mailedToken(t, "new@gmail.com")
+
+	if !strings.Contains(mailbox.sent[0].Body, ts.URL+"/verify-email?token=") {
+		t.Errorf("Link to verify endpoint expected but got: %v", mailbox.sent[0].Body)
+	}
+
+	res, _ = http.Post(ts.URL+"/login", "application/json", strings.NewReader(credentials))
+	resp := model.AuthError{}
+	json.NewDecoder(res.Body).Decode(&resp)
+	res.Body.Close()
+
+	if res.StatusCode != 403 || resp.ErrorCode != "Email Not Verified" {
+		t.Errorf("Unverified user mast not log in but got [%v, %v]", res.StatusCode, resp)
+	}
+
+	second := mailedToken(t, "new@gmail.com")
+
+	if second == first || len(mailbox.sent) != 2 {
+		t.Errorf("Fresh link mast be sent on login attempt but got %v", mailbox.sent)
+	}
+
+	res, _ = http.Get(ts.URL + "/verify-email?token=" + url.QueryEscape(first))
+	res.Body.Close()
+
+	if res.StatusCode != 200 {
+		t.Fatalf("Wrong response status code. Expected: [%v] Actual: [%v]", 200, res.StatusCode)
+	}
+
+	if u, _ := s.GetUserByLogin("new@gmail.com"); !u.Active || !u.EmailVerified {
+		t.Errorf("Verified active user expected but got %v", u)
+	}
+
+	res, _ = http.Get(ts.URL + "/verify-email?token=" + url.QueryEscape(first))
+	res.Body.Close()
+
+	if res.StatusCode != 400 {
+		t.Errorf("Verification link mast work once but got: %v", res.StatusCode)
+	}
+
+	res, _ = http.Post(ts.URL+"/login", "application/json", strings.NewReader(credentials))
+	res.Body.Close()
+
+	if res.StatusCode != 200 {
+		t.Errorf("Verified user mast log in but got: %v", res.StatusCode)
+	}
+}
+
+func TestVerifyEmail(t *testing.T) {
+
+	tests := []struct {
+		description  string
+		token        model.OneTimeToken
+		query        string
+		expectedBody string
+		expectedCode int
+	}{
+		{
+			description:  "Should require token",
+			expectedBody: `{"error":"Bad Request","reason":"No token provided"}`,
+			expectedCode: 400,
+		},
+		{
+			description:  "Should reject unknown token",
+			query:        "token=unknown",
+			expectedBody: `{"error":"Bad Request","reason":"Token is invalid or expired"}`,
+			expectedCode: 400,
+		},
+		{
+			description:  "Should reject expired token",
+			token:        model.OneTimeToken{Id: opaque.Hash("token"), Purpose: "verify-email", UserId: userId, Binding: "user@gmail.com", ExpiresAt: time.Now().Add(-time.Minute)},
+			query:        "token=token",
+			expectedBody: `{"error":"Bad Request","reason":"Token is invalid or expired"}`,
+			expectedCode: 400,
+		},
+		{
+			description:  "Should reject token of other purpose",
+			token:        model.OneTimeToken{Id: opaque.Hash("token"), Purpose: "reset-password", UserId: userId, Binding: "user@gmail.com", ExpiresAt: time.Now().Add(time.Hour)},
+			query:        "token=token",
+			expectedBody: `{"error":"Bad Request","reason":"Token is invalid or expired"}`,
+			expectedCode: 400,
+		},
+		{
+			description:  "Should reject token sent to other address",
+			token:        model.OneTimeToken{Id: opaque.Hash("token"), Purpose: "verify-email", UserId: userId, Binding: "old@gmail.com", ExpiresAt: time.Now().Add(time.Hour)},
+			query:        "token=token",
+			expectedBody: `{"error":"Bad Request","reason":"Token is invalid or expired"}`,
+			expectedCode: 400,
+		},
+		{
+			description:  "Should verify email",
+			token:        model.OneTimeToken{Id: opaque.Hash("token"), Purpose: "verify-email", UserId: userId, Binding: "user@gmail.com", ExpiresAt: time.Now().Add(time.Hour)},
+			query:        "token=token",
+			expectedBody: `{"id":"bfra5o2cc8imh64se1s0","credentials":{"email":"user@gmail.com"},"active":true,"banned":false,"email_verified":true}`,
+			expectedCode: 200,
+		},
+	}
+
+	ts := newTestServer()
+	defer ts.Close()
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			testSetToDefault()
+
+			u := testUser("user@gmail.com", "qwerty")
+			u.EmailVerified = false
+			s.Store(u)
+
+			if tc.token.Id != "" {
+				s.StoreOneTimeToken(tc.token)
+			}
+
+			res, err := http.PostForm(ts.URL+"/verify-email?"+tc.query, url.Values{})
+
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			defer res.Body.Close()
+
+			b, _ := ioutil.ReadAll(res.Body)
+
+			if ok := IsEqualJson(string(b), tc.expectedBody); !ok {
+				t.Errorf("Wrong response body. Expected: [%v] Actual: [%v]", tc.expectedBody, string(b))
+			}
+
+			if res.StatusCode != tc.expectedCode {
+				t.Errorf("Wrong response status code. Expected: [%v] Actual: [%v]", tc.expectedCode, res.StatusCode)
+			}
+		})
+	}
+}
+
+func TestVerifyEmail_KeepsDisabledAccountInactive(t *testing.T) {
+
+	ts := newTestServer()
+	defer ts.Close()
+
+	u := testUser("user@gmail.com", "qwerty")
+	u.EmailVerified = false
+	u.Active = false
+	s.Store(u)
+
+	credentials := `{"email":"user@gmail.com","password":"qwerty"}`
+
+	res, _ := http.Post(ts.URL+"/login", "application/json", strings.NewReader(credentials))
+	res.Body.Close()
+
+	if res.StatusCode != 403 || len(mailbox.sent) != 0 {
+		t.Errorf("Disabled user mast not log in nor get verification link but got [%v, %v]", res.StatusCode, mailbox.sent)
+	}
+
+	s.StoreOneTimeToken(model.OneTimeToken{Id: opaque.Hash("token"), Purpose: "verify-email", UserId: userId, Binding: "user@gmail.com", ExpiresAt: time.Now().Add(time.Hour)})
+
+	res, _ = http.Get(ts.URL + "/verify-email?token=token")
+	res.Body.Close()
+
+	if stored, _ := s.GetUserByLogin("user@gmail.com"); res.StatusCode != 200 || !stored.EmailVerified || stored.Active {
+		t.Errorf("Verified but still disabled user expected but got [%v, %v]", res.StatusCode, stored)
+	}
+
+	res, _ = http.Post(ts.URL+"/login", "application/json", strings.NewReader(credentials))
+	res.Body.Close()
+
+	if res.StatusCode != 403 {
+		t.Errorf("Disabled user mast not log in after verification but got: %v", res.StatusCode)
+	}
+}
@@ -0,0 +1,105 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"hash"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+)
+
+const DefaultAlgorithm = "PS512"
+
+var ErrUnsupportedAlgorithm = errors.New("Unsupported signing algorithm")
+
+var curveAlgorithms = map[string]string{
+	"P-256": "ES256",
+	"P-384": "ES384",
+	"P-521": "ES512",
+}
+
+// signingMethod returns asymmetric signing method for algorithm, shared secret and "none" algorithms are never accepted
+func signingMethod(alg string) (jwt.SigningMethod, error) {
+	switch alg {
+	case "RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA":
+		return jwt.GetSigningMethod(alg), nil
+	}
+	return nil, errors.Wrap(ErrUnsupportedAlgorithm, alg)
+}
+
+// checkAlgorithm verifies public key can be used with algorithm
+func checkAlgorithm(alg string, public crypto.PublicKey) error {
+
+	if _, err := signingMethod(alg); err != nil {
+		return err
+	}
+
+	switch k := public.(type) {
+	case *rsa.PublicKey:
+		if alg[0] == 'R' || alg[0] == 'P' {
+			return nil
+		}
+	case *ecdsa.PublicKey:
+		if curveAlgorithms[k.Curve.Params().Name] == alg {
+			return nil
+		}
+	case ed25519.PublicKey:
+		if alg == "EdDSA" {
+			return nil
+		}
+	}
+
+	return errors.Errorf("Key of type %T can't be used with %v algorithm", public, alg)
+}
+
+// defaultAlgorithm picks algorithm for a key when it is not configured explicitly,
+// RSA keys use preferred algorithm if it is an RSA one
+func defaultAlgorithm(public crypto.PublicKey, preferred string) string {
+
+	switch k := public.(type) {
+	case *ecdsa.PublicKey:
+		return curveAlgorithms[k.Curve.Params().Name]
+	case ed25519.PublicKey:
+		return "EdDSA"
+	}
+
+	if checkAlgorithm(preferred, public) == nil {
+		return preferred
+	}
+
+	return DefaultAlgorithm
+}
+
+// atHash is OpenID Connect access token hash: left half of the token hash made with hash function of the
+// signing algorithm, base64url encoded. EdDSA uses SHA-512 as Ed25519 does
+func atHash(alg, accessToken string) (string, error) {
+
+	var h hash.Hash
+
+	switch alg {
+	case "RS256", "PS256", "ES256":
+		h = sha256.New()
+	case "RS384", "PS384", "ES384":
+		h = sha512.New384()
+	case "RS512", "PS512", "ES512", "EdDSA":
+		h = sha512.New()
+	default:
+		return "", errors.Wrap(ErrUnsupportedAlgorithm, alg)
+	}
+
+	h.Write([]byte(accessToken))
+	sum := h.Sum(nil)
+
+	return base64.RawURLEncoding.EncodeToString(sum[:len(sum)/2]), nil
+}
+
+func curveName(c elliptic.Curve) string {
+	return c.Params().Name
+}
@@ -0,0 +1,65 @@
+package jwt
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"authService/model"
+)
+
+// User fields which can be embedded into issued tokens, "attributes.<name>" embeds a single attribute
+const (
+	EmailClaim      = "email"
+	RolesClaim      = "roles"
+	GroupsClaim     = "groups"
+	AttributesClaim = "attributes"
+)
+
+var DefaultClaims = []string{RolesClaim, GroupsClaim}
+
+var ErrUnknownClaim = errors.New("Unknown user claim")
+
+// ClaimsMapper decides which user fields get embedded into claims of issued token
+type ClaimsMapper interface {
+	MapClaims(u *model.User, claims *model.TokenClaims) error
+}
+
+// FieldMapper embeds listed user fields into token
+type FieldMapper []string
+
+func (m FieldMapper) MapClaims(u *model.User, claims *model.TokenClaims) error {
+
+	for _, field := range m {
+		switch {
+		case field == EmailClaim:
+			if u.Credentials != nil {
+				claims.Email = u.Credentials.Email
+			}
+		case field == RolesClaim:
+			claims.Roles = u.Roles
+		case field == GroupsClaim:
+			claims.Groups = u.Groups
+		case field == AttributesClaim:
+			for name, value := range u.Attributes {
+				setAttribute(claims, name, value)
+			}
+		case strings.HasPrefix(field, AttributesClaim+"."):
+			name := strings.TrimPrefix(field, AttributesClaim+".")
+			if value, ok := u.Attributes[name]; ok {
+				setAttribute(claims, name, value)
+			}
+		default:
+			return errors.Wrap(ErrUnknownClaim, field)
+		}
+	}
+
+	return nil
+}
+
+func setAttribute(claims *model.TokenClaims, name, value string) {
+	if claims.Attributes == nil {
+		claims.Attributes = map[string]string{}
+	}
+	claims.Attributes[name] = value
+}
@@ -0,0 +1,307 @@
+package jwt
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"authService/config"
+	"authService/model"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+	"github.com/rs/xid"
+)
+
+func testClaimsUser() *model.User {
+	return &model.User{
+		Id:          xid.New(),
+		Credentials: &model.Credentilas{Email: "user@gmail.com", Password: "hash"},
+		Roles:       []string{"admin"},
+		Groups:      []string{"staff"},
+		Attributes:  map[string]string{"department": "it", "phone": "555"},
+	}
+}
+
+func TestJwt_GenerateTokenClaims(t *testing.T) {
+
+	c := config.Configuration{
+		Token: config.TokenConfig{
+			TTL:      config.Duration{Duration: 15 * time.Minute},
+			Issuer:   "https://auth.example.com",
+			Audience: "example",
+		},
+	}
+	tok := NewTokenizer(TestKeyLoader{}, c)
+
+	first, _ := tok.GenerateToken(testClaimsUser())
+	second, _ := tok.GenerateToken(testClaimsUser())
+
+	pFirst, err := tok.ParceAndVerifyToken(first)
+
+	if err != nil {
+		t.Fatalf("Parse token mast parse it but return error: %v", err)
+	}
+
+	pSecond, _ := tok.ParceAndVerifyToken(second)
+
+	claims := pFirst.Claims.(*model.TokenClaims)
+
+	if claims.Issuer != "https://auth.example.com" || !claims.Audience.Contains("example") {
+		t.Errorf("Expected [%v, %v] but was: [%v, %v]", "https://auth.example.com", "example", claims.Issuer, claims.Audience)
+	}
+
+	if claims.ExpiresAt-claims.IssuedAt != int64((15*time.Minute).Seconds()) || claims.NotBefore != claims.IssuedAt {
+		t.Errorf("Expected token valid for [%v] from issue time but was: [iat %v, nbf %v, exp %v]", 15*time.Minute, claims.IssuedAt, claims.NotBefore, claims.ExpiresAt)
+	}
+
+	if claims.Id == "" || claims.Id == pSecond.Claims.(*model.TokenClaims).Id {
+		t.Errorf("Every token mast have unique 'jti' claim but got: %v", claims.Id)
+	}
+
+	if len(claims.Roles) != 1 || len(claims.Groups) != 1 || claims.Email != "" || claims.Attributes != nil {
+		t.Errorf("Only roles and groups mast be embedded by default but got: %v", claims)
+	}
+}
+
+func TestFieldMapper_MapClaims(t *testing.T) {
+
+	tests := []struct {
+		description string
+		fields      FieldMapper
+		expected    model.TokenClaims
+	}{
+		{
+			description: "No fields",
+			fields:      FieldMapper{},
+			expected:    model.TokenClaims{},
+		},
+		{
+			description: "Email and roles",
+			fields:      FieldMapper{EmailClaim, RolesClaim},
+			expected:    model.TokenClaims{Email: "user@gmail.com", Roles: []string{"admin"}},
+		},
+		{
+			description: "All attributes",
+			fields:      FieldMapper{GroupsClaim, AttributesClaim},
+			expected:    model.TokenClaims{Groups: []string{"staff"}, Attributes: map[string]string{"department": "it", "phone": "555"}},
+		},
+		{
+			description: "Single attribute",
+			fields:      FieldMapper{"attributes.department", "attributes.missing"},
+			expected:    model.TokenClaims{Attributes: map[string]string{"department": "it"}},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			claims := model.TokenClaims{}
+
+			if err := tc.fields.MapClaims(testClaimsUser(), &claims); err != nil {
+				t.Fatalf("Claims mast be mapped but got error: %v", err)
+			}
+
+			if !reflect.DeepEqual(claims, tc.expected) {
+				t.Errorf("Expected [%v] but was: [%v]", tc.expected, claims)
+			}
+		})
+	}
+
+	err := FieldMapper{"password"}.MapClaims(testClaimsUser(), &model.TokenClaims{})
+
+	if errors.Cause(err) != ErrUnknownClaim {
+		t.Errorf("Expected [%v] but was: [%v]", ErrUnknownClaim, err)
+	}
+}
+
+func TestJwt_ValidateClaims(t *testing.T) {
+
+	now := time.Now()
+	at := func(d time.Duration) int64 {
+		return now.Add(d).Unix()
+	}
+
+	token := config.TokenConfig{
+		Issuer:   "https://auth.example.com",
+		Audience: "example",
+		Skew:     config.Duration{Duration: 30 * time.Second},
+	}
+
+	with := func(change func(c *model.TokenClaims)) *model.TokenClaims {
+		c := &model.TokenClaims{
+			Issuer:    "https://auth.example.com",
+			Audience:  model.Audience{"example"},
+			IssuedAt:  at(0),
+			NotBefore: at(0),
+			ExpiresAt: at(time.Hour),
+		}
+		change(c)
+		return c
+	}
+
+	tests := []struct {
+		description string
+		config      config.TokenConfig
+		claims      *model.TokenClaims
+		expected    uint32
+	}{
+		{"Valid token", token, with(func(c *model.TokenClaims) {}), 0},
+		{"One of audiences", token, with(func(c *model.TokenClaims) { c.Audience = model.Audience{"other", "example"} }), 0},
+		{"Expired within skew", token, with(func(c *model.TokenClaims) { c.ExpiresAt = at(-20 * time.Second) }), 0},
+		{"Expired", token, with(func(c *model.TokenClaims) { c.ExpiresAt = at(-time.Minute) }), jwt.ValidationErrorExpired},
+		{"No expiration", token, with(func(c *model.TokenClaims) { c.ExpiresAt = 0 }), jwt.ValidationErrorClaimsInvalid},
+		{"Not valid yet within skew", token, with(func(c *model.TokenClaims) { c.NotBefore = at(20 * time.Second) }), 0},
+		{"Not valid yet", token, with(func(c *model.TokenClaims) { c.NotBefore = at(time.Minute) }), jwt.ValidationErrorNotValidYet},
+		{"Issued in future", token, with(func(c *model.TokenClaims) { c.IssuedAt = at(time.Minute) }), jwt.ValidationErrorIssuedAt},
+		{"Other issuer", token, with(func(c *model.TokenClaims) { c.Issuer = "https://auth.staging.example.com" }), jwt.ValidationErrorIssuer},
+		{"No issuer", token, with(func(c *model.TokenClaims) { c.Issuer = "" }), jwt.ValidationErrorIssuer},
+		{"Other audience", token, with(func(c *model.TokenClaims) { c.Audience = model.Audience{"staging"} }), jwt.ValidationErrorAudience},
+		{"No audience", token, with(func(c *model.TokenClaims) { c.Audience = nil }), jwt.ValidationErrorAudience},
+		{"Issuer and audience not configured", config.TokenConfig{}, with(func(c *model.TokenClaims) { c.Issuer = "any" }), 0},
+		{"No skew configured", config.TokenConfig{}, with(func(c *model.TokenClaims) { c.ExpiresAt = at(-time.Second) }), jwt.ValidationErrorExpired},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			tok := &Jwt{token: tc.config}
+
+			err := tok.validateClaims(tc.claims, now)
+
+			if tc.expected == 0 {
+				if err != nil {
+					t.Errorf("Claims mast be valid but got error: %v", err)
+				}
+				return
+			}
+
+			if e, ok := err.(*jwt.ValidationError); !ok || e.Errors&tc.expected == 0 {
+				t.Errorf("Expected validation error [%v] but was: [%v]", tc.expected, err)
+			}
+		})
+	}
+}
+
+func TestJwt_ParceAndVerifyTokenOfOtherEnvironment(t *testing.T) {
+
+	staging := NewTokenizer(TestKeyLoader{}, config.Configuration{Token: config.TokenConfig{Issuer: "https://auth.example.com", Audience: "staging"}})
+	production := NewTokenizer(TestKeyLoader{}, config.Configuration{Token: config.TokenConfig{Issuer: "https://auth.example.com", Audience: "production"}})
+
+	token, _ := staging.GenerateToken(&model.User{Id: xid.New()})
+
+	if pToken, err := production.ParceAndVerifyToken(token); err == nil || pToken.Valid {
+		t.Errorf("Token issued for other audience mast be rejected")
+	}
+
+	if _, err := staging.ParceAndVerifyToken(token); err != nil {
+		t.Errorf("Token mast be accepted by its audience but got error: %v", err)
+	}
+}
+
+func TestJwt_GenerateTokenUnknownClaim(t *testing.T) {
+
+	tok := NewTokenizer(TestKeyLoader{}, config.Configuration{Token: config.TokenConfig{Claims: []string{"password"}}})
+
+	if _, err := tok.GenerateToken(testClaimsUser()); err == nil {
+		t.Errorf("Token with unknown user field mast not be generated")
+	}
+}
+
+func TestJwt_GenerateTokenFor(t *testing.T) {
+
+	tok := NewTokenizer(TestKeyLoader{}, config.Configuration{})
+
+	token, err := tok.GenerateTokenFor(testClaimsUser(), "app", "openid profile")
+
+	if err != nil {
+		t.Fatalf("Token mast be generated but got error: %v", err)
+	}
+
+	pToken, _ := tok.ParceAndVerifyToken(token)
+
+	if claims := pToken.Claims.(*model.TokenClaims); claims.ClientId != "app" || claims.Scope != "openid profile" {
+		t.Errorf("Expected [%v, %v] but was: [%v, %v]", "app", "openid profile", claims.ClientId, claims.Scope)
+	}
+}
+
+func TestJwt_GenerateClientToken(t *testing.T) {
+
+	tok := NewTokenizer(TestKeyLoader{}, config.Configuration{Token: config.TokenConfig{Audience: "api", Claims: []string{EmailClaim, RolesClaim}}})
+
+	token, err := tok.GenerateClientToken(&model.Client{Id: "gateway", Scopes: []string{"users:read"}}, "users:read")
+
+	if err != nil {
+		t.Fatalf("Token mast be generated but got error: %v", err)
+	}
+
+	pToken, err := tok.ParceAndVerifyToken(token)
+
+	if err != nil {
+		t.Fatalf("Client token mast be valid but got error: %v", err)
+	}
+
+	claims := pToken.Claims.(*model.TokenClaims)
+
+	if claims.Subject != "gateway" || claims.ClientId != "gateway" || claims.Scope != "users:read" || !claims.IsClientToken() {
+		t.Errorf("Token mast be issued to client on its own behalf but got: %v", claims)
+	}
+
+	if claims.Email != "" || claims.Roles != nil || !claims.Audience.Contains("api") {
+		t.Errorf("Client token mast carry no user claims but got: %v", claims)
+	}
+}
+
+func TestJwt_GenerateIdToken(t *testing.T) {
+
+	tok := NewTokenizer(TestKeyLoader{}, config.Configuration{Token: config.TokenConfig{Issuer: "https://auth.example.com", Audience: "api"}})
+	u := testClaimsUser()
+	authTime := time.Now().Add(-time.Hour)
+
+	idToken, err := tok.GenerateIdToken(u, "app", "n-0S6_WzA2Mj", authTime, "access")
+
+	if err != nil {
+		t.Fatalf("ID token mast be generated but got error: %v", err)
+	}
+
+	claims := &model.TokenClaims{}
+	_, err = jwt.ParseWithClaims(idToken, claims, func(token *jwt.Token) (interface{}, error) {
+		return testKeys().Current().PublicKey, nil
+	})
+
+	if err != nil {
+		t.Fatalf("ID token mast be signed with current key but got error: %v", err)
+	}
+
+	expectedHash, _ := atHash(DefaultAlgorithm, "access")
+
+	if claims.Subject != u.Id.String() || claims.Issuer != "https://auth.example.com" || !claims.Audience.Contains("app") || claims.Audience.Contains("api") {
+		t.Errorf("ID token mast be issued to client but got: %v", claims)
+	}
+
+	if claims.Nonce != "n-0S6_WzA2Mj" || claims.AuthTime != authTime.Unix() || claims.AtHash != expectedHash || claims.ExpiresAt == 0 {
+		t.Errorf("ID token mast carry nonce, auth_time and at_hash but got: %v", claims)
+	}
+
+	if _, err := tok.ParceAndVerifyToken(idToken); err == nil {
+		t.Errorf("ID token mast not be accepted as access token")
+	}
+}
+
+func TestAtHash(t *testing.T) {
+
+	// OpenID Connect Core 1.0 A.3 example
+	hash, err := atHash("RS256", "jHkWEdUXMU1BwAsC4vtUsZwnNvTIxEl0z9K3vx5KF0Y")
+
+	if err != nil || hash != "77QmUPtjPfzWtF2AnpK9RQ" {
+		t.Errorf("Expected [%v] but was: [%v, %v]", "77QmUPtjPfzWtF2AnpK9RQ", hash, err)
+	}
+
+	for alg, size := range map[string]int{"ES384": 32, "PS512": 43, "EdDSA": 43} {
+		if hash, _ := atHash(alg, "token"); len(hash) != size {
+			t.Errorf("Expected [%v] characters for %v but was: [%v]", size, alg, hash)
+		}
+	}
+
+	if _, err := atHash("HS256", "token"); err == nil {
+		t.Errorf("Unsupported algorithm mast be rejected")
+	}
+}
@@ -0,0 +1,136 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/pkg/errors"
+)
+
+const rsaKeySize = 3072
+
+var logger = log.New(os.Stdout, "[jwt] ", log.LstdFlags)
+
+var ErrInsecureKeyFile = errors.New("Private key file is accessible by group or others")
+
+var algorithmCurves = map[string]elliptic.Curve{
+	"ES256": elliptic.P256(),
+	"ES384": elliptic.P384(),
+	"ES512": elliptic.P521(),
+}
+
+// GenerateKey creates a private key of the type algorithm signs with
+func GenerateKey(algorithm string) (crypto.Signer, error) {
+
+	if _, err := signingMethod(algorithm); err != nil {
+		return nil, err
+	}
+
+	switch algorithm[0] {
+	case 'R', 'P':
+		return rsa.GenerateKey(rand.Reader, rsaKeySize)
+	case 'E':
+		if curve, ok := algorithmCurves[algorithm]; ok {
+			return ecdsa.GenerateKey(curve, rand.Reader)
+		}
+		_, private, err := ed25519.GenerateKey(rand.Reader)
+		return private, err
+	}
+
+	return nil, errors.Wrap(ErrUnsupportedAlgorithm, algorithm)
+}
+
+// generateKeyPair writes a fresh key pair to the configured paths when neither of them exists yet.
+// It never overwrites a key, a single missing file is left for the loader to report
+func generateKeyPair(privatePath, publicPath, algorithm string) (bool, error) {
+
+	if !missing(privatePath) || !missing(publicPath) {
+		return false, nil
+	}
+
+	private, err := GenerateKey(algorithm)
+
+	if err != nil {
+		return false, err
+	}
+
+	privateDer, err := x509.MarshalPKCS8PrivateKey(private)
+
+	if err != nil {
+		return false, errors.Wrap(err, "Can't marshal private key")
+	}
+
+	publicDer, err := x509.MarshalPKIXPublicKey(private.Public())
+
+	if err != nil {
+		return false, errors.Wrap(err, "Can't marshal public key")
+	}
+
+	if err := writeKeyFile(privatePath, "PRIVATE KEY", privateDer, 0600); err != nil {
+		return false, err
+	}
+
+	if err := writeKeyFile(publicPath, "PUBLIC KEY", publicDer, 0644); err != nil {
+		os.Remove(privatePath)
+		return false, err
+	}
+
+	return true, nil
+}
+
+// writeKeyFile creates a new file only, so concurrently started instances can't replace each other's keys
+func writeKeyFile(path, blockType string, der []byte, perm os.FileMode) error {
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return errors.Wrapf(err, "Can't create key directory for %v", path)
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+
+	if err != nil {
+		return errors.Wrapf(err, "Can't create key %v", path)
+	}
+
+	if err := pem.Encode(f, &pem.Block{Type: blockType, Bytes: der}); err != nil {
+		f.Close()
+		os.Remove(path)
+		return errors.Wrapf(err, "Can't write key %v", path)
+	}
+
+	return f.Close()
+}
+
+// checkKeyPermissions refuses private keys other users can read, Windows has no such mode bits
+func checkKeyPermissions(path string) error {
+
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+
+	info, err := os.Stat(path)
+
+	if err != nil {
+		return errors.Wrap(err, "Can't read key")
+	}
+
+	if info.Mode().Perm()&0077 != 0 {
+		return errors.Wrapf(ErrInsecureKeyFile, "%v has mode %v, expected 0600", path, info.Mode().Perm())
+	}
+
+	return nil
+}
+
+func missing(path string) bool {
+	_, err := os.Stat(path)
+	return os.IsNotExist(err)
+}
@@ -0,0 +1,114 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+
+	"github.com/pkg/errors"
+)
+
+// JWK is a public key in JSON Web Key format (RFC 7517)
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Kid string `json:"kid"`
+	Crv string `json:"crv,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns public keys tokens can be verified with, including retired keys in grace period
+func (k *KeyChain) JWKS() JWKSet {
+
+	set := JWKSet{Keys: []JWK{}}
+
+	for _, key := range k.VerificationKeys() {
+		jwk, err := publicJWK(key.PublicKey)
+		if err != nil {
+			continue
+		}
+		jwk.Use = "sig"
+		jwk.Alg = key.Algorithm
+		jwk.Kid = key.Kid
+		set.Keys = append(set.Keys, jwk)
+	}
+
+	return set
+}
+
+// publicJWK returns required members of public key JWK, RFC 7638 thumbprint is computed over them
+func publicJWK(public crypto.PublicKey) (JWK, error) {
+
+	switch k := public.(type) {
+	case *rsa.PublicKey:
+		return JWK{
+			Kty: "RSA",
+			N:   encodeBigInt(k.N),
+			E:   encodeBigInt(big.NewInt(int64(k.E))),
+		}, nil
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		return JWK{
+			Kty: "EC",
+			Crv: curveName(k.Curve),
+			X:   base64.RawURLEncoding.EncodeToString(k.X.FillBytes(make([]byte, size))),
+			Y:   base64.RawURLEncoding.EncodeToString(k.Y.FillBytes(make([]byte, size))),
+		}, nil
+	case ed25519.PublicKey:
+		return JWK{
+			Kty: "OKP",
+			Crv: "Ed25519",
+			X:   base64.RawURLEncoding.EncodeToString(k),
+		}, nil
+	}
+
+	return JWK{}, errors.Errorf("Unsupported public key type %T", public)
+}
+
+// thumbprint is RFC 7638 key thumbprint, so the same key always gets the same kid
+func thumbprint(public crypto.PublicKey) (string, error) {
+
+	jwk, err := publicJWK(public)
+
+	if err != nil {
+		return "", err
+	}
+
+	// json.Marshal writes map keys in lexicographic order without whitespaces as RFC 7638 requires
+	members := map[string]string{"kty": jwk.Kty}
+
+	switch jwk.Kty {
+	case "RSA":
+		members["n"] = jwk.N
+		members["e"] = jwk.E
+	case "EC":
+		members["crv"] = jwk.Crv
+		members["x"] = jwk.X
+		members["y"] = jwk.Y
+	case "OKP":
+		members["crv"] = jwk.Crv
+		members["x"] = jwk.X
+	}
+
+	b, _ := json.Marshal(members)
+
+	sum := sha256.Sum256(b)
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+func encodeBigInt(i *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(i.Bytes())
+}
@@ -4,45 +4,147 @@ import (
 	"fmt"
 	"time"
 
+	"authService/config"
 	"authService/model"
 
 	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/pkg/errors"
+	"github.com/rs/xid"
 )
 
 type Tokenazer interface {
 	GenerateToken(u *model.User) (string, error)
+	// GenerateTokenFor issues token to OAuth client, clientId and scope are stamped on the token
+	GenerateTokenFor(u *model.User, clientId, scope string) (string, error)
+	// GenerateClientToken issues token to OAuth client acting on its own behalf, client is the subject
+	GenerateClientToken(c *model.Client, scope string) (string, error)
+	GenerateIdToken(u *model.User, clientId, nonce string, authTime time.Time, accessToken string) (string, error)
 	ParceAndVerifyToken(s string) (*jwt.Token, error)
+	JWKS() (JWKSet, error)
 }
 
 type Jwt struct {
 	keyLoader KeyLoader
+	allowed   []string
+	token     config.TokenConfig
+	mapper    ClaimsMapper
 }
 
-const (
-	tokenDuration = 1
-	expireOffset  = 3600
-)
+const defaultTokenTTL = time.Hour
 
 var tokenizer *Jwt = nil
 
-func NewTokenizer(k KeyLoader) *Jwt {
+func NewTokenizer(k KeyLoader, c config.Configuration) *Jwt {
 	return &Jwt{
 		keyLoader: k,
+		allowed:   c.Auth.AllowedAlgorithms,
+		token:     c.Token,
+		mapper:    FieldMapper(c.Token.Claims),
 	}
 
 }
 
 func (j *Jwt) GenerateToken(u *model.User) (string, error) {
-	keys := j.keyLoader.InitializeKeysChain()
+	return j.GenerateTokenFor(u, "", "")
+}
+
+func (j *Jwt) GenerateTokenFor(u *model.User, clientId, scope string) (string, error) {
+
+	now := time.Now()
+
+	claims := &model.TokenClaims{
+		Subject:   u.Id.String(),
+		Issuer:    j.token.Issuer,
+		Id:        xid.New().String(),
+		IssuedAt:  now.Unix(),
+		NotBefore: now.Unix(),
+		ExpiresAt: now.Add(j.ttl()).Unix(),
+		ClientId:  clientId,
+		Scope:     scope,
+	}
+
+	if j.token.Audience != "" {
+		claims.Audience = model.Audience{j.token.Audience}
+	}
+
+	if err := j.claimsMapper().MapClaims(u, claims); err != nil {
+		return "", err
+	}
+
+	return j.sign(claims, nil)
+}
+
+func (j *Jwt) GenerateClientToken(c *model.Client, scope string) (string, error) {
+
+	now := time.Now()
+
+	claims := &model.TokenClaims{
+		Subject:   c.Id,
+		Issuer:    j.token.Issuer,
+		Id:        xid.New().String(),
+		IssuedAt:  now.Unix(),
+		NotBefore: now.Unix(),
+		ExpiresAt: now.Add(j.ttl()).Unix(),
+		ClientId:  c.Id,
+		Scope:     scope,
+	}
+
+	if j.token.Audience != "" {
+		claims.Audience = model.Audience{j.token.Audience}
+	}
+
+	return j.sign(claims, nil)
+}
+
+// GenerateIdToken issues OpenID Connect ID token for client, at_hash binds it to access token issued along
+func (j *Jwt) GenerateIdToken(u *model.User, clientId, nonce string, authTime time.Time, accessToken string) (string, error) {
+
+	now := time.Now()
+
+	claims := &model.TokenClaims{
+		Subject:   u.Id.String(),
+		Issuer:    j.token.Issuer,
+		Audience:  model.Audience{clientId},
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(j.ttl()).Unix(),
+		Nonce:     nonce,
+		AuthTime:  authTime.Unix(),
+	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodPS512, jwt.MapClaims{
-		"sub": u.Id,
-		"iat": time.Now().Unix(),
-		"exp": time.Now().Add(time.Hour * time.Duration(tokenDuration)).Unix(),
+	return j.sign(claims, func(key *SigningKey) error {
+		hash, err := atHash(key.Algorithm, accessToken)
+		claims.AtHash = hash
+		return err
 	})
+}
+
+// sign signs claims with current key, stamp adds claims which depend on the key
+func (j *Jwt) sign(claims *model.TokenClaims, stamp func(key *SigningKey) error) (string, error) {
+
+	keys, err := j.keyLoader.InitializeKeysChain()
+
+	if err != nil {
+		return "", err
+	}
+
+	key := keys.Current()
+
+	method, err := signingMethod(key.Algorithm)
+
+	if err != nil {
+		return "", err
+	}
+
+	if stamp != nil {
+		if err := stamp(key); err != nil {
+			return "", err
+		}
+	}
+
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = key.Kid
 
-	tokenString, err := token.SignedString(keys.PrivateKey)
+	tokenString, err := token.SignedString(key.PrivateKey)
 
 	if err != nil {
 		return "", errors.New("No Token has been generated")
@@ -53,10 +155,123 @@ func (j *Jwt) GenerateToken(u *model.User) (string, error) {
 }
 
 func (j *Jwt) ParceAndVerifyToken(token string) (*jwt.Token, error) {
-	return jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodRSAPSS); !ok {
+
+	keys, err := j.keyLoader.InitializeKeysChain()
+
+	if err != nil {
+		return nil, err
+	}
+
+	// time based claims are validated with configured clock skew by validateClaims
+	parser := &jwt.Parser{ValidMethods: j.allowedAlgorithms(keys), SkipClaimsValidation: true}
+
+	parsed, err := parser.ParseWithClaims(token, &model.TokenClaims{}, func(token *jwt.Token) (interface{}, error) {
+
+		key := keys.Current()
+
+		// tokens issued before key ids were introduced are verified with current key
+		if kid, ok := token.Header["kid"].(string); ok {
+			var err error
+			if key, err = keys.Key(kid); err != nil {
+				return nil, err
+			}
+		}
+
+		// key is bound to a single algorithm, so a token can't switch it to another one
+		if token.Method.Alg() != key.Algorithm {
 			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
 		}
-		return j.keyLoader.InitializeKeysChain().PublicKey, nil
+
+		return key.PublicKey, nil
 	})
+
+	if err != nil {
+		return parsed, err
+	}
+
+	if err := j.validateClaims(parsed.Claims.(*model.TokenClaims), time.Now()); err != nil {
+		parsed.Valid = false
+		return parsed, err
+	}
+
+	return parsed, nil
+}
+
+// validateClaims requires exp, checks nbf and iat with clock skew tolerance and makes sure token
+// was issued for configured issuer and audience, so it can't be replayed in another environment
+func (j *Jwt) validateClaims(claims *model.TokenClaims, now time.Time) error {
+
+	skew := int64(j.token.Skew.Seconds())
+	unix := now.Unix()
+
+	if claims.ExpiresAt == 0 {
+		return jwt.NewValidationError("Token has no expiration time", jwt.ValidationErrorClaimsInvalid)
+	}
+
+	if unix > claims.ExpiresAt+skew {
+		return jwt.NewValidationError("Token is expired", jwt.ValidationErrorExpired)
+	}
+
+	if claims.NotBefore != 0 && unix+skew < claims.NotBefore {
+		return jwt.NewValidationError("Token is not valid yet", jwt.ValidationErrorNotValidYet)
+	}
+
+	if claims.IssuedAt != 0 && unix+skew < claims.IssuedAt {
+		return jwt.NewValidationError("Token used before issued", jwt.ValidationErrorIssuedAt)
+	}
+
+	if j.token.Issuer != "" && claims.Issuer != j.token.Issuer {
+		return jwt.NewValidationError("Token has unexpected issuer", jwt.ValidationErrorIssuer)
+	}
+
+	if j.token.Audience != "" && !claims.Audience.Contains(j.token.Audience) {
+		return jwt.NewValidationError("Token has unexpected audience", jwt.ValidationErrorAudience)
+	}
+
+	return nil
+}
+
+func (j *Jwt) ttl() time.Duration {
+
+	if j.token.TTL.Duration > 0 {
+		return j.token.TTL.Duration
+	}
+
+	return defaultTokenTTL
+}
+
+// claimsMapper embeds DefaultClaims unless user fields are configured
+func (j *Jwt) claimsMapper() ClaimsMapper {
+
+	if m, ok := j.mapper.(FieldMapper); j.mapper == nil || ok && len(m) == 0 {
+		return FieldMapper(DefaultClaims)
+	}
+
+	return j.mapper
+}
+
+// allowedAlgorithms returns configured allow-list or algorithms of keys in the chain
+func (j *Jwt) allowedAlgorithms(keys *KeyChain) []string {
+
+	if len(j.allowed) > 0 {
+		return j.allowed
+	}
+
+	allowed := []string{}
+	for _, key := range keys.VerificationKeys() {
+		allowed = append(allowed, key.Algorithm)
+	}
+
+	return allowed
+}
+
+func (j *Jwt) JWKS() (JWKSet, error) {
+
+	keys, err := j.keyLoader.InitializeKeysChain()
+
+	if err != nil {
+		return JWKSet{}, err
+	}
+
+	return keys.JWKS(), nil
 }
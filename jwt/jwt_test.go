@@ -1,11 +1,17 @@
 package jwt
 
 import (
+	"crypto"
+	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
+	"encoding/base64"
 	"encoding/pem"
+	"math/big"
 	"testing"
+	"time"
 
+	"authService/config"
 	"authService/model"
 
 	"github.com/dgrijalva/jwt-go"
@@ -14,16 +20,20 @@ import (
 
 type TestKeyLoader struct{}
 
-func (TestKeyLoader) LoadPrivateKey(path string) *rsa.PrivateKey {
+func (TestKeyLoader) LoadPrivateKey(path string) (crypto.PrivateKey, error) {
 
 	panic("implement me")
 }
 
-func (TestKeyLoader) LoadPublicKey(path string) *rsa.PublicKey {
+func (TestKeyLoader) LoadPublicKey(path string) (crypto.PublicKey, error) {
 	panic("implement me")
 }
 
-func (TestKeyLoader) InitializeKeysChain() *KeyChain {
+func (TestKeyLoader) InitializeKeysChain() (*KeyChain, error) {
+	return testKeys(), nil
+}
+
+func testKeys() *KeyChain {
 
 	public := `
 -----BEGIN PUBLIC KEY-----
@@ -60,15 +70,33 @@ BlpueQG3OxV6lqK8EpO9k9C4drpT5r0WrvFRE+9YLBc=
 
 	rsaPublic, _ := rsaPublicRaw.(*rsa.PublicKey)
 
-	return &KeyChain{
-		PublicKey:  rsaPublic,
-		PrivateKey: rsaPrivate,
+	key, _ := NewSigningKey(rsaPrivate, rsaPublic, DefaultAlgorithm)
+
+	return NewKeyChain(key, 0)
+}
+
+// ChainKeyLoader serves the same key chain for every call so it can be rotated in tests
+type ChainKeyLoader struct {
+	TestKeyLoader
+	keys *KeyChain
+}
+
+func (c ChainKeyLoader) InitializeKeysChain() (*KeyChain, error) {
+	return c.keys, nil
+}
+
+func newTestSigningKey(t *testing.T) *SigningKey {
+	private, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("Test key mast be generated but got error: %v", err)
 	}
+	key, _ := NewSigningKey(private, &private.PublicKey, DefaultAlgorithm)
+	return key
 }
 
 func TestNewTokenizer(t *testing.T) {
 	k := TestKeyLoader{}
-	tok := NewTokenizer(k)
+	tok := NewTokenizer(k, config.Configuration{})
 
 	if tok == nil {
 		t.Errorf("New Tokenizer mast return JWT tokenizer but got nil")
@@ -95,7 +123,7 @@ func TestJwt_GenerateTokenShouldGenerateValidToken(t *testing.T) {
 	}
 
 	pToken, _ := jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
-		return k.InitializeKeysChain().PrivateKey, nil
+		return testKeys().Current().PublicKey, nil
 	})
 
 	if claims, ok := pToken.Claims.(jwt.MapClaims); ok {
@@ -127,14 +155,155 @@ func TestJwt_ParceAndVerifyToken(t *testing.T) {
 		t.Errorf("Parse token mast parse it but return error: %v", err)
 	}
 
-	if claims, ok := pToken.Claims.(jwt.MapClaims); ok {
+	if claims, ok := pToken.Claims.(*model.TokenClaims); ok {
 
-		if id, isXidID := claims["sub"].(string); !isXidID || id != guid.String() {
-			t.Errorf("User id mast be 'sub' claim and with expected value: %v", id)
+		if claims.Subject != guid.String() {
+			t.Errorf("User id mast be 'sub' claim and with expected value: %v", claims.Subject)
 		}
 
 	} else {
-		t.Errorf("GenerateToken mast generate token with MapClaims but got: %v", pToken.Claims)
+		t.Errorf("ParceAndVerifyToken mast return token with TokenClaims but got: %v", pToken.Claims)
+	}
+
+}
+
+func TestJwt_GenerateTokenShouldStampKeyId(t *testing.T) {
+
+	k := TestKeyLoader{}
+	tok := NewTokenizer(k, config.Configuration{})
+
+	token, _ := tok.GenerateToken(&model.User{Id: xid.New()})
+
+	pToken, err := tok.ParceAndVerifyToken(token)
+
+	if err != nil {
+		t.Fatalf("Parse token mast parse it but return error: %v", err)
+	}
+
+	if kid := pToken.Header["kid"]; kid != testKeys().Current().Kid {
+		t.Errorf("Token mast have 'kid' header of signing key but got: %v", kid)
+	}
+}
+
+func TestKeyChain_JWKS(t *testing.T) {
+
+	keys := testKeys().Current()
+	set, err := NewTokenizer(TestKeyLoader{}, config.Configuration{}).JWKS()
+
+	if err != nil {
+		t.Fatalf("JWKS mast be returned but got error: %v", err)
+	}
+
+	if len(set.Keys) != 1 {
+		t.Fatalf("JWKS mast contain signing key but got: %v", set.Keys)
+	}
+
+	jwk := set.Keys[0]
+
+	if jwk.Kty != "RSA" || jwk.Use != "sig" || jwk.Alg != "PS512" || jwk.Kid != keys.Kid {
+		t.Errorf("Unexpected JWK: %v", jwk)
+	}
+
+	n, _ := base64.RawURLEncoding.DecodeString(jwk.N)
+	e, _ := base64.RawURLEncoding.DecodeString(jwk.E)
+
+	public := keys.PublicKey.(*rsa.PublicKey)
+
+	if new(big.Int).SetBytes(n).Cmp(public.N) != 0 || int(new(big.Int).SetBytes(e).Int64()) != public.E {
+		t.Errorf("JWK mast contain public key modulus and exponent")
 	}
 
+	if testKeys().Current().Kid != keys.Kid || len(keys.Kid) != 43 {
+		t.Errorf("Key id mast be stable base64url SHA-256 thumbprint but got: %v", keys.Kid)
+	}
+}
+
+func TestJwt_ParceAndVerifyTokenAfterRotation(t *testing.T) {
+
+	keys := testKeys()
+	tok := NewTokenizer(ChainKeyLoader{keys: keys}, config.Configuration{})
+	u := &model.User{Id: xid.New()}
+
+	old, _ := tok.GenerateToken(u)
+	oldKid := keys.Current().Kid
+
+	next := newTestSigningKey(t)
+	keys.Rotate(next)
+
+	fresh, _ := tok.GenerateToken(u)
+
+	pToken, err := tok.ParceAndVerifyToken(fresh)
+
+	if err != nil || pToken.Header["kid"] != next.Kid {
+		t.Errorf("New token mast be signed with rotated key but got [%v, %v]", pToken.Header["kid"], err)
+	}
+
+	if _, err := tok.ParceAndVerifyToken(old); err != nil {
+		t.Errorf("Token signed with retired key mast be valid during grace period but got error: %v", err)
+	}
+
+	set, _ := tok.JWKS()
+
+	if len(set.Keys) != 2 || set.Keys[0].Kid != next.Kid || set.Keys[1].Kid != oldKid {
+		t.Errorf("JWKS mast contain current and retired keys but got: %v", set.Keys)
+	}
+
+	keys.GracePeriod = time.Nanosecond
+	time.Sleep(time.Millisecond)
+
+	if _, err := tok.ParceAndVerifyToken(old); err == nil {
+		t.Errorf("Token signed with retired key mast be rejected after grace period")
+	}
+
+	if set, _ := tok.JWKS(); len(set.Keys) != 1 {
+		t.Errorf("JWKS mast not contain keys after grace period but got: %v", set.Keys)
+	}
+}
+
+func TestJwt_ParceAndVerifyTokenWithUnknownKey(t *testing.T) {
+
+	other := NewTokenizer(ChainKeyLoader{keys: NewKeyChain(newTestSigningKey(t), 0)}, config.Configuration{})
+	token, _ := other.GenerateToken(&model.User{Id: xid.New()})
+
+	if _, err := NewTokenizer(TestKeyLoader{}, config.Configuration{}).ParceAndVerifyToken(token); err == nil {
+		t.Errorf("Token signed with unknown key mast be rejected")
+	}
+}
+
+func TestKeyChain_Retire(t *testing.T) {
+
+	current := newTestSigningKey(t)
+	recent := newTestSigningKey(t)
+	outdated := newTestSigningKey(t)
+
+	keys := NewKeyChain(current, time.Hour)
+	keys.Retire(recent.PublicKey, DefaultAlgorithm, time.Now().Add(-time.Minute))
+	keys.Retire(outdated.PublicKey, DefaultAlgorithm, time.Now().Add(-2*time.Hour))
+	keys.Retire(current.PublicKey, DefaultAlgorithm, time.Now().Add(-2*time.Hour))
+
+	if k, err := keys.Key(current.Kid); err != nil || k.PrivateKey == nil {
+		t.Errorf("Current key mast not be retired but got [%v, %v]", k, err)
+	}
+
+	if k, err := keys.Key(recent.Kid); err != nil || k.PrivateKey != nil {
+		t.Errorf("Retired key mast be verification only key but got [%v, %v]", k, err)
+	}
+
+	if _, err := keys.Key(outdated.Kid); err == nil {
+		t.Errorf("Key retired before grace period mast be rejected")
+	}
+
+	if _, err := keys.Key("unknown"); err == nil {
+		t.Errorf("Unknown key mast be rejected")
+	}
+
+	if n := len(keys.VerificationKeys()); n != 2 {
+		t.Errorf("Expected [%v] verification keys but was: [%v]", 2, n)
+	}
+}
+
+func newToken(method jwt.SigningMethod, kid string) *jwt.Token {
+	token := jwt.NewWithClaims(method, jwt.MapClaims{"sub": xid.New().String(), "exp": time.Now().Add(time.Hour).Unix()})
+	token.Header["kid"] = kid
+	return token
 }
@@ -0,0 +1,165 @@
+package jwt
+
+import (
+	"crypto"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// defaultGracePeriod covers default token lifetime, longer lived tokens need longer grace period
+const defaultGracePeriod = defaultTokenTTL
+
+var (
+	ErrUnknownKey = errors.New("Unknown signing key")
+	ErrExpiredKey = errors.New("Signing key is retired")
+)
+
+// SigningKey is a key pair identified by kid. Retired keys have no private part,
+// they only verify tokens which were issued before rotation
+type SigningKey struct {
+	Kid        string
+	Algorithm  string
+	PrivateKey crypto.PrivateKey
+	PublicKey  crypto.PublicKey
+	RetiredAt  time.Time
+}
+
+func NewSigningKey(private crypto.PrivateKey, public crypto.PublicKey, algorithm string) (*SigningKey, error) {
+
+	if err := checkAlgorithm(algorithm, public); err != nil {
+		return nil, err
+	}
+
+	kid, err := thumbprint(public)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &SigningKey{
+		Kid:        kid,
+		Algorithm:  algorithm,
+		PrivateKey: private,
+		PublicKey:  public,
+	}, nil
+}
+
+// KeyChain holds current signing key and retired keys which still verify tokens during grace period
+type KeyChain struct {
+	mu          sync.RWMutex
+	current     *SigningKey
+	keys        map[string]*SigningKey
+	GracePeriod time.Duration
+}
+
+func NewKeyChain(current *SigningKey, grace time.Duration) *KeyChain {
+
+	if grace <= 0 {
+		grace = defaultGracePeriod
+	}
+
+	return &KeyChain{
+		current:     current,
+		keys:        map[string]*SigningKey{current.Kid: current},
+		GracePeriod: grace,
+	}
+}
+
+// Current returns key new tokens are signed with
+func (k *KeyChain) Current() *SigningKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.current
+}
+
+// Key returns key token with kid should be verified with, retired keys are returned until grace period is over
+func (k *KeyChain) Key(kid string) (*SigningKey, error) {
+
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	key, ok := k.keys[kid]
+
+	if !ok {
+		return nil, errors.Wrap(ErrUnknownKey, kid)
+	}
+
+	if k.expired(key, time.Now()) {
+		return nil, errors.Wrap(ErrExpiredKey, kid)
+	}
+
+	return key, nil
+}
+
+// Retire adds verification only key which was replaced at retiredAt
+func (k *KeyChain) Retire(public crypto.PublicKey, algorithm string, retiredAt time.Time) error {
+
+	key, err := NewSigningKey(nil, public, algorithm)
+
+	if err != nil {
+		return err
+	}
+
+	key.RetiredAt = retiredAt
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if key.Kid != k.current.Kid {
+		k.keys[key.Kid] = key
+	}
+
+	return nil
+}
+
+// Rotate makes next the signing key, the previous one keeps verifying tokens for grace period
+func (k *KeyChain) Rotate(next *SigningKey) {
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	now := time.Now()
+
+	previous := *k.current
+	previous.PrivateKey = nil
+	previous.RetiredAt = now
+
+	k.keys[previous.Kid] = &previous
+	k.keys[next.Kid] = next
+	k.current = next
+
+	for kid, key := range k.keys {
+		if k.expired(key, now) {
+			delete(k.keys, kid)
+		}
+	}
+}
+
+// VerificationKeys returns current key and all retired keys which are still in grace period
+func (k *KeyChain) VerificationKeys() []*SigningKey {
+
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	now := time.Now()
+	retired := []*SigningKey{}
+
+	for _, key := range k.keys {
+		if key != k.current && !k.expired(key, now) {
+			retired = append(retired, key)
+		}
+	}
+
+	sort.Slice(retired, func(i, j int) bool {
+		return retired[i].RetiredAt.After(retired[j].RetiredAt)
+	})
+
+	return append([]*SigningKey{k.current}, retired...)
+}
+
+func (k *KeyChain) expired(key *SigningKey, now time.Time) bool {
+	return key != k.current && !key.RetiredAt.IsZero() && now.After(key.RetiredAt.Add(k.GracePeriod))
+}
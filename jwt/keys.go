@@ -1,115 +1,261 @@
 package jwt
 
 import (
-	"bufio"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
 	"os"
 	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
 
 	"authService/config"
 )
 
+var ErrKeyMismatch = errors.New("Public key does not match private key")
+
 type PrivateKeyLoader interface {
-	LoadPrivateKey(path string) *rsa.PrivateKey
+	LoadPrivateKey(path string) (crypto.PrivateKey, error)
 }
 
 type PublicKeyLoader interface {
-	LoadPublicKey(path string) *rsa.PublicKey
+	LoadPublicKey(path string) (crypto.PublicKey, error)
 }
 
 type KeyLoader interface {
 	PrivateKeyLoader
 	PublicKeyLoader
-	InitializeKeysChain() *KeyChain
-}
-
-type KeyChain struct {
-	PrivateKey *rsa.PrivateKey
-	PublicKey  *rsa.PublicKey
+	InitializeKeysChain() (*KeyChain, error)
 }
 
 type FileKeyLoader struct {
-	privateKey string
-	publicKey  string
+	privateKey  string
+	publicKey   string
+	algorithm   string
+	retiredKeys []config.RetiredKeyConfig
+	gracePeriod time.Duration
+	generate    bool
+	once        sync.Once
+	keys        *KeyChain
+	err         error
 }
 
-var keys *KeyChain = nil
-
 func NewFileKeyLoader(c config.Configuration) *FileKeyLoader {
 	return &FileKeyLoader{
-		privateKey: c.Auth.PrivateKey,
-		publicKey:  c.Auth.PublicKey,
+		privateKey:  c.Auth.PrivateKey,
+		publicKey:   c.Auth.PublicKey,
+		algorithm:   c.Auth.Algorithm,
+		retiredKeys: c.Auth.RetiredKeys,
+		gracePeriod: c.Auth.GracePeriod.Duration,
+		generate:    c.Auth.GenerateKeys,
 	}
 }
 
-func (f *FileKeyLoader) InitializeKeysChain() *KeyChain {
-	if keys == nil {
-		keys = &KeyChain{
-			PublicKey:  f.LoadPublicKey(f.publicKey),
-			PrivateKey: f.LoadPrivateKey(f.privateKey),
+// InitializeKeysChain loads current key pair and public parts of retired keys once per loader,
+// the result including an error is kept so a broken key is reported the same way on every call
+func (f *FileKeyLoader) InitializeKeysChain() (*KeyChain, error) {
+	f.once.Do(func() {
+		f.keys, f.err = f.loadKeysChain()
+	})
+	return f.keys, f.err
+}
+
+func (f *FileKeyLoader) loadKeysChain() (*KeyChain, error) {
+
+	if f.generate {
+		algorithm := f.algorithm
+		if algorithm == "" {
+			algorithm = DefaultAlgorithm
+		}
+
+		generated, err := generateKeyPair(f.privateKey, f.publicKey, algorithm)
+
+		if err != nil {
+			return nil, errors.Wrap(err, "Can't generate signing key")
+		}
+
+		if generated {
+			logger.Printf("Generated %v signing key %v", algorithm, f.privateKey)
+		}
+	}
+
+	private, err := f.LoadPrivateKey(f.privateKey)
+
+	if err != nil {
+		return nil, err
+	}
+
+	public, err := f.LoadPublicKey(f.publicKey)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkKeyPair(private, public); err != nil {
+		return nil, errors.Wrapf(err, "%v and %v", f.privateKey, f.publicKey)
+	}
+
+	algorithm := f.algorithm
+	if algorithm == "" {
+		algorithm = defaultAlgorithm(public, DefaultAlgorithm)
+	}
+
+	current, err := NewSigningKey(private, public, algorithm)
+
+	if err != nil {
+		return nil, errors.Wrap(err, f.privateKey)
+	}
+
+	keys := NewKeyChain(current, f.gracePeriod)
+
+	for _, r := range f.retiredKeys {
+		retired, err := f.LoadPublicKey(r.PublicKey)
+
+		if err != nil {
+			return nil, err
+		}
+
+		algorithm := r.Algorithm
+		if algorithm == "" {
+			algorithm = defaultAlgorithm(retired, current.Algorithm)
+		}
+
+		if err := keys.Retire(retired, algorithm, r.RetiredAt); err != nil {
+			return nil, errors.Wrap(err, r.PublicKey)
 		}
 	}
-	return keys
+
+	return keys, nil
 }
 
-func (f *FileKeyLoader) LoadPrivateKey(path string) *rsa.PrivateKey {
+// LoadPrivateKey accepts PKCS#1 RSA, SEC1 EC and PKCS#8 RSA, EC or Ed25519 PEM keys
+// readable by owner only
+func (f *FileKeyLoader) LoadPrivateKey(path string) (crypto.PrivateKey, error) {
 
-	data := readKey(path)
+	data, err := readKey(path)
 
-	pubKeyImported, err := x509.ParsePKCS1PrivateKey(data.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkKeyPermissions(path); err != nil {
+		return nil, err
+	}
+
+	key, err := parsePrivateKey(data)
 
 	if err != nil {
-		panic(err)
+		return nil, errors.Wrapf(err, "Can't parse private key %v", path)
 	}
 
-	return pubKeyImported
+	return key, nil
 }
 
-func (f *FileKeyLoader) LoadPublicKey(path string) *rsa.PublicKey {
+// LoadPublicKey accepts PKIX RSA, EC or Ed25519 and PKCS#1 RSA PEM keys
+func (f *FileKeyLoader) LoadPublicKey(path string) (crypto.PublicKey, error) {
+
+	data, err := readKey(path)
 
-	data := readKey(path)
+	if err != nil {
+		return nil, err
+	}
 
-	pubKeyImported, err := x509.ParsePKIXPublicKey(data.Bytes)
+	key, err := parsePublicKey(data)
 
 	if err != nil {
-		panic(err)
+		return nil, errors.Wrapf(err, "Can't parse public key %v", path)
 	}
 
-	rsaPub, ok := pubKeyImported.(*rsa.PublicKey)
+	return key, nil
+}
+
+// checkKeyPair makes sure tokens signed with private key can be verified with public one
+func checkKeyPair(private crypto.PrivateKey, public crypto.PublicKey) error {
+
+	signer, ok := private.(crypto.Signer)
 
 	if !ok {
-		panic("cant validate public key")
+		return errors.Errorf("Unsupported private key type %T", private)
+	}
+
+	expected, ok := signer.Public().(interface{ Equal(crypto.PublicKey) bool })
+
+	if !ok || !expected.Equal(public) {
+		return ErrKeyMismatch
 	}
 
-	return rsaPub
+	return nil
 }
+func parsePrivateKey(data *pem.Block) (crypto.PrivateKey, error) {
+
+	switch data.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(data.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(data.Bytes)
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(data.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		switch k := key.(type) {
+		case *rsa.PrivateKey, *ecdsa.PrivateKey:
+			return k, nil
+		case ed25519.PrivateKey:
+			return k, nil
+		}
+		return nil, errors.Errorf("Unsupported private key type %T", key)
+	}
 
-func readKey(path string) *pem.Block {
+	return nil, errors.Errorf("Unsupported private key PEM block %v", data.Type)
+}
 
-	p, err := filepath.Abs(path)
+func parsePublicKey(data *pem.Block) (crypto.PublicKey, error) {
 
-	if err != nil {
-		panic(err)
+	switch data.Type {
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(data.Bytes)
+	case "PUBLIC KEY":
+		key, err := x509.ParsePKIXPublicKey(data.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		switch k := key.(type) {
+		case *rsa.PublicKey, *ecdsa.PublicKey:
+			return k, nil
+		case ed25519.PublicKey:
+			return k, nil
+		}
+		return nil, errors.Errorf("Unsupported public key type %T", key)
 	}
 
-	prKey, err := os.Open(p)
-	defer prKey.Close()
+	return nil, errors.Errorf("Unsupported public key PEM block %v", data.Type)
+}
+
+func readKey(path string) (*pem.Block, error) {
+
+	p, err := filepath.Abs(path)
 
 	if err != nil {
-		panic(err)
+		return nil, errors.Wrapf(err, "Can't resolve key path %v", path)
 	}
 
-	keyStat, _ := prKey.Stat()
+	pembytes, err := os.ReadFile(p)
 
-	var s int64 = keyStat.Size()
-	pembytes := make([]byte, s)
+	if err != nil {
+		return nil, errors.Wrap(err, "Can't read key")
+	}
 
-	buffer := bufio.NewReader(prKey)
-	buffer.Read(pembytes)
+	data, _ := pem.Decode(pembytes)
 
-	data, _ := pem.Decode([]byte(pembytes))
+	if data == nil {
+		return nil, errors.Errorf("No PEM encoded key found in %v", p)
+	}
 
-	return data
+	return data, nil
 }
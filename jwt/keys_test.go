@@ -0,0 +1,340 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"authService/config"
+	"authService/model"
+
+	"github.com/pkg/errors"
+	"github.com/rs/xid"
+)
+
+func writePEM(t *testing.T, name, blockType string, der []byte) string {
+	path := filepath.Join(t.TempDir(), name)
+	err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600)
+	if err != nil {
+		t.Fatalf("Test key mast be written but got error: %v", err)
+	}
+	return path
+}
+
+func writePKCS8(t *testing.T, private crypto.PrivateKey) string {
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatalf("Test key mast be marshaled but got error: %v", err)
+	}
+	return writePEM(t, "private_key", "PRIVATE KEY", der)
+}
+
+func writePKIX(t *testing.T, public crypto.PublicKey) string {
+	der, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		t.Fatalf("Test key mast be marshaled but got error: %v", err)
+	}
+	return writePEM(t, "public_key.pub", "PUBLIC KEY", der)
+}
+
+func TestFileKeyLoader_KeyFormats(t *testing.T) {
+
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 1024)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	edPublic, edPrivate, _ := ed25519.GenerateKey(rand.Reader)
+	ecDer, _ := x509.MarshalECPrivateKey(ecKey)
+
+	tests := []struct {
+		description string
+		private     string
+		public      string
+		algorithm   string
+	}{
+		{
+			description: "PKCS#1 RSA",
+			private:     writePEM(t, "private_key", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey)),
+			public:      writePEM(t, "public_key.pub", "RSA PUBLIC KEY", x509.MarshalPKCS1PublicKey(&rsaKey.PublicKey)),
+			algorithm:   "PS512",
+		},
+		{
+			description: "PKCS#8 RSA",
+			private:     writePKCS8(t, rsaKey),
+			public:      writePKIX(t, &rsaKey.PublicKey),
+			algorithm:   "PS512",
+		},
+		{
+			description: "SEC1 EC",
+			private:     writePEM(t, "private_key", "EC PRIVATE KEY", ecDer),
+			public:      writePKIX(t, &ecKey.PublicKey),
+			algorithm:   "ES256",
+		},
+		{
+			description: "PKCS#8 EC",
+			private:     writePKCS8(t, ecKey),
+			public:      writePKIX(t, &ecKey.PublicKey),
+			algorithm:   "ES256",
+		},
+		{
+			description: "PKCS#8 Ed25519",
+			private:     writePKCS8(t, edPrivate),
+			public:      writePKIX(t, edPublic),
+			algorithm:   "EdDSA",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			c := config.Configuration{Auth: config.AuthConfig{PrivateKey: tc.private, PublicKey: tc.public}}
+			tok := NewTokenizer(NewFileKeyLoader(c), c)
+
+			token, err := tok.GenerateToken(&model.User{Id: xid.New()})
+
+			if err != nil {
+				t.Fatalf("Token mast be generated but got error: %v", err)
+			}
+
+			pToken, err := tok.ParceAndVerifyToken(token)
+
+			if err != nil || !pToken.Valid {
+				t.Fatalf("Token mast be verified but got error: %v", err)
+			}
+
+			if pToken.Method.Alg() != tc.algorithm {
+				t.Errorf("Expected algorithm [%v] but was: [%v]", tc.algorithm, pToken.Method.Alg())
+			}
+
+			jwks, _ := tok.JWKS()
+			if len(jwks.Keys) != 1 || jwks.Keys[0].Alg != tc.algorithm || jwks.Keys[0].Kid != pToken.Header["kid"] {
+				t.Errorf("JWKS mast publish key of the token but got: %v", jwks.Keys)
+			}
+		})
+	}
+}
+
+func TestFileKeyLoader_Errors(t *testing.T) {
+
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 1024)
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 1024)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	notPEM := filepath.Join(t.TempDir(), "not_pem")
+	os.WriteFile(notPEM, []byte("not a key"), 0600)
+
+	private := writePKCS8(t, rsaKey)
+	public := writePKIX(t, &rsaKey.PublicKey)
+
+	tests := []struct {
+		description string
+		auth        config.AuthConfig
+		expected    string
+	}{
+		{
+			description: "Missing private key file",
+			auth:        config.AuthConfig{PrivateKey: "./missing", PublicKey: public},
+			expected:    "Can't read key",
+		},
+		{
+			description: "Missing public key file",
+			auth:        config.AuthConfig{PrivateKey: private, PublicKey: "./missing"},
+			expected:    "Can't read key",
+		},
+		{
+			description: "File without PEM block",
+			auth:        config.AuthConfig{PrivateKey: notPEM, PublicKey: public},
+			expected:    "No PEM encoded key found",
+		},
+		{
+			description: "Public key in place of private key",
+			auth:        config.AuthConfig{PrivateKey: public, PublicKey: public},
+			expected:    "Can't parse private key",
+		},
+		{
+			description: "Public key of other key pair",
+			auth:        config.AuthConfig{PrivateKey: private, PublicKey: writePKIX(t, &otherKey.PublicKey)},
+			expected:    ErrKeyMismatch.Error(),
+		},
+		{
+			description: "Algorithm does not match key",
+			auth:        config.AuthConfig{PrivateKey: writePKCS8(t, ecKey), PublicKey: writePKIX(t, &ecKey.PublicKey), Algorithm: "ES384"},
+			expected:    "can't be used with ES384 algorithm",
+		},
+		{
+			description: "Missing retired key",
+			auth:        config.AuthConfig{PrivateKey: private, PublicKey: public, RetiredKeys: []config.RetiredKeyConfig{{PublicKey: "./missing"}}},
+			expected:    "Can't read key",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			loader := NewFileKeyLoader(config.Configuration{Auth: tc.auth})
+
+			keys, err := loader.InitializeKeysChain()
+
+			if err == nil || !strings.Contains(err.Error(), tc.expected) {
+				t.Errorf("Expected error containing [%v] but got [%v]", tc.expected, err)
+			}
+
+			if keys != nil {
+				t.Errorf("No keys expected on error but got %v", keys)
+			}
+
+			if _, again := loader.InitializeKeysChain(); again != err {
+				t.Errorf("Loading error mast be kept but got [%v]", again)
+			}
+
+			tok := NewTokenizer(loader, config.Configuration{})
+
+			if _, err := tok.GenerateToken(&model.User{Id: xid.New()}); err == nil {
+				t.Errorf("Token mast not be generated without keys")
+			}
+
+			if _, err := tok.ParceAndVerifyToken("token"); err == nil {
+				t.Errorf("Token mast not be verified without keys")
+			}
+		})
+	}
+}
+
+func TestJwt_ParceAndVerifyTokenAllowList(t *testing.T) {
+
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	key, _ := NewSigningKey(ecKey, &ecKey.PublicKey, "ES256")
+	loader := ChainKeyLoader{keys: NewKeyChain(key, 0)}
+
+	token, _ := NewTokenizer(loader, config.Configuration{}).GenerateToken(&model.User{Id: xid.New()})
+
+	allowed := config.Configuration{Auth: config.AuthConfig{AllowedAlgorithms: []string{"ES256"}}}
+	if _, err := NewTokenizer(loader, allowed).ParceAndVerifyToken(token); err != nil {
+		t.Errorf("Token with allowed algorithm mast be verified but got error: %v", err)
+	}
+
+	denied := config.Configuration{Auth: config.AuthConfig{AllowedAlgorithms: []string{"PS512", "EdDSA"}}}
+	if _, err := NewTokenizer(loader, denied).ParceAndVerifyToken(token); err == nil {
+		t.Errorf("Token with not allowed algorithm mast be rejected")
+	}
+}
+
+func TestJwt_ParceAndVerifyTokenRejectsAlgorithmSwitch(t *testing.T) {
+
+	tok := NewTokenizer(TestKeyLoader{}, config.Configuration{Auth: config.AuthConfig{AllowedAlgorithms: []string{"PS512", "RS256"}}})
+	key := testKeys().Current()
+
+	method, _ := signingMethod("RS256")
+	token := newToken(method, key.Kid)
+	signed, _ := token.SignedString(key.PrivateKey)
+
+	if _, err := tok.ParceAndVerifyToken(signed); err == nil {
+		t.Errorf("Token signed with other algorithm than key is bound to mast be rejected")
+	}
+
+	if _, err := signingMethod("HS256"); err == nil {
+		t.Errorf("Shared secret algorithms mast not be supported")
+	}
+
+	if _, err := signingMethod("none"); err == nil {
+		t.Errorf("Unsigned tokens mast not be supported")
+	}
+}
+
+func TestFileKeyLoader_GenerateKeys(t *testing.T) {
+
+	for _, alg := range []string{"PS256", "ES256", "ES384", "ES512", "EdDSA"} {
+		t.Run(alg, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "keys")
+			auth := config.AuthConfig{
+				PrivateKey:   filepath.Join(dir, "private_key"),
+				PublicKey:    filepath.Join(dir, "public_key.pub"),
+				Algorithm:    alg,
+				GenerateKeys: true,
+			}
+
+			keys, err := NewFileKeyLoader(config.Configuration{Auth: auth}).InitializeKeysChain()
+
+			if err != nil {
+				t.Fatalf("Keys mast be generated but got error: %v", err)
+			}
+
+			if keys.Current().Algorithm != alg {
+				t.Errorf("Expected [%v] but was: [%v]", alg, keys.Current().Algorithm)
+			}
+
+			if info, _ := os.Stat(auth.PrivateKey); info.Mode().Perm() != 0600 {
+				t.Errorf("Expected [%v] but was: [%v]", os.FileMode(0600), info.Mode().Perm())
+			}
+
+			if info, _ := os.Stat(dir); info.Mode().Perm() != 0700 {
+				t.Errorf("Expected [%v] but was: [%v]", os.FileMode(0700), info.Mode().Perm())
+			}
+
+			// keys are generated on first start only, restart keeps them
+			reloaded, err := NewFileKeyLoader(config.Configuration{Auth: auth}).InitializeKeysChain()
+
+			if err != nil || reloaded.Current().Kid != keys.Current().Kid {
+				t.Errorf("Generated key mast be kept but got [%v, %v]", reloaded, err)
+			}
+		})
+	}
+}
+
+func TestFileKeyLoader_GenerateKeysKeepsExistingKey(t *testing.T) {
+
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	private := writePKCS8(t, ecKey)
+	before, _ := os.ReadFile(private)
+
+	auth := config.AuthConfig{
+		PrivateKey:   private,
+		PublicKey:    filepath.Join(t.TempDir(), "public_key.pub"),
+		GenerateKeys: true,
+	}
+
+	if _, err := NewFileKeyLoader(config.Configuration{Auth: auth}).InitializeKeysChain(); err == nil {
+		t.Errorf("Missing public key mast be reported")
+	}
+
+	if after, _ := os.ReadFile(private); string(after) != string(before) {
+		t.Errorf("Existing private key mast not be overwritten")
+	}
+
+	if _, err := os.Stat(auth.PublicKey); !os.IsNotExist(err) {
+		t.Errorf("Public key mast not be generated for existing private key")
+	}
+}
+
+func TestFileKeyLoader_InsecurePermissions(t *testing.T) {
+
+	if runtime.GOOS == "windows" {
+		t.Skip("file mode bits are not supported")
+	}
+
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	private := writePKCS8(t, ecKey)
+	public := writePKIX(t, &ecKey.PublicKey)
+
+	for _, mode := range []os.FileMode{0640, 0604, 0644} {
+		os.Chmod(private, mode)
+
+		_, err := NewFileKeyLoader(config.Configuration{Auth: config.AuthConfig{PrivateKey: private, PublicKey: public}}).InitializeKeysChain()
+
+		if errors.Cause(err) != ErrInsecureKeyFile {
+			t.Errorf("Expected [%v] for mode %v but was: [%v]", ErrInsecureKeyFile, mode, err)
+		}
+	}
+
+	os.Chmod(private, 0400)
+
+	if _, err := NewFileKeyLoader(config.Configuration{Auth: config.AuthConfig{PrivateKey: private, PublicKey: public}}).InitializeKeysChain(); err != nil {
+		t.Errorf("Owner only key mast be loaded but got error: %v", err)
+	}
+}
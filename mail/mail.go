@@ -0,0 +1,79 @@
+package mail
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/rs/xid"
+
+	"authService/config"
+)
+
+// Message is an email sent to user
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers messages to users, a mail server integration implements it in production
+type Mailer interface {
+	Send(m Message) error
+}
+
+// LogMailer writes messages to the log, so links can be followed in local setups without a mail server
+type LogMailer struct {
+	Logger *log.Logger
+}
+
+func (l LogMailer) Send(m Message) error {
+	l.Logger.Printf("Mail to %v\nSubject: %v\n\n%v", m.To, m.Subject, m.Body)
+	return nil
+}
+
+// FileMailer writes every message into its own file in Dir. Messages carry one-time tokens,
+// so files are readable by the owner only
+type FileMailer struct {
+	Dir string
+}
+
+func (f FileMailer) Send(m Message) error {
+
+	if err := os.MkdirAll(f.Dir, 0700); err != nil {
+		return errors.Wrap(err, "Can't create mail directory")
+	}
+
+	content := fmt.Sprintf("To: %v\nSubject: %v\n\n%v\n", m.To, m.Subject, m.Body)
+	path := filepath.Join(f.Dir, xid.New().String()+".eml")
+
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		return errors.Wrap(err, "Can't write mail")
+	}
+
+	return nil
+}
+
+// NewMailer creates mailer selected in configuration: "log" (default) or "file" writing to Path.
+// Messages carry links, so mailer is not created without absolute BaseURL they point to
+func NewMailer(c config.MailConfig) (Mailer, error) {
+
+	if u, err := url.Parse(c.BaseURL); err != nil || !u.IsAbs() || u.Host == "" {
+		return nil, errors.New("Mailer needs absolute base URL of links")
+	}
+
+	switch c.Type {
+	case "", "log":
+		return LogMailer{Logger: log.New(os.Stdout, "[mail] ", log.LstdFlags)}, nil
+	case "file":
+		if c.Path == "" {
+			return nil, errors.New("File mailer needs a directory path")
+		}
+		return FileMailer{Dir: c.Path}, nil
+	}
+	return nil, errors.Errorf("Unknown mailer type: %v", c.Type)
+}
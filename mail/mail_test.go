@@ -0,0 +1,59 @@
+package mail
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"authService/config"
+)
+
+func TestFileMailer(t *testing.T) {
+
+	dir := filepath.Join(t.TempDir(), "mail")
+	m := FileMailer{Dir: dir}
+
+	if err := m.Send(Message{To: "user@gmail.com", Subject: "Verify", Body: "https://auth.example.com/verify-email?token=abc"}); err != nil {
+		t.Fatalf("Message mast be written but got error: %v", err)
+	}
+
+	files, _ := ioutil.ReadDir(dir)
+
+	if len(files) != 1 || files[0].Mode().Perm() != 0600 {
+		t.Fatalf("Single owner readable message file expected but got: %v", files)
+	}
+
+	b, _ := ioutil.ReadFile(filepath.Join(dir, files[0].Name()))
+
+	if !strings.HasPrefix(string(b), "To: user@gmail.com\nSubject: Verify\n\n") || !strings.Contains(string(b), "token=abc") {
+		t.Errorf("Unexpected message content: %v", string(b))
+	}
+
+	if info, _ := os.Stat(dir); info.Mode().Perm() != 0700 {
+		t.Errorf("Expected [%v] but was: [%v]", os.FileMode(0700), info.Mode().Perm())
+	}
+}
+
+func TestNewMailer(t *testing.T) {
+
+	tests := []struct {
+		config config.MailConfig
+		valid  bool
+	}{
+		{config.MailConfig{BaseURL: "https://auth.example.com"}, true},
+		{config.MailConfig{Type: "log", BaseURL: "https://auth.example.com"}, true},
+		{config.MailConfig{Type: "file", Path: "./mail", BaseURL: "https://auth.example.com"}, true},
+		{config.MailConfig{Type: "file", BaseURL: "https://auth.example.com"}, false},
+		{config.MailConfig{Type: "smtp", BaseURL: "https://auth.example.com"}, false},
+		{config.MailConfig{}, false},
+		{config.MailConfig{Type: "log", BaseURL: "auth.example.com"}, false},
+	}
+
+	for _, tc := range tests {
+		if m, err := NewMailer(tc.config); (err == nil) != tc.valid || (m != nil) != tc.valid {
+			t.Errorf("Mailer for %v expected valid: [%v] but got [%v, %v]", tc.config, tc.valid, m, err)
+		}
+	}
+}
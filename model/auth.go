@@ -2,18 +2,25 @@ package model
 
 import (
 	"encoding/json"
+	"time"
 
 	"github.com/rs/xid"
 )
 
 type User struct {
-	Id          xid.ID       `json:"id,omitempty"`
-	Credentials *Credentilas `json:"credentials,omitempty"`
-	Active      bool         `json:"active"`
-	Banned      bool         `json:"banned"`
+	Id            xid.ID            `json:"id,omitempty"`
+	Credentials   *Credentilas      `json:"credentials,omitempty"`
+	Active        bool              `json:"active"`
+	Banned        bool              `json:"banned"`
+	EmailVerified bool              `json:"email_verified"`
+	Roles         []string          `json:"roles,omitempty"`
+	Groups        []string          `json:"groups,omitempty"`
+	Attributes    map[string]string `json:"attributes,omitempty"`
 	SSOData
 }
 
+// NewUser creates user with unverified email address, verification is tracked by EmailVerified
+// while Active stays the switch admins turn the account off with
 func NewUser(c Credentilas) User {
 	return User{
 		Id:          xid.New(),
@@ -25,17 +32,88 @@ func NewUser(c Credentilas) User {
 
 type Credentilas struct {
 	Email    string `json:"email"`
-	Password string `json:"password"`
+	Password string `json:"password,omitempty"`
 }
 
 type SSOData struct {
-	Valid  *bool   `json:"token_valid,omitempty"`
-	Claims []Claim `json:"claims,omitempty"`
+	Valid  *bool        `json:"token_valid,omitempty"`
+	Claims *TokenClaims `json:"claims,omitempty"`
 }
 
-type Claim struct {
+// RefreshToken is a storage record of opaque refresh token, the token itself is never stored, only its hash.
+// All tokens rotated from the same login share a Family so the whole chain can be revoked at once
+type RefreshToken struct {
+	Id          string
+	Family      string
+	UserId      xid.ID
+	AccessToken string
+	ExpiresAt   time.Time
+	Used        bool
+}
+
+// OneTimeToken is a storage record of opaque token mailed to user to confirm an action, only its hash is stored.
+// Binding ties the token to the state it was issued for, so it is void once that state changes
+type OneTimeToken struct {
+	Id        string
+	Purpose   string
+	UserId    xid.ID
+	Binding   string
+	ExpiresAt time.Time
+	Used      bool
+}
+
+// TOTP is authenticator app of the user, it is used as second factor once Confirmed with a valid code.
+// LastStep is the time step of the last accepted code, RecoveryCodes are hashes of codes not used yet
+type TOTP struct {
+	UserId        xid.ID
+	Secret        string
+	Confirmed     bool
+	LastStep      int64
+	RecoveryCodes []string
+}
+
+// WebAuthnCredential is a passkey of the user, PublicKey is COSE encoded key the authenticator signs with.
+// SignCount is the last signature counter reported, a counter which does not grow reveals cloned authenticator
+type WebAuthnCredential struct {
+	Id        string    `json:"id"`
+	UserId    xid.ID    `json:"-"`
+	PublicKey []byte    `json:"-"`
+	SignCount uint32    `json:"sign_count"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// LoginAttempts counts login attempts made with the same key, an account or an address, since Start.
+// Last is the time of the latest attempt
+type LoginAttempts struct {
 	Key   string
-	Value interface{}
+	Count int
+	Start time.Time
+	Last  time.Time
+}
+
+// MFAChallenge is login response of user with second factor, MFAToken is exchanged for tokens with a valid code
+type MFAChallenge struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+// TOTPEnrollment is shown to user once, URI is rendered as QR code for authenticator app
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+type RecoveryCodes struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IdToken      string `json:"id_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
 }
 
 type AuthError struct {
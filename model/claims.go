@@ -0,0 +1,69 @@
+package model
+
+import "encoding/json"
+
+// TokenClaims are registered claims of access and ID tokens and user fields embedded into them
+type TokenClaims struct {
+	Subject    string            `json:"sub"`
+	Issuer     string            `json:"iss,omitempty"`
+	Audience   Audience          `json:"aud,omitempty"`
+	Id         string            `json:"jti,omitempty"`
+	IssuedAt   int64             `json:"iat,omitempty"`
+	NotBefore  int64             `json:"nbf,omitempty"`
+	ExpiresAt  int64             `json:"exp,omitempty"`
+	ClientId   string            `json:"client_id,omitempty"`
+	Scope      string            `json:"scope,omitempty"`
+	Nonce      string            `json:"nonce,omitempty"`
+	AuthTime   int64             `json:"auth_time,omitempty"`
+	AtHash     string            `json:"at_hash,omitempty"`
+	Email      string            `json:"email,omitempty"`
+	Roles      []string          `json:"roles,omitempty"`
+	Groups     []string          `json:"groups,omitempty"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// Valid always passes, time based claims, issuer and audience are checked by tokenizer with clock skew
+func (c *TokenClaims) Valid() error {
+	return nil
+}
+
+// IsClientToken tells whether token was issued to client on its own behalf, its subject is the client then
+func (c *TokenClaims) IsClientToken() bool {
+	return c.ClientId != "" && c.Subject == c.ClientId
+}
+
+// Audience is aud claim which is either a single string or an array of them
+type Audience []string
+
+func (a Audience) Contains(audience string) bool {
+	for _, v := range a {
+		if v == audience {
+			return true
+		}
+	}
+	return false
+}
+
+func (a Audience) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+	return json.Marshal([]string(a))
+}
+
+func (a *Audience) UnmarshalJSON(b []byte) error {
+
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*a = Audience{single}
+		return nil
+	}
+
+	var many []string
+	if err := json.Unmarshal(b, &many); err != nil {
+		return err
+	}
+
+	*a = many
+	return nil
+}
@@ -0,0 +1,35 @@
+package model
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestAudience_JSON(t *testing.T) {
+
+	tests := []struct {
+		json     string
+		audience Audience
+	}{
+		{`"example"`, Audience{"example"}},
+		{`["example","other"]`, Audience{"example", "other"}},
+	}
+
+	for _, tc := range tests {
+		var a Audience
+
+		if err := json.Unmarshal([]byte(tc.json), &a); err != nil || !reflect.DeepEqual(a, tc.audience) {
+			t.Errorf("Expected [%v] but was: [%v, %v]", tc.audience, a, err)
+		}
+
+		if b, _ := json.Marshal(tc.audience); string(b) != tc.json {
+			t.Errorf("Expected [%v] but was: [%v]", tc.json, string(b))
+		}
+	}
+
+	var a Audience
+	if err := json.Unmarshal([]byte(`42`), &a); err == nil {
+		t.Errorf("Audience mast be string or array of strings")
+	}
+}
@@ -0,0 +1,87 @@
+package model
+
+import (
+	"time"
+
+	"github.com/rs/xid"
+)
+
+// Client is an application which calls OAuth endpoints, only hash of its secret is stored.
+// Public clients like SPA and mobile apps can't keep a secret and have to use PKCE instead.
+// Scopes are granted to tokens confidential client gets for itself with client credentials grant,
+// client may also ask users for them besides scopes of user claims
+type Client struct {
+	Id           string   `json:"client_id"`
+	SecretHash   string   `json:"-"`
+	Name         string   `json:"client_name,omitempty"`
+	RedirectURIs []string `json:"redirect_uris,omitempty"`
+	Public       bool     `json:"public"`
+	Scopes       []string `json:"scope,omitempty"`
+}
+
+// AuthorizationCode is a storage record of code issued by authorize endpoint, only hash of the code is stored.
+// The code can be exchanged once, by the same client, with the same redirect URI and PKCE verifier.
+// RedirectURI is empty when authorize request left it to the only one registered
+type AuthorizationCode struct {
+	Code          string
+	ClientId      string
+	UserId        xid.ID
+	RedirectURI   string
+	Scope         string
+	CodeChallenge string
+	Nonce         string
+	AuthTime      time.Time
+	ExpiresAt     time.Time
+	Used          bool
+	// AccessToken is token issued for the code, it is revoked when the code is replayed
+	AccessToken string
+}
+
+// Consent records scopes user allowed client to access, user is not asked again for them
+type Consent struct {
+	UserId    xid.ID
+	ClientId  string
+	Scopes    []string
+	GrantedAt time.Time
+}
+
+// IntrospectionResponse is RFC 7662 token introspection response, inactive tokens carry nothing but Active
+type IntrospectionResponse struct {
+	Active    bool     `json:"active"`
+	Scope     string   `json:"scope,omitempty"`
+	ClientId  string   `json:"client_id,omitempty"`
+	Username  string   `json:"username,omitempty"`
+	TokenType string   `json:"token_type,omitempty"`
+	Exp       int64    `json:"exp,omitempty"`
+	Iat       int64    `json:"iat,omitempty"`
+	Nbf       int64    `json:"nbf,omitempty"`
+	Sub       string   `json:"sub,omitempty"`
+	Aud       Audience `json:"aud,omitempty"`
+	Iss       string   `json:"iss,omitempty"`
+	Jti       string   `json:"jti,omitempty"`
+}
+
+// UserInfo is OpenID Connect userinfo response, claims are released according to scopes of access token
+type UserInfo struct {
+	Sub               string `json:"sub"`
+	Email             string `json:"email,omitempty"`
+	PreferredUsername string `json:"preferred_username,omitempty"`
+}
+
+// ProviderMetadata is OpenID Connect discovery document
+type ProviderMetadata struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserinfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JwksURI                           string   `json:"jwks_uri"`
+	IntrospectionEndpoint             string   `json:"introspection_endpoint"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IdTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
@@ -0,0 +1,30 @@
+package opaque
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+
+	"github.com/pkg/errors"
+)
+
+const tokenLength = 32
+
+// New generates random url safe token which carries no information by itself
+func New() (string, error) {
+
+	b := make([]byte, tokenLength)
+
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "Can't generate opaque token")
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Hash returns digest of the token which is safe to keep in storage instead of the token itself
+func Hash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,39 @@
+package opaque
+
+import (
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+
+	first, err := New()
+
+	if err != nil {
+		t.Fatalf("Token should be generated but got error: %v", err)
+	}
+
+	second, _ := New()
+
+	if first == second {
+		t.Errorf("Generated tokens should be unique but both were: %v", first)
+	}
+
+	if len(first) != 43 {
+		t.Errorf("Expected token length [%v] but was: [%v]", 43, len(first))
+	}
+}
+
+func TestHash(t *testing.T) {
+
+	if Hash("token") != Hash("token") {
+		t.Errorf("Hash of the same token should be stable")
+	}
+
+	if Hash("token") == Hash("token1") {
+		t.Errorf("Hash of different tokens should differ")
+	}
+
+	if Hash("token") == "token" {
+		t.Errorf("Hash should not return token itself")
+	}
+}
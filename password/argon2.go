@@ -0,0 +1,122 @@
+package password
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/argon2"
+
+	"authService/config"
+)
+
+const (
+	defaultArgon2Time    = 3
+	defaultArgon2Memory  = 64 * 1024
+	defaultArgon2Threads = 2
+	argon2SaltLength     = 16
+	argon2KeyLength      = 32
+)
+
+// Argon2idHasher uses PHC string format: $argon2id$v=19$m=<memory>,t=<time>,p=<threads>$<salt>$<hash>
+type Argon2idHasher struct {
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+}
+
+type argon2Hash struct {
+	Argon2idHasher
+	salt []byte
+	key  []byte
+}
+
+func NewArgon2idHasher(c config.Argon2idConfig) *Argon2idHasher {
+
+	h := &Argon2idHasher{
+		Time:    c.Time,
+		Memory:  c.Memory,
+		Threads: c.Threads,
+	}
+
+	if h.Time == 0 {
+		h.Time = defaultArgon2Time
+	}
+	if h.Memory == 0 {
+		h.Memory = defaultArgon2Memory
+	}
+	if h.Threads == 0 {
+		h.Threads = defaultArgon2Threads
+	}
+
+	return h
+}
+
+func (a *Argon2idHasher) Hash(password string) (string, error) {
+
+	salt, err := newSalt(argon2SaltLength)
+
+	if err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, a.Time, a.Memory, a.Threads, argon2KeyLength)
+
+	return fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		Argon2id, argon2.Version, a.Memory, a.Time, a.Threads, encode(salt), encode(key)), nil
+}
+
+func (a *Argon2idHasher) Verify(password, encoded string) (bool, error) {
+
+	h, err := parseArgon2(encoded)
+
+	if err != nil {
+		return false, err
+	}
+
+	key := argon2.IDKey([]byte(password), h.salt, h.Time, h.Memory, h.Threads, uint32(len(h.key)))
+
+	return equal(key, h.key), nil
+}
+
+func (a *Argon2idHasher) NeedsRehash(encoded string) bool {
+
+	h, err := parseArgon2(encoded)
+
+	return err != nil || h.Argon2idHasher != *a
+}
+
+func parseArgon2(encoded string) (*argon2Hash, error) {
+
+	parts := strings.Split(encoded, "$")
+
+	if len(parts) != 6 || parts[1] != Argon2id {
+		return nil, errors.Wrap(ErrMalformedHash, Argon2id)
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, errors.Wrap(ErrMalformedHash, "argon2id version")
+	}
+
+	h := &argon2Hash{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &h.Memory, &h.Time, &h.Threads); err != nil {
+		return nil, errors.Wrap(ErrMalformedHash, "argon2id parameters")
+	}
+
+	// argon2 panics on parameters it can't work with, stored hash must not crash login
+	if h.Time < 1 || h.Threads < 1 || h.Memory < 8*uint32(h.Threads) {
+		return nil, errors.Wrap(ErrMalformedHash, "argon2id parameters out of range")
+	}
+
+	var err error
+	if h.salt, err = decode(parts[4]); err != nil {
+		return nil, errors.Wrap(ErrMalformedHash, "argon2id salt")
+	}
+
+	if h.key, err = decode(parts[5]); err != nil || len(h.key) == 0 {
+		return nil, errors.Wrap(ErrMalformedHash, "argon2id key")
+	}
+
+	return h, nil
+}
@@ -0,0 +1,58 @@
+package password
+
+import (
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/bcrypt"
+
+	"authService/config"
+)
+
+const defaultBcryptCost = 12
+
+// BcryptHasher uses bcrypt own modular crypt format: $2a$<cost>$<salt and hash>
+type BcryptHasher struct {
+	Cost int
+}
+
+func NewBcryptHasher(c config.BcryptConfig) *BcryptHasher {
+
+	cost := c.Cost
+	if cost == 0 {
+		cost = defaultBcryptCost
+	}
+
+	return &BcryptHasher{Cost: cost}
+}
+
+func (b *BcryptHasher) Hash(password string) (string, error) {
+
+	h, err := bcrypt.GenerateFromPassword([]byte(password), b.Cost)
+
+	if err != nil {
+		return "", errors.Wrap(err, "Can't hash password with bcrypt")
+	}
+
+	return string(h), nil
+}
+
+func (b *BcryptHasher) Verify(password, encoded string) (bool, error) {
+
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, errors.Wrap(ErrMalformedHash, err.Error())
+	}
+
+	return true, nil
+}
+
+func (b *BcryptHasher) NeedsRehash(encoded string) bool {
+
+	cost, err := bcrypt.Cost([]byte(encoded))
+
+	return err != nil || cost != b.Cost
+}
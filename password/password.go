@@ -0,0 +1,119 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"authService/config"
+)
+
+const (
+	Bcrypt   = "bcrypt"
+	Argon2id = "argon2id"
+	Scrypt   = "scrypt"
+)
+
+var (
+	ErrUnknownAlgorithm = errors.New("Unknown password hash algorithm")
+	ErrMalformedHash    = errors.New("Malformed password hash")
+)
+
+// PasswordHasher produces self describing encoded hashes which contain
+// algorithm name and all parameters required to verify a password later
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	Verify(password, encoded string) (bool, error)
+	NeedsRehash(encoded string) bool
+}
+
+// Hasher creates new hashes with preferred algorithm and verifies hashes made by any known algorithm
+type Hasher struct {
+	algorithm string
+	hashers   map[string]PasswordHasher
+}
+
+func NewHasher(c config.PasswordConfig) (*Hasher, error) {
+
+	algorithm := c.Algorithm
+	if algorithm == "" {
+		algorithm = Argon2id
+	}
+
+	h := &Hasher{
+		algorithm: algorithm,
+		hashers: map[string]PasswordHasher{
+			Bcrypt:   NewBcryptHasher(c.Bcrypt),
+			Argon2id: NewArgon2idHasher(c.Argon2id),
+			Scrypt:   NewScryptHasher(c.Scrypt),
+		},
+	}
+
+	if _, ok := h.hashers[algorithm]; !ok {
+		return nil, errors.Wrap(ErrUnknownAlgorithm, algorithm)
+	}
+
+	return h, nil
+}
+
+func (h *Hasher) Hash(password string) (string, error) {
+	return h.hashers[h.algorithm].Hash(password)
+}
+
+func (h *Hasher) Verify(password, encoded string) (bool, error) {
+
+	hasher, ok := h.hashers[Algorithm(encoded)]
+
+	if !ok {
+		return false, ErrUnknownAlgorithm
+	}
+
+	return hasher.Verify(password, encoded)
+}
+
+func (h *Hasher) NeedsRehash(encoded string) bool {
+
+	if Algorithm(encoded) != h.algorithm {
+		return true
+	}
+
+	return h.hashers[h.algorithm].NeedsRehash(encoded)
+}
+
+// Algorithm returns name of algorithm encoded hash was made with
+func Algorithm(encoded string) string {
+
+	if strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$") {
+		return Bcrypt
+	}
+
+	parts := strings.Split(encoded, "$")
+	if len(parts) < 2 {
+		return ""
+	}
+
+	return parts[1]
+}
+
+func newSalt(n int) ([]byte, error) {
+	salt := make([]byte, n)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, errors.Wrap(err, "Can't generate salt")
+	}
+	return salt, nil
+}
+
+func encode(b []byte) string {
+	return base64.RawStdEncoding.EncodeToString(b)
+}
+
+func decode(s string) ([]byte, error) {
+	return base64.RawStdEncoding.DecodeString(s)
+}
+
+func equal(a, b []byte) bool {
+	return subtle.ConstantTimeCompare(a, b) == 1
+}
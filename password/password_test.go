@@ -0,0 +1,137 @@
+package password
+
+import (
+	"strings"
+	"testing"
+
+	"authService/config"
+)
+
+var testConfig = config.PasswordConfig{
+	Bcrypt:   config.BcryptConfig{Cost: 4},
+	Argon2id: config.Argon2idConfig{Time: 1, Memory: 1024, Threads: 1},
+	Scrypt:   config.ScryptConfig{LogN: 4, R: 8, P: 1},
+}
+
+func testHasher(t *testing.T, algorithm string) *Hasher {
+	c := testConfig
+	c.Algorithm = algorithm
+
+	h, err := NewHasher(c)
+
+	if err != nil {
+		t.Fatalf("Hasher for %v should be created but got error: %v", algorithm, err)
+	}
+
+	return h
+}
+
+func TestHasher_HashAndVerify(t *testing.T) {
+
+	tests := []struct {
+		algorithm string
+		prefix    string
+	}{
+		{algorithm: Bcrypt, prefix: "$2a$04$"},
+		{algorithm: Argon2id, prefix: "$argon2id$v=19$m=1024,t=1,p=1$"},
+		{algorithm: Scrypt, prefix: "$scrypt$ln=4,r=8,p=1$"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.algorithm, func(t *testing.T) {
+			h := testHasher(t, tc.algorithm)
+
+			encoded, err := h.Hash("qwerty")
+
+			if err != nil {
+				t.Fatalf("Password should be hashed but got error: %v", err)
+			}
+
+			if !strings.HasPrefix(encoded, tc.prefix) {
+				t.Errorf("Expected hash with prefix [%v] but got [%v]", tc.prefix, encoded)
+			}
+
+			if Algorithm(encoded) != tc.algorithm {
+				t.Errorf("Expected algorithm [%v] but got [%v]", tc.algorithm, Algorithm(encoded))
+			}
+
+			if ok, err := h.Verify("qwerty", encoded); !ok || err != nil {
+				t.Errorf("Correct password should be verified but got [%v, %v]", ok, err)
+			}
+
+			if ok, err := h.Verify("qwerty1", encoded); ok || err != nil {
+				t.Errorf("Wrong password should not be verified but got [%v, %v]", ok, err)
+			}
+
+			if h.NeedsRehash(encoded) {
+				t.Errorf("Hash made with current parameters should not need rehash")
+			}
+		})
+	}
+}
+
+func TestHasher_HashIsSalted(t *testing.T) {
+
+	h := testHasher(t, Argon2id)
+
+	first, _ := h.Hash("qwerty")
+	second, _ := h.Hash("qwerty")
+
+	if first == second {
+		t.Errorf("Two hashes of the same password should differ but both were [%v]", first)
+	}
+}
+
+func TestHasher_NeedsRehash(t *testing.T) {
+
+	old := testHasher(t, Scrypt)
+	encoded, _ := old.Hash("qwerty")
+
+	current := testHasher(t, Argon2id)
+
+	if !current.NeedsRehash(encoded) {
+		t.Errorf("Hash made with other algorithm should need rehash")
+	}
+
+	if ok, err := current.Verify("qwerty", encoded); !ok || err != nil {
+		t.Errorf("Hash made with other algorithm should still be verified but got [%v, %v]", ok, err)
+	}
+
+	c := testConfig
+	c.Algorithm = Scrypt
+	c.Scrypt.LogN = 5
+	upgraded, _ := NewHasher(c)
+
+	if !upgraded.NeedsRehash(encoded) {
+		t.Errorf("Hash made with outdated parameters should need rehash")
+	}
+}
+
+func TestHasher_Errors(t *testing.T) {
+
+	if _, err := NewHasher(config.PasswordConfig{Algorithm: "md5"}); err == nil {
+		t.Errorf("Hasher with unknown algorithm should not be created")
+	}
+
+	h := testHasher(t, Argon2id)
+
+	tests := []string{
+		"",
+		"plain",
+		"$md5$abc",
+		"$argon2id$v=19$m=1024,t=1,p=1$salt",
+		"$argon2id$v=18$m=1024,t=1,p=1$c2FsdA$a2V5",
+		"$argon2id$v=19$m=1024,t=0,p=1$c2FsdA$a2V5",
+		"$argon2id$v=19$m=1024,t=1,p=0$c2FsdA$a2V5",
+		"$argon2id$v=19$m=15,t=1,p=2$c2FsdA$a2V5",
+		"$scrypt$ln=x,r=8,p=1$c2FsdA$a2V5",
+		"$scrypt$ln=4,r=8,p=1$!!!$a2V5",
+		"$2a$04$short",
+	}
+
+	for _, encoded := range tests {
+		if ok, err := h.Verify("qwerty", encoded); ok || err == nil {
+			t.Errorf("Malformed hash [%v] should return error but got [%v, %v]", encoded, ok, err)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package password
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/scrypt"
+
+	"authService/config"
+)
+
+const (
+	defaultScryptLogN = 15
+	defaultScryptR    = 8
+	defaultScryptP    = 1
+	scryptSaltLength  = 16
+	scryptKeyLength   = 32
+)
+
+// ScryptHasher uses PHC like string format: $scrypt$ln=<log2 N>,r=<r>,p=<p>$<salt>$<hash>
+type ScryptHasher struct {
+	LogN int
+	R    int
+	P    int
+}
+
+type scryptHash struct {
+	ScryptHasher
+	salt []byte
+	key  []byte
+}
+
+func NewScryptHasher(c config.ScryptConfig) *ScryptHasher {
+
+	h := &ScryptHasher{
+		LogN: c.LogN,
+		R:    c.R,
+		P:    c.P,
+	}
+
+	if h.LogN == 0 {
+		h.LogN = defaultScryptLogN
+	}
+	if h.R == 0 {
+		h.R = defaultScryptR
+	}
+	if h.P == 0 {
+		h.P = defaultScryptP
+	}
+
+	return h
+}
+
+func (s *ScryptHasher) Hash(password string) (string, error) {
+
+	salt, err := newSalt(scryptSaltLength)
+
+	if err != nil {
+		return "", err
+	}
+
+	key, err := scrypt.Key([]byte(password), salt, 1<<uint(s.LogN), s.R, s.P, scryptKeyLength)
+
+	if err != nil {
+		return "", errors.Wrap(err, "Can't hash password with scrypt")
+	}
+
+	return fmt.Sprintf("$%s$ln=%d,r=%d,p=%d$%s$%s", Scrypt, s.LogN, s.R, s.P, encode(salt), encode(key)), nil
+}
+
+func (s *ScryptHasher) Verify(password, encoded string) (bool, error) {
+
+	h, err := parseScrypt(encoded)
+
+	if err != nil {
+		return false, err
+	}
+
+	key, err := scrypt.Key([]byte(password), h.salt, 1<<uint(h.LogN), h.R, h.P, len(h.key))
+
+	if err != nil {
+		return false, errors.Wrap(ErrMalformedHash, err.Error())
+	}
+
+	return equal(key, h.key), nil
+}
+
+func (s *ScryptHasher) NeedsRehash(encoded string) bool {
+
+	h, err := parseScrypt(encoded)
+
+	return err != nil || h.ScryptHasher != *s
+}
+
+func parseScrypt(encoded string) (*scryptHash, error) {
+
+	parts := strings.Split(encoded, "$")
+
+	if len(parts) != 5 || parts[1] != Scrypt {
+		return nil, errors.Wrap(ErrMalformedHash, Scrypt)
+	}
+
+	h := &scryptHash{}
+	if _, err := fmt.Sscanf(parts[2], "ln=%d,r=%d,p=%d", &h.LogN, &h.R, &h.P); err != nil || h.LogN < 1 || h.LogN > 30 {
+		return nil, errors.Wrap(ErrMalformedHash, "scrypt parameters")
+	}
+
+	var err error
+	if h.salt, err = decode(parts[3]); err != nil {
+		return nil, errors.Wrap(ErrMalformedHash, "scrypt salt")
+	}
+
+	if h.key, err = decode(parts[4]); err != nil || len(h.key) == 0 {
+		return nil, errors.Wrap(ErrMalformedHash, "scrypt key")
+	}
+
+	return h, nil
+}
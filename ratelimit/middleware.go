@@ -0,0 +1,121 @@
+package ratelimit
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"authService/config"
+	"authService/model"
+)
+
+type limits struct {
+	ip   Limit
+	user Limit
+}
+
+// Middleware limits every request by its client address and, when the request is made on behalf of a user,
+// by the user too. Responses carry RateLimit-* headers of the most exhausted bucket, denied requests get 429
+type Middleware struct {
+	limiter  *Limiter
+	defaults limits
+	routes   map[string]limits
+	identify func(r *http.Request) string
+}
+
+// New creates middleware with limits of configuration, identify returns user the request is made on behalf of
+// or empty string for anonymous requests
+func New(c config.RateLimitsConfig, identify func(r *http.Request) string) *Middleware {
+
+	m := &Middleware{
+		limiter:  NewLimiter(),
+		defaults: limits{ip: NewLimit(c.IP), user: NewLimit(c.User)},
+		routes:   map[string]limits{},
+		identify: identify,
+	}
+
+	for path, route := range c.Routes {
+		l := limits{ip: NewLimit(route.IP), user: NewLimit(route.User)}
+		if l.ip.IsZero() {
+			l.ip = m.defaults.ip
+		}
+		if l.user.IsZero() {
+			l.user = m.defaults.user
+		}
+		m.routes[path] = l
+	}
+
+	return m
+}
+
+func (m *Middleware) ServeHTTP(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+
+	// requests of a route with own limits are counted in buckets of that route only
+	l, prefix := m.defaults, ""
+	if route, ok := m.routes[r.URL.Path]; ok {
+		l, prefix = route, r.URL.Path+" "
+	}
+
+	var result *Result
+
+	if !l.ip.IsZero() {
+		result = m.take(result, prefix+"ip:"+ClientIP(r), l.ip)
+	}
+
+	if !l.user.IsZero() && m.identify != nil && (result == nil || result.Allowed) {
+		if user := m.identify(r); user != "" {
+			result = m.take(result, prefix+"user:"+user, l.user)
+		}
+	}
+
+	if result == nil {
+		next(rw, r)
+		return
+	}
+
+	rw.Header().Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+	rw.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	rw.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+
+	if !result.Allowed {
+		rw.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+		rw.WriteHeader(http.StatusTooManyRequests)
+		rw.Write(model.AuthError{ErrorCode: "Too Many Requests", Reason: "Rate limit exceeded"}.ToBytes())
+		return
+	}
+
+	next(rw, r)
+}
+
+// take requests token of the bucket and returns result of the more exhausted bucket
+func (m *Middleware) take(prev *Result, key string, limit Limit) *Result {
+
+	r := m.limiter.Allow(key, limit)
+
+	if prev != nil && prev.Remaining < r.Remaining && r.Allowed {
+		return prev
+	}
+	return &r
+}
+
+// ClientIP is address of the connection peer, forwarding headers are not trusted as anyone can set them
+func ClientIP(r *http.Request) string {
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+func ceilSeconds(d time.Duration) int {
+
+	s := int((d + time.Second - 1) / time.Second)
+	if s < 1 {
+		return 1
+	}
+	return s
+}
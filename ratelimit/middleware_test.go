@@ -0,0 +1,143 @@
+package ratelimit
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/urfave/negroni"
+
+	"authService/config"
+)
+
+func perMinute(requests, burst int) config.RateLimitConfig {
+	return config.RateLimitConfig{Requests: requests, Period: config.Duration{Duration: time.Minute}, Burst: burst}
+}
+
+func TestMiddleware(t *testing.T) {
+
+	type request struct {
+		path   string
+		ip     string
+		user   string
+		status int
+		limit  string
+		remain string
+	}
+
+	tests := []struct {
+		description string
+		config      config.RateLimitsConfig
+		requests    []request
+	}{
+		{
+			description: "Should not limit without configuration",
+			config:      config.RateLimitsConfig{},
+			requests: []request{
+				{path: "/login", ip: "10.0.0.1", status: 200},
+				{path: "/login", ip: "10.0.0.1", status: 200},
+			},
+		},
+		{
+			description: "Should limit requests of address",
+			config:      config.RateLimitsConfig{IP: perMinute(60, 2)},
+			requests: []request{
+				{path: "/login", ip: "10.0.0.1", status: 200, limit: "2", remain: "1"},
+				{path: "/sso", ip: "10.0.0.1", status: 200, limit: "2", remain: "0"},
+				{path: "/logout", ip: "10.0.0.1", status: 429, limit: "2", remain: "0"},
+				{path: "/logout", ip: "10.0.0.2", status: 200, limit: "2", remain: "1"},
+			},
+		},
+		{
+			description: "Should limit requests of user from any address",
+			config:      config.RateLimitsConfig{IP: perMinute(60, 10), User: perMinute(60, 2)},
+			requests: []request{
+				{path: "/userinfo", ip: "10.0.0.1", user: "user", status: 200, limit: "2", remain: "1"},
+				{path: "/userinfo", ip: "10.0.0.2", user: "user", status: 200, limit: "2", remain: "0"},
+				{path: "/userinfo", ip: "10.0.0.3", user: "user", status: 429, limit: "2", remain: "0"},
+				{path: "/userinfo", ip: "10.0.0.3", user: "other", status: 200, limit: "2", remain: "1"},
+				{path: "/userinfo", ip: "10.0.0.3", status: 200, limit: "10", remain: "7"},
+			},
+		},
+		{
+			description: "Should count requests of route with own limits separately",
+			config: config.RateLimitsConfig{IP: perMinute(60, 5), Routes: map[string]config.RouteRateLimitConfig{
+				"/signin": {IP: perMinute(1, 1)},
+			}},
+			requests: []request{
+				{path: "/signin", ip: "10.0.0.1", status: 200, limit: "1", remain: "0"},
+				{path: "/signin", ip: "10.0.0.1", status: 429, limit: "1", remain: "0"},
+				{path: "/login", ip: "10.0.0.1", status: 200, limit: "5", remain: "4"},
+				{path: "/signin/other", ip: "10.0.0.1", status: 200, limit: "5", remain: "3"},
+			},
+		},
+		{
+			description: "Should fall back to default limits missing for route",
+			config: config.RateLimitsConfig{User: perMinute(60, 1), Routes: map[string]config.RouteRateLimitConfig{
+				"/sso": {IP: perMinute(60, 5)},
+			}},
+			requests: []request{
+				{path: "/sso", ip: "10.0.0.1", user: "user", status: 200, limit: "1", remain: "0"},
+				{path: "/sso", ip: "10.0.0.1", user: "user", status: 429, limit: "1", remain: "0"},
+			},
+		},
+	}
+
+	for _, tc := range tests {
+
+		t.Run(tc.description, func(t *testing.T) {
+
+			m := New(tc.config, func(r *http.Request) string { return r.Header.Get("X-Test-User") })
+
+			n := negroni.New(m)
+			n.UseHandlerFunc(func(rw http.ResponseWriter, r *http.Request) {})
+
+			for i, req := range tc.requests {
+				r := httptest.NewRequest("GET", req.path, nil)
+				r.RemoteAddr = req.ip + ":1234"
+				if req.user != "" {
+					r.Header.Set("X-Test-User", req.user)
+				}
+
+				rw := httptest.NewRecorder()
+				n.ServeHTTP(rw, r)
+
+				if rw.Code != req.status {
+					t.Errorf("Request %d: expected status [%v] but was: [%v]", i, req.status, rw.Code)
+				}
+
+				if l, rem := rw.Header().Get("RateLimit-Limit"), rw.Header().Get("RateLimit-Remaining"); l != req.limit || rem != req.remain {
+					t.Errorf("Request %d: expected RateLimit headers [%v, %v] but was: [%v, %v]", i, req.limit, req.remain, l, rem)
+				}
+
+				if retry := rw.Header().Get("Retry-After"); (req.status == 429) != (retry != "") {
+					t.Errorf("Request %d: unexpected Retry-After header [%v]", i, retry)
+				}
+			}
+		})
+	}
+}
+
+func TestMiddleware_DeniedResponse(t *testing.T) {
+
+	m := New(config.RateLimitsConfig{IP: perMinute(2, 1)}, nil)
+
+	var rw *httptest.ResponseRecorder
+	for i := 0; i < 2; i++ {
+		rw = httptest.NewRecorder()
+		m.ServeHTTP(rw, httptest.NewRequest("POST", "/login", nil), func(rw http.ResponseWriter, r *http.Request) {})
+	}
+
+	b, _ := ioutil.ReadAll(rw.Body)
+	expected := `{"error":"Too Many Requests","reason":"Rate limit exceeded"}`
+
+	if rw.Code != 429 || string(b) != expected {
+		t.Errorf("Expected [%v, %v] but was: [%v, %v]", 429, expected, rw.Code, string(b))
+	}
+
+	if retry, reset := rw.Header().Get("Retry-After"), rw.Header().Get("RateLimit-Reset"); retry != "30" || reset != "30" {
+		t.Errorf("Expected Retry-After and RateLimit-Reset [%v, %v] but was: [%v, %v]", "30", "30", retry, reset)
+	}
+}
@@ -0,0 +1,125 @@
+// Package ratelimit limits request rate with token buckets. Buckets live in memory of the process,
+// so every replica applies limits to requests it serves
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"authService/config"
+)
+
+// pruneInterval is how often buckets which refilled completely are dropped, they are equal to new ones
+const pruneInterval = time.Minute
+
+// Limit allows Burst requests at once and Rate requests per second on average, zero Limit allows everything
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+func NewLimit(c config.RateLimitConfig) Limit {
+
+	if c.Requests <= 0 || c.Period.Duration <= 0 {
+		return Limit{}
+	}
+
+	burst := c.Burst
+	if burst <= 0 {
+		burst = c.Requests
+	}
+
+	return Limit{Rate: float64(c.Requests) / c.Period.Seconds(), Burst: burst}
+}
+
+func (l Limit) IsZero() bool {
+	return l.Rate <= 0 || l.Burst <= 0
+}
+
+// Result is state of the bucket after request was taken. Reset is time until the bucket is full again,
+// RetryAfter is time until denied request would be allowed
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration
+	RetryAfter time.Duration
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	limit  Limit
+}
+
+// Limiter keeps a bucket per key, it is safe for concurrent use
+type Limiter struct {
+	mu         sync.Mutex
+	buckets    map[string]*bucket
+	lastPruned time.Time
+	now        func() time.Time
+}
+
+func NewLimiter() *Limiter {
+	return &Limiter{buckets: make(map[string]*bucket), now: time.Now}
+}
+
+// Allow takes a request from bucket of the key, a new bucket is full
+func (l *Limiter) Allow(key string, limit Limit) Result {
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.prune(now)
+
+	b, ok := l.buckets[key]
+	if !ok || b.limit != limit {
+		b = &bucket{tokens: float64(limit.Burst), last: now, limit: limit}
+		l.buckets[key] = b
+	}
+
+	b.refill(now)
+
+	r := Result{Limit: limit.Burst}
+
+	if b.tokens >= 1 {
+		b.tokens--
+		r.Allowed = true
+	} else {
+		r.RetryAfter = seconds((1 - b.tokens) / limit.Rate)
+	}
+
+	r.Remaining = int(math.Floor(b.tokens))
+	r.Reset = seconds((float64(limit.Burst) - b.tokens) / limit.Rate)
+
+	return r
+}
+
+func (b *bucket) refill(now time.Time) {
+
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(float64(b.limit.Burst), b.tokens+elapsed.Seconds()*b.limit.Rate)
+		b.last = now
+	}
+}
+
+func (l *Limiter) prune(now time.Time) {
+
+	if now.Sub(l.lastPruned) < pruneInterval {
+		return
+	}
+
+	for key, b := range l.buckets {
+		if b.refill(now); b.tokens >= float64(b.limit.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+
+	l.lastPruned = now
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
@@ -0,0 +1,103 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"authService/config"
+)
+
+type clock struct {
+	t time.Time
+}
+
+func (c *clock) now() time.Time {
+	return c.t
+}
+
+func newTestLimiter() (*Limiter, *clock) {
+	clk := &clock{t: time.Date(2019, 1, 1, 10, 0, 0, 0, time.UTC)}
+	l := NewLimiter()
+	l.now = clk.now
+	return l, clk
+}
+
+func TestNewLimit(t *testing.T) {
+
+	tests := []struct {
+		description string
+		config      config.RateLimitConfig
+		expected    Limit
+	}{
+		{"No limit", config.RateLimitConfig{}, Limit{}},
+		{"No period", config.RateLimitConfig{Requests: 10}, Limit{}},
+		{"Burst defaults to requests", config.RateLimitConfig{Requests: 10, Period: config.Duration{Duration: 5 * time.Second}}, Limit{Rate: 2, Burst: 10}},
+		{"Burst", config.RateLimitConfig{Requests: 60, Period: config.Duration{Duration: time.Minute}, Burst: 5}, Limit{Rate: 1, Burst: 5}},
+	}
+
+	for _, tc := range tests {
+		if l := NewLimit(tc.config); l != tc.expected {
+			t.Errorf("%v: expected [%v] but was: [%v]", tc.description, tc.expected, l)
+		}
+	}
+}
+
+func TestLimiter_Allow(t *testing.T) {
+
+	l, clk := newTestLimiter()
+	limit := Limit{Rate: 0.5, Burst: 3}
+
+	for i := 2; i >= 0; i-- {
+		r := l.Allow("ip:10.0.0.1", limit)
+		if !r.Allowed || r.Limit != 3 || r.Remaining != i || r.Reset != time.Duration(3-i)*2*time.Second {
+			t.Errorf("Expected allowed request with [%v] remaining but was: [%+v]", i, r)
+		}
+	}
+
+	r := l.Allow("ip:10.0.0.1", limit)
+	if r.Allowed || r.Remaining != 0 || r.RetryAfter != 2*time.Second || r.Reset != 6*time.Second {
+		t.Errorf("Expected denied request but was: [%+v]", r)
+	}
+
+	if r := l.Allow("ip:10.0.0.2", limit); !r.Allowed || r.Remaining != 2 {
+		t.Errorf("Expected full bucket of other key but was: [%+v]", r)
+	}
+
+	clk.t = clk.t.Add(time.Second)
+
+	if r := l.Allow("ip:10.0.0.1", limit); r.Allowed || r.RetryAfter != time.Second {
+		t.Errorf("Expected denied request until token is refilled but was: [%+v]", r)
+	}
+
+	clk.t = clk.t.Add(time.Second)
+
+	if r := l.Allow("ip:10.0.0.1", limit); !r.Allowed || r.Remaining != 0 {
+		t.Errorf("Expected allowed request with refilled token but was: [%+v]", r)
+	}
+
+	clk.t = clk.t.Add(time.Hour)
+
+	if r := l.Allow("ip:10.0.0.1", limit); !r.Allowed || r.Remaining != 2 {
+		t.Errorf("Expected bucket refilled up to burst but was: [%+v]", r)
+	}
+}
+
+func TestLimiter_PrunesFullBuckets(t *testing.T) {
+
+	l, clk := newTestLimiter()
+	limit := Limit{Rate: 1, Burst: 10}
+
+	l.Allow("ip:10.0.0.1", limit)
+	l.Allow("ip:10.0.0.2", Limit{Rate: 0.001, Burst: 10})
+
+	clk.t = clk.t.Add(pruneInterval)
+	l.Allow("ip:10.0.0.3", limit)
+
+	if _, ok := l.buckets["ip:10.0.0.1"]; ok {
+		t.Errorf("Refilled bucket mast be dropped")
+	}
+
+	if _, ok := l.buckets["ip:10.0.0.2"]; !ok {
+		t.Errorf("Bucket being refilled mast be kept")
+	}
+}
@@ -1,12 +1,17 @@
 package main
 
 import (
+	"log"
+
+	"authService/access"
 	"authService/jwt"
 
 	"github.com/gorilla/mux"
 	"github.com/urfave/negroni"
 	"authService/config"
 	"authService/handlers"
+	"authService/mail"
+	"authService/password"
 	"authService/server"
 	"authService/storage"
 )
@@ -23,18 +28,87 @@ func main() {
 
 	router.Handle("/sso", negroni.New(negroni.HandlerFunc(handlers.Sso))).Methods("POST")
 	router.Handle("/login", negroni.New(negroni.HandlerFunc(handlers.Login))).Methods("POST")
+	router.Handle("/login/mfa", negroni.New(negroni.HandlerFunc(handlers.LoginMFA))).Methods("POST")
+	router.Handle("/webauthn/login/begin", negroni.New(negroni.HandlerFunc(handlers.BeginPasskeyLogin))).Methods("POST")
+	router.Handle("/webauthn/login/finish", negroni.New(negroni.HandlerFunc(handlers.FinishPasskeyLogin))).Methods("POST")
 	router.Handle("/logout", negroni.New(negroni.HandlerFunc(handlers.Logout))).Methods("POST")
 	router.Handle("/signin", negroni.New(negroni.HandlerFunc(handlers.Signin))).Methods("POST")
+	router.Handle("/password/forgot", negroni.New(negroni.HandlerFunc(handlers.ForgotPassword))).Methods("POST")
+	router.Handle("/password/reset", negroni.New(negroni.HandlerFunc(handlers.ResetPassword))).Methods("GET", "POST")
+	router.Handle("/verify-email", negroni.New(negroni.HandlerFunc(handlers.VerifyEmail))).Methods("GET", "POST")
+	router.Handle("/health", negroni.New(negroni.HandlerFunc(handlers.Health))).Methods("GET")
+	router.Handle("/.well-known/jwks.json", negroni.New(negroni.HandlerFunc(handlers.Jwks))).Methods("GET")
+	router.Handle("/token/refresh", negroni.New(negroni.HandlerFunc(handlers.Refresh))).Methods("POST")
+	router.Handle("/authorize", negroni.New(negroni.HandlerFunc(handlers.OAuthAuthorize))).Methods("GET", "POST")
+	router.Handle("/token", negroni.New(negroni.HandlerFunc(handlers.OAuthToken))).Methods("POST")
+	router.Handle("/introspect", negroni.New(negroni.HandlerFunc(handlers.Introspect))).Methods("POST")
+	router.Handle("/mfa/totp", handlers.Protect(handlers.EnrollTOTP)).Methods("POST")
+	router.Handle("/mfa/totp", handlers.Protect(handlers.DisableTOTP)).Methods("DELETE")
+	router.Handle("/mfa/totp/confirm", handlers.Protect(handlers.ConfirmTOTP)).Methods("POST")
+	router.Handle("/webauthn/register/begin", handlers.Protect(handlers.BeginPasskeyRegistration)).Methods("POST")
+	router.Handle("/webauthn/register/finish", handlers.Protect(handlers.FinishPasskeyRegistration)).Methods("POST")
+	router.Handle("/userinfo", handlers.Protect(handlers.UserInfo)).Methods("GET", "POST")
+	router.Handle("/.well-known/openid-configuration", negroni.New(negroni.HandlerFunc(handlers.Discovery))).Methods("GET")
+	router.Handle("/admin/users/{id}", handlers.Protect(handlers.GetUser, "users:read")).Methods("GET")
+	router.Handle("/admin/users/{id}/roles", handlers.Protect(handlers.SetUserRoles, "users:write")).Methods("PUT")
+	router.Handle("/admin/users/{id}/status", handlers.Protect(handlers.SetUserStatus, "users:write")).Methods("PUT")
+
+	storage, err := storage.NewStore(c.Storage)
+
+	if err != nil {
+		panic(err)
+	}
+
+	hasher, err := password.NewHasher(c.Password)
+
+	if err != nil {
+		panic(err)
+	}
+
+	mailer, err := mail.NewMailer(c.Mail)
 
-	storage := storage.NewMemoryStore()
+	if err != nil {
+		panic(err)
+	}
+
+	if err := server.SeedClients(storage, hasher, c.Clients); err != nil {
+		panic(err)
+	}
+
+	keyLoader := jwt.NewFileKeyLoader(*c)
+
+	checks := []server.HealthCheck{
+		{Name: "signing keys", Check: func() error {
+			_, err := keyLoader.InitializeKeysChain()
+			return err
+		}},
+	}
+
+	if p, ok := storage.(interface{ Ping() error }); ok {
+		checks = append(checks, server.HealthCheck{Name: "storage", Check: p.Ping})
+	}
 
 	s := server.Server{
-		Config:       *c,
-		Tokenizer:    jwt.NewTokenizer(jwt.NewFileKeyLoader(*c)),
-		Router:       router,
-		UserStore:    storage,
-		SessionStore: storage,
+		Config:        *c,
+		Tokenizer:     jwt.NewTokenizer(keyLoader, *c),
+		Router:        router,
+		UserStore:     storage,
+		SessionStore:  storage,
+		RefreshStore:  storage,
+		ClientStore:   storage,
+		CodeStore:     storage,
+		ConsentStore:  storage,
+		OneTimeStore:  storage,
+		TOTPStore:     storage,
+		WebAuthnStore: storage,
+		AttemptStore:  storage,
+		Mailer:        mailer,
+		Hasher:        hasher,
+		Access:        access.NewPolicy(c.Access),
+		HealthChecks:  checks,
 	}
 
-	s.Run()
+	if err := s.Run(); err != nil {
+		log.Fatal(err)
+	}
 }
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"net/url"
+
+	"github.com/pkg/errors"
+
+	"authService/config"
+	"authService/model"
+	"authService/password"
+	"authService/storage"
+)
+
+// SeedClients registers clients from configuration, secrets of already stored clients are replaced
+// so a secret is rotated by changing configuration and restarting the service
+func SeedClients(store storage.ClientStore, hasher password.PasswordHasher, clients []config.ClientConfig) error {
+
+	for _, c := range clients {
+
+		if c.Id == "" {
+			return errors.Errorf("Client %q has no id", c.Name)
+		}
+
+		if c.Public == (c.Secret != "") {
+			return errors.Errorf("Client %v must have either secret or be public", c.Id)
+		}
+
+		for _, uri := range c.RedirectURIs {
+			if err := checkRedirectURI(uri); err != nil {
+				return errors.Wrapf(err, "Client %v", c.Id)
+			}
+		}
+
+		if c.Public && len(c.Scopes) > 0 {
+			return errors.Errorf("Public client %v can't have scopes of its own", c.Id)
+		}
+
+		client := model.Client{Id: c.Id, Name: c.Name, RedirectURIs: c.RedirectURIs, Public: c.Public, Scopes: c.Scopes}
+
+		if !c.Public {
+			hash, err := hasher.Hash(c.Secret)
+
+			if err != nil {
+				return errors.Wrapf(err, "Can't hash secret of client %v", c.Id)
+			}
+
+			client.SecretHash = hash
+		}
+
+		if err := store.SaveClient(client); err != nil {
+			return errors.Wrapf(err, "Can't register client %v", c.Id)
+		}
+	}
+
+	return nil
+}
+
+// checkRedirectURI accepts absolute URIs without fragment, RFC 6749 3.1.2
+func checkRedirectURI(uri string) error {
+
+	u, err := url.Parse(uri)
+
+	if err != nil || !u.IsAbs() || u.Fragment != "" {
+		return errors.Errorf("Redirect URI %q must be absolute and have no fragment", uri)
+	}
+
+	return nil
+}
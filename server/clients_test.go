@@ -0,0 +1,61 @@
+package server
+
+import (
+	"testing"
+
+	"authService/config"
+	"authService/password"
+	"authService/storage"
+)
+
+func TestSeedClients(t *testing.T) {
+
+	store := storage.NewMemoryStore()
+	hasher, _ := password.NewHasher(config.PasswordConfig{Algorithm: password.Bcrypt, Bcrypt: config.BcryptConfig{Cost: 4}})
+
+	err := SeedClients(store, hasher, []config.ClientConfig{{Id: "gateway", Secret: "secret", Name: "API gateway", Scopes: []string{"users:read"}}})
+
+	if err != nil {
+		t.Fatalf("Clients mast be seeded but got error: %v", err)
+	}
+
+	c, err := store.GetClient("gateway")
+
+	if err != nil || c.Name != "API gateway" || len(c.Scopes) != 1 {
+		t.Fatalf("Seeded client expected but got [%v, %v]", c, err)
+	}
+
+	if ok, _ := hasher.Verify("secret", c.SecretHash); !ok || c.SecretHash == "secret" {
+		t.Errorf("Client secret mast be stored hashed but got: %v", c.SecretHash)
+	}
+
+	invalid := []config.ClientConfig{
+		{Id: "gateway"},
+		{Id: "app", Secret: "secret", Public: true},
+		{Secret: "secret"},
+		{Id: "app", Public: true, RedirectURIs: []string{"/callback"}},
+		{Id: "app", Public: true, RedirectURIs: []string{"https://app.example.com/callback#fragment"}},
+		{Id: "app", Public: true, Scopes: []string{"users:read"}},
+	}
+
+	for _, c := range invalid {
+		if err := SeedClients(store, hasher, []config.ClientConfig{c}); err == nil {
+			t.Errorf("Client %v mast be rejected", c)
+		}
+	}
+}
+
+func TestSeedClients_Public(t *testing.T) {
+
+	store := storage.NewMemoryStore()
+
+	err := SeedClients(store, nil, []config.ClientConfig{{Id: "app", Public: true, RedirectURIs: []string{"app://callback"}}})
+
+	if err != nil {
+		t.Fatalf("Public client mast be seeded but got error: %v", err)
+	}
+
+	if c, _ := store.GetClient("app"); !c.Public || c.SecretHash != "" || len(c.RedirectURIs) != 1 {
+		t.Errorf("Public client without secret expected but got %v", c)
+	}
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"log"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+var logger = log.New(os.Stdout, "[server] ", log.LstdFlags)
+
+// HealthCheck is a named dependency probe, it returns nil when dependency is usable
+type HealthCheck struct {
+	Name  string
+	Check func() error
+}
+
+type CheckResult struct {
+	Name    string `json:"name"`
+	Healthy bool   `json:"healthy"`
+	Error   string `json:"error,omitempty"`
+}
+
+type HealthReport struct {
+	Healthy bool          `json:"healthy"`
+	Checks  []CheckResult `json:"checks"`
+}
+
+// Health runs every registered check and collects their results
+func (s *Server) Health() HealthReport {
+
+	report := HealthReport{Healthy: true, Checks: []CheckResult{}}
+
+	for _, c := range s.HealthChecks {
+		result := CheckResult{Name: c.Name, Healthy: true}
+
+		if err := c.Check(); err != nil {
+			result.Healthy = false
+			result.Error = err.Error()
+			report.Healthy = false
+		}
+
+		report.Checks = append(report.Checks, result)
+	}
+
+	return report
+}
+
+// startupReport logs health of every dependency and fails if any of them is not usable
+func (s *Server) startupReport() error {
+
+	report := s.Health()
+
+	for _, c := range report.Checks {
+		if c.Healthy {
+			logger.Printf("%v: ok", c.Name)
+		} else {
+			logger.Printf("%v: FAILED: %v", c.Name, c.Error)
+		}
+	}
+
+	if !report.Healthy {
+		return errors.New("Startup health check failed")
+	}
+
+	return nil
+}
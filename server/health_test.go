@@ -0,0 +1,32 @@
+package server
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRun_FailsOnBrokenDependency(t *testing.T) {
+
+	s := Server{
+		HealthChecks: []HealthCheck{
+			{Name: "signing keys", Check: func() error { return errors.New("Can't read key") }},
+		},
+	}
+
+	if err := s.Run(); err == nil {
+		t.Errorf("Server mast not start when dependency is broken")
+	}
+
+	if RunningServer != nil {
+		t.Errorf("Server mast not be registered as running")
+	}
+}
+
+func TestHealth_NoChecks(t *testing.T) {
+
+	report := (&Server{}).Health()
+
+	if !report.Healthy || len(report.Checks) != 0 {
+		t.Errorf("Expected [healthy report without checks] but was: [%v]", report)
+	}
+}
@@ -1,34 +1,116 @@
 package server
 
 import (
+	"net"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 
 	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
 	"github.com/urfave/negroni"
+	"authService/access"
 	"authService/config"
 	"authService/jwt"
+	"authService/mail"
+	"authService/model"
+	"authService/password"
+	"authService/ratelimit"
 	"authService/storage"
 )
 
 type Server struct {
-	Config       config.Configuration
-	Tokenizer    jwt.Tokenazer
-	Router       *mux.Router
-	UserStore    storage.UserStore
-	SessionStore storage.SessionStorage
+	Config        config.Configuration
+	Tokenizer     jwt.Tokenazer
+	Router        *mux.Router
+	UserStore     storage.UserStore
+	SessionStore  storage.SessionStorage
+	RefreshStore  storage.RefreshTokenStore
+	ClientStore   storage.ClientStore
+	CodeStore     storage.AuthorizationCodeStore
+	ConsentStore  storage.ConsentStore
+	OneTimeStore  storage.OneTimeTokenStore
+	TOTPStore     storage.TOTPStore
+	WebAuthnStore storage.WebAuthnStore
+	AttemptStore  storage.LoginAttemptStore
+	Mailer        mail.Mailer
+	Hasher        password.PasswordHasher
+	Access        access.Policy
+	HealthChecks  []HealthCheck
 }
 
 var RunningServer *Server = nil
 
-func (s Server) Run() {
+// Run refuses to start when any health check fails or token issuer is not configured,
+// so misconfiguration is reported at startup
+func (s Server) Run() error {
+
+	if err := s.startupReport(); err != nil {
+		return err
+	}
+
+	if err := checkIssuer(s.Config.Token.Issuer); err != nil {
+		return err
+	}
 
 	port := ":" + strconv.Itoa(s.Config.Port)
 
 	n := negroni.Classic()
+	n.Use(ratelimit.New(s.Config.RateLimit, s.tokenSubject))
 	n.UseHandler(s.Router)
 
 	RunningServer = &s
 
-	http.ListenAndServe(port, n)
+	return http.ListenAndServe(port, n)
+}
+
+// checkIssuer requires absolute https issuer. It names the OpenID provider in discovery, ID tokens, TOTP
+// and passkeys, so it can't be taken from request Host which clients control. Plain http is accepted on loopback
+func checkIssuer(issuer string) error {
+
+	u, err := url.Parse(issuer)
+
+	if err != nil || !u.IsAbs() || u.Host == "" {
+		return errors.New("Token issuer has to be an absolute URL")
+	}
+
+	if u.Scheme == "https" || u.Scheme == "http" && isLoopback(u.Hostname()) {
+		return nil
+	}
+
+	return errors.Errorf("Token issuer has to be an https URL: %v", issuer)
+}
+
+func isLoopback(host string) bool {
+
+	if host == "localhost" {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// tokenSubject is user or client of a valid bearer token, so their limits follow them across addresses.
+// Unverified tokens are ignored, otherwise anyone could exhaust limits of other users
+func (s *Server) tokenSubject(r *http.Request) string {
+
+	h := r.Header.Get("Authorization")
+
+	if len(h) < 7 || !strings.EqualFold(h[:7], "Bearer ") {
+		return ""
+	}
+
+	pt, err := s.Tokenizer.ParceAndVerifyToken(strings.TrimSpace(h[7:]))
+
+	if err != nil || !pt.Valid {
+		return ""
+	}
+
+	if claims, ok := pt.Claims.(*model.TokenClaims); ok {
+		return claims.Subject
+	}
+
+	return ""
 }
@@ -0,0 +1,41 @@
+package server
+
+import "testing"
+
+func TestRun_FailsWithoutIssuer(t *testing.T) {
+
+	if err := (Server{}).Run(); err == nil {
+		t.Errorf("Server mast not start without token issuer")
+	}
+
+	if RunningServer != nil {
+		t.Errorf("Server mast not be registered as running")
+	}
+}
+
+func TestCheckIssuer(t *testing.T) {
+
+	tests := []struct {
+		description string
+		issuer      string
+		valid       bool
+	}{
+		{description: "Should accept https issuer", issuer: "https://auth.example.com", valid: true},
+		{description: "Should accept https issuer with path", issuer: "https://example.com/auth/", valid: true},
+		{description: "Should accept http issuer on localhost", issuer: "http://localhost:8081", valid: true},
+		{description: "Should accept http issuer on loopback address", issuer: "http://127.0.0.1:8081", valid: true},
+		{description: "Should reject missing issuer", issuer: ""},
+		{description: "Should reject relative issuer", issuer: "auth.example.com"},
+		{description: "Should reject http issuer", issuer: "http://auth.example.com"},
+		{description: "Should reject issuer of other scheme", issuer: "ftp://auth.example.com"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+
+			if err := checkIssuer(tc.issuer); (err == nil) != tc.valid {
+				t.Errorf("Expected valid [%v] but got error: [%v]", tc.valid, err)
+			}
+		})
+	}
+}
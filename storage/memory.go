@@ -3,103 +3,607 @@ package storage
 import (
 	"log"
 	"os"
+	"sort"
+	"sync"
+	"time"
 
 	"github.com/rs/xid"
 
 	"github.com/pkg/errors"
+
 	"authService/model"
 )
 
-//Memory storage should be used for development and test purpose only
+// MemoryStorage should be used for development and test purpose only.
+// It is safe for concurrent use, all maps are guarded by a single RWMutex
 type MemoryStorage struct {
-	Users    map[string]model.User
-	Sessions map[string]struct{}
+	mu            sync.RWMutex
+	users         map[string]model.User
+	ids           map[xid.ID]string
+	sessions      map[string]string
+	refreshTokens map[string]model.RefreshToken
+	clients       map[string]model.Client
+	codes         map[string]model.AuthorizationCode
+	consents      map[consentKey]model.Consent
+	oneTimeTokens map[string]model.OneTimeToken
+	totps         map[xid.ID]model.TOTP
+	credentials   map[string]model.WebAuthnCredential
+	attempts      map[string]model.LoginAttempts
+}
+
+type consentKey struct {
+	userId   xid.ID
+	clientId string
 }
 
 var logger = log.New(os.Stdout, "[store] ", log.LstdFlags)
 
 func (f *MemoryStorage) Store(u model.User) error {
 
-	logger.Println(f.Users)
+	if u.Credentials == nil {
+		return ErrNoCredentials
+	}
 
-	if _, ok := f.Users[u.Credentials.Email]; !ok {
-		f.Users[u.Credentials.Email] = u
-		return nil
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.users[u.Credentials.Email]; ok {
+		return ErrUserExists
 	}
 
-	return errors.New("Usetr Allready exist")
+	f.users[u.Credentials.Email] = copyUser(u)
+	f.ids[u.Id] = u.Credentials.Email
+
+	return nil
+}
+
+func (f *MemoryStorage) UpdateUser(u model.User) error {
+
+	if u.Credentials == nil {
+		return ErrNoCredentials
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	old, ok := f.users[u.Credentials.Email]
+
+	if !ok {
+		return ErrUserNotFound
+	}
+
+	delete(f.ids, old.Id)
+	f.users[u.Credentials.Email] = copyUser(u)
+	f.ids[u.Id] = u.Credentials.Email
+
+	return nil
 }
 
 func (f *MemoryStorage) GetUserByLogin(login string) (*model.User, error) {
 
-	logger.Println(f.Users)
+	f.mu.RLock()
+	defer f.mu.RUnlock()
 
-	if u, ok := f.Users[login]; ok {
+	if u, ok := f.users[login]; ok {
+		u = copyUser(u)
 		return &u, nil
 	}
 
-	return nil, errors.New("No user found")
+	return nil, ErrUserNotFound
 }
 
 func (f *MemoryStorage) GetUserById(id string) (*model.User, error) {
 
-	logger.Println(f.Users)
-
 	idx, err := xid.FromString(id)
 
 	if err != nil {
-		return nil, err
+		return nil, errors.Wrap(ErrInvalidUserId, id)
 	}
 
-	for _, u := range f.Users {
-		if u.Id == idx {
-			return &u, nil
-		}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if login, ok := f.ids[idx]; ok {
+		u := copyUser(f.users[login])
+		return &u, nil
 	}
 
-	return nil, errors.New("No user found")
+	return nil, ErrUserNotFound
 }
 
 func (f *MemoryStorage) IsUserExistByLogin(login string) bool {
-	_, ok := f.Users[login]
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	_, ok := f.users[login]
 	return ok
 }
 
+// copyUser detaches stored user from caller's credentials, roles and attributes so they can't be changed bypassing the lock
+func copyUser(u model.User) model.User {
+	c := *u.Credentials
+	u.Credentials = &c
+	u.Roles = append([]string(nil), u.Roles...)
+	u.Groups = append([]string(nil), u.Groups...)
+
+	if u.Attributes != nil {
+		attributes := make(map[string]string, len(u.Attributes))
+		for k, v := range u.Attributes {
+			attributes[k] = v
+		}
+		u.Attributes = attributes
+	}
+
+	return u
+}
+
 //SessionStore Implementation
 
-func (f *MemoryStorage) StoreToken(t string) error {
-	if _, exist := f.Sessions[t]; !exist {
-		f.Sessions[t] = struct{}{}
+func (f *MemoryStorage) StoreToken(t, owner string) error {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.storeToken(t, owner)
+}
+
+func (f *MemoryStorage) storeToken(t, owner string) error {
+	if _, exist := f.sessions[t]; !exist {
+		f.sessions[t] = owner
 		return nil
 	}
-	return errors.New("Sugested Token allready stored in session")
+	return ErrTokenExists
 }
 
-func (f *MemoryStorage) UpdateToken(old, new string) error {
-	if _, exist := f.Sessions[old]; exist {
-		delete(f.Sessions, old)
-		f.Sessions[new] = struct{}{}
+func (f *MemoryStorage) UpdateToken(old, new, owner string) error {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, exist := f.sessions[old]; exist {
+		delete(f.sessions, old)
+		f.sessions[new] = owner
 		return nil
 	}
-	return f.StoreToken(new)
+	return f.storeToken(new, owner)
 }
 
 func (f *MemoryStorage) DeleteToken(t string) error {
-	if _, exist := f.Sessions[t]; exist {
-		delete(f.Sessions, t)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, exist := f.sessions[t]; exist {
+		delete(f.sessions, t)
 		return nil
 	}
-	return errors.New("Token has not been found for deleting")
+	return ErrTokenNotFound
+}
+
+func (f *MemoryStorage) DeleteOwnerTokens(owner string) error {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for t, o := range f.sessions {
+		if o == owner {
+			delete(f.sessions, t)
+		}
+	}
+	return nil
 }
 
 func (f *MemoryStorage) IsTokenPresent(t string) bool {
-	_, ok := f.Sessions[t]
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	_, ok := f.sessions[t]
 	return ok
 }
 
+//RefreshTokenStore Implementation
+
+func (f *MemoryStorage) StoreRefreshToken(t model.RefreshToken) error {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, exist := f.refreshTokens[t.Id]; !exist {
+		f.refreshTokens[t.Id] = t
+		return nil
+	}
+	return ErrTokenExists
+}
+
+func (f *MemoryStorage) UseRefreshToken(id string) (*model.RefreshToken, error) {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	t, exist := f.refreshTokens[id]
+	if !exist {
+		return nil, ErrTokenNotFound
+	}
+
+	used := t
+	used.Used = true
+	f.refreshTokens[id] = used
+
+	return &t, nil
+}
+
+func (f *MemoryStorage) RevokeTokenFamily(family string) ([]model.RefreshToken, error) {
+	return f.revokeRefreshTokens(func(t model.RefreshToken) bool { return t.Family == family }), nil
+}
+
+func (f *MemoryStorage) RevokeAccessTokenFamily(accessToken string) ([]model.RefreshToken, error) {
+
+	f.mu.Lock()
+	families := map[string]bool{}
+	for _, t := range f.refreshTokens {
+		if t.AccessToken == accessToken {
+			families[t.Family] = true
+		}
+	}
+	f.mu.Unlock()
+
+	return f.revokeRefreshTokens(func(t model.RefreshToken) bool { return families[t.Family] }), nil
+}
+
+func (f *MemoryStorage) RevokeUserTokens(userId xid.ID) ([]model.RefreshToken, error) {
+	return f.revokeRefreshTokens(func(t model.RefreshToken) bool { return t.UserId == userId }), nil
+}
+
+func (f *MemoryStorage) revokeRefreshTokens(match func(t model.RefreshToken) bool) []model.RefreshToken {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	revoked := []model.RefreshToken{}
+	for id, t := range f.refreshTokens {
+		if match(t) {
+			revoked = append(revoked, t)
+			delete(f.refreshTokens, id)
+		}
+	}
+	return revoked
+}
+
+//ClientStore Implementation
+
+func (f *MemoryStorage) SaveClient(c model.Client) error {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	c.RedirectURIs = append([]string(nil), c.RedirectURIs...)
+	c.Scopes = append([]string(nil), c.Scopes...)
+	f.clients[c.Id] = c
+	return nil
+}
+
+func (f *MemoryStorage) GetClient(id string) (*model.Client, error) {
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if c, ok := f.clients[id]; ok {
+		c.RedirectURIs = append([]string(nil), c.RedirectURIs...)
+		c.Scopes = append([]string(nil), c.Scopes...)
+		return &c, nil
+	}
+	return nil, ErrClientNotFound
+}
+
+//AuthorizationCodeStore Implementation
+
+func (f *MemoryStorage) StoreAuthorizationCode(c model.AuthorizationCode) error {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, exist := f.codes[c.Code]; !exist {
+		f.codes[c.Code] = c
+		return nil
+	}
+	return ErrCodeExists
+}
+
+func (f *MemoryStorage) UseAuthorizationCode(code string) (*model.AuthorizationCode, error) {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	c, exist := f.codes[code]
+	if !exist {
+		return nil, ErrCodeNotFound
+	}
+
+	used := c
+	used.Used = true
+	f.codes[code] = used
+
+	return &c, nil
+}
+
+func (f *MemoryStorage) SetAuthorizationCodeToken(code, token string) error {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	c, exist := f.codes[code]
+	if !exist {
+		return ErrCodeNotFound
+	}
+
+	c.AccessToken = token
+	f.codes[code] = c
+
+	return nil
+}
+
+//ConsentStore Implementation
+
+func (f *MemoryStorage) SaveConsent(c model.Consent) error {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	c.Scopes = append([]string(nil), c.Scopes...)
+	f.consents[consentKey{c.UserId, c.ClientId}] = c
+	return nil
+}
+
+func (f *MemoryStorage) GetConsent(userId xid.ID, clientId string) (*model.Consent, error) {
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if c, ok := f.consents[consentKey{userId, clientId}]; ok {
+		c.Scopes = append([]string(nil), c.Scopes...)
+		return &c, nil
+	}
+	return nil, ErrNoConsent
+}
+
+//OneTimeTokenStore Implementation
+
+func (f *MemoryStorage) StoreOneTimeToken(t model.OneTimeToken) error {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, exist := f.oneTimeTokens[t.Id]; !exist {
+		f.oneTimeTokens[t.Id] = t
+		return nil
+	}
+	return ErrOneTimeTokenExists
+}
+
+func (f *MemoryStorage) UseOneTimeToken(id, purpose string) (*model.OneTimeToken, error) {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	t, exist := f.oneTimeTokens[id]
+	if !exist || t.Purpose != purpose {
+		return nil, ErrOneTimeTokenNotFound
+	}
+
+	used := t
+	used.Used = true
+	f.oneTimeTokens[id] = used
+
+	return &t, nil
+}
+
+//TOTPStore Implementation
+
+func (f *MemoryStorage) SaveTOTP(t model.TOTP) error {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	t.RecoveryCodes = append([]string(nil), t.RecoveryCodes...)
+	f.totps[t.UserId] = t
+	return nil
+}
+
+func (f *MemoryStorage) GetTOTP(userId xid.ID) (*model.TOTP, error) {
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if t, ok := f.totps[userId]; ok {
+		t.RecoveryCodes = append([]string(nil), t.RecoveryCodes...)
+		return &t, nil
+	}
+	return nil, ErrTOTPNotFound
+}
+
+func (f *MemoryStorage) DeleteTOTP(userId xid.ID) error {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	delete(f.totps, userId)
+	return nil
+}
+
+func (f *MemoryStorage) UseTOTPStep(userId xid.ID, step int64) error {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	t, ok := f.totps[userId]
+	if !ok {
+		return ErrTOTPNotFound
+	}
+
+	if step <= t.LastStep {
+		return ErrTOTPStepUsed
+	}
+
+	t.LastStep = step
+	f.totps[userId] = t
+	return nil
+}
+
+func (f *MemoryStorage) UseRecoveryCode(userId xid.ID, hash string) error {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	t, ok := f.totps[userId]
+	if !ok {
+		return ErrRecoveryCodeNotFound
+	}
+
+	for i, c := range t.RecoveryCodes {
+		if c == hash {
+			t.RecoveryCodes = append(append([]string(nil), t.RecoveryCodes[:i]...), t.RecoveryCodes[i+1:]...)
+			f.totps[userId] = t
+			return nil
+		}
+	}
+	return ErrRecoveryCodeNotFound
+}
+
+//WebAuthnStore Implementation
+
+func (f *MemoryStorage) SaveWebAuthnCredential(c model.WebAuthnCredential) error {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, exist := f.credentials[c.Id]; !exist {
+		c.PublicKey = append([]byte(nil), c.PublicKey...)
+		f.credentials[c.Id] = c
+		return nil
+	}
+	return ErrCredentialExists
+}
+
+func (f *MemoryStorage) GetWebAuthnCredential(id string) (*model.WebAuthnCredential, error) {
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if c, ok := f.credentials[id]; ok {
+		c.PublicKey = append([]byte(nil), c.PublicKey...)
+		return &c, nil
+	}
+	return nil, ErrCredentialNotFound
+}
+
+func (f *MemoryStorage) GetUserWebAuthnCredentials(userId xid.ID) ([]model.WebAuthnCredential, error) {
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	credentials := []model.WebAuthnCredential{}
+	for _, c := range f.credentials {
+		if c.UserId == userId {
+			c.PublicKey = append([]byte(nil), c.PublicKey...)
+			credentials = append(credentials, c)
+		}
+	}
+
+	sort.Slice(credentials, func(i, j int) bool {
+		if !credentials[i].CreatedAt.Equal(credentials[j].CreatedAt) {
+			return credentials[i].CreatedAt.Before(credentials[j].CreatedAt)
+		}
+		return credentials[i].Id < credentials[j].Id
+	})
+
+	return credentials, nil
+}
+
+func (f *MemoryStorage) UpdateSignCount(id string, count uint32) error {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	c, ok := f.credentials[id]
+	if !ok {
+		return ErrCredentialNotFound
+	}
+
+	if count <= c.SignCount && (count != 0 || c.SignCount != 0) {
+		return ErrSignCountNotIncreased
+	}
+
+	c.SignCount = count
+	f.credentials[id] = c
+	return nil
+}
+
+//LoginAttemptStore Implementation
+
+func (f *MemoryStorage) AddLoginAttempt(key string, at time.Time, window time.Duration) (*model.LoginAttempts, error) {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	a, ok := f.attempts[key]
+	if !ok || !at.Before(a.Start.Add(window)) {
+		a = model.LoginAttempts{Key: key, Start: at}
+	}
+
+	a.Count++
+	a.Last = at
+	f.attempts[key] = a
+
+	return &a, nil
+}
+
+func (f *MemoryStorage) GetLoginAttempts(key string) (*model.LoginAttempts, error) {
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if a, ok := f.attempts[key]; ok {
+		return &a, nil
+	}
+	return nil, ErrLoginAttemptsNotFound
+}
+
+func (f *MemoryStorage) ResetLoginAttempts(key string) error {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	delete(f.attempts, key)
+	return nil
+}
+
+func (f *MemoryStorage) PruneLoginAttempts(before time.Time) error {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for key, a := range f.attempts {
+		if a.Last.Before(before) {
+			delete(f.attempts, key)
+		}
+	}
+	return nil
+}
+
 func NewMemoryStore() Store {
 	return &MemoryStorage{
-		Users:    make(map[string]model.User),
-		Sessions: make(map[string]struct{}),
+		users:         make(map[string]model.User),
+		ids:           make(map[xid.ID]string),
+		sessions:      make(map[string]string),
+		refreshTokens: make(map[string]model.RefreshToken),
+		clients:       make(map[string]model.Client),
+		codes:         make(map[string]model.AuthorizationCode),
+		consents:      make(map[consentKey]model.Consent),
+		oneTimeTokens: make(map[string]model.OneTimeToken),
+		totps:         make(map[xid.ID]model.TOTP),
+		credentials:   make(map[string]model.WebAuthnCredential),
+		attempts:      make(map[string]model.LoginAttempts),
 	}
 }
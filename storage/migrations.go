@@ -0,0 +1,222 @@
+package storage
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+type migration struct {
+	version    int
+	statements []string
+}
+
+// migrations are applied in order and never changed once released, new schema changes go to a new migration
+var migrations = []migration{
+	{
+		version: 1,
+		statements: []string{
+			`CREATE TABLE users (
+				id       TEXT PRIMARY KEY,
+				email    TEXT NOT NULL UNIQUE,
+				password TEXT NOT NULL,
+				active   INTEGER NOT NULL,
+				banned   INTEGER NOT NULL
+			)`,
+			`CREATE TABLE sessions (
+				token TEXT PRIMARY KEY
+			)`,
+			`CREATE TABLE refresh_tokens (
+				id           TEXT PRIMARY KEY,
+				family       TEXT NOT NULL,
+				user_id      TEXT NOT NULL,
+				access_token TEXT NOT NULL,
+				expires_at   INTEGER NOT NULL,
+				used         INTEGER NOT NULL
+			)`,
+			`CREATE INDEX refresh_tokens_family ON refresh_tokens (family)`,
+		},
+	},
+	{
+		version: 2,
+		statements: []string{
+			`ALTER TABLE users ADD COLUMN roles TEXT NOT NULL DEFAULT '[]'`,
+			`ALTER TABLE users ADD COLUMN user_groups TEXT NOT NULL DEFAULT '[]'`,
+			`ALTER TABLE users ADD COLUMN attributes TEXT NOT NULL DEFAULT '{}'`,
+		},
+	},
+	{
+		version: 3,
+		statements: []string{
+			`CREATE TABLE clients (
+				id          TEXT PRIMARY KEY,
+				secret_hash TEXT NOT NULL,
+				name        TEXT NOT NULL
+			)`,
+		},
+	},
+	{
+		version: 4,
+		statements: []string{
+			`ALTER TABLE clients ADD COLUMN redirect_uris TEXT NOT NULL DEFAULT '[]'`,
+			`ALTER TABLE clients ADD COLUMN public INTEGER NOT NULL DEFAULT 0`,
+			`CREATE TABLE authorization_codes (
+				code           TEXT PRIMARY KEY,
+				client_id      TEXT NOT NULL,
+				user_id        TEXT NOT NULL,
+				redirect_uri   TEXT NOT NULL,
+				scope          TEXT NOT NULL,
+				code_challenge TEXT NOT NULL,
+				expires_at     INTEGER NOT NULL,
+				used           INTEGER NOT NULL,
+				access_token   TEXT NOT NULL DEFAULT ''
+			)`,
+			`CREATE TABLE consents (
+				user_id    TEXT NOT NULL,
+				client_id  TEXT NOT NULL,
+				scopes     TEXT NOT NULL,
+				granted_at INTEGER NOT NULL,
+				PRIMARY KEY (user_id, client_id)
+			)`,
+		},
+	},
+	{
+		version: 5,
+		statements: []string{
+			`ALTER TABLE authorization_codes ADD COLUMN nonce TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE authorization_codes ADD COLUMN auth_time INTEGER NOT NULL DEFAULT 0`,
+		},
+	},
+	{
+		version: 6,
+		statements: []string{
+			`ALTER TABLE clients ADD COLUMN scopes TEXT NOT NULL DEFAULT 'null'`,
+		},
+	},
+	{
+		version: 7,
+		statements: []string{
+			// users registered before email verification was introduced keep signing in, pending verification
+			// is told by email_verified alone and active stays the admin switch
+			`ALTER TABLE users ADD COLUMN email_verified INTEGER NOT NULL DEFAULT 1`,
+			`CREATE TABLE one_time_tokens (
+				id         TEXT PRIMARY KEY,
+				purpose    TEXT NOT NULL,
+				user_id    TEXT NOT NULL,
+				binding    TEXT NOT NULL,
+				expires_at INTEGER NOT NULL,
+				used       INTEGER NOT NULL
+			)`,
+		},
+	},
+	{
+		version: 8,
+		statements: []string{
+			// sessions stored before owners were introduced can't be revoked per user, they expire on their own
+			`ALTER TABLE sessions ADD COLUMN owner TEXT NOT NULL DEFAULT ''`,
+			`CREATE INDEX sessions_owner ON sessions (owner)`,
+			`CREATE INDEX refresh_tokens_user ON refresh_tokens (user_id)`,
+		},
+	},
+	{
+		version: 9,
+		statements: []string{
+			`CREATE TABLE totp (
+				user_id   TEXT PRIMARY KEY,
+				secret    TEXT NOT NULL,
+				confirmed INTEGER NOT NULL,
+				last_step INTEGER NOT NULL
+			)`,
+			`CREATE TABLE recovery_codes (
+				user_id TEXT NOT NULL,
+				hash    TEXT NOT NULL,
+				PRIMARY KEY (user_id, hash)
+			)`,
+		},
+	},
+	{
+		version: 10,
+		statements: []string{
+			`CREATE TABLE webauthn_credentials (
+				id         TEXT PRIMARY KEY,
+				user_id    TEXT NOT NULL,
+				public_key BLOB NOT NULL,
+				sign_count INTEGER NOT NULL,
+				created_at INTEGER NOT NULL
+			)`,
+			`CREATE INDEX webauthn_credentials_user ON webauthn_credentials (user_id)`,
+		},
+	},
+	{
+		version: 11,
+		statements: []string{
+			`CREATE TABLE login_attempts (
+				key      TEXT PRIMARY KEY,
+				count    INTEGER NOT NULL,
+				start_at INTEGER NOT NULL,
+				last_at  INTEGER NOT NULL
+			)`,
+			`CREATE INDEX login_attempts_last ON login_attempts (last_at)`,
+		},
+	},
+	{
+		version: 12,
+		statements: []string{
+			`CREATE INDEX refresh_tokens_access_token ON refresh_tokens (access_token)`,
+		},
+	},
+}
+
+// migrate brings database schema to the latest version, every migration is applied in its own transaction
+func migrate(db *sql.DB) error {
+
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY)`)
+
+	if err != nil {
+		return errors.Wrap(err, "Can't create schema_migrations table")
+	}
+
+	var current int
+	err = db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current)
+
+	if err != nil {
+		return errors.Wrap(err, "Can't read schema version")
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+
+		if err := apply(db, m); err != nil {
+			return errors.Wrapf(err, "Migration %d failed", m.version)
+		}
+
+		logger.Printf("Schema migrated to version %d", m.version)
+	}
+
+	return nil
+}
+
+func apply(db *sql.DB, m migration) error {
+
+	tx, err := db.Begin()
+
+	if err != nil {
+		return err
+	}
+
+	for _, s := range m.statements {
+		if _, err := tx.Exec(s); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if _, err := tx.Exec(`INSERT INTO schema_migrations (version) VALUES (?)`, m.version); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
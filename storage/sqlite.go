@@ -0,0 +1,835 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/xid"
+
+	"authService/model"
+
+	_ "modernc.org/sqlite"
+)
+
+// SQLStorage keeps users and sessions in embedded SQLite database
+type SQLStorage struct {
+	db *sql.DB
+}
+
+func NewSQLiteStore(path string) (*SQLStorage, error) {
+
+	db, err := sql.Open("sqlite", path)
+
+	if err != nil {
+		return nil, errors.Wrap(err, "Can't open database")
+	}
+
+	// SQLite allows single writer only, so all access goes through one connection
+	// which also keeps ":memory:" databases alive between calls
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(`PRAGMA busy_timeout = 5000`); err != nil {
+		db.Close()
+		return nil, errors.Wrap(err, "Can't configure database")
+	}
+
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &SQLStorage{db: db}, nil
+}
+
+func (s *SQLStorage) Close() error {
+	return s.db.Close()
+}
+
+func (s *SQLStorage) Ping() error {
+	return s.db.Ping()
+}
+
+func (s *SQLStorage) Store(u model.User) error {
+
+	if u.Credentials == nil {
+		return ErrNoCredentials
+	}
+
+	roles, groups, attributes := encodeUserClaims(u)
+
+	_, err := s.db.Exec(`INSERT INTO users (id, email, password, active, banned, email_verified, roles, user_groups, attributes) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		u.Id.String(), u.Credentials.Email, u.Credentials.Password, u.Active, u.Banned, u.EmailVerified, roles, groups, attributes)
+
+	if err != nil && s.IsUserExistByLogin(u.Credentials.Email) {
+		return ErrUserExists
+	}
+
+	if err != nil {
+		return errors.Wrap(err, "Can't store user")
+	}
+
+	return nil
+}
+
+func (s *SQLStorage) UpdateUser(u model.User) error {
+
+	if u.Credentials == nil {
+		return ErrNoCredentials
+	}
+
+	roles, groups, attributes := encodeUserClaims(u)
+
+	res, err := s.db.Exec(`UPDATE users SET id = ?, password = ?, active = ?, banned = ?, email_verified = ?, roles = ?, user_groups = ?, attributes = ? WHERE email = ?`,
+		u.Id.String(), u.Credentials.Password, u.Active, u.Banned, u.EmailVerified, roles, groups, attributes, u.Credentials.Email)
+
+	if err != nil {
+		return errors.Wrap(err, "Can't update user")
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+func (s *SQLStorage) GetUserByLogin(login string) (*model.User, error) {
+	return s.queryUser(`SELECT `+userColumns+` FROM users WHERE email = ?`, login)
+}
+
+func (s *SQLStorage) GetUserById(id string) (*model.User, error) {
+
+	idx, err := xid.FromString(id)
+
+	if err != nil {
+		return nil, errors.Wrap(ErrInvalidUserId, id)
+	}
+
+	return s.queryUser(`SELECT `+userColumns+` FROM users WHERE id = ?`, idx.String())
+}
+
+func (s *SQLStorage) IsUserExistByLogin(login string) bool {
+	return s.exists(`SELECT COUNT(*) FROM users WHERE email = ?`, login)
+}
+
+func (s *SQLStorage) exists(query string, arg interface{}) bool {
+
+	var n int
+	err := s.db.QueryRow(query, arg).Scan(&n)
+
+	return err == nil && n > 0
+}
+
+const userColumns = `id, email, password, active, banned, email_verified, roles, user_groups, attributes`
+
+func (s *SQLStorage) queryUser(query string, arg interface{}) (*model.User, error) {
+
+	var id, roles, groups, attributes string
+	u := &model.User{Credentials: &model.Credentilas{}}
+
+	err := s.db.QueryRow(query, arg).Scan(&id, &u.Credentials.Email, &u.Credentials.Password, &u.Active, &u.Banned, &u.EmailVerified, &roles, &groups, &attributes)
+
+	if err == sql.ErrNoRows {
+		return nil, ErrUserNotFound
+	}
+
+	if err != nil {
+		return nil, errors.Wrap(err, "Can't read user")
+	}
+
+	if u.Id, err = xid.FromString(id); err != nil {
+		return nil, errors.Wrap(err, "Stored user id is malformed")
+	}
+
+	if err := decodeUserClaims(u, roles, groups, attributes); err != nil {
+		return nil, errors.Wrap(err, "Stored user roles are malformed")
+	}
+
+	return u, nil
+}
+
+// encodeUserClaims stores roles, groups and attributes as JSON columns
+func encodeUserClaims(u model.User) (string, string, string) {
+
+	roles, _ := json.Marshal(u.Roles)
+	groups, _ := json.Marshal(u.Groups)
+	attributes, _ := json.Marshal(u.Attributes)
+
+	return string(roles), string(groups), string(attributes)
+}
+
+func decodeUserClaims(u *model.User, roles, groups, attributes string) error {
+
+	if err := json.Unmarshal([]byte(roles), &u.Roles); err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal([]byte(groups), &u.Groups); err != nil {
+		return err
+	}
+
+	return json.Unmarshal([]byte(attributes), &u.Attributes)
+}
+
+//SessionStore Implementation
+
+func (s *SQLStorage) StoreToken(t, owner string) error {
+
+	_, err := s.db.Exec(`INSERT INTO sessions (token, owner) VALUES (?, ?)`, t, owner)
+
+	if err != nil && s.IsTokenPresent(t) {
+		return ErrTokenExists
+	}
+
+	if err != nil {
+		return errors.Wrap(err, "Can't store token")
+	}
+
+	return nil
+}
+
+func (s *SQLStorage) UpdateToken(old, new, owner string) error {
+
+	res, err := s.db.Exec(`UPDATE sessions SET token = ?, owner = ? WHERE token = ?`, new, owner, old)
+
+	if err != nil {
+		return errors.Wrap(err, "Can't update token")
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return s.StoreToken(new, owner)
+	}
+
+	return nil
+}
+
+func (s *SQLStorage) DeleteToken(t string) error {
+
+	res, err := s.db.Exec(`DELETE FROM sessions WHERE token = ?`, t)
+
+	if err != nil {
+		return errors.Wrap(err, "Can't delete token")
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrTokenNotFound
+	}
+
+	return nil
+}
+
+func (s *SQLStorage) DeleteOwnerTokens(owner string) error {
+
+	if _, err := s.db.Exec(`DELETE FROM sessions WHERE owner = ?`, owner); err != nil {
+		return errors.Wrap(err, "Can't delete tokens")
+	}
+
+	return nil
+}
+
+func (s *SQLStorage) IsTokenPresent(t string) bool {
+	return s.exists(`SELECT COUNT(*) FROM sessions WHERE token = ?`, t)
+}
+
+//RefreshTokenStore Implementation
+
+func (s *SQLStorage) StoreRefreshToken(t model.RefreshToken) error {
+
+	_, err := s.db.Exec(`INSERT INTO refresh_tokens (id, family, user_id, access_token, expires_at, used) VALUES (?, ?, ?, ?, ?, ?)`,
+		t.Id, t.Family, t.UserId.String(), t.AccessToken, t.ExpiresAt.UnixNano(), t.Used)
+
+	if err != nil && s.exists(`SELECT COUNT(*) FROM refresh_tokens WHERE id = ?`, t.Id) {
+		return ErrTokenExists
+	}
+
+	if err != nil {
+		return errors.Wrap(err, "Can't store refresh token")
+	}
+
+	return nil
+}
+
+func (s *SQLStorage) UseRefreshToken(id string) (*model.RefreshToken, error) {
+
+	// conditional update makes check and mark a single atomic step
+	res, err := s.db.Exec(`UPDATE refresh_tokens SET used = 1 WHERE id = ? AND used = 0`, id)
+
+	if err != nil {
+		return nil, errors.Wrap(err, "Can't use refresh token")
+	}
+
+	n, _ := res.RowsAffected()
+
+	rows, err := s.queryRefreshTokens(`SELECT id, family, user_id, access_token, expires_at, used FROM refresh_tokens WHERE id = ?`, id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rows) == 0 {
+		return nil, ErrTokenNotFound
+	}
+
+	t := rows[0]
+	t.Used = n == 0
+
+	return &t, nil
+}
+
+func (s *SQLStorage) RevokeTokenFamily(family string) ([]model.RefreshToken, error) {
+	return s.queryRefreshTokens(`DELETE FROM refresh_tokens WHERE family = ? RETURNING id, family, user_id, access_token, expires_at, used`, family)
+}
+
+func (s *SQLStorage) RevokeAccessTokenFamily(accessToken string) ([]model.RefreshToken, error) {
+	return s.queryRefreshTokens(`DELETE FROM refresh_tokens WHERE family IN (SELECT family FROM refresh_tokens WHERE access_token = ?)
+		RETURNING id, family, user_id, access_token, expires_at, used`, accessToken)
+}
+
+func (s *SQLStorage) RevokeUserTokens(userId xid.ID) ([]model.RefreshToken, error) {
+	return s.queryRefreshTokens(`DELETE FROM refresh_tokens WHERE user_id = ? RETURNING id, family, user_id, access_token, expires_at, used`, userId.String())
+}
+
+func (s *SQLStorage) queryRefreshTokens(query string, args ...interface{}) ([]model.RefreshToken, error) {
+
+	rows, err := s.db.Query(query, args...)
+
+	if err != nil {
+		return nil, errors.Wrap(err, "Can't read refresh tokens")
+	}
+	defer rows.Close()
+
+	tokens := []model.RefreshToken{}
+
+	for rows.Next() {
+		var userId string
+		var expiresAt int64
+		t := model.RefreshToken{}
+
+		if err := rows.Scan(&t.Id, &t.Family, &userId, &t.AccessToken, &expiresAt, &t.Used); err != nil {
+			return nil, errors.Wrap(err, "Can't read refresh token")
+		}
+
+		if t.UserId, err = xid.FromString(userId); err != nil {
+			return nil, errors.Wrap(err, "Stored user id is malformed")
+		}
+
+		t.ExpiresAt = time.Unix(0, expiresAt)
+		tokens = append(tokens, t)
+	}
+
+	return tokens, rows.Err()
+}
+
+//ClientStore Implementation
+
+func (s *SQLStorage) SaveClient(c model.Client) error {
+
+	redirectURIs, _ := json.Marshal(c.RedirectURIs)
+	scopes, _ := json.Marshal(c.Scopes)
+
+	_, err := s.db.Exec(`INSERT INTO clients (id, secret_hash, name, redirect_uris, public, scopes) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET secret_hash = excluded.secret_hash, name = excluded.name,
+		redirect_uris = excluded.redirect_uris, public = excluded.public, scopes = excluded.scopes`,
+		c.Id, c.SecretHash, c.Name, string(redirectURIs), c.Public, string(scopes))
+
+	if err != nil {
+		return errors.Wrap(err, "Can't store client")
+	}
+
+	return nil
+}
+
+func (s *SQLStorage) GetClient(id string) (*model.Client, error) {
+
+	var redirectURIs, scopes string
+	c := &model.Client{}
+
+	err := s.db.QueryRow(`SELECT id, secret_hash, name, redirect_uris, public, scopes FROM clients WHERE id = ?`, id).
+		Scan(&c.Id, &c.SecretHash, &c.Name, &redirectURIs, &c.Public, &scopes)
+
+	if err == sql.ErrNoRows {
+		return nil, ErrClientNotFound
+	}
+
+	if err != nil {
+		return nil, errors.Wrap(err, "Can't read client")
+	}
+
+	if err := json.Unmarshal([]byte(redirectURIs), &c.RedirectURIs); err != nil {
+		return nil, errors.Wrap(err, "Stored redirect URIs are malformed")
+	}
+
+	if err := json.Unmarshal([]byte(scopes), &c.Scopes); err != nil {
+		return nil, errors.Wrap(err, "Stored client scopes are malformed")
+	}
+
+	return c, nil
+}
+
+//AuthorizationCodeStore Implementation
+
+func (s *SQLStorage) StoreAuthorizationCode(c model.AuthorizationCode) error {
+
+	_, err := s.db.Exec(`INSERT INTO authorization_codes (code, client_id, user_id, redirect_uri, scope, code_challenge, nonce, auth_time, expires_at, used)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		c.Code, c.ClientId, c.UserId.String(), c.RedirectURI, c.Scope, c.CodeChallenge, c.Nonce, c.AuthTime.UnixNano(), c.ExpiresAt.UnixNano(), c.Used)
+
+	if err != nil && s.exists(`SELECT COUNT(*) FROM authorization_codes WHERE code = ?`, c.Code) {
+		return ErrCodeExists
+	}
+
+	if err != nil {
+		return errors.Wrap(err, "Can't store authorization code")
+	}
+
+	return nil
+}
+
+func (s *SQLStorage) UseAuthorizationCode(code string) (*model.AuthorizationCode, error) {
+
+	// conditional update makes check and mark a single atomic step
+	res, err := s.db.Exec(`UPDATE authorization_codes SET used = 1 WHERE code = ? AND used = 0`, code)
+
+	if err != nil {
+		return nil, errors.Wrap(err, "Can't use authorization code")
+	}
+
+	n, _ := res.RowsAffected()
+
+	var userId string
+	var authTime, expiresAt int64
+	c := &model.AuthorizationCode{}
+
+	err = s.db.QueryRow(`SELECT code, client_id, user_id, redirect_uri, scope, code_challenge, nonce, auth_time, expires_at, access_token
+		FROM authorization_codes WHERE code = ?`, code).
+		Scan(&c.Code, &c.ClientId, &userId, &c.RedirectURI, &c.Scope, &c.CodeChallenge, &c.Nonce, &authTime, &expiresAt, &c.AccessToken)
+
+	if err == sql.ErrNoRows {
+		return nil, ErrCodeNotFound
+	}
+
+	if err != nil {
+		return nil, errors.Wrap(err, "Can't read authorization code")
+	}
+
+	if c.UserId, err = xid.FromString(userId); err != nil {
+		return nil, errors.Wrap(err, "Stored user id is malformed")
+	}
+
+	c.AuthTime = time.Unix(0, authTime)
+	c.ExpiresAt = time.Unix(0, expiresAt)
+	c.Used = n == 0
+
+	return c, nil
+}
+
+func (s *SQLStorage) SetAuthorizationCodeToken(code, token string) error {
+
+	res, err := s.db.Exec(`UPDATE authorization_codes SET access_token = ? WHERE code = ?`, token, code)
+
+	if err != nil {
+		return errors.Wrap(err, "Can't set authorization code token")
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrCodeNotFound
+	}
+
+	return nil
+}
+
+//ConsentStore Implementation
+
+func (s *SQLStorage) SaveConsent(c model.Consent) error {
+
+	scopes, _ := json.Marshal(c.Scopes)
+
+	_, err := s.db.Exec(`INSERT INTO consents (user_id, client_id, scopes, granted_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (user_id, client_id) DO UPDATE SET scopes = excluded.scopes, granted_at = excluded.granted_at`,
+		c.UserId.String(), c.ClientId, string(scopes), c.GrantedAt.UnixNano())
+
+	if err != nil {
+		return errors.Wrap(err, "Can't store consent")
+	}
+
+	return nil
+}
+
+func (s *SQLStorage) GetConsent(userId xid.ID, clientId string) (*model.Consent, error) {
+
+	var scopes string
+	var grantedAt int64
+	c := &model.Consent{UserId: userId, ClientId: clientId}
+
+	err := s.db.QueryRow(`SELECT scopes, granted_at FROM consents WHERE user_id = ? AND client_id = ?`, userId.String(), clientId).
+		Scan(&scopes, &grantedAt)
+
+	if err == sql.ErrNoRows {
+		return nil, ErrNoConsent
+	}
+
+	if err != nil {
+		return nil, errors.Wrap(err, "Can't read consent")
+	}
+
+	if err := json.Unmarshal([]byte(scopes), &c.Scopes); err != nil {
+		return nil, errors.Wrap(err, "Stored consent scopes are malformed")
+	}
+
+	c.GrantedAt = time.Unix(0, grantedAt)
+
+	return c, nil
+}
+
+//OneTimeTokenStore Implementation
+
+func (s *SQLStorage) StoreOneTimeToken(t model.OneTimeToken) error {
+
+	_, err := s.db.Exec(`INSERT INTO one_time_tokens (id, purpose, user_id, binding, expires_at, used) VALUES (?, ?, ?, ?, ?, ?)`,
+		t.Id, t.Purpose, t.UserId.String(), t.Binding, t.ExpiresAt.UnixNano(), t.Used)
+
+	if err != nil && s.exists(`SELECT COUNT(*) FROM one_time_tokens WHERE id = ?`, t.Id) {
+		return ErrOneTimeTokenExists
+	}
+
+	if err != nil {
+		return errors.Wrap(err, "Can't store one-time token")
+	}
+
+	return nil
+}
+
+func (s *SQLStorage) UseOneTimeToken(id, purpose string) (*model.OneTimeToken, error) {
+
+	// conditional update makes check and mark a single atomic step
+	res, err := s.db.Exec(`UPDATE one_time_tokens SET used = 1 WHERE id = ? AND purpose = ? AND used = 0`, id, purpose)
+
+	if err != nil {
+		return nil, errors.Wrap(err, "Can't use one-time token")
+	}
+
+	n, _ := res.RowsAffected()
+
+	var userId string
+	var expiresAt int64
+	t := &model.OneTimeToken{}
+
+	err = s.db.QueryRow(`SELECT id, purpose, user_id, binding, expires_at FROM one_time_tokens WHERE id = ? AND purpose = ?`, id, purpose).
+		Scan(&t.Id, &t.Purpose, &userId, &t.Binding, &expiresAt)
+
+	if err == sql.ErrNoRows {
+		return nil, ErrOneTimeTokenNotFound
+	}
+
+	if err != nil {
+		return nil, errors.Wrap(err, "Can't read one-time token")
+	}
+
+	if t.UserId, err = xid.FromString(userId); err != nil {
+		return nil, errors.Wrap(err, "Stored user id is malformed")
+	}
+
+	t.ExpiresAt = time.Unix(0, expiresAt)
+	t.Used = n == 0
+
+	return t, nil
+}
+
+//TOTPStore Implementation
+
+func (s *SQLStorage) SaveTOTP(t model.TOTP) error {
+
+	tx, err := s.db.Begin()
+
+	if err != nil {
+		return errors.Wrap(err, "Can't store TOTP")
+	}
+
+	if err := saveTOTP(tx, t); err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "Can't store TOTP")
+	}
+
+	return errors.Wrap(tx.Commit(), "Can't store TOTP")
+}
+
+func saveTOTP(tx *sql.Tx, t model.TOTP) error {
+
+	userId := t.UserId.String()
+
+	_, err := tx.Exec(`INSERT INTO totp (user_id, secret, confirmed, last_step) VALUES (?, ?, ?, ?)
+		ON CONFLICT (user_id) DO UPDATE SET secret = excluded.secret, confirmed = excluded.confirmed, last_step = excluded.last_step`,
+		userId, t.Secret, t.Confirmed, t.LastStep)
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = ?`, userId); err != nil {
+		return err
+	}
+
+	for _, c := range t.RecoveryCodes {
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO recovery_codes (user_id, hash) VALUES (?, ?)`, userId, c); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *SQLStorage) GetTOTP(userId xid.ID) (*model.TOTP, error) {
+
+	t := &model.TOTP{UserId: userId}
+
+	err := s.db.QueryRow(`SELECT secret, confirmed, last_step FROM totp WHERE user_id = ?`, userId.String()).
+		Scan(&t.Secret, &t.Confirmed, &t.LastStep)
+
+	if err == sql.ErrNoRows {
+		return nil, ErrTOTPNotFound
+	}
+
+	if err != nil {
+		return nil, errors.Wrap(err, "Can't read TOTP")
+	}
+
+	rows, err := s.db.Query(`SELECT hash FROM recovery_codes WHERE user_id = ? ORDER BY rowid`, userId.String())
+
+	if err != nil {
+		return nil, errors.Wrap(err, "Can't read recovery codes")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return nil, errors.Wrap(err, "Can't read recovery codes")
+		}
+		t.RecoveryCodes = append(t.RecoveryCodes, hash)
+	}
+
+	return t, errors.Wrap(rows.Err(), "Can't read recovery codes")
+}
+
+func (s *SQLStorage) DeleteTOTP(userId xid.ID) error {
+
+	tx, err := s.db.Begin()
+
+	if err != nil {
+		return errors.Wrap(err, "Can't delete TOTP")
+	}
+
+	for _, query := range []string{`DELETE FROM totp WHERE user_id = ?`, `DELETE FROM recovery_codes WHERE user_id = ?`} {
+		if _, err := tx.Exec(query, userId.String()); err != nil {
+			tx.Rollback()
+			return errors.Wrap(err, "Can't delete TOTP")
+		}
+	}
+
+	return errors.Wrap(tx.Commit(), "Can't delete TOTP")
+}
+
+func (s *SQLStorage) UseTOTPStep(userId xid.ID, step int64) error {
+
+	// conditional update makes check and record a single atomic step
+	res, err := s.db.Exec(`UPDATE totp SET last_step = ? WHERE user_id = ? AND last_step < ?`, step, userId.String(), step)
+
+	if err != nil {
+		return errors.Wrap(err, "Can't use TOTP code")
+	}
+
+	if n, _ := res.RowsAffected(); n > 0 {
+		return nil
+	}
+
+	if s.exists(`SELECT COUNT(*) FROM totp WHERE user_id = ?`, userId.String()) {
+		return ErrTOTPStepUsed
+	}
+
+	return ErrTOTPNotFound
+}
+
+func (s *SQLStorage) UseRecoveryCode(userId xid.ID, hash string) error {
+
+	res, err := s.db.Exec(`DELETE FROM recovery_codes WHERE user_id = ? AND hash = ?`, userId.String(), hash)
+
+	if err != nil {
+		return errors.Wrap(err, "Can't use recovery code")
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrRecoveryCodeNotFound
+	}
+
+	return nil
+}
+
+//WebAuthnStore Implementation
+
+func (s *SQLStorage) SaveWebAuthnCredential(c model.WebAuthnCredential) error {
+
+	_, err := s.db.Exec(`INSERT INTO webauthn_credentials (id, user_id, public_key, sign_count, created_at) VALUES (?, ?, ?, ?, ?)`,
+		c.Id, c.UserId.String(), c.PublicKey, c.SignCount, c.CreatedAt.UnixNano())
+
+	if err != nil && s.exists(`SELECT COUNT(*) FROM webauthn_credentials WHERE id = ?`, c.Id) {
+		return ErrCredentialExists
+	}
+
+	if err != nil {
+		return errors.Wrap(err, "Can't store WebAuthn credential")
+	}
+
+	return nil
+}
+
+const credentialColumns = `id, user_id, public_key, sign_count, created_at`
+
+func scanCredential(row interface{ Scan(...interface{}) error }) (*model.WebAuthnCredential, error) {
+
+	var userId string
+	var createdAt int64
+	c := &model.WebAuthnCredential{}
+
+	if err := row.Scan(&c.Id, &userId, &c.PublicKey, &c.SignCount, &createdAt); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if c.UserId, err = xid.FromString(userId); err != nil {
+		return nil, errors.Wrap(err, "Stored user id is malformed")
+	}
+
+	c.CreatedAt = time.Unix(0, createdAt)
+
+	return c, nil
+}
+
+func (s *SQLStorage) GetWebAuthnCredential(id string) (*model.WebAuthnCredential, error) {
+
+	c, err := scanCredential(s.db.QueryRow(`SELECT `+credentialColumns+` FROM webauthn_credentials WHERE id = ?`, id))
+
+	if err == sql.ErrNoRows {
+		return nil, ErrCredentialNotFound
+	}
+
+	if err != nil {
+		return nil, errors.Wrap(err, "Can't read WebAuthn credential")
+	}
+
+	return c, nil
+}
+
+func (s *SQLStorage) GetUserWebAuthnCredentials(userId xid.ID) ([]model.WebAuthnCredential, error) {
+
+	rows, err := s.db.Query(`SELECT `+credentialColumns+` FROM webauthn_credentials WHERE user_id = ? ORDER BY created_at, id`, userId.String())
+
+	if err != nil {
+		return nil, errors.Wrap(err, "Can't read WebAuthn credentials")
+	}
+	defer rows.Close()
+
+	credentials := []model.WebAuthnCredential{}
+
+	for rows.Next() {
+		c, err := scanCredential(rows)
+		if err != nil {
+			return nil, errors.Wrap(err, "Can't read WebAuthn credentials")
+		}
+		credentials = append(credentials, *c)
+	}
+
+	return credentials, errors.Wrap(rows.Err(), "Can't read WebAuthn credentials")
+}
+
+func (s *SQLStorage) UpdateSignCount(id string, count uint32) error {
+
+	// conditional update makes check and store a single atomic step
+	res, err := s.db.Exec(`UPDATE webauthn_credentials SET sign_count = ?
+		WHERE id = ? AND (sign_count < ? OR (sign_count = 0 AND ? = 0))`, count, id, count, count)
+
+	if err != nil {
+		return errors.Wrap(err, "Can't update WebAuthn signature counter")
+	}
+
+	if n, _ := res.RowsAffected(); n > 0 {
+		return nil
+	}
+
+	if s.exists(`SELECT COUNT(*) FROM webauthn_credentials WHERE id = ?`, id) {
+		return ErrSignCountNotIncreased
+	}
+
+	return ErrCredentialNotFound
+}
+
+//LoginAttemptStore Implementation
+
+func (s *SQLStorage) AddLoginAttempt(key string, at time.Time, window time.Duration) (*model.LoginAttempts, error) {
+
+	// upsert makes restart or increment a single atomic step, so replicas sharing the database don't lose attempts
+	row := s.db.QueryRow(`INSERT INTO login_attempts (key, count, start_at, last_at) VALUES (?, 1, ?, ?)
+		ON CONFLICT (key) DO UPDATE SET
+			count    = CASE WHEN start_at <= ? THEN 1 ELSE count + 1 END,
+			start_at = CASE WHEN start_at <= ? THEN excluded.start_at ELSE start_at END,
+			last_at  = excluded.last_at
+		RETURNING count, start_at, last_at`,
+		key, at.UnixNano(), at.UnixNano(), at.Add(-window).UnixNano(), at.Add(-window).UnixNano())
+
+	a, err := scanLoginAttempts(key, row)
+
+	if err != nil {
+		return nil, errors.Wrap(err, "Can't count login attempt")
+	}
+
+	return a, nil
+}
+
+func (s *SQLStorage) GetLoginAttempts(key string) (*model.LoginAttempts, error) {
+
+	a, err := scanLoginAttempts(key, s.db.QueryRow(`SELECT count, start_at, last_at FROM login_attempts WHERE key = ?`, key))
+
+	if err == sql.ErrNoRows {
+		return nil, ErrLoginAttemptsNotFound
+	}
+
+	if err != nil {
+		return nil, errors.Wrap(err, "Can't read login attempts")
+	}
+
+	return a, nil
+}
+
+func (s *SQLStorage) ResetLoginAttempts(key string) error {
+
+	if _, err := s.db.Exec(`DELETE FROM login_attempts WHERE key = ?`, key); err != nil {
+		return errors.Wrap(err, "Can't reset login attempts")
+	}
+
+	return nil
+}
+
+func (s *SQLStorage) PruneLoginAttempts(before time.Time) error {
+
+	if _, err := s.db.Exec(`DELETE FROM login_attempts WHERE last_at < ?`, before.UnixNano()); err != nil {
+		return errors.Wrap(err, "Can't prune login attempts")
+	}
+
+	return nil
+}
+
+func scanLoginAttempts(key string, row *sql.Row) (*model.LoginAttempts, error) {
+
+	var start, last int64
+	a := &model.LoginAttempts{Key: key}
+
+	if err := row.Scan(&a.Count, &start, &last); err != nil {
+		return nil, err
+	}
+
+	a.Start = time.Unix(0, start)
+	a.Last = time.Unix(0, last)
+
+	return a, nil
+}
@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+
+	"authService/model"
+)
+
+func TestSQLiteStoreKeepsDataBetweenRestarts(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "auth.db")
+
+	s, err := NewSQLiteStore(path)
+	if err != nil {
+		t.Fatalf("SQLite store should be created but got error: %v", err)
+	}
+
+	u := model.NewUser(model.Credentilas{Email: "test@gmail.com", Password: "hash"})
+	s.Store(u)
+	s.StoreToken("token", u.Id.String())
+	s.Close()
+
+	s, err = NewSQLiteStore(path)
+	if err != nil {
+		t.Fatalf("SQLite store should be reopened but got error: %v", err)
+	}
+	defer s.Close()
+
+	if stored, err := s.GetUserById(u.Id.String()); err != nil || stored.Credentials.Email != "test@gmail.com" {
+		t.Errorf("User should survive restart but got [%v, %v]", stored, err)
+	}
+
+	if !s.IsTokenPresent("token") {
+		t.Errorf("Session should survive restart")
+	}
+}
+
+func TestMigrate(t *testing.T) {
+
+	s, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("SQLite store should be created but got error: %v", err)
+	}
+	defer s.Close()
+
+	if err := migrate(s.db); err != nil {
+		t.Errorf("Repeated migration should be a no-op but got error: %v", err)
+	}
+
+	var version int
+	s.db.QueryRow(`SELECT MAX(version) FROM schema_migrations`).Scan(&version)
+
+	if latest := migrations[len(migrations)-1].version; version != latest {
+		t.Errorf("Expected schema version [%v] but was: [%v]", latest, version)
+	}
+}
@@ -1,24 +1,159 @@
 package storage
 
 import (
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/xid"
+
+	"authService/config"
 	"authService/model"
 )
 
+var (
+	ErrUserExists     = errors.New("User already exist")
+	ErrUserNotFound   = errors.New("No user found")
+	ErrInvalidUserId  = errors.New("Malformed user id")
+	ErrNoCredentials  = errors.New("User has no credentials")
+	ErrTokenExists    = errors.New("Token already stored")
+	ErrTokenNotFound  = errors.New("Token has not been found")
+	ErrClientNotFound = errors.New("No client found")
+	ErrCodeExists     = errors.New("Authorization code already stored")
+	ErrCodeNotFound   = errors.New("Authorization code has not been found")
+	ErrNoConsent      = errors.New("No consent has been given")
+
+	ErrOneTimeTokenExists   = errors.New("One-time token already stored")
+	ErrOneTimeTokenNotFound = errors.New("One-time token has not been found")
+
+	ErrTOTPNotFound         = errors.New("No TOTP has been set up")
+	ErrTOTPStepUsed         = errors.New("TOTP code has been used already")
+	ErrRecoveryCodeNotFound = errors.New("Recovery code has not been found")
+
+	ErrCredentialExists      = errors.New("WebAuthn credential already registered")
+	ErrCredentialNotFound    = errors.New("WebAuthn credential has not been found")
+	ErrSignCountNotIncreased = errors.New("WebAuthn signature counter has not increased")
+
+	ErrLoginAttemptsNotFound = errors.New("No login attempts have been counted")
+)
+
 type Store interface {
 	UserStore
 	SessionStorage
+	RefreshTokenStore
+	ClientStore
+	AuthorizationCodeStore
+	ConsentStore
+	OneTimeTokenStore
+	TOTPStore
+	WebAuthnStore
+	LoginAttemptStore
 }
 
 type UserStore interface {
 	Store(u model.User) error
+	UpdateUser(u model.User) error
 	GetUserByLogin(login string) (*model.User, error)
 	GetUserById(id string) (*model.User, error)
 	IsUserExistByLogin(login string) bool
 }
 
+// SessionStorage keeps tokens of logged in users and clients, owner is the user or client id a token is issued to
 type SessionStorage interface {
-	StoreToken(t string) error
-	UpdateToken(old, new string) error
+	StoreToken(t, owner string) error
+	UpdateToken(old, new, owner string) error
 	IsTokenPresent(t string) bool
 	DeleteToken(t string) error
+	// DeleteOwnerTokens logs the owner out everywhere
+	DeleteOwnerTokens(owner string) error
+}
+
+type RefreshTokenStore interface {
+	StoreRefreshToken(t model.RefreshToken) error
+	// UseRefreshToken marks token as used and returns its state before the call,
+	// so a token which was already used can be detected
+	UseRefreshToken(id string) (*model.RefreshToken, error)
+	// RevokeTokenFamily deletes every token of the family and returns deleted tokens
+	RevokeTokenFamily(family string) ([]model.RefreshToken, error)
+	// RevokeAccessTokenFamily deletes every token of the family the access token was issued with
+	// and returns deleted tokens
+	RevokeAccessTokenFamily(accessToken string) ([]model.RefreshToken, error)
+	// RevokeUserTokens deletes every token of the user and returns deleted tokens
+	RevokeUserTokens(userId xid.ID) ([]model.RefreshToken, error)
+}
+
+type ClientStore interface {
+	// SaveClient creates client or replaces stored one with the same id
+	SaveClient(c model.Client) error
+	GetClient(id string) (*model.Client, error)
+}
+
+type AuthorizationCodeStore interface {
+	StoreAuthorizationCode(c model.AuthorizationCode) error
+	// UseAuthorizationCode marks code as used and returns its state before the call,
+	// so a code which was already exchanged can be detected
+	UseAuthorizationCode(code string) (*model.AuthorizationCode, error)
+	// SetAuthorizationCodeToken records access token issued for the code
+	SetAuthorizationCodeToken(code, token string) error
+}
+
+type ConsentStore interface {
+	// SaveConsent creates consent or replaces scopes user gave to the client before
+	SaveConsent(c model.Consent) error
+	GetConsent(userId xid.ID, clientId string) (*model.Consent, error)
+}
+
+type OneTimeTokenStore interface {
+	StoreOneTimeToken(t model.OneTimeToken) error
+	// UseOneTimeToken marks token of the purpose as used and returns its state before the call,
+	// so a token which was already used can be detected
+	UseOneTimeToken(id, purpose string) (*model.OneTimeToken, error)
+}
+
+type TOTPStore interface {
+	// SaveTOTP creates or replaces TOTP of the user together with its recovery codes
+	SaveTOTP(t model.TOTP) error
+	GetTOTP(userId xid.ID) (*model.TOTP, error)
+	DeleteTOTP(userId xid.ID) error
+	// UseTOTPStep records time step of accepted code, steps up to the last recorded one are rejected,
+	// so every code is accepted once
+	UseTOTPStep(userId xid.ID, step int64) error
+	// UseRecoveryCode deletes recovery code with the hash, so it can't be used again
+	UseRecoveryCode(userId xid.ID, hash string) error
+}
+
+// WebAuthnStore keeps passkeys of users, credential id is base64url encoded id chosen by authenticator
+type WebAuthnStore interface {
+	SaveWebAuthnCredential(c model.WebAuthnCredential) error
+	GetWebAuthnCredential(id string) (*model.WebAuthnCredential, error)
+	// GetUserWebAuthnCredentials returns credentials of the user in order of registration
+	GetUserWebAuthnCredentials(userId xid.ID) ([]model.WebAuthnCredential, error)
+	// UpdateSignCount stores signature counter of the credential. Counter which did not grow is rejected,
+	// unless it is zero both times as authenticator does not count at all
+	UpdateSignCount(id string, count uint32) error
+}
+
+// LoginAttemptStore counts login attempts, replicas sharing the store see the same counters
+type LoginAttemptStore interface {
+	// AddLoginAttempt counts attempt made at the time and returns the counter. Counter started
+	// window or more before the attempt starts over, so attempts are counted in fixed windows
+	AddLoginAttempt(key string, at time.Time, window time.Duration) (*model.LoginAttempts, error)
+	GetLoginAttempts(key string) (*model.LoginAttempts, error)
+	ResetLoginAttempts(key string) error
+	// PruneLoginAttempts deletes counters with the latest attempt made before the time
+	PruneLoginAttempts(before time.Time) error
+}
+
+// NewStore creates storage backend selected in configuration
+func NewStore(c config.StorageConfig) (Store, error) {
+	switch c.Type {
+	case "", "memory":
+		return NewMemoryStore(), nil
+	case "sqlite":
+		s, err := NewSQLiteStore(c.Path)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	}
+	return nil, errors.Errorf("Unknown storage type: %v", c.Type)
 }
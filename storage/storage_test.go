@@ -0,0 +1,56 @@
+package storage_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"authService/config"
+	"authService/storage"
+	"authService/storage/storagetest"
+)
+
+func newSQLiteStore(t *testing.T) storage.Store {
+	s, err := storage.NewSQLiteStore(filepath.Join(t.TempDir(), "auth.db"))
+	if err != nil {
+		t.Fatalf("SQLite store should be created but got error: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func newMemoryStore(t *testing.T) storage.Store {
+	return storage.NewMemoryStore()
+}
+
+func TestMemoryStorage(t *testing.T) {
+	storagetest.Run(t, newMemoryStore)
+	storagetest.RunConcurrent(t, newMemoryStore)
+}
+
+func TestSQLStorage(t *testing.T) {
+	storagetest.Run(t, newSQLiteStore)
+	storagetest.RunConcurrent(t, newSQLiteStore)
+}
+
+func TestNewStore(t *testing.T) {
+
+	if s, err := storage.NewStore(config.StorageConfig{}); err != nil {
+		t.Errorf("Memory store expected by default but got [%v, %v]", s, err)
+	} else if _, ok := s.(*storage.MemoryStorage); !ok {
+		t.Errorf("Memory store expected by default but got %T", s)
+	}
+
+	s, err := storage.NewStore(config.StorageConfig{Type: "sqlite", Path: filepath.Join(t.TempDir(), "auth.db")})
+	if err != nil {
+		t.Fatalf("SQLite store expected but got error: %v", err)
+	}
+	if sql, ok := s.(*storage.SQLStorage); !ok {
+		t.Errorf("SQLite store expected but got %T", s)
+	} else {
+		sql.Close()
+	}
+
+	if _, err := storage.NewStore(config.StorageConfig{Type: "mongo"}); err == nil {
+		t.Errorf("Error expected for unknown storage type")
+	}
+}
@@ -0,0 +1,1081 @@
+// Package storagetest provides contract tests every storage.Store implementation has to pass.
+//
+// Backend tests call Run with a factory returning a new empty store:
+//
+//	func TestMyStore(t *testing.T) {
+//		storagetest.Run(t, func(t *testing.T) storage.Store { return NewMyStore() })
+//	}
+package storagetest
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/xid"
+
+	"authService/model"
+	"authService/storage"
+)
+
+// Factory returns new empty store, it is called once for every test case
+type Factory func(t *testing.T) storage.Store
+
+// Run runs whole storage contract against stores created by factory
+func Run(t *testing.T, newStore Factory) {
+	t.Run("UserStore", func(t *testing.T) { TestUserStore(t, newStore) })
+	t.Run("SessionStorage", func(t *testing.T) { TestSessionStorage(t, newStore) })
+	t.Run("RefreshTokenStore", func(t *testing.T) { TestRefreshTokenStore(t, newStore) })
+	t.Run("ClientStore", func(t *testing.T) { TestClientStore(t, newStore) })
+	t.Run("AuthorizationCodeStore", func(t *testing.T) { TestAuthorizationCodeStore(t, newStore) })
+	t.Run("ConsentStore", func(t *testing.T) { TestConsentStore(t, newStore) })
+	t.Run("OneTimeTokenStore", func(t *testing.T) { TestOneTimeTokenStore(t, newStore) })
+	t.Run("TOTPStore", func(t *testing.T) { TestTOTPStore(t, newStore) })
+	t.Run("WebAuthnStore", func(t *testing.T) { TestWebAuthnStore(t, newStore) })
+	t.Run("LoginAttemptStore", func(t *testing.T) { TestLoginAttemptStore(t, newStore) })
+}
+
+// RunConcurrent checks store behaves correctly when it is called from many goroutines at once
+func RunConcurrent(t *testing.T, newStore Factory) {
+	t.Run("ConcurrentUsers", func(t *testing.T) { TestConcurrentUsers(t, newStore) })
+	t.Run("ConcurrentSessions", func(t *testing.T) { TestConcurrentSessions(t, newStore) })
+	t.Run("ConcurrentRefreshTokens", func(t *testing.T) { TestConcurrentRefreshTokens(t, newStore) })
+	t.Run("ConcurrentLoginAttempts", func(t *testing.T) { TestConcurrentLoginAttempts(t, newStore) })
+}
+
+func NewUser(email string) model.User {
+	return model.NewUser(model.Credentilas{Email: email, Password: "hash"})
+}
+
+func expectError(t *testing.T, action string, err, expected error) {
+	t.Helper()
+	if errors.Cause(err) != expected {
+		t.Errorf("%v: expected error [%v] but got [%v]", action, expected, err)
+	}
+}
+
+func TestUserStore(t *testing.T, newStore Factory) {
+
+	t.Run("Store and get by login", func(t *testing.T) {
+		s := newStore(t)
+		u := NewUser("test@gmail.com")
+		u.Banned = true
+		u.EmailVerified = true
+
+		if err := s.Store(u); err != nil {
+			t.Fatalf("User should be stored but got error: %v", err)
+		}
+
+		stored, err := s.GetUserByLogin("test@gmail.com")
+
+		if err != nil {
+			t.Fatalf("Stored user expected by login but got error: %v", err)
+		}
+
+		if stored.Id != u.Id || stored.Credentials.Email != u.Credentials.Email || stored.Credentials.Password != u.Credentials.Password {
+			t.Errorf("Expected user [%v] but got [%v]", u, stored)
+		}
+
+		if stored.Active != u.Active || stored.Banned != u.Banned || stored.EmailVerified != u.EmailVerified {
+			t.Errorf("Expected user flags active: [%v] banned: [%v] verified: [%v] but got [%v, %v, %v]", u.Active, u.Banned, u.EmailVerified, stored.Active, stored.Banned, stored.EmailVerified)
+		}
+	})
+
+	t.Run("Duplicate email is rejected", func(t *testing.T) {
+		s := newStore(t)
+		first := NewUser("test@gmail.com")
+		s.Store(first)
+
+		expectError(t, "Store duplicate", s.Store(NewUser("test@gmail.com")), storage.ErrUserExists)
+
+		stored, _ := s.GetUserByLogin("test@gmail.com")
+		if stored == nil || stored.Id != first.Id {
+			t.Errorf("Rejected user should not replace stored one but got %v", stored)
+		}
+	})
+
+	t.Run("Get by id", func(t *testing.T) {
+		s := newStore(t)
+		u := NewUser("test@gmail.com")
+		s.Store(u)
+		s.Store(NewUser("other@gmail.com"))
+
+		stored, err := s.GetUserById(u.Id.String())
+
+		if err != nil || stored.Id != u.Id || stored.Credentials.Email != "test@gmail.com" {
+			t.Errorf("Stored user expected by id but got [%v, %v]", stored, err)
+		}
+	})
+
+	t.Run("Get unknown user", func(t *testing.T) {
+		s := newStore(t)
+		s.Store(NewUser("test@gmail.com"))
+
+		u, err := s.GetUserByLogin("other@gmail.com")
+		expectError(t, "GetUserByLogin", err, storage.ErrUserNotFound)
+		if u != nil {
+			t.Errorf("No user expected but got %v", u)
+		}
+
+		u, err = s.GetUserById(xid.New().String())
+		expectError(t, "GetUserById", err, storage.ErrUserNotFound)
+		if u != nil {
+			t.Errorf("No user expected but got %v", u)
+		}
+	})
+
+	t.Run("Get by malformed id", func(t *testing.T) {
+		s := newStore(t)
+		s.Store(NewUser("test@gmail.com"))
+
+		for _, id := range []string{"", "malformed", "bfra5o2cc8imh64se1s", "bfra5o2cc8imh64se1s0!"} {
+			u, err := s.GetUserById(id)
+			expectError(t, fmt.Sprintf("GetUserById(%q)", id), err, storage.ErrInvalidUserId)
+			if u != nil {
+				t.Errorf("No user expected for malformed id but got %v", u)
+			}
+		}
+	})
+
+	t.Run("User existence", func(t *testing.T) {
+		s := newStore(t)
+
+		if s.IsUserExistByLogin("test@gmail.com") {
+			t.Errorf("User should not exist in empty store")
+		}
+
+		s.Store(NewUser("test@gmail.com"))
+
+		if !s.IsUserExistByLogin("test@gmail.com") {
+			t.Errorf("Stored user should exist")
+		}
+
+		if s.IsUserExistByLogin("other@gmail.com") {
+			t.Errorf("Not stored user should not exist")
+		}
+	})
+
+	t.Run("Update user", func(t *testing.T) {
+		s := newStore(t)
+		u := NewUser("test@gmail.com")
+		s.Store(u)
+
+		u.Credentials.Password = "new hash"
+		u.Banned = true
+		u.Active = false
+
+		if err := s.UpdateUser(u); err != nil {
+			t.Fatalf("User should be updated but got error: %v", err)
+		}
+
+		stored, _ := s.GetUserById(u.Id.String())
+
+		if stored.Credentials.Password != "new hash" || !stored.Banned || stored.Active {
+			t.Errorf("Updated user expected but got %v", stored)
+		}
+
+		expectError(t, "UpdateUser unknown", s.UpdateUser(NewUser("other@gmail.com")), storage.ErrUserNotFound)
+
+		if s.IsUserExistByLogin("other@gmail.com") {
+			t.Errorf("Updating unknown user should not store it")
+		}
+	})
+	t.Run("Roles, groups and attributes", func(t *testing.T) {
+		s := newStore(t)
+		u := NewUser("test@gmail.com")
+		u.Roles = []string{"user"}
+		s.Store(u)
+
+		stored, _ := s.GetUserByLogin("test@gmail.com")
+
+		if !reflect.DeepEqual(stored.Roles, []string{"user"}) || len(stored.Groups) != 0 || len(stored.Attributes) != 0 {
+			t.Errorf("Stored roles expected but got %v", stored)
+		}
+
+		u.Roles = []string{"user", "admin"}
+		u.Groups = []string{"staff"}
+		u.Attributes = map[string]string{"department": "it"}
+		s.UpdateUser(u)
+
+		// changing caller's copy must not change stored user
+		u.Roles[0] = "changed"
+		u.Attributes["department"] = "changed"
+
+		stored, _ = s.GetUserById(u.Id.String())
+
+		if !reflect.DeepEqual(stored.Roles, []string{"user", "admin"}) || !reflect.DeepEqual(stored.Groups, []string{"staff"}) ||
+			!reflect.DeepEqual(stored.Attributes, map[string]string{"department": "it"}) {
+			t.Errorf("Updated roles, groups and attributes expected but got %v", stored)
+		}
+	})
+}
+
+func TestSessionStorage(t *testing.T, newStore Factory) {
+
+	t.Run("Store token", func(t *testing.T) {
+		s := newStore(t)
+
+		if s.IsTokenPresent("token") {
+			t.Errorf("Token should not be present in empty store")
+		}
+
+		if err := s.StoreToken("token", "user"); err != nil {
+			t.Fatalf("Token should be stored but got error: %v", err)
+		}
+
+		if !s.IsTokenPresent("token") {
+			t.Errorf("Stored token should be present")
+		}
+
+		expectError(t, "StoreToken duplicate", s.StoreToken("token", "user"), storage.ErrTokenExists)
+	})
+
+	t.Run("Update token", func(t *testing.T) {
+		s := newStore(t)
+		s.StoreToken("old", "user")
+
+		if err := s.UpdateToken("old", "new", "user"); err != nil {
+			t.Fatalf("Token should be updated but got error: %v", err)
+		}
+
+		if s.IsTokenPresent("old") || !s.IsTokenPresent("new") {
+			t.Errorf("Old token should be replaced by new one")
+		}
+	})
+
+	t.Run("Update missing token falls back to store", func(t *testing.T) {
+		s := newStore(t)
+
+		if err := s.UpdateToken("missing", "new", "user"); err != nil {
+			t.Fatalf("New token should be stored but got error: %v", err)
+		}
+
+		if !s.IsTokenPresent("new") {
+			t.Errorf("New token should be present")
+		}
+
+		s.StoreToken("existing", "user")
+		expectError(t, "UpdateToken to stored token", s.UpdateToken("missing", "existing", "user"), storage.ErrTokenExists)
+	})
+
+	t.Run("Delete token", func(t *testing.T) {
+		s := newStore(t)
+		s.StoreToken("token", "user")
+		s.StoreToken("other", "other")
+
+		if err := s.DeleteToken("token"); err != nil {
+			t.Fatalf("Token should be deleted but got error: %v", err)
+		}
+
+		if s.IsTokenPresent("token") || !s.IsTokenPresent("other") {
+			t.Errorf("Only deleted token should be removed")
+		}
+
+		expectError(t, "DeleteToken missing", s.DeleteToken("token"), storage.ErrTokenNotFound)
+	})
+
+	t.Run("Delete owner tokens", func(t *testing.T) {
+		s := newStore(t)
+		s.StoreToken("first", "user")
+		s.StoreToken("old", "user")
+		s.UpdateToken("old", "second", "user")
+		s.UpdateToken("missing", "third", "user")
+		s.StoreToken("other", "other")
+
+		if err := s.DeleteOwnerTokens("user"); err != nil {
+			t.Fatalf("Tokens should be deleted but got error: %v", err)
+		}
+
+		for _, token := range []string{"first", "second", "third"} {
+			if s.IsTokenPresent(token) {
+				t.Errorf("Token %v of the owner should be deleted", token)
+			}
+		}
+
+		if !s.IsTokenPresent("other") {
+			t.Errorf("Tokens of other owners should be kept")
+		}
+
+		if err := s.DeleteOwnerTokens("unknown"); err != nil {
+			t.Errorf("Deleting tokens of owner without tokens should succeed but got error: %v", err)
+		}
+	})
+}
+
+func TestRefreshTokenStore(t *testing.T, newStore Factory) {
+
+	userId := xid.New()
+	expiresAt := time.Now().Add(time.Hour)
+
+	token := func(id, family string) model.RefreshToken {
+		return model.RefreshToken{Id: id, Family: family, UserId: userId, AccessToken: "access-" + id, ExpiresAt: expiresAt}
+	}
+
+	t.Run("Store and use", func(t *testing.T) {
+		s := newStore(t)
+
+		if err := s.StoreRefreshToken(token("first", "family")); err != nil {
+			t.Fatalf("Refresh token should be stored but got error: %v", err)
+		}
+
+		expectError(t, "StoreRefreshToken duplicate", s.StoreRefreshToken(token("first", "family")), storage.ErrTokenExists)
+
+		rt, err := s.UseRefreshToken("first")
+
+		if err != nil {
+			t.Fatalf("Refresh token should be used but got error: %v", err)
+		}
+
+		if rt.Used || rt.Id != "first" || rt.Family != "family" || rt.UserId != userId || rt.AccessToken != "access-first" || !rt.ExpiresAt.Equal(expiresAt) {
+			t.Errorf("Unused refresh token [%v] expected but got [%v]", token("first", "family"), rt)
+		}
+
+		if rt, err := s.UseRefreshToken("first"); err != nil || !rt.Used {
+			t.Errorf("Second use should report used token but got [%v, %v]", rt, err)
+		}
+	})
+
+	t.Run("Use missing token", func(t *testing.T) {
+		s := newStore(t)
+
+		rt, err := s.UseRefreshToken("missing")
+		expectError(t, "UseRefreshToken missing", err, storage.ErrTokenNotFound)
+
+		if rt != nil {
+			t.Errorf("No refresh token expected but got %v", rt)
+		}
+	})
+
+	t.Run("Revoke family", func(t *testing.T) {
+		s := newStore(t)
+		s.StoreRefreshToken(token("first", "family"))
+		s.StoreRefreshToken(token("second", "family"))
+		s.StoreRefreshToken(token("other", "other"))
+
+		revoked, err := s.RevokeTokenFamily("family")
+
+		if err != nil || len(revoked) != 2 {
+			t.Fatalf("Two revoked tokens expected but got [%v, %v]", revoked, err)
+		}
+
+		for _, rt := range revoked {
+			if rt.Family != "family" || rt.AccessToken != "access-"+rt.Id {
+				t.Errorf("Revoked token of family [family] expected but got %v", rt)
+			}
+
+			_, err := s.UseRefreshToken(rt.Id)
+			expectError(t, "UseRefreshToken revoked", err, storage.ErrTokenNotFound)
+		}
+
+		if _, err := s.UseRefreshToken("other"); err != nil {
+			t.Errorf("Tokens of other families should not be revoked but got error: %v", err)
+		}
+
+		if revoked, err := s.RevokeTokenFamily("missing"); err != nil || len(revoked) != 0 {
+			t.Errorf("Revoking unknown family should be a no-op but got [%v, %v]", revoked, err)
+		}
+	})
+
+	t.Run("Revoke family of access token", func(t *testing.T) {
+		s := newStore(t)
+		s.StoreRefreshToken(token("first", "family"))
+		s.StoreRefreshToken(token("second", "family"))
+		s.StoreRefreshToken(token("other", "other"))
+
+		revoked, err := s.RevokeAccessTokenFamily("access-second")
+
+		if err != nil || len(revoked) != 2 {
+			t.Fatalf("Two revoked tokens expected but got [%v, %v]", revoked, err)
+		}
+
+		for _, id := range []string{"first", "second"} {
+			_, err := s.UseRefreshToken(id)
+			expectError(t, "UseRefreshToken revoked", err, storage.ErrTokenNotFound)
+		}
+
+		if _, err := s.UseRefreshToken("other"); err != nil {
+			t.Errorf("Tokens of other families should not be revoked but got error: %v", err)
+		}
+
+		if revoked, err := s.RevokeAccessTokenFamily("missing"); err != nil || len(revoked) != 0 {
+			t.Errorf("Revoking family of unknown access token should be a no-op but got [%v, %v]", revoked, err)
+		}
+	})
+	t.Run("Revoke user tokens", func(t *testing.T) {
+		s := newStore(t)
+		s.StoreRefreshToken(token("first", "family"))
+		s.StoreRefreshToken(token("second", "other"))
+
+		other := token("foreign", "foreign")
+		other.UserId = xid.New()
+		s.StoreRefreshToken(other)
+
+		revoked, err := s.RevokeUserTokens(userId)
+
+		if err != nil || len(revoked) != 2 {
+			t.Fatalf("Two revoked tokens expected but got [%v, %v]", revoked, err)
+		}
+
+		for _, id := range []string{"first", "second"} {
+			_, err := s.UseRefreshToken(id)
+			expectError(t, "UseRefreshToken revoked", err, storage.ErrTokenNotFound)
+		}
+
+		if _, err := s.UseRefreshToken("foreign"); err != nil {
+			t.Errorf("Tokens of other users should not be revoked but got error: %v", err)
+		}
+	})
+}
+
+const workers = 32
+
+func TestClientStore(t *testing.T, newStore Factory) {
+
+	t.Run("Save and get", func(t *testing.T) {
+		s := newStore(t)
+		c := model.Client{Id: "gateway", SecretHash: "hash", Name: "API gateway", RedirectURIs: []string{"https://app.example.com/callback"}, Scopes: []string{"users:read"}}
+
+		if err := s.SaveClient(c); err != nil {
+			t.Fatalf("Client should be saved but got error: %v", err)
+		}
+
+		stored, err := s.GetClient("gateway")
+
+		if err != nil || !reflect.DeepEqual(*stored, c) {
+			t.Errorf("Client [%v] expected but got [%v, %v]", c, stored, err)
+		}
+
+		_, err = s.GetClient("other")
+		expectError(t, "GetClient unknown", err, storage.ErrClientNotFound)
+	})
+
+	t.Run("Save replaces client", func(t *testing.T) {
+		s := newStore(t)
+		s.SaveClient(model.Client{Id: "gateway", SecretHash: "hash", Name: "API gateway"})
+
+		c := model.Client{Id: "gateway", Name: "Gateway", Public: true, RedirectURIs: []string{"app://callback", "https://app.example.com/callback"}}
+
+		if err := s.SaveClient(c); err != nil {
+			t.Fatalf("Client should be replaced but got error: %v", err)
+		}
+
+		if stored, _ := s.GetClient("gateway"); !reflect.DeepEqual(*stored, c) {
+			t.Errorf("Client [%v] expected but got [%v]", c, stored)
+		}
+	})
+}
+
+func TestAuthorizationCodeStore(t *testing.T, newStore Factory) {
+
+	userId := xid.New()
+	authTime := time.Now().Add(-time.Hour)
+	expiresAt := time.Now().Add(time.Minute)
+
+	code := func(c string) model.AuthorizationCode {
+		return model.AuthorizationCode{Code: c, ClientId: "app", UserId: userId, RedirectURI: "app://callback",
+			Scope: "openid profile", CodeChallenge: "challenge", Nonce: "nonce", AuthTime: authTime, ExpiresAt: expiresAt}
+	}
+
+	t.Run("Store and use", func(t *testing.T) {
+		s := newStore(t)
+
+		if err := s.StoreAuthorizationCode(code("first")); err != nil {
+			t.Fatalf("Authorization code should be stored but got error: %v", err)
+		}
+
+		expectError(t, "StoreAuthorizationCode duplicate", s.StoreAuthorizationCode(code("first")), storage.ErrCodeExists)
+
+		c, err := s.UseAuthorizationCode("first")
+
+		if err != nil {
+			t.Fatalf("Authorization code should be used but got error: %v", err)
+		}
+
+		expected := code("first")
+		if c.Used || !c.ExpiresAt.Equal(expiresAt) || !c.AuthTime.Equal(authTime) {
+			t.Errorf("Unused authorization code [%v] expected but got [%v]", expected, c)
+		}
+
+		c.AuthTime = authTime
+		c.ExpiresAt = expiresAt
+		if !reflect.DeepEqual(*c, expected) {
+			t.Errorf("Authorization code [%v] expected but got [%v]", expected, c)
+		}
+
+		if c, err := s.UseAuthorizationCode("first"); err != nil || !c.Used {
+			t.Errorf("Second use should report used code but got [%v, %v]", c, err)
+		}
+
+		_, err = s.UseAuthorizationCode("unknown")
+		expectError(t, "UseAuthorizationCode unknown", err, storage.ErrCodeNotFound)
+	})
+
+	t.Run("Set token", func(t *testing.T) {
+		s := newStore(t)
+		s.StoreAuthorizationCode(code("code"))
+
+		if err := s.SetAuthorizationCodeToken("code", "token"); err != nil {
+			t.Fatalf("Token of authorization code should be set but got error: %v", err)
+		}
+
+		if c, err := s.UseAuthorizationCode("code"); err != nil || c.AccessToken != "token" {
+			t.Errorf("Authorization code with token expected but got [%v, %v]", c, err)
+		}
+
+		expectError(t, "SetAuthorizationCodeToken unknown", s.SetAuthorizationCodeToken("unknown", "token"), storage.ErrCodeNotFound)
+	})
+
+	t.Run("Concurrent use", func(t *testing.T) {
+		s := newStore(t)
+		s.StoreAuthorizationCode(code("code"))
+
+		unused := make(chan bool, 20)
+
+		parallel(20, func(i int) {
+			c, err := s.UseAuthorizationCode("code")
+			unused <- err == nil && !c.Used
+		})
+		close(unused)
+
+		n := 0
+		for ok := range unused {
+			if ok {
+				n++
+			}
+		}
+
+		if n != 1 {
+			t.Errorf("Authorization code should be exchanged exactly once but was %d times", n)
+		}
+	})
+}
+
+func TestConsentStore(t *testing.T, newStore Factory) {
+
+	userId := xid.New()
+
+	t.Run("Save and get", func(t *testing.T) {
+		s := newStore(t)
+
+		_, err := s.GetConsent(userId, "app")
+		expectError(t, "GetConsent missing", err, storage.ErrNoConsent)
+
+		grantedAt := time.Now()
+
+		if err := s.SaveConsent(model.Consent{UserId: userId, ClientId: "app", Scopes: []string{"openid"}, GrantedAt: grantedAt}); err != nil {
+			t.Fatalf("Consent should be saved but got error: %v", err)
+		}
+
+		c, err := s.GetConsent(userId, "app")
+
+		if err != nil || !reflect.DeepEqual(c.Scopes, []string{"openid"}) || !c.GrantedAt.Equal(grantedAt) {
+			t.Errorf("Saved consent expected but got [%v, %v]", c, err)
+		}
+
+		_, err = s.GetConsent(userId, "other")
+		expectError(t, "GetConsent other client", err, storage.ErrNoConsent)
+
+		_, err = s.GetConsent(xid.New(), "app")
+		expectError(t, "GetConsent other user", err, storage.ErrNoConsent)
+	})
+
+	t.Run("Save replaces scopes", func(t *testing.T) {
+		s := newStore(t)
+		s.SaveConsent(model.Consent{UserId: userId, ClientId: "app", Scopes: []string{"openid"}, GrantedAt: time.Now()})
+		s.SaveConsent(model.Consent{UserId: userId, ClientId: "app", Scopes: []string{"openid", "profile"}, GrantedAt: time.Now()})
+
+		if c, _ := s.GetConsent(userId, "app"); !reflect.DeepEqual(c.Scopes, []string{"openid", "profile"}) {
+			t.Errorf("Replaced consent scopes expected but got %v", c)
+		}
+	})
+}
+
+func parallel(n int, f func(i int)) {
+	wg := sync.WaitGroup{}
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			defer wg.Done()
+			f(i)
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestOneTimeTokenStore(t *testing.T, newStore Factory) {
+
+	userId := xid.New()
+	expiresAt := time.Now().Add(time.Hour)
+
+	token := func(id, purpose string) model.OneTimeToken {
+		return model.OneTimeToken{Id: id, Purpose: purpose, UserId: userId, Binding: "test@gmail.com", ExpiresAt: expiresAt}
+	}
+
+	t.Run("Store and use", func(t *testing.T) {
+		s := newStore(t)
+
+		if err := s.StoreOneTimeToken(token("first", "verify-email")); err != nil {
+			t.Fatalf("One-time token should be stored but got error: %v", err)
+		}
+
+		expectError(t, "StoreOneTimeToken duplicate", s.StoreOneTimeToken(token("first", "verify-email")), storage.ErrOneTimeTokenExists)
+
+		_, err := s.UseOneTimeToken("first", "reset-password")
+		expectError(t, "UseOneTimeToken of other purpose", err, storage.ErrOneTimeTokenNotFound)
+
+		stored, err := s.UseOneTimeToken("first", "verify-email")
+
+		if err != nil {
+			t.Fatalf("One-time token should be used but got error: %v", err)
+		}
+
+		expected := token("first", "verify-email")
+		if stored.Used || !stored.ExpiresAt.Equal(expiresAt) {
+			t.Errorf("Unused one-time token [%v] expected but got [%v]", expected, stored)
+		}
+
+		stored.ExpiresAt = expiresAt
+		if !reflect.DeepEqual(*stored, expected) {
+			t.Errorf("One-time token [%v] expected but got [%v]", expected, stored)
+		}
+
+		if stored, err := s.UseOneTimeToken("first", "verify-email"); err != nil || !stored.Used {
+			t.Errorf("Second use should report used token but got [%v, %v]", stored, err)
+		}
+
+		_, err = s.UseOneTimeToken("unknown", "verify-email")
+		expectError(t, "UseOneTimeToken unknown", err, storage.ErrOneTimeTokenNotFound)
+	})
+
+	t.Run("Concurrent use", func(t *testing.T) {
+		s := newStore(t)
+		s.StoreOneTimeToken(token("token", "verify-email"))
+
+		unused := make(chan bool, 20)
+
+		parallel(20, func(i int) {
+			stored, err := s.UseOneTimeToken("token", "verify-email")
+			unused <- err == nil && !stored.Used
+		})
+		close(unused)
+
+		n := 0
+		for ok := range unused {
+			if ok {
+				n++
+			}
+		}
+
+		if n != 1 {
+			t.Errorf("One-time token should be used exactly once but was %d times", n)
+		}
+	})
+}
+
+func TestTOTPStore(t *testing.T, newStore Factory) {
+
+	userId := xid.New()
+
+	totp := func(codes ...string) model.TOTP {
+		return model.TOTP{UserId: userId, Secret: "JBSWY3DPEHPK3PXP", Confirmed: true, LastStep: 10, RecoveryCodes: codes}
+	}
+
+	t.Run("Save and get", func(t *testing.T) {
+		s := newStore(t)
+
+		_, err := s.GetTOTP(userId)
+		expectError(t, "GetTOTP unknown", err, storage.ErrTOTPNotFound)
+
+		if err := s.SaveTOTP(model.TOTP{UserId: userId, Secret: "pending"}); err != nil {
+			t.Fatalf("TOTP should be saved but got error: %v", err)
+		}
+
+		if err := s.SaveTOTP(totp("first", "second")); err != nil {
+			t.Fatalf("TOTP should be replaced but got error: %v", err)
+		}
+
+		stored, err := s.GetTOTP(userId)
+
+		if err != nil {
+			t.Fatalf("TOTP should be found but got error: %v", err)
+		}
+
+		if expected := totp("first", "second"); !reflect.DeepEqual(*stored, expected) {
+			t.Errorf("TOTP [%v] expected but got [%v]", expected, stored)
+		}
+	})
+
+	t.Run("Use step", func(t *testing.T) {
+		s := newStore(t)
+
+		expectError(t, "UseTOTPStep unknown", s.UseTOTPStep(userId, 11), storage.ErrTOTPNotFound)
+
+		s.SaveTOTP(totp())
+
+		expectError(t, "UseTOTPStep of last step", s.UseTOTPStep(userId, 10), storage.ErrTOTPStepUsed)
+
+		if err := s.UseTOTPStep(userId, 12); err != nil {
+			t.Fatalf("Next step should be accepted but got error: %v", err)
+		}
+
+		expectError(t, "UseTOTPStep of older step", s.UseTOTPStep(userId, 11), storage.ErrTOTPStepUsed)
+
+		if stored, _ := s.GetTOTP(userId); stored.LastStep != 12 {
+			t.Errorf("Expected last step [%v] but was: [%v]", 12, stored.LastStep)
+		}
+	})
+
+	t.Run("Use recovery code", func(t *testing.T) {
+		s := newStore(t)
+
+		expectError(t, "UseRecoveryCode unknown user", s.UseRecoveryCode(userId, "first"), storage.ErrRecoveryCodeNotFound)
+
+		s.SaveTOTP(totp("first", "second"))
+
+		if err := s.UseRecoveryCode(userId, "first"); err != nil {
+			t.Fatalf("Recovery code should be used but got error: %v", err)
+		}
+
+		expectError(t, "UseRecoveryCode twice", s.UseRecoveryCode(userId, "first"), storage.ErrRecoveryCodeNotFound)
+		expectError(t, "UseRecoveryCode of other user", s.UseRecoveryCode(xid.New(), "second"), storage.ErrRecoveryCodeNotFound)
+
+		if stored, _ := s.GetTOTP(userId); !reflect.DeepEqual(stored.RecoveryCodes, []string{"second"}) {
+			t.Errorf("Expected recovery codes [%v] but was: [%v]", []string{"second"}, stored.RecoveryCodes)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		s := newStore(t)
+		s.SaveTOTP(totp("first"))
+
+		if err := s.DeleteTOTP(userId); err != nil {
+			t.Fatalf("TOTP should be deleted but got error: %v", err)
+		}
+
+		_, err := s.GetTOTP(userId)
+		expectError(t, "GetTOTP deleted", err, storage.ErrTOTPNotFound)
+		expectError(t, "UseRecoveryCode deleted", s.UseRecoveryCode(userId, "first"), storage.ErrRecoveryCodeNotFound)
+
+		if err := s.DeleteTOTP(userId); err != nil {
+			t.Errorf("Deleting missing TOTP should succeed but got error: %v", err)
+		}
+	})
+
+	t.Run("Concurrent step use", func(t *testing.T) {
+		s := newStore(t)
+		s.SaveTOTP(totp())
+
+		accepted := make(chan bool, 20)
+
+		parallel(20, func(i int) {
+			accepted <- s.UseTOTPStep(userId, 11) == nil
+		})
+		close(accepted)
+
+		n := 0
+		for ok := range accepted {
+			if ok {
+				n++
+			}
+		}
+
+		if n != 1 {
+			t.Errorf("TOTP step should be accepted exactly once but was %d times", n)
+		}
+	})
+}
+
+func TestWebAuthnStore(t *testing.T, newStore Factory) {
+
+	userId := xid.New()
+	createdAt := time.Now().Truncate(time.Second)
+
+	credential := func(id string, signCount uint32, age time.Duration) model.WebAuthnCredential {
+		return model.WebAuthnCredential{Id: id, UserId: userId, PublicKey: []byte{0xa5, 0x01, 0x02}, SignCount: signCount, CreatedAt: createdAt.Add(-age)}
+	}
+
+	t.Run("Save and get", func(t *testing.T) {
+		s := newStore(t)
+
+		if err := s.SaveWebAuthnCredential(credential("first", 0, 0)); err != nil {
+			t.Fatalf("Credential should be saved but got error: %v", err)
+		}
+
+		expectError(t, "SaveWebAuthnCredential duplicate", s.SaveWebAuthnCredential(credential("first", 0, 0)), storage.ErrCredentialExists)
+
+		stored, err := s.GetWebAuthnCredential("first")
+
+		if err != nil {
+			t.Fatalf("Credential should be found but got error: %v", err)
+		}
+
+		expected := credential("first", 0, 0)
+		if !stored.CreatedAt.Equal(expected.CreatedAt) {
+			t.Errorf("Credential [%v] expected but got [%v]", expected, stored)
+		}
+
+		stored.CreatedAt = expected.CreatedAt
+		if !reflect.DeepEqual(*stored, expected) {
+			t.Errorf("Credential [%v] expected but got [%v]", expected, stored)
+		}
+
+		_, err = s.GetWebAuthnCredential("unknown")
+		expectError(t, "GetWebAuthnCredential unknown", err, storage.ErrCredentialNotFound)
+	})
+
+	t.Run("User credentials", func(t *testing.T) {
+		s := newStore(t)
+
+		s.SaveWebAuthnCredential(credential("new", 0, 0))
+		s.SaveWebAuthnCredential(credential("old", 0, time.Hour))
+		other := credential("other", 0, 0)
+		other.UserId = xid.New()
+		s.SaveWebAuthnCredential(other)
+
+		credentials, err := s.GetUserWebAuthnCredentials(userId)
+
+		if err != nil || len(credentials) != 2 || credentials[0].Id != "old" || credentials[1].Id != "new" {
+			t.Errorf("Credentials [old, new] expected but got [%v, %v]", credentials, err)
+		}
+
+		if credentials, err := s.GetUserWebAuthnCredentials(xid.New()); err != nil || len(credentials) != 0 {
+			t.Errorf("No credentials expected but got [%v, %v]", credentials, err)
+		}
+	})
+
+	t.Run("Update sign count", func(t *testing.T) {
+		s := newStore(t)
+
+		s.SaveWebAuthnCredential(credential("counting", 5, 0))
+		s.SaveWebAuthnCredential(credential("not counting", 0, 0))
+
+		expectError(t, "UpdateSignCount unknown", s.UpdateSignCount("unknown", 1), storage.ErrCredentialNotFound)
+		expectError(t, "UpdateSignCount same", s.UpdateSignCount("counting", 5), storage.ErrSignCountNotIncreased)
+		expectError(t, "UpdateSignCount lower", s.UpdateSignCount("counting", 4), storage.ErrSignCountNotIncreased)
+		expectError(t, "UpdateSignCount reset", s.UpdateSignCount("counting", 0), storage.ErrSignCountNotIncreased)
+
+		if err := s.UpdateSignCount("counting", 6); err != nil {
+			t.Errorf("Greater counter should be stored but got error: %v", err)
+		}
+
+		if err := s.UpdateSignCount("not counting", 0); err != nil {
+			t.Errorf("Zero counter of authenticator which does not count should be accepted but got error: %v", err)
+		}
+
+		if stored, _ := s.GetWebAuthnCredential("counting"); stored.SignCount != 6 {
+			t.Errorf("Expected sign count [%v] but was: [%v]", 6, stored.SignCount)
+		}
+	})
+
+	t.Run("Concurrent sign count update", func(t *testing.T) {
+		s := newStore(t)
+		s.SaveWebAuthnCredential(credential("credential", 1, 0))
+
+		accepted := make(chan bool, 20)
+
+		parallel(20, func(i int) {
+			accepted <- s.UpdateSignCount("credential", 2) == nil
+		})
+		close(accepted)
+
+		n := 0
+		for ok := range accepted {
+			if ok {
+				n++
+			}
+		}
+
+		if n != 1 {
+			t.Errorf("Sign count should be accepted exactly once but was %d times", n)
+		}
+	})
+}
+
+func TestLoginAttemptStore(t *testing.T, newStore Factory) {
+
+	start := time.Now().Truncate(time.Second)
+
+	t.Run("Count attempts in window", func(t *testing.T) {
+		s := newStore(t)
+
+		_, err := s.GetLoginAttempts("account:test@gmail.com")
+		expectError(t, "GetLoginAttempts before attempt", err, storage.ErrLoginAttemptsNotFound)
+
+		s.AddLoginAttempt("account:test@gmail.com", start, time.Minute)
+		s.AddLoginAttempt("account:other@gmail.com", start, time.Minute)
+		a, err := s.AddLoginAttempt("account:test@gmail.com", start.Add(30*time.Second), time.Minute)
+
+		if err != nil {
+			t.Fatalf("Attempt should be counted but got error: %v", err)
+		}
+
+		if a.Key != "account:test@gmail.com" || a.Count != 2 || !a.Start.Equal(start) || !a.Last.Equal(start.Add(30*time.Second)) {
+			t.Errorf("Expected two attempts since [%v] but got [%v]", start, a)
+		}
+
+		stored, err := s.GetLoginAttempts("account:test@gmail.com")
+
+		if err != nil || stored.Count != 2 || !stored.Start.Equal(a.Start) || !stored.Last.Equal(a.Last) {
+			t.Errorf("Expected stored attempts [%v] but got [%v, %v]", a, stored, err)
+		}
+	})
+
+	t.Run("Counter starts over after window", func(t *testing.T) {
+		s := newStore(t)
+		s.AddLoginAttempt("ip:127.0.0.1", start, time.Minute)
+		s.AddLoginAttempt("ip:127.0.0.1", start.Add(time.Second), time.Minute)
+
+		a, _ := s.AddLoginAttempt("ip:127.0.0.1", start.Add(time.Minute), time.Minute)
+
+		if a.Count != 1 || !a.Start.Equal(start.Add(time.Minute)) {
+			t.Errorf("Expected new counter since [%v] but got [%v]", start.Add(time.Minute), a)
+		}
+	})
+
+	t.Run("Reset", func(t *testing.T) {
+		s := newStore(t)
+		s.AddLoginAttempt("account:test@gmail.com", start, time.Minute)
+		s.AddLoginAttempt("account:other@gmail.com", start, time.Minute)
+
+		if err := s.ResetLoginAttempts("account:test@gmail.com"); err != nil {
+			t.Fatalf("Attempts should be reset but got error: %v", err)
+		}
+
+		_, err := s.GetLoginAttempts("account:test@gmail.com")
+		expectError(t, "GetLoginAttempts after reset", err, storage.ErrLoginAttemptsNotFound)
+
+		if a, err := s.GetLoginAttempts("account:other@gmail.com"); err != nil || a.Count != 1 {
+			t.Errorf("Attempts of other key mast be kept but got [%v, %v]", a, err)
+		}
+
+		if err := s.ResetLoginAttempts("account:unknown@gmail.com"); err != nil {
+			t.Errorf("Reset of unknown key should succeed but got error: %v", err)
+		}
+	})
+
+	t.Run("Prune", func(t *testing.T) {
+		s := newStore(t)
+		s.AddLoginAttempt("account:stale@gmail.com", start, time.Minute)
+		s.AddLoginAttempt("account:recent@gmail.com", start, time.Minute)
+		s.AddLoginAttempt("account:recent@gmail.com", start.Add(time.Minute), time.Minute)
+
+		if err := s.PruneLoginAttempts(start.Add(time.Minute)); err != nil {
+			t.Fatalf("Attempts should be pruned but got error: %v", err)
+		}
+
+		_, err := s.GetLoginAttempts("account:stale@gmail.com")
+		expectError(t, "GetLoginAttempts after prune", err, storage.ErrLoginAttemptsNotFound)
+
+		if a, err := s.GetLoginAttempts("account:recent@gmail.com"); err != nil || a.Count != 1 {
+			t.Errorf("Attempts made since the time mast be kept but got [%v, %v]", a, err)
+		}
+	})
+}
+
+func TestConcurrentUsers(t *testing.T, newStore Factory) {
+
+	s := newStore(t)
+	results := make(chan error, workers)
+
+	parallel(workers, func(i int) {
+		results <- s.Store(NewUser("same@gmail.com"))
+		s.Store(NewUser(fmt.Sprintf("user%d@gmail.com", i)))
+		s.IsUserExistByLogin("same@gmail.com")
+		s.GetUserByLogin("same@gmail.com")
+	})
+	close(results)
+
+	stored := 0
+	for err := range results {
+		if err == nil {
+			stored++
+			continue
+		}
+		expectError(t, "Concurrent Store duplicate", err, storage.ErrUserExists)
+	}
+
+	if stored != 1 {
+		t.Errorf("Exactly one user with the same email should be stored but was: %v", stored)
+	}
+
+	for i := 0; i < workers; i++ {
+		u, err := s.GetUserByLogin(fmt.Sprintf("user%d@gmail.com", i))
+		if err != nil {
+			t.Errorf("Concurrently stored user expected but got error: %v", err)
+			continue
+		}
+		if byId, err := s.GetUserById(u.Id.String()); err != nil || byId.Id != u.Id {
+			t.Errorf("Concurrently stored user expected by id but got [%v, %v]", byId, err)
+		}
+	}
+}
+
+func TestConcurrentSessions(t *testing.T, newStore Factory) {
+
+	s := newStore(t)
+
+	parallel(workers, func(i int) {
+		token := fmt.Sprintf("token%d", i)
+		s.StoreToken(token, "user")
+		s.UpdateToken(token, token+"-rotated", "user")
+		s.IsTokenPresent(token)
+		if i%2 == 0 {
+			s.DeleteToken(token + "-rotated")
+		}
+	})
+
+	for i := 0; i < workers; i++ {
+		token := fmt.Sprintf("token%d-rotated", i)
+		if present := s.IsTokenPresent(token); present != (i%2 == 1) {
+			t.Errorf("Token %v presence expected to be [%v] but was [%v]", token, i%2 == 1, present)
+		}
+	}
+}
+
+func TestConcurrentRefreshTokens(t *testing.T, newStore Factory) {
+
+	s := newStore(t)
+	s.StoreRefreshToken(model.RefreshToken{Id: "token", Family: "family", UserId: xid.New(), ExpiresAt: time.Now().Add(time.Hour)})
+
+	unused := make(chan bool, workers)
+
+	parallel(workers, func(i int) {
+		rt, err := s.UseRefreshToken("token")
+		if err != nil {
+			t.Errorf("Refresh token should be found but got error: %v", err)
+			return
+		}
+		unused <- !rt.Used
+	})
+	close(unused)
+
+	n := 0
+	for u := range unused {
+		if u {
+			n++
+		}
+	}
+
+	if n != 1 {
+		t.Errorf("Exactly one caller should get unused refresh token but was: %v", n)
+	}
+}
+
+func TestConcurrentLoginAttempts(t *testing.T, newStore Factory) {
+
+	s := newStore(t)
+	at := time.Now()
+
+	parallel(workers, func(i int) {
+		if _, err := s.AddLoginAttempt("ip:127.0.0.1", at, time.Minute); err != nil {
+			t.Errorf("Attempt should be counted but got error: %v", err)
+		}
+	})
+
+	if a, err := s.GetLoginAttempts("ip:127.0.0.1"); err != nil || a.Count != workers {
+		t.Errorf("Expected [%v] attempts but got [%v, %v]", workers, a, err)
+	}
+}
@@ -0,0 +1,198 @@
+// Package throttle slows down password guessing. Failed logins of an account delay the next attempt
+// exponentially and finally lock the account for a while, login requests from one address are limited
+// in fixed windows. Counters live in storage.LoginAttemptStore, so replicas sharing it share the limits
+package throttle
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"authService/config"
+	"authService/storage"
+)
+
+var (
+	ErrAccountLocked  = errors.New("Account is temporarily locked after too many failed logins")
+	ErrLoginDelayed   = errors.New("Too many failed logins, try again later")
+	ErrAddressLimited = errors.New("Too many login attempts from this address")
+)
+
+const (
+	DefaultMaxFailures = 5
+	DefaultBaseDelay   = time.Second
+	DefaultMaxDelay    = time.Minute
+	DefaultLockout     = 15 * time.Minute
+	DefaultWindow      = 15 * time.Minute
+	DefaultIPAttempts  = 100
+	DefaultIPWindow    = 15 * time.Minute
+)
+
+// pruneInterval is how often counters no limit looks at anymore are deleted
+const pruneInterval = time.Minute
+
+// pruned is shared by trackers, they are created per request
+var pruned struct {
+	sync.Mutex
+	last time.Time
+}
+
+// Tracker applies login limits of configuration to counters of the store
+type Tracker struct {
+	maxFailures int
+	baseDelay   time.Duration
+	maxDelay    time.Duration
+	lockout     time.Duration
+	window      time.Duration
+	ipAttempts  int
+	ipWindow    time.Duration
+	store       storage.LoginAttemptStore
+	now         func() time.Time
+}
+
+func NewTracker(c config.LoginThrottleConfig, store storage.LoginAttemptStore) *Tracker {
+	return &Tracker{
+		maxFailures: orInt(c.MaxFailures, DefaultMaxFailures),
+		baseDelay:   orDuration(c.BaseDelay.Duration, DefaultBaseDelay),
+		maxDelay:    orDuration(c.MaxDelay.Duration, DefaultMaxDelay),
+		lockout:     orDuration(c.Lockout.Duration, DefaultLockout),
+		window:      orDuration(c.Window.Duration, DefaultWindow),
+		ipAttempts:  orInt(c.IPAttempts, DefaultIPAttempts),
+		ipWindow:    orDuration(c.IPWindow.Duration, DefaultIPWindow),
+		store:       store,
+		now:         time.Now,
+	}
+}
+
+// AllowAddress counts login request from the address. Once the address is over its limit
+// it returns ErrAddressLimited and time left until the window ends
+func (t *Tracker) AllowAddress(ip string) (time.Duration, error) {
+
+	now := t.now()
+
+	a, err := t.store.AddLoginAttempt(addressKey(ip), now, t.ipWindow)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if a.Count > t.ipAttempts {
+		return a.Start.Add(t.ipWindow).Sub(now), ErrAddressLimited
+	}
+
+	return 0, nil
+}
+
+// AllowAccount tells whether password of the account may be checked now. It returns ErrAccountLocked
+// or ErrLoginDelayed and time to wait when the account has failed logins recently
+func (t *Tracker) AllowAccount(login string) (time.Duration, error) {
+
+	a, err := t.store.GetLoginAttempts(accountKey(login))
+
+	if err == storage.ErrLoginAttemptsNotFound {
+		return 0, nil
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	now := t.now()
+
+	// lockout is kept to its end even when the window it started in is over
+	if a.Count >= t.maxFailures {
+		if until := a.Last.Add(t.lockout); now.Before(until) {
+			return until.Sub(now), ErrAccountLocked
+		}
+		return 0, nil
+	}
+
+	if !now.Before(a.Start.Add(t.window)) {
+		return 0, nil
+	}
+
+	if until := a.Last.Add(t.delay(a.Count)); now.Before(until) {
+		return until.Sub(now), ErrLoginDelayed
+	}
+
+	return 0, nil
+}
+
+// Failed counts failed login of the account, logins of unknown accounts are counted too,
+// so responses don't tell which accounts exist
+func (t *Tracker) Failed(login string) error {
+	_, err := t.store.AddLoginAttempt(accountKey(login), t.now(), t.window)
+	return err
+}
+
+// Succeeded forgets failed logins of the account
+func (t *Tracker) Succeeded(login string) error {
+	return t.store.ResetLoginAttempts(accountKey(login))
+}
+
+// Prune deletes counters idle longer than every window and lockout, they count for nothing anymore.
+// Failed logins of unknown accounts are counted too, so without pruning counters would pile up.
+// Counters are pruned at most once per interval
+func (t *Tracker) Prune() error {
+
+	now := t.now()
+
+	pruned.Lock()
+	if now.Sub(pruned.last) < pruneInterval {
+		pruned.Unlock()
+		return nil
+	}
+	pruned.last = now
+	pruned.Unlock()
+
+	retention := t.lockout
+	for _, d := range []time.Duration{t.window, t.ipWindow} {
+		if d > retention {
+			retention = d
+		}
+	}
+
+	return t.store.PruneLoginAttempts(now.Add(-retention))
+}
+
+// delay after n failures doubles from base delay up to max delay. A single failure is a typo
+// more likely than a guess, so it is not delayed
+func (t *Tracker) delay(failures int) time.Duration {
+
+	if failures < 2 {
+		return 0
+	}
+
+	d := t.baseDelay
+	for i := 2; i < failures && d < t.maxDelay; i++ {
+		d *= 2
+	}
+
+	if d > t.maxDelay {
+		return t.maxDelay
+	}
+	return d
+}
+
+func accountKey(login string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(login))
+}
+
+func addressKey(ip string) string {
+	return "ip:" + ip
+}
+
+func orInt(v, def int) int {
+	if v > 0 {
+		return v
+	}
+	return def
+}
+
+func orDuration(v, def time.Duration) time.Duration {
+	if v > 0 {
+		return v
+	}
+	return def
+}
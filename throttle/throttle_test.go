@@ -0,0 +1,181 @@
+package throttle
+
+import (
+	"testing"
+	"time"
+
+	"authService/config"
+	"authService/storage"
+)
+
+type clock struct {
+	t time.Time
+}
+
+func (c *clock) now() time.Time {
+	return c.t
+}
+
+func newTestTracker(c config.LoginThrottleConfig) (*Tracker, *clock) {
+	clk := &clock{t: time.Date(2019, 1, 1, 10, 0, 0, 0, time.UTC)}
+	t := NewTracker(c, storage.NewMemoryStore())
+	t.now = clk.now
+	return t, clk
+}
+
+func expectAllowed(t *testing.T, action string, wait time.Duration, err, expectedErr error, expectedWait time.Duration) {
+	t.Helper()
+	if err != expectedErr || wait != expectedWait {
+		t.Errorf("%v: expected [%v, %v] but was: [%v, %v]", action, expectedWait, expectedErr, wait, err)
+	}
+}
+
+func TestTracker_Backoff(t *testing.T) {
+
+	tr, clk := newTestTracker(config.LoginThrottleConfig{MaxFailures: 10, BaseDelay: config.Duration{Duration: time.Second}, MaxDelay: config.Duration{Duration: 5 * time.Second}})
+
+	wait, err := tr.AllowAccount("user@gmail.com")
+	expectAllowed(t, "No failures", wait, err, nil, 0)
+
+	tr.Failed("user@gmail.com")
+
+	wait, err = tr.AllowAccount("user@gmail.com")
+	expectAllowed(t, "Single failure", wait, err, nil, 0)
+
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+
+	for i, delay := range expected {
+		tr.Failed("user@gmail.com")
+
+		wait, err := tr.AllowAccount("User@Gmail.com ")
+		expectAllowed(t, "Right after failure", wait, err, ErrLoginDelayed, delay)
+
+		clk.t = clk.t.Add(delay / 2)
+		wait, err = tr.AllowAccount("user@gmail.com")
+		expectAllowed(t, "In the middle of delay", wait, err, ErrLoginDelayed, delay-delay/2)
+
+		clk.t = clk.t.Add(delay - delay/2)
+		wait, err = tr.AllowAccount("user@gmail.com")
+		expectAllowed(t, "After delay "+expected[i].String(), wait, err, nil, 0)
+	}
+
+	wait, err = tr.AllowAccount("other@gmail.com")
+	expectAllowed(t, "Other account", wait, err, nil, 0)
+
+	tr.Succeeded("user@gmail.com")
+	tr.Failed("user@gmail.com")
+	tr.Failed("user@gmail.com")
+
+	wait, err = tr.AllowAccount("user@gmail.com")
+	expectAllowed(t, "Failures after success", wait, err, ErrLoginDelayed, time.Second)
+}
+
+func TestTracker_Lockout(t *testing.T) {
+
+	tr, clk := newTestTracker(config.LoginThrottleConfig{MaxFailures: 3, BaseDelay: config.Duration{Duration: time.Second}, Lockout: config.Duration{Duration: time.Hour}, Window: config.Duration{Duration: 10 * time.Minute}})
+
+	for i := 0; i < 3; i++ {
+		tr.Failed("user@gmail.com")
+		clk.t = clk.t.Add(time.Minute)
+	}
+
+	wait, err := tr.AllowAccount("user@gmail.com")
+	expectAllowed(t, "Locked account", wait, err, ErrAccountLocked, 59*time.Minute)
+
+	// lockout outlives window of failures which caused it
+	clk.t = clk.t.Add(30 * time.Minute)
+	wait, err = tr.AllowAccount("user@gmail.com")
+	expectAllowed(t, "Locked account after window", wait, err, ErrAccountLocked, 29*time.Minute)
+
+	clk.t = clk.t.Add(29 * time.Minute)
+	wait, err = tr.AllowAccount("user@gmail.com")
+	expectAllowed(t, "After lockout", wait, err, nil, 0)
+
+	tr.Failed("user@gmail.com")
+	tr.Failed("user@gmail.com")
+	wait, err = tr.AllowAccount("user@gmail.com")
+	expectAllowed(t, "Failures after lockout", wait, err, ErrLoginDelayed, time.Second)
+}
+
+func TestTracker_FailuresAreForgottenAfterWindow(t *testing.T) {
+
+	tr, clk := newTestTracker(config.LoginThrottleConfig{MaxFailures: 3, BaseDelay: config.Duration{Duration: time.Second}, Window: config.Duration{Duration: 10 * time.Minute}})
+
+	tr.Failed("user@gmail.com")
+	tr.Failed("user@gmail.com")
+
+	clk.t = clk.t.Add(10 * time.Minute)
+	tr.Failed("user@gmail.com")
+	tr.Failed("user@gmail.com")
+
+	wait, err := tr.AllowAccount("user@gmail.com")
+	expectAllowed(t, "Failures in new window", wait, err, ErrLoginDelayed, time.Second)
+}
+
+func TestTracker_AllowAddress(t *testing.T) {
+
+	tr, clk := newTestTracker(config.LoginThrottleConfig{IPAttempts: 2, IPWindow: config.Duration{Duration: time.Minute}})
+
+	for i := 0; i < 2; i++ {
+		wait, err := tr.AllowAddress("10.0.0.1")
+		expectAllowed(t, "Attempt in limit", wait, err, nil, 0)
+	}
+
+	clk.t = clk.t.Add(20 * time.Second)
+
+	wait, err := tr.AllowAddress("10.0.0.1")
+	expectAllowed(t, "Attempt over limit", wait, err, ErrAddressLimited, 40*time.Second)
+
+	wait, err = tr.AllowAddress("10.0.0.2")
+	expectAllowed(t, "Attempt from other address", wait, err, nil, 0)
+
+	clk.t = clk.t.Add(40 * time.Second)
+
+	wait, err = tr.AllowAddress("10.0.0.1")
+	expectAllowed(t, "Attempt in new window", wait, err, nil, 0)
+}
+
+func TestTracker_Prune(t *testing.T) {
+
+	thirtySeconds := config.Duration{Duration: 30 * time.Second}
+	tr, clk := newTestTracker(config.LoginThrottleConfig{Lockout: thirtySeconds, Window: thirtySeconds, IPWindow: thirtySeconds})
+	pruned.last = time.Time{}
+
+	counted := func(login string) bool {
+		_, err := tr.store.GetLoginAttempts(accountKey(login))
+		return err == nil
+	}
+
+	tr.Failed("stale@gmail.com")
+
+	clk.t = clk.t.Add(40 * time.Second)
+	tr.Failed("recent@gmail.com")
+
+	if err := tr.Prune(); err != nil || counted("stale@gmail.com") || !counted("recent@gmail.com") {
+		t.Errorf("Only counters idle past every limit mast be pruned but got [%v, %v, %v]", err, counted("stale@gmail.com"), counted("recent@gmail.com"))
+	}
+
+	clk.t = clk.t.Add(40 * time.Second)
+	tr.Prune()
+
+	if !counted("recent@gmail.com") {
+		t.Errorf("Counters mast not be pruned again within prune interval")
+	}
+
+	clk.t = clk.t.Add(20 * time.Second)
+	tr.Prune()
+
+	if counted("recent@gmail.com") {
+		t.Errorf("Counters mast be pruned once prune interval passes")
+	}
+}
+
+func TestNewTracker_Defaults(t *testing.T) {
+
+	tr := NewTracker(config.LoginThrottleConfig{}, storage.NewMemoryStore())
+
+	if tr.maxFailures != DefaultMaxFailures || tr.baseDelay != DefaultBaseDelay || tr.maxDelay != DefaultMaxDelay || tr.lockout != DefaultLockout ||
+		tr.window != DefaultWindow || tr.ipAttempts != DefaultIPAttempts || tr.ipWindow != DefaultIPWindow {
+		t.Errorf("Expected default limits but was: %+v", tr)
+	}
+}
@@ -0,0 +1,117 @@
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Parameters are the defaults of RFC 6238 which every authenticator app supports
+const (
+	Digits = 6
+	Period = 30 * time.Second
+
+	secretLength = 20
+)
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewSecret generates random base32 encoded key shared with authenticator app
+func NewSecret() (string, error) {
+
+	b := make([]byte, secretLength)
+
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "Can't generate TOTP secret")
+	}
+
+	return encoding.EncodeToString(b), nil
+}
+
+// Step returns number of the time step t belongs to
+func Step(t time.Time) int64 {
+	return t.Unix() / int64(Period/time.Second)
+}
+
+// Code returns one-time password for the time step
+func Code(secret string, step int64) (string, error) {
+
+	key, err := encoding.DecodeString(strings.ToUpper(secret))
+
+	if err != nil {
+		return "", errors.Wrap(err, "TOTP secret is malformed")
+	}
+
+	return hotp(key, step, Digits), nil
+}
+
+// Validate returns time step the code was generated for. Codes of one step before and after t
+// are accepted too, so clocks of user's device and the service may drift apart a little
+func Validate(secret, code string, t time.Time) (int64, bool) {
+
+	if len(code) != Digits {
+		return 0, false
+	}
+
+	step := Step(t)
+
+	for _, s := range []int64{step, step - 1, step + 1} {
+		c, err := Code(secret, s)
+
+		if err != nil {
+			return 0, false
+		}
+
+		if subtle.ConstantTimeCompare([]byte(c), []byte(code)) == 1 {
+			return s, true
+		}
+	}
+
+	return 0, false
+}
+
+// ProvisioningURI returns otpauth URI authenticator apps read from QR code, account is shown under issuer name
+func ProvisioningURI(issuer, account, secret string) string {
+
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", issuer)
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", fmt.Sprint(Digits))
+	q.Set("period", fmt.Sprint(int(Period/time.Second)))
+
+	label := url.PathEscape(issuer + ":" + account)
+
+	// some authenticator apps don't decode "+" in query as a space
+	return "otpauth://totp/" + label + "?" + strings.Replace(q.Encode(), "+", "%20", -1)
+}
+
+// hotp is HMAC-based one-time password of RFC 4226
+func hotp(key []byte, counter int64, digits int) string {
+
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(counter))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < digits; i++ {
+		mod *= 10
+	}
+
+	return fmt.Sprintf("%0*d", digits, value%mod)
+}
@@ -0,0 +1,104 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// test vectors of RFC 6238 appendix B for SHA1
+func TestHotpRFC6238(t *testing.T) {
+
+	key := []byte("12345678901234567890")
+
+	tests := []struct {
+		time     int64
+		expected string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+
+	for _, tc := range tests {
+		if code := hotp(key, Step(time.Unix(tc.time, 0)), 8); code != tc.expected {
+			t.Errorf("Expected [%v] but was: [%v] for time %v", tc.expected, code, tc.time)
+		}
+	}
+}
+
+func TestCode(t *testing.T) {
+
+	secret := encoding.EncodeToString([]byte("12345678901234567890"))
+
+	code, err := Code(strings.ToLower(secret), Step(time.Unix(59, 0)))
+
+	if err != nil {
+		t.Fatalf("Code should be generated but got error: %v", err)
+	}
+
+	if code != "287082" {
+		t.Errorf("Expected [%v] but was: [%v]", "287082", code)
+	}
+
+	if _, err := Code("not base32!", 1); err == nil {
+		t.Errorf("Malformed secret mast be rejected")
+	}
+}
+
+func TestValidate(t *testing.T) {
+
+	secret, err := NewSecret()
+
+	if err != nil {
+		t.Fatalf("Secret should be generated but got error: %v", err)
+	}
+
+	now := time.Now()
+	step := Step(now)
+
+	tests := []struct {
+		description string
+		step        int64
+		valid       bool
+	}{
+		{"Should accept code of current step", step, true},
+		{"Should accept code of previous step", step - 1, true},
+		{"Should accept code of next step", step + 1, true},
+		{"Should reject code two steps old", step - 2, false},
+		{"Should reject code two steps ahead", step + 2, false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			code, _ := Code(secret, tc.step)
+
+			s, ok := Validate(secret, code, now)
+
+			if ok != tc.valid {
+				t.Fatalf("Expected [%v] but was: [%v]", tc.valid, ok)
+			}
+
+			if ok && s != tc.step {
+				t.Errorf("Expected step [%v] but was: [%v]", tc.step, s)
+			}
+		})
+	}
+
+	if _, ok := Validate(secret, "12345", now); ok {
+		t.Errorf("Code of wrong length mast be rejected")
+	}
+}
+
+func TestProvisioningURI(t *testing.T) {
+
+	uri := ProvisioningURI("Auth Service", "user@gmail.com", "JBSWY3DPEHPK3PXP")
+	expected := "otpauth://totp/Auth%20Service:user@gmail.com?algorithm=SHA1&digits=6&issuer=Auth%20Service&period=30&secret=JBSWY3DPEHPK3PXP"
+
+	if uri != expected {
+		t.Errorf("Expected [%v] but was: [%v]", expected, uri)
+	}
+}
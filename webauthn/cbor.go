@@ -0,0 +1,129 @@
+package webauthn
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/pkg/errors"
+)
+
+const maxCBORDepth = 16
+
+var errMalformedCBOR = errors.New("Malformed CBOR")
+
+// decodeCBOR reads one data item of RFC 8949 and returns it with the rest of input. Only what authenticators
+// send is supported: integers, byte and text strings, arrays, maps and simple values of definite length.
+// Integers are int64, maps are map[interface{}]interface{}
+func decodeCBOR(b []byte) (interface{}, []byte, error) {
+	return decodeItem(b, 0)
+}
+
+func decodeItem(b []byte, depth int) (interface{}, []byte, error) {
+
+	if depth > maxCBORDepth || len(b) == 0 {
+		return nil, nil, errMalformedCBOR
+	}
+
+	major, info := b[0]>>5, b[0]&0x1f
+
+	if major == 7 {
+		switch info {
+		case 20:
+			return false, b[1:], nil
+		case 21:
+			return true, b[1:], nil
+		case 22, 23:
+			return nil, b[1:], nil
+		}
+		return nil, nil, errors.Errorf("Unsupported CBOR simple value %d", info)
+	}
+
+	arg, b, err := decodeArgument(info, b[1:])
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch major {
+	case 0:
+		if arg > math.MaxInt64 {
+			return nil, nil, errMalformedCBOR
+		}
+		return int64(arg), b, nil
+	case 1:
+		if arg > math.MaxInt64 {
+			return nil, nil, errMalformedCBOR
+		}
+		return -1 - int64(arg), b, nil
+	case 2, 3:
+		if arg > uint64(len(b)) {
+			return nil, nil, errMalformedCBOR
+		}
+		if major == 3 {
+			return string(b[:arg]), b[arg:], nil
+		}
+		return append([]byte(nil), b[:arg]...), b[arg:], nil
+	case 4:
+		// every item takes at least a byte, longer arrays can't be in the input
+		if arg > uint64(len(b)) {
+			return nil, nil, errMalformedCBOR
+		}
+		items := make([]interface{}, arg)
+		for i := range items {
+			if items[i], b, err = decodeItem(b, depth+1); err != nil {
+				return nil, nil, err
+			}
+		}
+		return items, b, nil
+	case 5:
+		if arg > uint64(len(b))/2 {
+			return nil, nil, errMalformedCBOR
+		}
+		m := make(map[interface{}]interface{}, arg)
+		for i := uint64(0); i < arg; i++ {
+			var k, v interface{}
+			if k, b, err = decodeItem(b, depth+1); err != nil {
+				return nil, nil, err
+			}
+			switch k.(type) {
+			case int64, string:
+			default:
+				return nil, nil, errors.New("Unsupported CBOR map key")
+			}
+			if v, b, err = decodeItem(b, depth+1); err != nil {
+				return nil, nil, err
+			}
+			m[k] = v
+		}
+		return m, b, nil
+	}
+
+	return nil, nil, errors.Errorf("Unsupported CBOR major type %d", major)
+}
+
+func decodeArgument(info byte, b []byte) (uint64, []byte, error) {
+
+	if info < 24 {
+		return uint64(info), b, nil
+	}
+
+	if info > 27 {
+		return 0, nil, errors.New("Indefinite length CBOR is not supported")
+	}
+
+	n := 1 << (info - 24)
+
+	if len(b) < n {
+		return 0, nil, errMalformedCBOR
+	}
+
+	switch n {
+	case 1:
+		return uint64(b[0]), b[1:], nil
+	case 2:
+		return uint64(binary.BigEndian.Uint16(b)), b[2:], nil
+	case 4:
+		return uint64(binary.BigEndian.Uint32(b)), b[4:], nil
+	}
+	return binary.BigEndian.Uint64(b), b[8:], nil
+}
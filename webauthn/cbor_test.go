@@ -0,0 +1,95 @@
+package webauthn
+
+import (
+	"encoding/hex"
+	"reflect"
+	"testing"
+)
+
+// examples of RFC 8949 appendix A
+func TestDecodeCBOR(t *testing.T) {
+
+	tests := []struct {
+		encoded  string
+		expected interface{}
+	}{
+		{"00", int64(0)},
+		{"17", int64(23)},
+		{"1818", int64(24)},
+		{"1903e8", int64(1000)},
+		{"1a000f4240", int64(1000000)},
+		{"1b000000e8d4a51000", int64(1000000000000)},
+		{"20", int64(-1)},
+		{"3903e7", int64(-1000)},
+		{"f4", false},
+		{"f5", true},
+		{"f6", nil},
+		{"4401020304", []byte{1, 2, 3, 4}},
+		{"6449455446", "IETF"},
+		{"83010203", []interface{}{int64(1), int64(2), int64(3)}},
+		{"a201020304", map[interface{}]interface{}{int64(1): int64(2), int64(3): int64(4)}},
+		{"a26161016162820203", map[interface{}]interface{}{"a": int64(1), "b": []interface{}{int64(2), int64(3)}}},
+	}
+
+	for _, tc := range tests {
+		b, _ := hex.DecodeString(tc.encoded)
+
+		v, rest, err := decodeCBOR(b)
+
+		if err != nil || len(rest) != 0 {
+			t.Errorf("%v should be decoded but got error: %v, rest: %v", tc.encoded, err, rest)
+			continue
+		}
+
+		if !reflect.DeepEqual(v, tc.expected) {
+			t.Errorf("Expected [%#v] but was: [%#v]", tc.expected, v)
+		}
+	}
+}
+
+func TestDecodeCBOR_Rest(t *testing.T) {
+
+	v, rest, err := decodeCBOR([]byte{0x01, 0xff})
+
+	if err != nil || v != int64(1) || !reflect.DeepEqual(rest, []byte{0xff}) {
+		t.Errorf("Item and rest of input expected but got [%v, %v, %v]", v, rest, err)
+	}
+}
+
+func TestDecodeCBOR_Malformed(t *testing.T) {
+
+	tests := []struct {
+		description string
+		encoded     string
+	}{
+		{"Should reject empty input", ""},
+		{"Should reject truncated argument", "19 03"},
+		{"Should reject byte string longer than input", "45 01020304"},
+		{"Should reject array longer than input", "9a ffffffff"},
+		{"Should reject indefinite length", "5f 4101 ff"},
+		{"Should reject float", "f9 3c00"},
+		{"Should reject too large integer", "1b ffffffffffffffff"},
+		{"Should reject array map key", "a1 80 01"},
+		{"Should reject too deep nesting", "818181818181818181818181818181818181 00"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			b, _ := hex.DecodeString(stripSpaces(tc.encoded))
+
+			if v, _, err := decodeCBOR(b); err == nil {
+				t.Errorf("Error expected but got: %v", v)
+			}
+		})
+	}
+}
+
+func stripSpaces(s string) string {
+	r := []byte{}
+	for i := 0; i < len(s); i++ {
+		if s[i] != ' ' {
+			r = append(r, s[i])
+		}
+	}
+	return string(r)
+}
@@ -0,0 +1,88 @@
+package webauthn
+
+// Options and responses below are JSON forms of WebAuthn Level 3 dictionaries,
+// browsers read and produce them with PublicKeyCredential.parse*OptionsFromJSON and toJSON
+
+type RelyingPartyEntity struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type UserEntity struct {
+	ID          URLEncodedBytes `json:"id"`
+	Name        string          `json:"name"`
+	DisplayName string          `json:"displayName"`
+}
+
+type CredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+type CredentialDescriptor struct {
+	Type string          `json:"type"`
+	ID   URLEncodedBytes `json:"id"`
+}
+
+type AuthenticatorSelection struct {
+	ResidentKey      string `json:"residentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+// CreationOptions start registration ceremony
+type CreationOptions struct {
+	Challenge              string                 `json:"challenge"`
+	RP                     RelyingPartyEntity     `json:"rp"`
+	User                   UserEntity             `json:"user"`
+	PubKeyCredParams       []CredentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int                    `json:"timeout"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection AuthenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                 `json:"attestation"`
+}
+
+// RequestOptions start authentication ceremony, AllowCredentials is empty for discoverable credentials
+type RequestOptions struct {
+	Challenge        string                 `json:"challenge"`
+	Timeout          int                    `json:"timeout"`
+	RPID             string                 `json:"rpId"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+// NewCredentialParameters lists accepted key algorithms in order of preference
+func NewCredentialParameters() []CredentialParameter {
+	return []CredentialParameter{
+		{Type: "public-key", Alg: AlgES256},
+		{Type: "public-key", Alg: AlgEdDSA},
+		{Type: "public-key", Alg: AlgRS256},
+	}
+}
+
+type AttestationResponse struct {
+	ClientDataJSON    URLEncodedBytes `json:"clientDataJSON"`
+	AttestationObject URLEncodedBytes `json:"attestationObject"`
+}
+
+// RegistrationResponse is a new credential returned by navigator.credentials.create
+type RegistrationResponse struct {
+	ID       string              `json:"id"`
+	RawID    URLEncodedBytes     `json:"rawId"`
+	Type     string              `json:"type"`
+	Response AttestationResponse `json:"response"`
+}
+
+type AssertionResponse struct {
+	ClientDataJSON    URLEncodedBytes `json:"clientDataJSON"`
+	AuthenticatorData URLEncodedBytes `json:"authenticatorData"`
+	Signature         URLEncodedBytes `json:"signature"`
+	UserHandle        URLEncodedBytes `json:"userHandle,omitempty"`
+}
+
+// AuthenticationResponse is an assertion returned by navigator.credentials.get
+type AuthenticationResponse struct {
+	ID       string            `json:"id"`
+	RawID    URLEncodedBytes   `json:"rawId"`
+	Type     string            `json:"type"`
+	Response AssertionResponse `json:"response"`
+}
@@ -0,0 +1,320 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"math/big"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Flags of authenticator data
+const (
+	FlagUserPresent            byte = 0x01
+	FlagUserVerified           byte = 0x04
+	FlagAttestedCredentialData byte = 0x40
+	FlagExtensionData          byte = 0x80
+)
+
+// COSE algorithms of credential keys which are accepted
+const (
+	AlgES256 = -7
+	AlgEdDSA = -8
+	AlgRS256 = -257
+)
+
+// Ceremony types stamped on client data by browser
+const (
+	TypeCreate = "webauthn.create"
+	TypeGet    = "webauthn.get"
+)
+
+var (
+	ErrUnsupportedKey         = errors.New("Credential key algorithm is not supported")
+	ErrUnsupportedAttestation = errors.New("Only none attestation is supported")
+	ErrInvalidSignature       = errors.New("Assertion signature is invalid")
+)
+
+// URLEncodedBytes is binary field of WebAuthn JSON, it is base64url encoded with or without padding
+type URLEncodedBytes []byte
+
+func (b URLEncodedBytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal(base64.RawURLEncoding.EncodeToString(b))
+}
+
+func (b *URLEncodedBytes) UnmarshalJSON(data []byte) error {
+
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+
+	v, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return err
+	}
+
+	*b = v
+	return nil
+}
+
+// RelyingParty is the service credentials are registered with. ID is a domain credentials are scoped to
+// and Origins are pages which may run ceremonies
+type RelyingParty struct {
+	ID      string
+	Name    string
+	Origins []string
+}
+
+// CollectedClientData is what browser signs together with authenticator data
+type CollectedClientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+// ParseClientData reads client data of the ceremony type, challenge is left for caller to check
+func ParseClientData(raw []byte, ceremony string) (*CollectedClientData, error) {
+
+	c := &CollectedClientData{}
+
+	if err := json.Unmarshal(raw, c); err != nil {
+		return nil, errors.Wrap(err, "Client data is malformed")
+	}
+
+	if c.Type != ceremony {
+		return nil, errors.Errorf("Client data of %v ceremony expected", ceremony)
+	}
+
+	if c.Challenge == "" {
+		return nil, errors.New("Client data has no challenge")
+	}
+
+	return c, nil
+}
+
+// VerifyOrigin checks the ceremony was run by one of relying party pages
+func (rp RelyingParty) VerifyOrigin(c *CollectedClientData) error {
+
+	if c.CrossOrigin {
+		return errors.New("Cross origin ceremony is not allowed")
+	}
+
+	for _, o := range rp.Origins {
+		if c.Origin == o {
+			return nil
+		}
+	}
+
+	return errors.Errorf("Origin %v is not allowed", c.Origin)
+}
+
+// VerifyAuthenticatorData checks data was made for the relying party with user present
+func (rp RelyingParty) VerifyAuthenticatorData(d *AuthenticatorData) error {
+
+	hash := sha256.Sum256([]byte(rp.ID))
+
+	if !bytes.Equal(d.RPIDHash, hash[:]) {
+		return errors.New("Authenticator data is made for other relying party")
+	}
+
+	if d.Flags&FlagUserPresent == 0 {
+		return errors.New("User presence is required")
+	}
+
+	return nil
+}
+
+// AuthenticatorData is of WebAuthn 6.1, credential fields are set for registration only
+type AuthenticatorData struct {
+	RPIDHash     []byte
+	Flags        byte
+	SignCount    uint32
+	CredentialId []byte
+	PublicKey    []byte
+}
+
+// ParseAuthenticatorData reads authenticator data, PublicKey is left COSE encoded
+func ParseAuthenticatorData(b []byte) (*AuthenticatorData, error) {
+
+	if len(b) < 37 {
+		return nil, errors.New("Authenticator data is too short")
+	}
+
+	d := &AuthenticatorData{
+		RPIDHash:  append([]byte(nil), b[:32]...),
+		Flags:     b[32],
+		SignCount: binary.BigEndian.Uint32(b[33:37]),
+	}
+
+	rest := b[37:]
+
+	if d.Flags&FlagAttestedCredentialData != 0 {
+
+		// AAGUID and length of credential id
+		if len(rest) < 18 {
+			return nil, errors.New("Attested credential data is too short")
+		}
+
+		n := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+
+		if n == 0 || len(rest) < n {
+			return nil, errors.New("Credential id is malformed")
+		}
+
+		d.CredentialId = append([]byte(nil), rest[:n]...)
+		rest = rest[n:]
+
+		_, after, err := decodeCBOR(rest)
+
+		if err != nil {
+			return nil, errors.Wrap(err, "Credential public key is malformed")
+		}
+
+		d.PublicKey = append([]byte(nil), rest[:len(rest)-len(after)]...)
+		rest = after
+	}
+
+	if d.Flags&FlagExtensionData != 0 {
+		var err error
+		if _, rest, err = decodeCBOR(rest); err != nil {
+			return nil, errors.Wrap(err, "Extension data is malformed")
+		}
+	}
+
+	if len(rest) != 0 {
+		return nil, errors.New("Authenticator data has trailing bytes")
+	}
+
+	return d, nil
+}
+
+// ParseAttestationObject returns authenticator data of a new credential. Service does not rely on
+// authenticator make and model, so "none" attestation is the only one accepted
+func ParseAttestationObject(b []byte) (*AuthenticatorData, error) {
+
+	v, rest, err := decodeCBOR(b)
+
+	if err != nil || len(rest) != 0 {
+		return nil, errors.New("Attestation object is malformed")
+	}
+
+	m, _ := v.(map[interface{}]interface{})
+	format, _ := m["fmt"].(string)
+	statement, _ := m["attStmt"].(map[interface{}]interface{})
+	raw, _ := m["authData"].([]byte)
+
+	if format != "none" || len(statement) != 0 {
+		return nil, ErrUnsupportedAttestation
+	}
+
+	d, err := ParseAuthenticatorData(raw)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if d.CredentialId == nil {
+		return nil, errors.New("Attestation has no credential")
+	}
+
+	return d, nil
+}
+
+// ParsePublicKey decodes COSE key of RFC 8152 and returns it with its algorithm
+func ParsePublicKey(cose []byte) (crypto.PublicKey, int, error) {
+
+	v, rest, err := decodeCBOR(cose)
+
+	m, ok := v.(map[interface{}]interface{})
+
+	if err != nil || len(rest) != 0 || !ok {
+		return nil, 0, errors.New("Credential public key is malformed")
+	}
+
+	kty, _ := m[int64(1)].(int64)
+	alg, _ := m[int64(3)].(int64)
+
+	switch {
+	case kty == 2 && alg == AlgES256:
+		crv, _ := m[int64(-1)].(int64)
+		x, _ := m[int64(-2)].([]byte)
+		y, _ := m[int64(-3)].([]byte)
+
+		if crv != 1 || len(x) != 32 || len(y) != 32 {
+			return nil, 0, ErrUnsupportedKey
+		}
+
+		k := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+
+		if !k.Curve.IsOnCurve(k.X, k.Y) {
+			return nil, 0, errors.New("Credential public key is not on curve")
+		}
+
+		return k, AlgES256, nil
+
+	case kty == 1 && alg == AlgEdDSA:
+		crv, _ := m[int64(-1)].(int64)
+		x, _ := m[int64(-2)].([]byte)
+
+		if crv != 6 || len(x) != ed25519.PublicKeySize {
+			return nil, 0, ErrUnsupportedKey
+		}
+
+		return ed25519.PublicKey(x), AlgEdDSA, nil
+
+	case kty == 3 && alg == AlgRS256:
+		n, _ := m[int64(-1)].([]byte)
+		e, _ := m[int64(-2)].([]byte)
+
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, 0, ErrUnsupportedKey
+		}
+
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, AlgRS256, nil
+	}
+
+	return nil, 0, ErrUnsupportedKey
+}
+
+// VerifyAssertion checks signature of authenticator data and client data made with the credential key
+func VerifyAssertion(cose, authenticatorData, clientData, signature []byte) error {
+
+	key, _, err := ParsePublicKey(cose)
+
+	if err != nil {
+		return err
+	}
+
+	clientHash := sha256.Sum256(clientData)
+	signed := append(append([]byte(nil), authenticatorData...), clientHash[:]...)
+	digest := sha256.Sum256(signed)
+
+	ok := false
+
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		ok = ecdsa.VerifyASN1(k, digest[:], signature)
+	case ed25519.PublicKey:
+		ok = ed25519.Verify(k, signed, signature)
+	case *rsa.PublicKey:
+		ok = rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], signature) == nil
+	}
+
+	if !ok {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
@@ -0,0 +1,203 @@
+package webauthn_test
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"testing"
+
+	"authService/webauthn"
+	"authService/webauthn/webauthntest"
+)
+
+var rp = webauthn.RelyingParty{ID: "example.com", Name: "Example", Origins: []string{"https://auth.example.com"}}
+
+func TestRegistration(t *testing.T) {
+
+	a := webauthntest.New("example.com", "https://auth.example.com")
+	res := a.Register(webauthn.CreationOptions{Challenge: "challenge", User: webauthn.UserEntity{ID: []byte("user")}})
+
+	c, err := webauthn.ParseClientData(res.Response.ClientDataJSON, webauthn.TypeCreate)
+
+	if err != nil || c.Challenge != "challenge" {
+		t.Fatalf("Client data with challenge expected but got [%v, %v]", c, err)
+	}
+
+	if err := rp.VerifyOrigin(c); err != nil {
+		t.Errorf("Origin should be allowed but got error: %v", err)
+	}
+
+	if _, err := webauthn.ParseClientData(res.Response.ClientDataJSON, webauthn.TypeGet); err == nil {
+		t.Errorf("Client data of other ceremony mast be rejected")
+	}
+
+	d, err := webauthn.ParseAttestationObject(res.Response.AttestationObject)
+
+	if err != nil {
+		t.Fatalf("Attestation should be parsed but got error: %v", err)
+	}
+
+	if err := rp.VerifyAuthenticatorData(d); err != nil {
+		t.Errorf("Authenticator data should be valid but got error: %v", err)
+	}
+
+	if string(d.CredentialId) != string(a.CredentialId) || string(d.PublicKey) != string(a.PublicKey()) {
+		t.Errorf("Credential [%v] expected but was: [%v]", a.CredentialId, d.CredentialId)
+	}
+
+	if _, alg, err := webauthn.ParsePublicKey(d.PublicKey); err != nil || alg != webauthn.AlgES256 {
+		t.Errorf("ES256 key expected but got [%v, %v]", alg, err)
+	}
+}
+
+func TestParseAttestationObject_Format(t *testing.T) {
+
+	a := webauthntest.New("example.com", "https://auth.example.com")
+
+	packed := webauthntest.EncodeCBOR(map[string]interface{}{
+		"fmt":      "packed",
+		"attStmt":  map[string]interface{}{"alg": -7, "sig": []byte{1}},
+		"authData": a.AuthenticatorData(true),
+	})
+
+	if _, err := webauthn.ParseAttestationObject(packed); err != webauthn.ErrUnsupportedAttestation {
+		t.Errorf("Expected [%v] but was: [%v]", webauthn.ErrUnsupportedAttestation, err)
+	}
+
+	assertion := webauthntest.EncodeCBOR(map[string]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[string]interface{}{},
+		"authData": a.AuthenticatorData(false),
+	})
+
+	if _, err := webauthn.ParseAttestationObject(assertion); err == nil {
+		t.Errorf("Attestation without credential mast be rejected")
+	}
+}
+
+func TestParseAuthenticatorData(t *testing.T) {
+
+	a := webauthntest.New("example.com", "https://auth.example.com")
+	a.SignCount = 7
+
+	d, err := webauthn.ParseAuthenticatorData(a.AuthenticatorData(false))
+
+	if err != nil {
+		t.Fatalf("Authenticator data should be parsed but got error: %v", err)
+	}
+
+	hash := sha256.Sum256([]byte("example.com"))
+
+	if string(d.RPIDHash) != string(hash[:]) || d.SignCount != 7 || d.Flags != a.Flags || d.CredentialId != nil {
+		t.Errorf("Unexpected authenticator data: %v", d)
+	}
+
+	if _, err := webauthn.ParseAuthenticatorData(append(a.AuthenticatorData(false), 0)); err == nil {
+		t.Errorf("Trailing bytes mast be rejected")
+	}
+
+	if _, err := webauthn.ParseAuthenticatorData(a.AuthenticatorData(false)[:36]); err == nil {
+		t.Errorf("Truncated data mast be rejected")
+	}
+}
+
+func TestVerifyAuthenticatorData(t *testing.T) {
+
+	a := webauthntest.New("other.com", "https://auth.example.com")
+	d, _ := webauthn.ParseAuthenticatorData(a.AuthenticatorData(false))
+
+	if err := rp.VerifyAuthenticatorData(d); err == nil {
+		t.Errorf("Data of other relying party mast be rejected")
+	}
+
+	a = webauthntest.New("example.com", "https://auth.example.com")
+	a.Flags = webauthn.FlagUserVerified
+	d, _ = webauthn.ParseAuthenticatorData(a.AuthenticatorData(false))
+
+	if err := rp.VerifyAuthenticatorData(d); err == nil {
+		t.Errorf("Data without user presence mast be rejected")
+	}
+}
+
+func TestVerifyOrigin(t *testing.T) {
+
+	tests := []struct {
+		description string
+		clientData  webauthn.CollectedClientData
+		valid       bool
+	}{
+		{"Should accept allowed origin", webauthn.CollectedClientData{Origin: "https://auth.example.com"}, true},
+		{"Should reject other origin", webauthn.CollectedClientData{Origin: "https://evil.com"}, false},
+		{"Should reject cross origin ceremony", webauthn.CollectedClientData{Origin: "https://auth.example.com", CrossOrigin: true}, false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			if err := rp.VerifyOrigin(&tc.clientData); (err == nil) != tc.valid {
+				t.Errorf("Expected valid [%v] but got error: %v", tc.valid, err)
+			}
+		})
+	}
+}
+
+func TestVerifyAssertion(t *testing.T) {
+
+	a := webauthntest.New("example.com", "https://auth.example.com")
+	res := a.Login(webauthn.RequestOptions{Challenge: "challenge"})
+	r := res.Response
+
+	if err := webauthn.VerifyAssertion(a.PublicKey(), r.AuthenticatorData, r.ClientDataJSON, r.Signature); err != nil {
+		t.Errorf("Assertion should be valid but got error: %v", err)
+	}
+
+	tampered := append([]byte(nil), r.ClientDataJSON...)
+	tampered[len(tampered)-2] = 'x'
+
+	if err := webauthn.VerifyAssertion(a.PublicKey(), r.AuthenticatorData, tampered, r.Signature); err != webauthn.ErrInvalidSignature {
+		t.Errorf("Expected [%v] but was: [%v]", webauthn.ErrInvalidSignature, err)
+	}
+
+	other := webauthntest.New("example.com", "https://auth.example.com")
+
+	if err := webauthn.VerifyAssertion(other.PublicKey(), r.AuthenticatorData, r.ClientDataJSON, r.Signature); err != webauthn.ErrInvalidSignature {
+		t.Errorf("Expected [%v] but was: [%v]", webauthn.ErrInvalidSignature, err)
+	}
+}
+
+func TestParsePublicKey_Unsupported(t *testing.T) {
+
+	tests := []struct {
+		description string
+		key         map[int]interface{}
+	}{
+		{"Should reject unknown algorithm", map[int]interface{}{1: 2, 3: -35, -1: 2, -2: make([]byte, 48), -3: make([]byte, 48)}},
+		{"Should reject point not on curve", map[int]interface{}{1: 2, 3: -7, -1: 1, -2: make([]byte, 32), -3: make([]byte, 32)}},
+		{"Should reject short RSA key", map[int]interface{}{1: 3, 3: -257, -1: make([]byte, 128), -2: []byte{1, 0, 1}}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			if _, _, err := webauthn.ParsePublicKey(webauthntest.EncodeCBOR(tc.key)); err == nil {
+				t.Errorf("Key mast be rejected")
+			}
+		})
+	}
+}
+
+func TestURLEncodedBytes(t *testing.T) {
+
+	var b webauthn.URLEncodedBytes
+
+	for _, s := range []string{`"AQL_"`, `"AQL_=="`} {
+		if err := json.Unmarshal([]byte(s), &b); err != nil || string(b) != "\x01\x02\xff" {
+			t.Errorf("Expected [%v] but was: [%v, %v]", []byte{1, 2, 255}, b, err)
+		}
+	}
+
+	if j, _ := json.Marshal(b); string(j) != `"AQL_"` {
+		t.Errorf("Expected [%v] but was: [%v]", `"AQL_"`, string(j))
+	}
+
+	if err := json.Unmarshal([]byte(`"AQL/"`), &b); err == nil {
+		t.Errorf("Standard base64 alphabet mast be rejected")
+	}
+}
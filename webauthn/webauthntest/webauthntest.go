@@ -0,0 +1,182 @@
+// Package webauthntest provides software authenticator which answers WebAuthn ceremonies in tests
+package webauthntest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"sort"
+
+	"authService/webauthn"
+)
+
+// Authenticator holds a single P-256 credential. Flags are set on every authenticator data it makes
+// and SignCount grows by one with every assertion, tests change them to simulate other authenticators
+type Authenticator struct {
+	RPID         string
+	Origin       string
+	Key          *ecdsa.PrivateKey
+	CredentialId []byte
+	UserHandle   []byte
+	Flags        byte
+	SignCount    uint32
+}
+
+func New(rpId, origin string) *Authenticator {
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	id := make([]byte, 16)
+	rand.Read(id)
+
+	return &Authenticator{
+		RPID:         rpId,
+		Origin:       origin,
+		Key:          key,
+		CredentialId: id,
+		Flags:        webauthn.FlagUserPresent | webauthn.FlagUserVerified,
+	}
+}
+
+// Register answers creation options with "none" attestation
+func (a *Authenticator) Register(options webauthn.CreationOptions) webauthn.RegistrationResponse {
+
+	a.UserHandle = options.User.ID
+
+	attestation := EncodeCBOR(map[string]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[string]interface{}{},
+		"authData": a.AuthenticatorData(true),
+	})
+
+	return webauthn.RegistrationResponse{
+		ID:    base64.RawURLEncoding.EncodeToString(a.CredentialId),
+		RawID: a.CredentialId,
+		Type:  "public-key",
+		Response: webauthn.AttestationResponse{
+			ClientDataJSON:    a.ClientData(webauthn.TypeCreate, options.Challenge),
+			AttestationObject: attestation,
+		},
+	}
+}
+
+// Login answers request options with assertion signed by the credential key
+func (a *Authenticator) Login(options webauthn.RequestOptions) webauthn.AuthenticationResponse {
+
+	a.SignCount++
+
+	clientData := a.ClientData(webauthn.TypeGet, options.Challenge)
+	authData := a.AuthenticatorData(false)
+
+	clientHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte(nil), authData...), clientHash[:]...))
+	signature, _ := ecdsa.SignASN1(rand.Reader, a.Key, digest[:])
+
+	return webauthn.AuthenticationResponse{
+		ID:    base64.RawURLEncoding.EncodeToString(a.CredentialId),
+		RawID: a.CredentialId,
+		Type:  "public-key",
+		Response: webauthn.AssertionResponse{
+			ClientDataJSON:    clientData,
+			AuthenticatorData: authData,
+			Signature:         signature,
+			UserHandle:        a.UserHandle,
+		},
+	}
+}
+
+func (a *Authenticator) ClientData(ceremony, challenge string) []byte {
+	b, _ := json.Marshal(webauthn.CollectedClientData{Type: ceremony, Challenge: challenge, Origin: a.Origin})
+	return b
+}
+
+// AuthenticatorData includes the credential when attested is set
+func (a *Authenticator) AuthenticatorData(attested bool) []byte {
+
+	hash := sha256.Sum256([]byte(a.RPID))
+	b := append([]byte(nil), hash[:]...)
+
+	flags := a.Flags
+	if attested {
+		flags |= webauthn.FlagAttestedCredentialData
+	}
+
+	b = append(b, flags)
+	b = binary.BigEndian.AppendUint32(b, a.SignCount)
+
+	if attested {
+		b = append(b, make([]byte, 16)...)
+		b = binary.BigEndian.AppendUint16(b, uint16(len(a.CredentialId)))
+		b = append(b, a.CredentialId...)
+		b = append(b, a.PublicKey()...)
+	}
+
+	return b
+}
+
+// PublicKey returns COSE encoded public key of the credential
+func (a *Authenticator) PublicKey() []byte {
+
+	x := make([]byte, 32)
+	y := make([]byte, 32)
+	a.Key.X.FillBytes(x)
+	a.Key.Y.FillBytes(y)
+
+	return EncodeCBOR(map[int]interface{}{1: 2, 3: webauthn.AlgES256, -1: 1, -2: x, -3: y})
+}
+
+// EncodeCBOR encodes ints, strings, byte strings and maps with int or string keys, keys are sorted
+func EncodeCBOR(v interface{}) []byte {
+
+	switch v := v.(type) {
+	case int:
+		if v < 0 {
+			return header(1, uint64(-1-v))
+		}
+		return header(0, uint64(v))
+	case []byte:
+		return append(header(2, uint64(len(v))), v...)
+	case string:
+		return append(header(3, uint64(len(v))), v...)
+	case map[int]interface{}:
+		keys := []int{}
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Ints(keys)
+		b := header(5, uint64(len(v)))
+		for _, k := range keys {
+			b = append(append(b, EncodeCBOR(k)...), EncodeCBOR(v[k])...)
+		}
+		return b
+	case map[string]interface{}:
+		keys := []string{}
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		b := header(5, uint64(len(v)))
+		for _, k := range keys {
+			b = append(append(b, EncodeCBOR(k)...), EncodeCBOR(v[k])...)
+		}
+		return b
+	}
+	panic("unsupported CBOR value")
+}
+
+func header(major byte, arg uint64) []byte {
+	switch {
+	case arg < 24:
+		return []byte{major<<5 | byte(arg)}
+	case arg <= 0xff:
+		return []byte{major<<5 | 24, byte(arg)}
+	case arg <= 0xffff:
+		return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(arg))
+	case arg <= 0xffffffff:
+		return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(arg))
+	}
+	return binary.BigEndian.AppendUint64([]byte{major<<5 | 27}, arg)
+}